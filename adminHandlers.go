@@ -0,0 +1,226 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultUserSearchLimit = 50
+	maxUserSearchLimit     = 200
+)
+
+// AdminUser is a user as admins see it.
+type AdminUser struct {
+	User
+	SuspendedAt           *time.Time `json:"suspended_at"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	// ChirpCount and Sessions are only filled in for a single user.
+	ChirpCount *int           `json:"chirp_count,omitempty"`
+	Sessions   []AdminSession `json:"sessions,omitempty"`
+}
+
+// AdminSession describes a refresh token without giving it away.
+type AdminSession struct {
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	Active    bool       `json:"active"`
+}
+
+func newAdminUserResp(u store.User) AdminUser {
+	return AdminUser{
+		User:                  newUserResp(u),
+		SuspendedAt:           timeOrNil(u.SuspendedAt),
+		PasswordResetRequired: u.PasswordResetRequired,
+	}
+}
+
+func timeOrNil(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// adminUserID parses the user ID of an /admin/users/{userID} route.
+func adminUserID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, "Not a valid uuid", err)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func respondWithAdminError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		respondWithError(w, r, http.StatusNotFound, "User not found", err)
+	case errors.Is(err, service.ErrSelfAction):
+		respondWithError(w, r, http.StatusForbidden, "Admins can't do this to their own account", err)
+	default:
+		respondWithDBError(w, r, http.StatusInternalServerError, msg, err)
+	}
+}
+
+// adminSearchUsersHandler lists the users whose email contains the email
+// query parameter, or all of them up to limit.
+func (cfg *apiConfig) adminSearchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultUserSearchLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxUserSearchLimit {
+			respondWithError(w, r, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxUserSearchLimit), err)
+			return
+		}
+		limit = n
+	}
+
+	users, err := cfg.service.SearchUsers(r.Context(), r.URL.Query().Get("email"), limit)
+	if err != nil {
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't search users", err)
+		return
+	}
+	res := make([]AdminUser, len(users))
+	for i, u := range users {
+		res[i] = newAdminUserResp(u)
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+func (cfg *apiConfig) adminGetUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+	d, err := cfg.service.UserDetails(r.Context(), id)
+	if err != nil {
+		respondWithAdminError(w, r, "Couldn't get user", err)
+		return
+	}
+
+	res := newAdminUserResp(d.User)
+	res.ChirpCount = &d.ChirpCount
+	now := time.Now()
+	res.Sessions = make([]AdminSession, len(d.Sessions))
+	for i, t := range d.Sessions {
+		res.Sessions[i] = AdminSession{
+			CreatedAt: t.CreatedAt,
+			ExpiresAt: t.ExpiresAt,
+			RevokedAt: timeOrNil(t.RevokedAt),
+			Active:    !t.RevokedAt.Valid && t.ExpiresAt.After(now),
+		}
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+func (cfg *apiConfig) adminSuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
+	id, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.service.Suspend(r.Context(), p.UserID, id); err != nil {
+		respondWithAdminError(w, r, "Couldn't suspend user", err)
+		return
+	}
+	cfg.publish(r, pubsub.Event{Type: pubsub.UserUpdated, UserID: id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) adminUnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.service.Unsuspend(r.Context(), id); err != nil {
+		respondWithAdminError(w, r, "Couldn't unsuspend user", err)
+		return
+	}
+	cfg.publish(r, pubsub.Event{Type: pubsub.UserUpdated, UserID: id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminPasswordResetHandler logs the user out everywhere and makes them
+// choose a new password on their next login. It responds with the
+// one-time reset token the admin passes on to the user, who logs in with
+// it instead of the old password.
+func (cfg *apiConfig) adminPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+	token, err := cfg.service.ForcePasswordReset(r.Context(), id)
+	if err != nil {
+		respondWithAdminError(w, r, "Couldn't reset password", err)
+		return
+	}
+	cfg.publish(r, pubsub.Event{Type: pubsub.UserUpdated, UserID: id})
+	respondWithJSON(w, http.StatusOK, struct {
+		ResetToken string `json:"reset_token"`
+	}{token})
+}
+
+// adminRevokeSessionsHandler revokes the user's refresh tokens. Access
+// tokens already handed out stay good until they expire.
+func (cfg *apiConfig) adminRevokeSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+	n, err := cfg.service.RevokeSessions(r.Context(), id)
+	if err != nil {
+		respondWithAdminError(w, r, "Couldn't revoke sessions", err)
+		return
+	}
+	type revokeRes struct {
+		Revoked int `json:"revoked"`
+	}
+	respondWithJSON(w, http.StatusOK, revokeRes{Revoked: n})
+}
+
+func (cfg *apiConfig) adminSetChirpyRedHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+	var params struct {
+		IsChirpyRed *bool `json:"is_chirpy_red"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.IsChirpyRed == nil {
+		respondWithError(w, r, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := cfg.service.SetChirpyRed(r.Context(), id, *params.IsChirpyRed); err != nil {
+		respondWithAdminError(w, r, "Couldn't update user", err)
+		return
+	}
+	cfg.publish(r, pubsub.Event{Type: pubsub.UserUpdated, UserID: id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminDeleteUserHandler deletes the user with their chirps and tokens.
+func (cfg *apiConfig) adminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
+	id, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.service.DeleteUser(r.Context(), p.UserID, id); err != nil {
+		respondWithAdminError(w, r, "Couldn't delete user", err)
+		return
+	}
+	// Chirps went with the user, so streams and caches start over.
+	cfg.publish(r, pubsub.Event{Type: pubsub.Resync})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/auth"
+	"github.com/TheMaru/go-http-server/internal/store"
+)
+
+func TestAdminUserHandlers(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	admin, adminToken := newTestUser(t, cfg, store.RoleAdmin, time.Hour)
+	_, userToken := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	target, err := cfg.service.CreateUser(t.Context(), "gale@example.com", "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetToken, _ := auth.MakeJWT(target.ID, testSecret, time.Hour)
+	if _, err := cfg.service.CreateChirp(t.Context(), target.ID, "lab notes"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.service.Login(t.Context(), "gale@example.com", "pw"); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", cfg.loginHandler)
+	mux.HandleFunc("GET /api/whoami", cfg.requireUser(whoami))
+	mux.HandleFunc("GET /admin/users", cfg.requireAdmin(cfg.adminSearchUsersHandler))
+	mux.HandleFunc("GET /admin/users/{userID}", cfg.requireAdmin(cfg.adminGetUserHandler))
+	mux.HandleFunc("POST /admin/users/{userID}/suspend", cfg.requireAdmin(cfg.adminSuspendUserHandler))
+	mux.HandleFunc("POST /admin/users/{userID}/unsuspend", cfg.requireAdmin(cfg.adminUnsuspendUserHandler))
+	mux.HandleFunc("POST /admin/users/{userID}/password-reset", cfg.requireAdmin(cfg.adminPasswordResetHandler))
+	mux.HandleFunc("POST /admin/users/{userID}/revoke-sessions", cfg.requireAdmin(cfg.adminRevokeSessionsHandler))
+	mux.HandleFunc("PUT /admin/users/{userID}/chirpy-red", cfg.requireAdmin(cfg.adminSetChirpyRedHandler))
+	mux.HandleFunc("DELETE /admin/users/{userID}", cfg.requireAdmin(cfg.adminDeleteUserHandler))
+
+	users := "/admin/users/" + target.ID.String()
+	self := "/admin/users/" + admin.ID.String()
+	login := `{"email":"gale@example.com","password":"pw"}`
+	reset := func(token string) string {
+		return `{"email":"gale@example.com","reset_token":"` + token + `","new_password":"pw2"}`
+	}
+	// resetToken is the token the password reset step hands out; bodies
+	// take it in place of {reset_token}.
+	var resetToken string
+
+	// The steps run in order, each on the state the last one left.
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{name: "Search as user", method: http.MethodGet, path: "/admin/users", token: userToken, wantCode: http.StatusForbidden},
+		{name: "Search", method: http.MethodGet, path: "/admin/users?email=GALE", token: adminToken, wantCode: http.StatusOK, wantBody: `"email":"gale@example.com"`},
+		{name: "Search with bad limit", method: http.MethodGet, path: "/admin/users?limit=0", token: adminToken, wantCode: http.StatusBadRequest},
+		{name: "Get", method: http.MethodGet, path: users, token: adminToken, wantCode: http.StatusOK, wantBody: `"chirp_count":1`},
+		{name: "Get hides tokens", method: http.MethodGet, path: users, token: adminToken, wantCode: http.StatusOK, wantBody: `"sessions":[{"created_at"`},
+		{name: "Get bad ID", method: http.MethodGet, path: "/admin/users/nope", token: adminToken, wantCode: http.StatusBadRequest},
+		{name: "Get unknown", method: http.MethodGet, path: "/admin/users/3311741c-680c-4546-99f3-fc9efac2036c", token: adminToken, wantCode: http.StatusNotFound},
+		{name: "Suspend self", method: http.MethodPost, path: self + "/suspend", token: adminToken, wantCode: http.StatusForbidden},
+		{name: "Suspend", method: http.MethodPost, path: users + "/suspend", token: adminToken, wantCode: http.StatusNoContent},
+		{name: "Suspended login", method: http.MethodPost, path: "/api/login", body: login, wantCode: http.StatusForbidden},
+		{name: "Suspended access token", method: http.MethodGet, path: "/api/whoami", token: targetToken, wantCode: http.StatusForbidden},
+		{name: "Suspended shows", method: http.MethodGet, path: users, token: adminToken, wantCode: http.StatusOK, wantBody: `"active":false`},
+		{name: "Unsuspend", method: http.MethodPost, path: users + "/unsuspend", token: adminToken, wantCode: http.StatusNoContent},
+		{name: "Login after unsuspend", method: http.MethodPost, path: "/api/login", body: login, wantCode: http.StatusOK},
+		{name: "Force password reset", method: http.MethodPost, path: users + "/password-reset", token: adminToken, wantCode: http.StatusOK, wantBody: `"reset_token":"`},
+		{name: "Login with old password", method: http.MethodPost, path: "/api/login", body: login, wantCode: http.StatusForbidden},
+		{name: "Old password with new one", method: http.MethodPost, path: "/api/login", body: `{"email":"gale@example.com","password":"pw","new_password":"pw2"}`, wantCode: http.StatusBadRequest},
+		{name: "Access token before reset", method: http.MethodGet, path: "/api/whoami", token: targetToken, wantCode: http.StatusForbidden},
+		{name: "Reset with wrong token", method: http.MethodPost, path: "/api/login", body: reset("pw"), wantCode: http.StatusUnauthorized},
+		{name: "Reset without new password", method: http.MethodPost, path: "/api/login", body: `{"email":"gale@example.com","reset_token":"{reset_token}"}`, wantCode: http.StatusBadRequest},
+		{name: "Reset", method: http.MethodPost, path: "/api/login", body: reset("{reset_token}"), wantCode: http.StatusOK},
+		{name: "Reset again", method: http.MethodPost, path: "/api/login", body: reset("{reset_token}"), wantCode: http.StatusUnauthorized},
+		{name: "Login with new password", method: http.MethodPost, path: "/api/login", body: `{"email":"gale@example.com","password":"pw2"}`, wantCode: http.StatusOK},
+		{name: "Access token after reset", method: http.MethodGet, path: "/api/whoami", token: targetToken, wantCode: http.StatusOK},
+		{name: "Revoke sessions", method: http.MethodPost, path: users + "/revoke-sessions", token: adminToken, wantCode: http.StatusOK, wantBody: `{"revoked":2}`},
+		{name: "Chirpy Red without body", method: http.MethodPut, path: users + "/chirpy-red", body: `{}`, token: adminToken, wantCode: http.StatusBadRequest},
+		{name: "Chirpy Red", method: http.MethodPut, path: users + "/chirpy-red", body: `{"is_chirpy_red":true}`, token: adminToken, wantCode: http.StatusNoContent},
+		{name: "Chirpy Red shows", method: http.MethodGet, path: users, token: adminToken, wantCode: http.StatusOK, wantBody: `"is_chirpy_red":true`},
+		{name: "Delete self", method: http.MethodDelete, path: self, token: adminToken, wantCode: http.StatusForbidden},
+		{name: "Delete", method: http.MethodDelete, path: users, token: adminToken, wantCode: http.StatusNoContent},
+		{name: "Get deleted", method: http.MethodGet, path: users, token: adminToken, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.ReplaceAll(tt.body, "{reset_token}", resetToken)
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantCode, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body, tt.wantBody)
+			}
+			var issued struct {
+				ResetToken string `json:"reset_token"`
+			}
+			if json.Unmarshal(rec.Body.Bytes(), &issued) == nil && issued.ResetToken != "" {
+				resetToken = issued.ResetToken
+			}
+		})
+	}
+}
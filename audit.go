@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+// audited records every call of the admin action next, including the
+// ones the auth middleware turned away. It authenticates the request
+// itself, so the event names the caller even when a middleware further in
+// refuses them. Routes on a user or a report name it as the target.
+func (cfg *apiConfig) audited(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authed, _, err := cfg.withPrincipal(r); err == nil {
+			r = authed
+		}
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		event := service.AuditRecord{
+			Action:  action,
+			Outcome: service.AuditSuccess,
+			Details: map[string]string{"status": strconv.Itoa(rec.status)},
+		}
+		switch {
+		case rec.status == http.StatusUnauthorized, rec.status == http.StatusForbidden:
+			event.Outcome = service.AuditDenied
+		case rec.status >= 400:
+			event.Outcome = service.AuditFailure
+		}
+		if target := r.PathValue("userID"); target != "" {
+			if id, err := uuid.Parse(target); err == nil {
+				event.TargetUserID = id
+			} else {
+				event.Details["target_user_id"] = target
+			}
+		}
+		if target := r.PathValue("reportID"); target != "" {
+			event.Details["target_report_id"] = target
+		}
+		cfg.recordAudit(r, event)
+	}
+}
+
+// recordAudit writes event to the audit log lines and appends it to the
+// audit_events table. The actor defaults to the request's principal. The
+// action has happened by now, so a failed write is logged rather than
+// failing the request.
+func (cfg *apiConfig) recordAudit(r *http.Request, event service.AuditRecord) {
+	event.Details = maps.Clone(event.Details)
+	if event.Details == nil {
+		event.Details = map[string]string{}
+	}
+	if p, ok := principalFrom(r); ok && event.ActorID == uuid.Nil && event.ActorService == "" {
+		event.ActorID, event.ActorService = p.UserID, p.Service
+		if p.IsUser() {
+			event.Details["actor_role"] = p.Role
+		}
+	}
+	event.IP = cfg.clientIP(r)
+	if info := getRequestInfo(r.Context()); info != nil {
+		event.Details["request_id"] = info.id
+	}
+
+	attrs := append(requestAttrs(r),
+		slog.String("action", event.Action),
+		slog.String("outcome", event.Outcome),
+		slog.String("ip", event.IP),
+	)
+	if event.ActorID != uuid.Nil {
+		attrs = append(attrs, slog.String("actor_id", event.ActorID.String()))
+	}
+	if event.ActorService != "" {
+		attrs = append(attrs, slog.String("actor_service", event.ActorService))
+	}
+	if event.TargetUserID != uuid.Nil {
+		attrs = append(attrs, slog.String("target_user_id", event.TargetUserID.String()))
+	}
+	for _, k := range slices.Sorted(maps.Keys(event.Details)) {
+		if k != "request_id" {
+			attrs = append(attrs, slog.String(k, event.Details[k]))
+		}
+	}
+	cfg.auditLog.InfoContext(r.Context(), "audit", attrs...)
+
+	// A client hanging up doesn't get to keep its action off the record.
+	if _, err := cfg.service.RecordAudit(context.WithoutCancel(r.Context()), event); err != nil {
+		slog.ErrorContext(r.Context(), "recording audit event", append(requestAttrs(r), slog.String("action", event.Action), slog.Any("error", err))...)
+	}
+}
+
+// clientIP is the address the request came from. A request relayed by
+// one of the trusted proxies comes from the rightmost X-Forwarded-For
+// address that isn't a trusted proxy too; the addresses left of it were
+// sent by the client and could be made up. Anyone else's X-Forwarded-For
+// is ignored.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !cfg.trustedProxy(addr) {
+		return host
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for _, hop := range slices.Backward(hops) {
+		hopAddr, err := netip.ParseAddr(strings.TrimSpace(hop))
+		if err != nil {
+			// A proxy wouldn't forward garbage, so the client wrote it
+			// and the last proxy to be believed is the one it reached.
+			break
+		}
+		addr = hopAddr.Unmap()
+		if !cfg.trustedProxy(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+func (cfg *apiConfig) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range cfg.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 500
+)
+
+type auditEventResp struct {
+	Seq          int64           `json:"seq"`
+	CreatedAt    time.Time       `json:"created_at"`
+	Action       string          `json:"action"`
+	Outcome      string          `json:"outcome"`
+	ActorID      *uuid.UUID      `json:"actor_id"`
+	ActorService string          `json:"actor_service,omitempty"`
+	TargetUserID *uuid.UUID      `json:"target_user_id"`
+	IP           string          `json:"ip"`
+	Details      json.RawMessage `json:"details"`
+	PrevHash     string          `json:"prev_hash"`
+	Hash         string          `json:"hash"`
+}
+
+func newAuditEventResp(e store.AuditEvent) auditEventResp {
+	return auditEventResp{
+		Seq:          e.Seq,
+		CreatedAt:    e.CreatedAt,
+		Action:       e.Action,
+		Outcome:      e.Outcome,
+		ActorID:      uuidOrNil(e.ActorID),
+		ActorService: e.ActorService,
+		TargetUserID: uuidOrNil(e.TargetUserID),
+		IP:           e.IP,
+		Details:      json.RawMessage(e.Details),
+		PrevHash:     e.PrevHash,
+		Hash:         e.Hash,
+	}
+}
+
+// adminAuditHandler lists audit events, newest first. The query narrows
+// them down; before pages back from the seq of the last event seen.
+func (cfg *apiConfig) adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := store.AuditEventFilter{
+		Action:  q.Get("action"),
+		Outcome: q.Get("outcome"),
+		Limit:   defaultAuditLimit,
+	}
+	bad := func(msg string, err error) {
+		respondWithError(w, r, http.StatusBadRequest, msg, err)
+	}
+	for param, dst := range map[string]*uuid.NullUUID{"actor_id": &filter.ActorID, "target_user_id": &filter.TargetUserID} {
+		if s := q.Get(param); s != "" {
+			id, err := uuid.Parse(s)
+			if err != nil {
+				bad(param+" is not a valid uuid", err)
+				return
+			}
+			*dst = uuid.NullUUID{UUID: id, Valid: true}
+		}
+	}
+	for param, dst := range map[string]*sql.NullTime{"since": &filter.Since, "until": &filter.Until} {
+		if s := q.Get(param); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				bad(param+" must be an RFC 3339 time", err)
+				return
+			}
+			*dst = sql.NullTime{Time: t.UTC(), Valid: true}
+		}
+	}
+	if s := q.Get("before"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 1 {
+			bad("before must be a positive seq", err)
+			return
+		}
+		filter.BeforeSeq = n
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			bad("limit must be between 1 and "+strconv.Itoa(maxAuditLimit), err)
+			return
+		}
+		filter.Limit = n
+	}
+
+	events, err := cfg.service.AuditEvents(r.Context(), filter)
+	if err != nil {
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't load audit events", err)
+		return
+	}
+	res := make([]auditEventResp, len(events))
+	for i, e := range events {
+		res[i] = newAuditEventResp(e)
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+func TestAudited(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	var buf bytes.Buffer
+	cfg.auditLog = slog.New(slog.NewJSONHandler(&buf, nil))
+	handler := cfg.audited("reset", cfg.requireAdmin(func(w http.ResponseWriter, r *http.Request) {}))
+
+	admin, adminToken := newTestUser(t, cfg, store.RoleAdmin, time.Hour)
+	_, userToken := newTestUser(t, cfg, store.RoleUser, time.Hour)
+
+	tests := []struct {
+		name        string
+		token       string
+		wantOutcome string
+		wantActor   string
+	}{
+		{"Admin", adminToken, "success", admin.ID.String()},
+		{"User", userToken, "denied", ""},
+		{"Anonymous", "", "denied", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodPost, "/admin/reset", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			handler(httptest.NewRecorder(), req)
+
+			var line struct {
+				Msg       string `json:"msg"`
+				Action    string `json:"action"`
+				Outcome   string `json:"outcome"`
+				ActorID   string `json:"actor_id"`
+				ActorRole string `json:"actor_role"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("audit log %q: %v", buf.String(), err)
+			}
+			if line.Msg != "audit" || line.Action != "reset" || line.Outcome != tt.wantOutcome {
+				t.Errorf("audit line = %+v, want action reset with outcome %s", line, tt.wantOutcome)
+			}
+			if tt.wantActor != "" && (line.ActorID != tt.wantActor || line.ActorRole != store.RoleAdmin) {
+				t.Errorf("actor = %s (%s), want %s (admin)", line.ActorID, line.ActorRole, tt.wantActor)
+			}
+
+			events, err := cfg.service.AuditEvents(t.Context(), store.AuditEventFilter{Limit: 1})
+			if err != nil || len(events) != 1 {
+				t.Fatalf("AuditEvents() = %v, %v", events, err)
+			}
+			if e := events[0]; e.Action != "reset" || e.Outcome != tt.wantOutcome || tt.wantActor != "" && e.ActorID.UUID.String() != tt.wantActor {
+				t.Errorf("stored event = %+v, want action reset with outcome %s", e, tt.wantOutcome)
+			}
+		})
+	}
+}
+
+func TestAuditedTarget(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	_, token := newTestUser(t, cfg, store.RoleAdmin, time.Hour)
+	target := uuid.NewString()
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+
+	tests := []struct {
+		name    string
+		pattern string
+		path    string
+		key     string
+	}{
+		{"User", "POST /admin/users/{userID}/suspend", "/admin/users/" + target + "/suspend", "target_user_id"},
+		{"Report", "POST /admin/moderation/{reportID}/claim", "/admin/moderation/" + target + "/claim", "target_report_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			cfg.auditLog = slog.New(slog.NewJSONHandler(&buf, nil))
+			mux := http.NewServeMux()
+			mux.HandleFunc(tt.pattern, cfg.audited("action", cfg.requireAdmin(noop)))
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			mux.ServeHTTP(httptest.NewRecorder(), req)
+
+			var line map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("audit log %q: %v", buf.String(), err)
+			}
+			if line[tt.key] != target {
+				t.Errorf("%s = %v, want %q", tt.key, line[tt.key], target)
+			}
+		})
+	}
+}
+
+func TestAuditTrail(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	_, adminToken := newTestUser(t, cfg, store.RoleAdmin, time.Hour)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/users", cfg.addUserHandler)
+	mux.HandleFunc("PUT /api/users", cfg.requireUser(cfg.updateUserHandler))
+	mux.HandleFunc("POST /api/login", cfg.loginHandler)
+	mux.HandleFunc("POST /api/revoke", cfg.revokeHandler)
+	mux.HandleFunc("POST /api/polka/webhooks", cfg.requireScope(scopeWebhooks, cfg.polkaWebhookHandler))
+	mux.HandleFunc("GET /admin/audit", cfg.audited("view_audit_log", cfg.requireAdmin(cfg.adminAuditHandler)))
+
+	do := func(method, path, body string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		maps.Copy(req.Header, header)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	bearer := func(token string) http.Header { return http.Header{"Authorization": {"Bearer " + token}} }
+
+	do(http.MethodPost, "/api/users", `{"email":"marie@example.com","password":"pw"}`, nil)
+	do(http.MethodPost, "/api/login", `{"email":"marie@example.com","password":"nope"}`, nil)
+	var session User
+	rec := do(http.MethodPost, "/api/login", `{"email":"marie@example.com","password":"pw"}`, nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &session); err != nil {
+		t.Fatalf("login: %s", rec.Body)
+	}
+	do(http.MethodPut, "/api/users", `{"email":"marie@minerals.example","password":"pw2"}`, bearer(session.Token))
+	do(http.MethodPost, "/api/revoke", "", bearer(session.RefreshToken))
+	do(http.MethodPost, "/api/polka/webhooks", `{"event":"user.upgraded","data":{"user_id":"`+session.ID.String()+`"}}`, http.Header{"Authorization": {"ApiKey polka-key"}})
+	do(http.MethodGet, "/admin/audit", "", bearer(session.Token))
+
+	target := "target_user_id=" + session.ID.String()
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		want     []string
+	}{
+		{"For user", target, http.StatusOK, []string{
+			"polka_upgrade/success", "revoke_token/success", "update_user/success", "login/success", "create_user/success",
+		}},
+		{"By action", "action=login", http.StatusOK, []string{"login/success", "login/failure"}},
+		{"By outcome", "outcome=denied&actor_id=" + session.ID.String(), http.StatusOK, []string{"view_audit_log/denied"}},
+		{"Paged", target + "&before=5&limit=2", http.StatusOK, []string{"update_user/success", "login/success"}},
+		{"Until", "until=2000-01-01T00:00:00Z", http.StatusOK, nil},
+		{"Bad actor", "actor_id=nope", http.StatusBadRequest, nil},
+		{"Bad since", "since=yesterday", http.StatusBadRequest, nil},
+		{"Bad limit", "limit=501", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(http.MethodGet, "/admin/audit?"+tt.query, "", bearer(adminToken))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantCode, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var events []auditEventResp
+			if err := json.Unmarshal(rec.Body.Bytes(), &events); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range events {
+				got = append(got, e.Action+"/"+e.Outcome)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	events, _ := cfg.service.AuditEvents(t.Context(), store.AuditEventFilter{Action: "update_user", Limit: 1})
+	if len(events) != 1 || !strings.Contains(events[0].Details, `"old_email":"marie@example.com"`) {
+		t.Errorf("update_user details = %v", events)
+	}
+	if v, err := cfg.service.VerifyAuditLog(t.Context()); err != nil || v.Events < 8 {
+		t.Errorf("VerifyAuditLog() = %+v, %v", v, err)
+	}
+}
+
+func TestConcurrentLoginsAudited(t *testing.T) {
+	const logins = 10
+	cfg := newTestAPIConfig(newSQLiteStore(t))
+	if _, err := cfg.service.CreateUser(t.Context(), "marie@example.com", "pw"); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]int, logins)
+	for i := range logins {
+		wg.Go(func() {
+			req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"email":"marie@example.com","password":"pw"}`))
+			rec := httptest.NewRecorder()
+			cfg.loginHandler(rec, req)
+			codes[i] = rec.Code
+		})
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("login %d status = %d, want %d", i, code, http.StatusOK)
+		}
+	}
+	events, err := cfg.service.AuditEvents(t.Context(), store.AuditEventFilter{Action: "login", Limit: 100})
+	if err != nil || len(events) != logins {
+		t.Errorf("AuditEvents() = %d events, %v, want %d", len(events), err, logins)
+	}
+	if v, err := cfg.service.VerifyAuditLog(t.Context()); err != nil || v.Events != logins {
+		t.Errorf("VerifyAuditLog() = %+v, %v, want %d events", v, err, logins)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	cfg.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"Direct", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"Forged by an untrusted client", "192.0.2.1:1234", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"Through a trusted proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"Forged behind a trusted proxy", "10.0.0.1:1234", []string{"203.0.113.9, 198.51.100.1"}, "198.51.100.1"},
+		{"Through a chain of trusted proxies", "10.0.0.1:1234", []string{"198.51.100.1, 10.0.0.2", "fd00::1"}, "198.51.100.1"},
+		{"Trusted proxy without the header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"Only trusted proxies forwarded", "10.0.0.1:1234", []string{"10.0.0.3"}, "10.0.0.3"},
+		{"Garbage behind a trusted proxy", "10.0.0.1:1234", []string{"unknown, 10.0.0.2"}, "10.0.0.2"},
+		{"IPv4 mapped proxy", "[::ffff:10.0.0.1]:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"Unparsable remote address", "pipe", []string{"198.51.100.1"}, "pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := cfg.clientIP(req); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
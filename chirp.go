@@ -1,14 +1,14 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"time"
 
-	"github.com/TheMaru/go-http-server/internal/auth"
-	"github.com/TheMaru/go-http-server/internal/database"
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/store"
 	"github.com/google/uuid"
 )
 
@@ -18,151 +18,177 @@ type chirpResp struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	Body      string    `json:"body"`
 	UserID    uuid.UUID `json:"user_id"`
+	// ParentID is the chirp this one replies to, null for chirps that
+	// start a thread.
+	ParentID *uuid.UUID `json:"parent_id"`
+	// Hidden chirps only ever reach their author, with Notice telling
+	// them why nobody else sees it.
+	Hidden bool   `json:"hidden,omitempty"`
+	Notice string `json:"notice,omitempty"`
 }
 
-func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request) {
-	const maxChirpLength = 140
+const hiddenNotice = "Hidden by a moderator. Only you can see this chirp."
 
-	token, err := auth.GetBearerToken(r.Header)
+func newChirpResp(chirp store.Chirp) chirpResp {
+	res := chirpResp{
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		Body:      chirp.Body,
+		UserID:    chirp.UserID,
+		ParentID:  uuidOrNil(chirp.ParentID),
+	}
+	if chirp.HiddenAt.Valid {
+		res.Hidden = true
+		res.Notice = hiddenNotice
+	}
+	return res
+}
+
+// createChirp and deleteChirp make the change through the service and
+// announce it. Both the API and the web UI go through them. createChirp
+// posts a reply to parentID unless it is uuid.Nil.
+func (cfg *apiConfig) createChirp(r *http.Request, userID, parentID uuid.UUID, body string) (store.Chirp, error) {
+	chirp, err := cfg.service.ReplyToChirp(r.Context(), userID, parentID, body)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Not logged in", err)
-		return
+		return store.Chirp{}, err
 	}
+	cfg.metrics.chirpsCreated.Inc()
+	cfg.publish(r, pubsub.ChirpEvent(pubsub.ChirpCreated, chirp))
+	return chirp, nil
+}
 
-	uuid, err := auth.ValidateJWT(token, cfg.secret)
+func (cfg *apiConfig) deleteChirp(r *http.Request, userID, chirpID uuid.UUID) error {
+	chirp, err := cfg.service.DeleteChirp(r.Context(), userID, chirpID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid token", err)
-		return
+		return err
 	}
+	cfg.publish(r, pubsub.ChirpEvent(pubsub.ChirpDeleted, chirp))
+	return nil
+}
+
+func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
+
 	type parameters struct {
-		Body string `json:"body"`
+		Body     string    `json:"body"`
+		ParentID uuid.UUID `json:"parent_id"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		respondWithError(w, r, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
 
-	if len(params.Body) > maxChirpLength {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+	chirp, err := cfg.createChirp(r, p.UserID, params.ParentID, params.Body)
+	if errors.Is(err, service.ErrChirpTooLong) {
+		respondWithError(w, r, http.StatusBadRequest, "Chirp is too long", nil)
 		return
 	}
-
-	chirpParams := database.CreateChirpParams{
-		Body:   filterProfanity(params.Body),
-		UserID: uuid,
+	if errors.Is(err, store.ErrNotFound) && params.ParentID != uuid.Nil {
+		respondWithError(w, r, http.StatusNotFound, "Parent chirp not found", err)
+		return
 	}
-
-	chirp, err := cfg.dbQueries.CreateChirp(context.Background(), chirpParams)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Chirp could not be created", err)
+		respondWithDBError(w, r, http.StatusInternalServerError, "Chirp could not be created", err)
 		return
 	}
 
-	chirpRes := chirpResp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
-	}
-
-	respondWithJSON(w, http.StatusCreated, chirpRes)
+	respondWithJSON(w, http.StatusCreated, newChirpResp(chirp))
 }
 
 func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, r *http.Request) {
-	queryParamString := r.URL.Query().Get("author_id")
-
-	var chirpsFromDB []database.Chirp
-	var err error
-
-	if queryParamString == "" {
-		chirpsFromDB, err = cfg.dbQueries.GetChirpsAsc(context.Background())
-	} else {
-		userId, err := uuid.Parse(r.URL.Query().Get("author_id"))
+	p, _ := principalFrom(r)
+	authorID := uuid.Nil
+	if queryParamString := r.URL.Query().Get("author_id"); queryParamString != "" {
+		userId, err := uuid.Parse(queryParamString)
 		if err != nil {
-			respondWithError(w, http.StatusBadRequest, "Author param malformed", err)
+			respondWithError(w, r, http.StatusBadRequest, "Author param malformed", err)
+			return
 		}
-		chirpsFromDB, err = cfg.dbQueries.GetChirpsByAuthor(context.Background(), userId)
+		authorID = userId
 	}
+
+	chirps, err := cfg.service.ListChirps(r.Context(), p.UserID, authorID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Chirps could not be loaded", err)
+		respondWithDBError(w, r, http.StatusInternalServerError, "Chirps could not be loaded", err)
 		return
 	}
 
-	chirpsResponse := make([]chirpResp, len(chirpsFromDB))
-	for i, chirp := range chirpsFromDB {
-		chirpsResponse[i] = chirpResp{
-			ID:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserID:    chirp.UserID,
-		}
+	chirpsResponse := make([]chirpResp, len(chirps))
+	for i, chirp := range chirps {
+		chirpsResponse[i] = newChirpResp(chirp)
 	}
 
 	respondWithJSON(w, http.StatusOK, chirpsResponse)
 }
 
 func (cfg *apiConfig) getChirpByIDHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
 	id, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Not a valid uuid", err)
+		respondWithError(w, r, http.StatusBadRequest, "Not a valid uuid", err)
 		return
 	}
 
-	chirpFromDB, err := cfg.dbQueries.GetChirpByID(context.Background(), id)
+	chirp, err := cfg.service.GetChirp(r.Context(), p.UserID, id)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Chirp not found", err)
+		respondWithDBError(w, r, http.StatusNotFound, "Chirp not found", err)
 		return
 	}
 
-	chirp := chirpResp{
-		ID:        chirpFromDB.ID,
-		CreatedAt: chirpFromDB.CreatedAt,
-		UpdatedAt: chirpFromDB.UpdatedAt,
-		Body:      chirpFromDB.Body,
-		UserID:    chirpFromDB.UserID,
-	}
-
-	respondWithJSON(w, http.StatusOK, chirp)
+	respondWithJSON(w, http.StatusOK, newChirpResp(chirp))
 }
 
-func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+// getChirpRepliesHandler lists the replies to a chirp the caller can see,
+// oldest first.
+func (cfg *apiConfig) getChirpRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
+	id, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Token not found", err)
+		respondWithError(w, r, http.StatusBadRequest, "Not a valid uuid", err)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.secret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid token", err)
+	replies, err := cfg.service.ListReplies(r.Context(), p.UserID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		respondWithError(w, r, http.StatusNotFound, "Chirp not found", err)
 		return
 	}
-
-	id, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Not a valid uuid", err)
+		respondWithDBError(w, r, http.StatusInternalServerError, "Replies could not be loaded", err)
 		return
 	}
-	chirpFromDb, err := cfg.dbQueries.GetChirpByID(context.Background(), id)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Chirp not found", err)
-		return
+
+	res := make([]chirpResp, len(replies))
+	for i, reply := range replies {
+		res[i] = newChirpResp(reply)
 	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
 
-	if chirpFromDb.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "Not authorized to delete others chirps", errors.New("Forbidden"))
+	id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, "Not a valid uuid", err)
 		return
 	}
 
-	err = cfg.dbQueries.DeleteChirp(context.Background(), id)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Error in database query", err)
+	err = cfg.deleteChirp(r, p.UserID, id)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		respondWithError(w, r, http.StatusNotFound, "Chirp not found", err)
+		return
+	case errors.Is(err, service.ErrForbidden):
+		respondWithError(w, r, http.StatusForbidden, "Not authorized to delete others chirps", err)
+		return
+	case err != nil:
+		respondWithDBError(w, r, http.StatusInternalServerError, "Error in database query", err)
 		return
 	}
 
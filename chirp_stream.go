@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/google/uuid"
+)
+
+const (
+	// streamBuffer is how many events a stream may fall behind before the
+	// hub drops it. The client then reconnects and resumes from the replay
+	// buffer.
+	streamBuffer = 64
+	// streamWriteTimeout bounds every write to a stream. It replaces the
+	// server's WriteTimeout, which would otherwise end each stream early.
+	streamWriteTimeout = 10 * time.Second
+)
+
+// chirpFilter holds the optional author_id and hashtag query parameters
+// of a stream.
+type chirpFilter struct {
+	authorID uuid.UUID
+	hashtag  string
+}
+
+func (f chirpFilter) match(e pubsub.Event) bool {
+	if e.Type != pubsub.ChirpCreated && e.Type != pubsub.ChirpDeleted {
+		return false
+	}
+	if f.authorID != uuid.Nil && e.Chirp.UserID != f.authorID {
+		return false
+	}
+	// Deletions carry no body to look for the hashtag in, so they all
+	// go out; a client drops the ones for chirps it never had.
+	return f.hashtag == "" || e.Type == pubsub.ChirpDeleted || slices.Contains(hashtags(e.Chirp.Body), f.hashtag)
+}
+
+// hashtags returns the tags in body, lower-cased and without the #.
+func hashtags(body string) []string {
+	var tags []string
+	words := strings.FieldsFunc(body, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '#'
+	})
+	for _, word := range words {
+		tag, ok := strings.CutPrefix(word, "#")
+		if ok && tag != "" && !strings.Contains(tag, "#") {
+			tags = append(tags, strings.ToLower(tag))
+		}
+	}
+	return tags
+}
+
+// streamChirpsHandler pushes chirp_created and chirp_deleted events as
+// Server-Sent Events. Clients resuming with Last-Event-ID first get the
+// events they missed, as far as the replay buffer reaches back.
+func (cfg *apiConfig) streamChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	var filter chirpFilter
+	if s := r.URL.Query().Get("author_id"); s != "" {
+		authorID, err := uuid.Parse(s)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, "Author param malformed", err)
+			return
+		}
+		filter.authorID = authorID
+	}
+	if s := r.URL.Query().Get("hashtag"); s != "" {
+		filter.hashtag = strings.ToLower(strings.TrimPrefix(s, "#"))
+		if filter.hashtag == "" {
+			respondWithError(w, r, http.StatusBadRequest, "Hashtag param malformed", nil)
+			return
+		}
+	}
+	var lastID uint64
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, "Last-Event-ID malformed", err)
+			return
+		}
+		lastID = id
+	}
+
+	sub, missed := cfg.events.Subscribe(lastID, streamBuffer)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop nginx and friends from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	send := func(write func(io.Writer) error) bool {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err := write(w); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	comment := func(text string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, ": %s\n\n", text)
+			return err
+		}
+	}
+
+	if !send(comment("connected")) {
+		return
+	}
+	for _, e := range missed {
+		if filter.match(e) && !send(writeEvent(e)) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(cfg.streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind, or shutting down.
+				return
+			}
+			if filter.match(e) && !send(writeEvent(e)) {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(comment("heartbeat")) {
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(e pubsub.Event) func(io.Writer) error {
+	return func(w io.Writer) error {
+		data, err := json.Marshal(eventData(e))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		return err
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+func TestHashtags(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"no tags here", nil},
+		{"#Go is fun", []string{"go"}},
+		{"loving #golang, #SSE and #go_lang!", []string{"golang", "sse", "go_lang"}},
+		{"not a tag: a#b, # or ##double", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			if got := hashtags(tt.body); !slices.Equal(got, tt.want) {
+				t.Errorf("hashtags(%q) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+// sseEvent is one message read off a stream. Comment holds the text of a
+// comment line, the other fields the event.
+type sseEvent struct {
+	ID, Type, Data, Comment string
+}
+
+type sseClient struct {
+	t    *testing.T
+	resp *http.Response
+	r    *bufio.Reader
+}
+
+func openStream(t *testing.T, url string, lastEventID uint64) *sseClient {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(lastEventID, 10))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+	c := &sseClient{t: t, resp: resp, r: bufio.NewReader(resp.Body)}
+	// The handler subscribes before it says hello, so events published
+	// from here on reach this client.
+	if e := c.next(); e.Comment != "connected" {
+		t.Fatalf("first message = %+v, want the connected comment", e)
+	}
+	return c
+}
+
+func (c *sseClient) next() sseEvent {
+	c.t.Helper()
+	var e sseEvent
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("reading stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return e
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "":
+			e.Comment = value
+		case "id":
+			e.ID = value
+		case "event":
+			e.Type = value
+		case "data":
+			e.Data = value
+		}
+	}
+}
+
+func (c *sseClient) nextEvent() sseEvent {
+	c.t.Helper()
+	for {
+		if e := c.next(); e.Comment == "" {
+			return e
+		}
+	}
+}
+
+// publish publishes e on bus and returns it as delivered, with its ID.
+func publish(t *testing.T, bus pubsub.Bus, e pubsub.Event) pubsub.Event {
+	t.Helper()
+	sub, _ := bus.Subscribe(0, 1)
+	defer sub.Close()
+	if err := bus.Publish(context.Background(), e); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	return <-sub.C
+}
+
+func newStreamServer(t *testing.T, cfg *apiConfig) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/stream", cfg.streamChirpsHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Cleanup(cfg.events.Close)
+	return srv
+}
+
+func TestStreamChirpsFilters(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newStreamServer(t, cfg)
+	alice, bob := uuid.New(), uuid.New()
+	gone := uuid.New()
+
+	// "deleted" stands for the deletion of alice's chirp gone, which
+	// passes every hashtag filter because it carries no body.
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"Everything", "", []string{"one #go", "two", "three #Go", "deleted"}},
+		{"Author", "?author_id=" + alice.String(), []string{"one #go", "two", "deleted"}},
+		{"Hashtag", "?hashtag=%23go", []string{"one #go", "three #Go", "deleted"}},
+		{"Author and hashtag", "?hashtag=go&author_id=" + alice.String(), []string{"one #go", "deleted"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := openStream(t, srv.URL+"/api/chirps/stream"+tt.query, 0)
+
+			publish(t, cfg.events, pubsub.ChirpEvent(pubsub.ChirpCreated, store.Chirp{Body: "one #go", UserID: alice}))
+			publish(t, cfg.events, pubsub.Event{Type: pubsub.UserUpgraded, UserID: alice})
+			publish(t, cfg.events, pubsub.ChirpEvent(pubsub.ChirpCreated, store.Chirp{Body: "two", UserID: alice}))
+			publish(t, cfg.events, pubsub.ChirpEvent(pubsub.ChirpCreated, store.Chirp{Body: "three #Go", UserID: bob}))
+			publish(t, cfg.events, pubsub.ChirpEvent(pubsub.ChirpDeleted, store.Chirp{ID: gone, Body: "four #go", UserID: alice}))
+
+			for _, body := range tt.want {
+				e := c.nextEvent()
+				if body == "deleted" {
+					if e.Type != pubsub.ChirpDeleted || e.Data != `{"id":"`+gone.String()+`","user_id":"`+alice.String()+`"}` {
+						t.Errorf("got %+v, want the deletion of %s without its body", e, gone)
+					}
+					continue
+				}
+				var chirp chirpResp
+				if err := json.Unmarshal([]byte(e.Data), &chirp); err != nil {
+					t.Fatalf("event data %q: %v", e.Data, err)
+				}
+				if e.Type != pubsub.ChirpCreated || chirp.Body != body {
+					t.Errorf("got %s of %q, want %s of %q", e.Type, chirp.Body, pubsub.ChirpCreated, body)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamHiddenChirp(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	mod, modToken := newTestUser(t, cfg, store.RoleModerator, time.Hour)
+	author, _ := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	chirp, err := cfg.service.CreateChirp(t.Context(), author.ID, "the secret recipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := cfg.service.ReportChirp(t.Context(), mod.ID, chirp.ID, service.ReasonSpam, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/stream", cfg.streamChirpsHandler)
+	mux.HandleFunc("POST /admin/moderation/{reportID}/hide-chirp", cfg.requireScope(scopeModerate, cfg.hideReportedChirpHandler))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Cleanup(cfg.events.Close)
+	live := openStream(t, srv.URL+"/api/chirps/stream", 0)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/moderation/"+report.ID.String()+"/hide-chirp", nil)
+	req.Header.Set("Authorization", "Bearer "+modToken)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("hiding the chirp: status = %d (body %s)", rec.Code, rec.Body)
+	}
+
+	// Replaying from the start sends the same event out of the buffer.
+	for name, c := range map[string]*sseClient{"Live": live, "Replayed": openStream(t, srv.URL+"/api/chirps/stream", 1)} {
+		e := c.nextEvent()
+		if e.Type != pubsub.ChirpDeleted || !strings.Contains(e.Data, chirp.ID.String()) {
+			t.Errorf("%s: got %+v, want the deletion of %s", name, e, chirp.ID)
+		}
+		if strings.Contains(e.Data, "secret recipe") {
+			t.Errorf("%s: deletion event %s carries the hidden body", name, e.Data)
+		}
+	}
+}
+
+func TestStreamChirpsResumes(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newStreamServer(t, cfg)
+
+	first := publish(t, cfg.events, pubsub.ChirpEvent(pubsub.ChirpCreated, store.Chirp{Body: "seen"}))
+	publish(t, cfg.events, pubsub.ChirpEvent(pubsub.ChirpCreated, store.Chirp{Body: "missed"}))
+
+	c := openStream(t, srv.URL+"/api/chirps/stream", first.ID)
+	e := c.nextEvent()
+	if !strings.Contains(e.Data, `"missed"`) {
+		t.Errorf("first event after resume = %+v, want the missed chirp", e)
+	}
+	if e.ID != strconv.FormatUint(first.ID+1, 10) {
+		t.Errorf("event id = %s, want %d", e.ID, first.ID+1)
+	}
+
+	live := publish(t, cfg.events, pubsub.ChirpEvent(pubsub.ChirpCreated, store.Chirp{Body: "live"}))
+	if e := c.nextEvent(); e.ID != strconv.FormatUint(live.ID, 10) {
+		t.Errorf("next event id = %s, want %d", e.ID, live.ID)
+	}
+}
+
+func TestStreamChirpsHeartbeat(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	cfg.streamHeartbeat = 10 * time.Millisecond
+	srv := newStreamServer(t, cfg)
+
+	c := openStream(t, srv.URL+"/api/chirps/stream", 0)
+	if e := c.next(); e.Comment != "heartbeat" {
+		t.Errorf("idle stream sent %+v, want a heartbeat", e)
+	}
+}
+
+func TestStreamChirpsEndsOnHubClose(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newStreamServer(t, cfg)
+
+	c := openStream(t, srv.URL+"/api/chirps/stream", 0)
+	cfg.events.Close()
+	if _, err := c.r.ReadString('\n'); err == nil {
+		t.Error("stream still open after the hub closed")
+	}
+}
+
+func TestStreamChirpsBadRequest(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newStreamServer(t, cfg)
+
+	tests := []struct {
+		name        string
+		query       string
+		lastEventID string
+	}{
+		{"Bad author", "?author_id=nope", ""},
+		{"Empty hashtag", "?hashtag=%23", ""},
+		{"Bad Last-Event-ID", "", "yesterday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/chirps/stream"+tt.query, nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", resp.StatusCode)
+			}
+		})
+	}
+}
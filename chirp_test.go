@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+)
+
+func TestChirpReplies(t *testing.T) {
+	s := store.NewMemory()
+	cfg := newTestAPIConfig(s)
+	walt, waltToken := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	_, jesseToken := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	root, _ := cfg.service.CreateChirp(t.Context(), walt.ID, "we need to cook")
+	hidden, _ := cfg.service.CreateChirp(t.Context(), walt.ID, "tread lightly")
+	if err := s.SetChirpHidden(t.Context(), hidden.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chirps", cfg.requireUser(cfg.createChirpHandler))
+	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.optionalUser(cfg.getChirpByIDHandler))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/replies", cfg.optionalUser(cfg.getChirpRepliesHandler))
+
+	replies := "/api/chirps/" + root.ID.String() + "/replies"
+	reply := func(parent string) string { return `{"body":"yeah science","parent_id":"` + parent + `"}` }
+
+	// The steps run in order, each on the state the last one left.
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{name: "No replies", method: http.MethodGet, path: replies, wantCode: http.StatusOK, wantBody: `[]`},
+		{name: "Thread starter", method: http.MethodGet, path: "/api/chirps/" + root.ID.String(), wantCode: http.StatusOK, wantBody: `"parent_id":null`},
+		{name: "Reply", method: http.MethodPost, path: "/api/chirps", body: reply(root.ID.String()), token: jesseToken, wantCode: http.StatusCreated, wantBody: `"parent_id":"` + root.ID.String() + `"`},
+		{name: "Replies", method: http.MethodGet, path: replies, wantCode: http.StatusOK, wantBody: `"body":"yeah science"`},
+		{name: "Reply to unknown chirp", method: http.MethodPost, path: "/api/chirps", body: reply("3311741c-680c-4546-99f3-fc9efac2036c"), token: jesseToken, wantCode: http.StatusNotFound},
+		{name: "Reply to hidden chirp", method: http.MethodPost, path: "/api/chirps", body: reply(hidden.ID.String()), token: jesseToken, wantCode: http.StatusNotFound},
+		{name: "Reply to own hidden chirp", method: http.MethodPost, path: "/api/chirps", body: reply(hidden.ID.String()), token: waltToken, wantCode: http.StatusCreated},
+		{name: "Replies to hidden chirp", method: http.MethodGet, path: "/api/chirps/" + hidden.ID.String() + "/replies", token: jesseToken, wantCode: http.StatusNotFound},
+		{name: "Replies to unknown chirp", method: http.MethodGet, path: "/api/chirps/3311741c-680c-4546-99f3-fc9efac2036c/replies", wantCode: http.StatusNotFound},
+		{name: "Replies to malformed ID", method: http.MethodGet, path: "/api/chirps/nope/replies", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantCode, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
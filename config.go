@@ -3,57 +3,112 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"html"
+	"html/template"
+	"log/slog"
 	"net/http"
-	"os"
-	"strconv"
-	"sync/atomic"
+	"net/netip"
+	"strings"
+	"time"
 
-	"github.com/TheMaru/go-http-server/internal/database"
+	"github.com/TheMaru/go-http-server/internal/config"
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/TheMaru/go-http-server/internal/ratelimit"
+	"github.com/TheMaru/go-http-server/internal/service"
+	dto "github.com/prometheus/client_model/go"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	dbQueries      *database.Queries
-	secret         string
-	polkaKey       string
+	service  *service.Service
+	metrics  *metrics
+	events   pubsub.Bus
+	secret   string
+	polkaKey string
+	// streamHeartbeat is how often idle event streams get a heartbeat.
+	streamHeartbeat time.Duration
+	// pages are the templates of the web UI, by page name.
+	pages map[string]*template.Template
+	// secureCookies marks session cookies Secure, so browsers only send
+	// them over HTTPS. It is off on the dev platform.
+	secureCookies   bool
+	refreshTokenTTL time.Duration
+	// auditLog gets a line for every audit event.
+	auditLog *slog.Logger
+	// limiter keeps the buckets of the rateLimits, which are keyed by
+	// route pattern. A nil limiter limits nothing.
+	limiter    ratelimit.Store
+	rateLimits map[string]config.RateLimitPolicy
+	// trustedProxies are the proxies whose X-Forwarded-For clientIP
+	// believes.
+	trustedProxies []netip.Prefix
 }
 
-func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits.Add(1)
-		log.Printf("metrics called, new hits: %d\n", cfg.fileserverHits.Load())
-		next.ServeHTTP(w, r)
-	})
+// publishTimeout bounds how long publishing an event may hold up the
+// response.
+const publishTimeout = 5 * time.Second
+
+// publish announces e after the change it describes has been made. A
+// failure doesn't undo the change, so it is logged instead of returned.
+// The change is made even if the client hangs up, so the announcement
+// can't be called off by that either.
+func (cfg *apiConfig) publish(r *http.Request, e pubsub.Event) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), publishTimeout)
+	defer cancel()
+	if err := cfg.events.Publish(ctx, e); err != nil {
+		slog.ErrorContext(r.Context(), "publishing event", "type", e.Type, "error", err)
+	}
 }
 
 func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	hits, err := cfg.metrics.appHits()
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, "Couldn't gather metrics", err)
+		return
+	}
+	families, err := cfg.metrics.registry.Gather()
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, "Couldn't gather metrics", err)
+		return
+	}
+
+	var rows strings.Builder
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), metricsNamespace+"_") {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			fmt.Fprintf(&rows, "<tr><td>%s</td><td>%s</td><td>%s</td></tr>",
+				html.EscapeString(family.GetName()),
+				html.EscapeString(formatLabels(metric.GetLabel())),
+				html.EscapeString(formatMetricValue(family.GetType(), metric)))
+		}
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	content := fmt.Sprintf("<html><body><h1>Welcome, Chirpy Admin</h1><p>Chirpy has been visited %d times!</p></body></html>", cfg.fileserverHits.Load())
-	res := []byte("Hits: ")
-	res = strconv.AppendInt(res, int64(cfg.fileserverHits.Load()), 10)
+	content := fmt.Sprintf("<html><body><h1>Welcome, Chirpy Admin</h1><p>Chirpy has been visited %d times!</p>"+
+		"<table><tr><th>Metric</th><th>Labels</th><th>Value</th></tr>%s</table></body></html>", hits, rows.String())
 	w.Write([]byte(content))
 }
 
-func (cfg *apiConfig) resetHitsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-
-	platform := os.Getenv("PLATFORM")
-
-	if platform != "dev" {
-		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte("Forbidden"))
-		return
+func formatLabels(labels []*dto.LabelPair) string {
+	parts := make([]string, len(labels))
+	for i, label := range labels {
+		parts[i] = fmt.Sprintf("%s=%q", label.GetName(), label.GetValue())
 	}
+	return strings.Join(parts, ", ")
+}
 
-	cfg.fileserverHits.Store(0)
-
-	err := cfg.dbQueries.DeleteAllUsers(context.Background())
-	if err != nil {
-		log.Printf("DeleteAllUsers encountered a db error: %v\n", err)
+func formatMetricValue(kind dto.MetricType, metric *dto.Metric) string {
+	switch kind {
+	case dto.MetricType_COUNTER:
+		return fmt.Sprint(metric.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		return fmt.Sprint(metric.GetGauge().GetValue())
+	case dto.MetricType_HISTOGRAM:
+		h := metric.GetHistogram()
+		return fmt.Sprintf("count %d, sum %.3f", h.GetSampleCount(), h.GetSampleSum())
+	default:
+		return ""
 	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// statusClientClosedRequest is the non-standard status nginx uses when the
+// client hung up before we could answer. Nobody receives it, but it keeps
+// abandoned requests apart from real server errors in the logs.
+const statusClientClosedRequest = 499
+
+// withDeadline bounds the request context, and with it every query the
+// handler runs, to d.
+func withDeadline(d time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// respondWithDBError reports a failed query. If the request context is done
+// the query was cut short, so the client gets 499 when it went away and 503
+// when the route deadline passed, instead of code.
+func respondWithDBError(w http.ResponseWriter, r *http.Request, code int, msg string, err error) {
+	switch ctxErr := r.Context().Err(); {
+	case errors.Is(ctxErr, context.DeadlineExceeded):
+		respondWithError(w, r, http.StatusServiceUnavailable, "Request timed out", err)
+	case errors.Is(ctxErr, context.Canceled):
+		respondWithError(w, r, statusClientClosedRequest, "Client closed request", err)
+	default:
+		respondWithError(w, r, code, msg, err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+// blockingConnector hands out connections whose queries never finish on
+// their own. They only return once their context is done, and report that
+// on cancelled so tests can tell the query was actually abandoned.
+type blockingConnector struct {
+	cancelled chan error
+}
+
+func (c *blockingConnector) Connect(context.Context) (driver.Conn, error) {
+	return &blockingConn{cancelled: c.cancelled}, nil
+}
+
+func (c *blockingConnector) Driver() driver.Driver { return nil }
+
+type blockingConn struct {
+	cancelled chan error
+}
+
+func (c *blockingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c *blockingConn) Close() error { return nil }
+
+func (c *blockingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+
+func (c *blockingConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	c.cancelled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func (c *blockingConn) ExecContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	c.cancelled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func newBlockingDB(t *testing.T) (*sql.DB, chan error) {
+	t.Helper()
+	cancelled := make(chan error, 1)
+	db := sql.OpenDB(&blockingConnector{cancelled: cancelled})
+	t.Cleanup(func() { db.Close() })
+	return db, cancelled
+}
+
+func newBlockingAPIConfig(t *testing.T) (*apiConfig, chan error) {
+	t.Helper()
+	db, cancelled := newBlockingDB(t)
+	return &apiConfig{service: service.New(store.NewPostgres(db), service.Config{})}, cancelled
+}
+
+func TestRouteDeadlineCancelsQuery(t *testing.T) {
+	cfg, cancelled := newBlockingAPIConfig(t)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/chirps/{chirpID}", withDeadline(20*time.Millisecond, http.HandlerFunc(cfg.getChirpByIDHandler)))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps/"+uuid.NewString(), nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("query ended with %v, want deadline exceeded", err)
+		}
+	default:
+		t.Error("query was not cancelled")
+	}
+}
+
+func TestClientDisconnectCancelsQuery(t *testing.T) {
+	cfg, cancelled := newBlockingAPIConfig(t)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/chirps", withDeadline(time.Minute, http.HandlerFunc(cfg.getChirpsHandler)))
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mux.ServeHTTP(rec, req)
+	}()
+
+	time.AfterFunc(20*time.Millisecond, disconnect)
+
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("query ended with %v, want canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("query kept running after the client went away")
+	}
+	<-done
+
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("status = %d, want %d", rec.Code, statusClientClosedRequest)
+	}
+}
+
+func TestRespondWithDBErrorKeepsCodeWhileContextAlive(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+	rec := httptest.NewRecorder()
+
+	respondWithDBError(rec, req, http.StatusNotFound, "Chirp not found", sql.ErrNoRows)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/TheMaru/go-http-server/internal/config"
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/TheMaru/go-http-server/internal/store"
+)
+
+// newEventBus returns the bus conf selects. The postgres bus only delivers
+// while its listener runs, so that is started on workers. s loads chirps
+// too large to travel in a notification; it should be the uncached store.
+func newEventBus(conf config.StreamConfig, db *sql.DB, dsn string, s store.Store, workers *workerGroup) pubsub.Bus {
+	hub := pubsub.NewHub(conf.ReplaySize)
+	if conf.Bus != "postgres" {
+		return hub
+	}
+	bus := pubsub.NewPostgres(db, dsn, hub, s.GetChirpByID)
+	workers.Go("event-listener", bus.Run)
+	return bus
+}
+
+// invalidateCache drops the entries of c that events on bus made stale.
+// Writes through c already do that themselves; this catches the writes of
+// other instances, which c would otherwise keep serving until the TTL. It
+// subscribes right away, so no event is missed before the worker runs.
+func invalidateCache(bus pubsub.Bus, c *store.Cached) func(ctx context.Context) error {
+	sub, _ := bus.Subscribe(0, streamBuffer)
+	return func(ctx context.Context) error {
+		for {
+			dropped := applyInvalidations(ctx, sub, c)
+			sub.Close()
+			if !dropped {
+				// Stopped, or the bus closed for shutdown.
+				<-ctx.Done()
+				return nil
+			}
+			// Whatever was missed could have touched anything.
+			sub, _ = bus.Subscribe(0, streamBuffer)
+			c.Clear(ctx)
+		}
+	}
+}
+
+// applyInvalidations handles events from sub until ctx is done or sub
+// closes, and reports whether it was dropped for falling behind.
+func applyInvalidations(ctx context.Context, sub *pubsub.Subscription, c *store.Cached) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case e, ok := <-sub.C:
+			if !ok {
+				return sub.Dropped()
+			}
+			switch e.Type {
+			case pubsub.ChirpCreated, pubsub.ChirpDeleted:
+				c.InvalidateChirp(ctx, e.Chirp)
+			case pubsub.UserUpgraded, pubsub.UserUpdated:
+				c.InvalidateUser(ctx, e.UserID)
+			case pubsub.Resync:
+				c.Clear(ctx)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/cache"
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/TheMaru/go-http-server/internal/store"
+)
+
+func TestInvalidateCache(t *testing.T) {
+	tests := []struct {
+		name string
+		// write changes the data through the other instance and returns
+		// the event it would publish.
+		write func(ctx context.Context, s store.Store, u store.User) pubsub.Event
+		// fresh reports whether s shows the write.
+		fresh func(ctx context.Context, s store.Store, u store.User) bool
+	}{
+		{
+			name: "User updated",
+			write: func(ctx context.Context, s store.Store, u store.User) pubsub.Event {
+				s.UpdateUser(ctx, store.UpdateUserParams{ID: u.ID, Email: "new@example.com", HashedPassword: "y"})
+				return pubsub.Event{Type: pubsub.UserUpdated, UserID: u.ID}
+			},
+			fresh: func(ctx context.Context, s store.Store, u store.User) bool {
+				got, _ := s.GetUserByID(ctx, u.ID)
+				return got.Email == "new@example.com"
+			},
+		},
+		{
+			name: "Chirp created",
+			write: func(ctx context.Context, s store.Store, u store.User) pubsub.Event {
+				c, _ := s.CreateChirp(ctx, store.CreateChirpParams{Body: "hi", UserID: u.ID})
+				return pubsub.ChirpEvent(pubsub.ChirpCreated, c)
+			},
+			fresh: func(ctx context.Context, s store.Store, u store.User) bool {
+				chirps, _ := s.GetChirpsByAuthor(ctx, u.ID)
+				return len(chirps) == 1
+			},
+		},
+		{
+			name: "Resync",
+			write: func(ctx context.Context, s store.Store, u store.User) pubsub.Event {
+				s.DeleteAllUsers(ctx)
+				return pubsub.Event{Type: pubsub.Resync}
+			},
+			fresh: func(ctx context.Context, s store.Store, u store.User) bool {
+				_, err := s.GetUserByID(ctx, u.ID)
+				return err != nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Two instances with their own caches in front of one database.
+			db := store.NewMemory()
+			other := store.NewCached(db, cache.NewLRU(100), time.Hour)
+			local := store.NewCached(db, cache.NewLRU(100), time.Hour)
+			hub := pubsub.NewHub(10)
+			defer hub.Close()
+			go invalidateCache(hub, local)(ctx)
+
+			u, err := db.CreateUser(ctx, store.CreateUserParams{Email: "old@example.com", HashedPassword: "x"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			local.GetUserByID(ctx, u.ID)
+			local.GetChirpsByAuthor(ctx, u.ID)
+
+			e := tt.write(ctx, other, u)
+			if tt.fresh(ctx, local, u) {
+				t.Fatal("local cache saw the write before the event")
+			}
+			publish(t, hub, e)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for !tt.fresh(ctx, local, u) {
+				if time.Now().After(deadline) {
+					t.Fatal("local cache still stale after the event")
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// ctxBus records the state of the context of every publish.
+type ctxBus struct {
+	*pubsub.Hub
+	errs      []error
+	deadlines []time.Time
+}
+
+func (b *ctxBus) Publish(ctx context.Context, e pubsub.Event) error {
+	deadline, _ := ctx.Deadline()
+	b.errs = append(b.errs, ctx.Err())
+	b.deadlines = append(b.deadlines, deadline)
+	return b.Hub.Publish(ctx, e)
+}
+
+func TestPublishOutlivesRequest(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	bus := &ctxBus{Hub: pubsub.NewHub(10)}
+	cfg.events = bus
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodPost, "/api/chirps", nil).WithContext(ctx)
+	cfg.publish(r, pubsub.Event{Type: pubsub.Resync})
+
+	if len(bus.errs) != 1 {
+		t.Fatalf("published %d times, want once", len(bus.errs))
+	}
+	if bus.errs[0] != nil {
+		t.Errorf("publish context error = %v, want it to outlive the request", bus.errs[0])
+	}
+	if d := bus.deadlines[0]; d.IsZero() || time.Until(d) > publishTimeout {
+		t.Errorf("publish deadline = %v, want one within %v", d, publishTimeout)
+	}
+}
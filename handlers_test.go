@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/auth"
+	"github.com/TheMaru/go-http-server/internal/cache"
+	"github.com/TheMaru/go-http-server/internal/migrate"
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func newTestAPIConfig(s store.Store) *apiConfig {
+	return &apiConfig{
+		service: service.New(s, service.Config{
+			Secret:          testSecret,
+			AccessTokenTTL:  time.Hour,
+			RefreshTokenTTL: 24 * time.Hour,
+		}),
+		metrics:         newMetrics(nil),
+		events:          pubsub.NewHub(100),
+		secret:          testSecret,
+		polkaKey:        "polka-key",
+		streamHeartbeat: time.Minute,
+		pages:           testPages,
+		refreshTokenTTL: 24 * time.Hour,
+		auditLog:        slog.New(slog.DiscardHandler),
+	}
+}
+
+// testPages are the web UI templates, parsed once for all tests.
+var testPages = func() map[string]*template.Template {
+	assets, err := openAssets("")
+	if err != nil {
+		panic(err)
+	}
+	pages, err := loadPages(assets)
+	if err != nil {
+		panic(err)
+	}
+	return pages
+}()
+
+// newTestUser creates a user with role and returns it with an access
+// token that expires after ttl.
+func newTestUser(t *testing.T, cfg *apiConfig, role string, ttl time.Duration) (store.User, string) {
+	t.Helper()
+	ctx := context.Background()
+	u, err := cfg.service.CreateUser(ctx, uuid.NewString()+"@example.com", "pw")
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if err := cfg.service.SetRole(ctx, u.ID, role); err != nil {
+		t.Fatalf("SetRole() error = %v", err)
+	}
+	u.Role = role
+	token, err := auth.MakeJWT(u.ID, testSecret, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u, token
+}
+
+// newSQLiteStore returns a store backed by a migrated SQLite file that is
+// removed after the test.
+func newSQLiteStore(t *testing.T) store.Store {
+	t.Helper()
+	driver, dsn, err := store.DriverFor("sqlite://" + filepath.Join(t.TempDir(), "chirpy.db"))
+	if err != nil {
+		t.Fatalf("DriverFor() error = %v", err)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, driver)
+	if err != nil {
+		t.Fatalf("migrate.New() error = %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("applying migrations: %v", err)
+	}
+	return store.NewSQLite(db, store.WithQueryWrapper(traceQueries(store.DriverSQLite)))
+}
+
+func TestHandlers(t *testing.T) {
+	backends := []struct {
+		name     string
+		newStore func(t *testing.T) store.Store
+	}{
+		{"Memory", func(*testing.T) store.Store { return store.NewMemory() }},
+		{"SQLite", newSQLiteStore},
+		{"CachedMemory", func(*testing.T) store.Store {
+			return store.NewCached(store.NewMemory(), cache.NewLRU(100), time.Minute)
+		}},
+	}
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			testHandlers(t, newTestAPIConfig(backend.newStore(t)))
+		})
+	}
+}
+
+func testHandlers(t *testing.T, cfg *apiConfig) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/users", cfg.addUserHandler)
+	mux.HandleFunc("POST /api/login", cfg.loginHandler)
+	mux.HandleFunc("POST /api/revoke", cfg.revokeHandler)
+	mux.HandleFunc("POST /api/polka/webhooks", cfg.requireScope(scopeWebhooks, cfg.polkaWebhookHandler))
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		header   http.Header
+		wantCode int
+	}{
+		{
+			name:     "Create user",
+			path:     "/api/users",
+			body:     `{"email":"walt@example.com","password":"pw"}`,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Duplicate email",
+			path:     "/api/users",
+			body:     `{"email":"walt@example.com","password":"pw"}`,
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "Login with unknown email",
+			path:     "/api/login",
+			body:     `{"email":"nobody@example.com","password":"pw"}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Login",
+			path:     "/api/login",
+			body:     `{"email":"walt@example.com","password":"pw"}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Revoke unknown refresh token",
+			path:     "/api/revoke",
+			header:   http.Header{"Authorization": {"Bearer unknown"}},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Upgrade unknown user",
+			path:     "/api/polka/webhooks",
+			body:     `{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`,
+			header:   http.Header{"Authorization": {"ApiKey polka-key"}},
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d (body %s)", rec.Code, tt.wantCode, rec.Body)
+			}
+		})
+	}
+}
+
+func TestOpenAssets(t *testing.T) {
+	assets, err := openAssets("")
+	if err != nil {
+		t.Fatalf("openAssets() error = %v", err)
+	}
+	handler := http.StripPrefix("/app/static", assets)
+
+	tests := []struct {
+		path     string
+		wantCode int
+	}{
+		{"/app/static/style.css", http.StatusOK},
+		{"/app/static/assets/logo.png", http.StatusOK},
+		{"/app/static/" + assets.Path("assets/logo.png"), http.StatusOK},
+		{"/app/static/assets/", http.StatusNotFound},
+		{"/app/static/.env", http.StatusNotFound},
+		{"/app/static/go.mod", http.StatusNotFound},
+		{"/app/static/main.go", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestMovedAssets(t *testing.T) {
+	assets, err := openAssets("")
+	if err != nil {
+		t.Fatalf("openAssets() error = %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(fileserverRoute, http.StripPrefix("/app/static", assets))
+	mux.Handle("/app/", movedAssets(assets))
+
+	tests := []struct {
+		path         string
+		wantCode     int
+		wantLocation string
+	}{
+		{"/app/assets/logo.png", http.StatusMovedPermanently, "/app/static/assets/logo.png"},
+		{"/app/style.css?v=2", http.StatusMovedPermanently, "/app/static/style.css?v=2"},
+		{"/app/index.html", http.StatusMovedPermanently, "/app/"},
+		{"/app/static/style.css", http.StatusOK, ""},
+		{"/app/nope.css", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
@@ -1,9 +1,152 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/migrate"
+)
+
+// readinessCheckTimeout bounds each individual readiness check.
+const readinessCheckTimeout = 2 * time.Second
 
 func healthzHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
+
+// livezHandler only tells the orchestrator the process is up and serving.
+// It deliberately checks no dependencies, so a database outage makes the
+// pod unready instead of getting it restarted.
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type readinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// readyzHandler runs every check concurrently and answers 503 with the per
+// check breakdown if any of them fails.
+func readyzHandler(checks []readinessCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := readinessResponse{
+			Status: "ok",
+			Checks: make(map[string]checkResult, len(checks)),
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, c := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+				defer cancel()
+
+				start := time.Now()
+				err := c.check(ctx)
+				result := checkResult{Status: "ok", Duration: time.Since(start).String()}
+				if err != nil {
+					result.Status = "failed"
+					result.Error = err.Error()
+				}
+
+				mu.Lock()
+				res.Checks[c.name] = result
+				if err != nil {
+					res.Status = "unavailable"
+				}
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		code := http.StatusOK
+		if res.Status != "ok" {
+			code = http.StatusServiceUnavailable
+			slog.Warn("not ready", append(requestAttrs(r), "checks", res.Checks)...)
+		}
+		respondWithJSON(w, code, res)
+	}
+}
+
+func dbCheck(db *sql.DB) readinessCheck {
+	return readinessCheck{name: "database", check: db.PingContext}
+}
+
+// migrationCheck makes sure every migration embedded in this build has
+// been applied, so we never serve traffic against an outdated schema.
+func migrationCheck(migrator *migrate.Migrator) readinessCheck {
+	return readinessCheck{name: "migrations", check: func(ctx context.Context) error {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return fmt.Errorf("reading schema version: %w", err)
+		}
+		if pending {
+			return errors.New("schema has pending migrations")
+		}
+		return nil
+	}}
+}
+
+func workersCheck(workers *workerGroup) readinessCheck {
+	return readinessCheck{name: "workers", check: func(context.Context) error {
+		return workers.Healthy()
+	}}
+}
+
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// waitForDB pings db with exponential backoff until it answers or timeout
+// passes. sql.Open never connects, so without this a bad DB_URL would only
+// show up on the first request.
+func waitForDB(ctx context.Context, db pinger, timeout time.Duration) error {
+	const (
+		initialBackoff = 250 * time.Millisecond
+		maxBackoff     = 10 * time.Second
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return errors.Join(fmt.Errorf("database unreachable after %d attempts", attempt), err)
+		}
+
+		slog.Warn("database not reachable, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return errors.Join(fmt.Errorf("database unreachable after %d attempts", attempt), err)
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyzHandler(t *testing.T) {
+	ok := readinessCheck{name: "ok", check: func(context.Context) error { return nil }}
+	broken := readinessCheck{name: "broken", check: func(context.Context) error { return errors.New("down") }}
+
+	tests := []struct {
+		name       string
+		checks     []readinessCheck
+		wantCode   int
+		wantStatus string
+		wantFailed []string
+	}{
+		{
+			name:       "All checks pass",
+			checks:     []readinessCheck{ok},
+			wantCode:   http.StatusOK,
+			wantStatus: "ok",
+		},
+		{
+			name:       "One check fails",
+			checks:     []readinessCheck{ok, broken},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "unavailable",
+			wantFailed: []string{"broken"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			readyzHandler(tt.checks).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/readyz", nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			var res readinessResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if res.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", res.Status, tt.wantStatus)
+			}
+			if len(res.Checks) != len(tt.checks) {
+				t.Errorf("got %d check results, want %d", len(res.Checks), len(tt.checks))
+			}
+			for _, name := range tt.wantFailed {
+				if res.Checks[name].Status != "failed" || res.Checks[name].Error == "" {
+					t.Errorf("check %q = %+v, want failed with error", name, res.Checks[name])
+				}
+			}
+		})
+	}
+}
+
+func TestWorkerGroupHealth(t *testing.T) {
+	workers := newWorkerGroup()
+	workers.Go("steady", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	crashed := make(chan struct{})
+	workers.Go("crashy", func(ctx context.Context) error {
+		defer close(crashed)
+		return errors.New("boom")
+	})
+	<-crashed
+
+	deadline := time.Now().Add(time.Second)
+	for workers.Healthy() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if workers.Healthy() == nil {
+		t.Error("Healthy() = nil after a worker crashed")
+	}
+
+	if err := workers.Stop(context.Background()); err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+}
+
+type flakyPinger struct {
+	failures int
+	calls    int
+}
+
+func (p *flakyPinger) PingContext(context.Context) error {
+	p.calls++
+	if p.calls <= p.failures {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestWaitForDB(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		timeout  time.Duration
+		wantErr  bool
+	}{
+		{
+			name:     "Reachable right away",
+			failures: 0,
+			timeout:  time.Second,
+		},
+		{
+			name:     "Reachable after retries",
+			failures: 2,
+			timeout:  5 * time.Second,
+		},
+		{
+			name:     "Never reachable",
+			failures: 1000,
+			timeout:  300 * time.Millisecond,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &flakyPinger{failures: tt.failures}
+			err := waitForDB(context.Background(), p, tt.timeout)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("waitForDB() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && p.calls != tt.failures+1 {
+				t.Errorf("pinged %d times, want %d", p.calls, tt.failures+1)
+			}
+		})
+	}
+}
@@ -39,6 +39,13 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	uid, _, err := ValidateJWTWithExpiry(tokenString, tokenSecret)
+	return uid, err
+}
+
+// ValidateJWTWithExpiry is ValidateJWT that also returns when the token
+// expires, for connections that outlive the request that opened them.
+func ValidateJWTWithExpiry(tokenString, tokenSecret string) (uuid.UUID, time.Time, error) {
 	keyFunc := func(token *jwt.Token) (any, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
@@ -46,24 +53,24 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 		return []byte(tokenSecret), nil
 	}
 
-	parser := jwt.NewParser(jwt.WithLeeway(1 * time.Second))
+	parser := jwt.NewParser(jwt.WithLeeway(1*time.Second), jwt.WithExpirationRequired())
 
 	token, err := parser.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, keyFunc)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, time.Time{}, err
 	}
 
 	claims, ok := token.Claims.(*jwt.RegisteredClaims)
 	if !ok {
-		return uuid.Nil, errors.New("unexpected type")
+		return uuid.Nil, time.Time{}, errors.New("unexpected type")
 	}
 
 	uid, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, time.Time{}, err
 	}
 
-	return uid, nil
+	return uid, claims.ExpiresAt.Time, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
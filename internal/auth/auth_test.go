@@ -119,6 +119,28 @@ func TestValidateJWT(t *testing.T) {
 	}
 }
 
+func TestValidateJWTWithExpiry(t *testing.T) {
+	userID := uuid.New()
+	before := time.Now().Add(time.Hour).Truncate(time.Second)
+	token, _ := MakeJWT(userID, "secret", time.Hour)
+
+	gotUserID, expiresAt, err := ValidateJWTWithExpiry(token, "secret")
+	if err != nil {
+		t.Fatalf("ValidateJWTWithExpiry() error = %v", err)
+	}
+	if gotUserID != userID {
+		t.Errorf("ValidateJWTWithExpiry() userID = %v, want %v", gotUserID, userID)
+	}
+	if expiresAt.Before(before) || expiresAt.After(before.Add(time.Minute)) {
+		t.Errorf("ValidateJWTWithExpiry() expiresAt = %v, want about %v", expiresAt, before)
+	}
+
+	expired, _ := MakeJWT(userID, "secret", -time.Minute)
+	if _, _, err := ValidateJWTWithExpiry(expired, "secret"); err == nil {
+		t.Error("ValidateJWTWithExpiry() accepted an expired token")
+	}
+}
+
 func TestGetBearerToken(t *testing.T) {
 	tests := []struct {
 		name      string
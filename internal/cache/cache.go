@@ -0,0 +1,20 @@
+// Package cache holds the read-through caches that sit in front of the
+// store. Values are opaque bytes, so callers work the same against the
+// in-process LRU and against a Redis server shared by several replicas.
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache is a key/value store whose entries expire after a TTL. Get reports
+// a missing or expired key as a miss, not as an error; errors are reserved
+// for a backend that could not be reached.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	// Clear drops every entry owned by this cache.
+	Clear(ctx context.Context) error
+}
@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testCache checks the behaviour every Cache shares. advance moves the
+// cache's clock forward.
+func testCache(t *testing.T, c Cache, advance func(time.Duration)) {
+	ctx := context.Background()
+
+	get := func(key string) (string, bool) {
+		t.Helper()
+		v, ok, err := c.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%s) error = %v", key, err)
+		}
+		return string(v), ok
+	}
+	set := func(key, value string, ttl time.Duration) {
+		t.Helper()
+		if err := c.Set(ctx, key, []byte(value), ttl); err != nil {
+			t.Fatalf("Set(%s) error = %v", key, err)
+		}
+	}
+
+	if _, ok := get("missing"); ok {
+		t.Error("Get(missing) hit, want miss")
+	}
+
+	set("a", "1", time.Minute)
+	set("b", "2", time.Minute)
+	set("short", "3", time.Second)
+	if v, ok := get("a"); !ok || v != "1" {
+		t.Errorf("Get(a) = %q, %v, want 1, true", v, ok)
+	}
+
+	set("a", "one", time.Minute)
+	if v, _ := get("a"); v != "one" {
+		t.Errorf("Get(a) after overwrite = %q, want one", v)
+	}
+
+	advance(2 * time.Second)
+	if _, ok := get("short"); ok {
+		t.Error("Get(short) hit after its ttl, want miss")
+	}
+
+	if err := c.Delete(ctx, "a", "nope"); err != nil {
+		t.Fatalf("Delete error = %v", err)
+	}
+	if _, ok := get("a"); ok {
+		t.Error("Get(a) hit after Delete, want miss")
+	}
+	if _, ok := get("b"); !ok {
+		t.Error("Get(b) missed, Delete removed too much")
+	}
+
+	if err := c.Clear(ctx); err != nil {
+		t.Fatalf("Clear error = %v", err)
+	}
+	if _, ok := get("b"); ok {
+		t.Error("Get(b) hit after Clear, want miss")
+	}
+}
+
+func TestLRU(t *testing.T) {
+	c := NewLRU(10)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	testCache(t, c, func(d time.Duration) { now = now.Add(d) })
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(3)
+	for i := range 3 {
+		c.Set(ctx, fmt.Sprint(i), []byte{byte(i)}, time.Minute)
+	}
+	// Touch 0 so 1 becomes the oldest.
+	c.Get(ctx, "0")
+	c.Set(ctx, "3", []byte{3}, time.Minute)
+
+	if c.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", c.Len())
+	}
+	for key, want := range map[string]bool{"0": true, "1": false, "2": true, "3": true} {
+		if _, ok, _ := c.Get(ctx, key); ok != want {
+			t.Errorf("Get(%s) hit = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	c, err := NewRedis("redis://"+server.Addr(), "chirpy:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping error = %v", err)
+	}
+
+	// Clear must leave other applications' keys alone.
+	server.Set("other:key", "kept")
+
+	testCache(t, c, server.FastForward)
+
+	if !server.Exists("other:key") {
+		t.Error("Clear removed a key outside the prefix")
+	}
+}
+
+func TestRedisUnreachable(t *testing.T) {
+	server := miniredis.RunT(t)
+	c, err := NewRedis("redis://"+server.Addr(), "chirpy:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	server.Close()
+
+	if _, _, err := c.Get(context.Background(), "a"); err == nil {
+		t.Error("Get error = nil with the server down, want an error")
+	}
+}
@@ -0,0 +1,747 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds everything the server needs at startup. Values are resolved
+// in this order, later sources winning: defaults, config file, environment,
+// command line flags.
+type Config struct {
+	Port      string          `yaml:"port" toml:"port"`
+	Platform  string          `yaml:"platform" toml:"platform"`
+	Secret    string          `yaml:"secret" toml:"secret"`
+	PolkaKey  string          `yaml:"polka_key" toml:"polka_key"`
+	LogLevel  string          `yaml:"log_level" toml:"log_level"`
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Tokens    TokenConfig     `yaml:"tokens" toml:"tokens"`
+	DB        DBConfig        `yaml:"db" toml:"db"`
+	Cache     CacheConfig     `yaml:"cache" toml:"cache"`
+	Stream    StreamConfig    `yaml:"stream" toml:"stream"`
+	Static    StaticConfig    `yaml:"static" toml:"static"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	// TestSupport is left out of config files on purpose: test mode
+	// has to be asked for explicitly, with TEST_MODE or -test-mode.
+	TestSupport TestSupportConfig `yaml:"-" toml:"-"`
+}
+
+// ServerConfig tunes the HTTP server. TrustedProxies lists the proxies,
+// as CIDRs or single IPs, whose X-Forwarded-For header is believed when
+// telling clients apart by IP; requests from anywhere else are taken to
+// come from their connection's address.
+type ServerConfig struct {
+	TrustedProxies    []string      `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+type TokenConfig struct {
+	AccessTTL  time.Duration `yaml:"access_ttl" toml:"access_ttl"`
+	RefreshTTL time.Duration `yaml:"refresh_ttl" toml:"refresh_ttl"`
+}
+
+type DBConfig struct {
+	URL             string        `yaml:"url" toml:"url"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+	// QueryTimeout bounds how long a request may spend on the database.
+	// RouteTimeouts overrides it for individual routes, keyed by the mux
+	// pattern such as "POST /api/login".
+	QueryTimeout  time.Duration            `yaml:"query_timeout" toml:"query_timeout"`
+	RouteTimeouts map[string]time.Duration `yaml:"route_timeouts" toml:"route_timeouts"`
+	// ConnectTimeout is how long startup keeps retrying an unreachable
+	// database before giving up.
+	ConnectTimeout time.Duration `yaml:"connect_timeout" toml:"connect_timeout"`
+	// AutoMigrate applies pending migrations before the server starts.
+	AutoMigrate bool `yaml:"auto_migrate" toml:"auto_migrate"`
+}
+
+// CacheConfig selects the read-through cache in front of the store.
+// Backend is one of "none", "memory" or "redis". Size only applies to the
+// memory backend, RedisURL only to the redis one.
+type CacheConfig struct {
+	Backend  string        `yaml:"backend" toml:"backend"`
+	Size     int           `yaml:"size" toml:"size"`
+	TTL      time.Duration `yaml:"ttl" toml:"ttl"`
+	RedisURL string        `yaml:"redis_url" toml:"redis_url"`
+}
+
+// StreamConfig tunes the real-time routes. Heartbeat is how often an idle
+// event stream gets a comment, and how often WebSockets are pinged, so
+// proxies don't close them; ReplaySize is how many recent events a
+// reconnecting event stream client can catch up on. Bus is "local" to
+// deliver events within this instance only, or "postgres" to share them
+// with every instance on the same Postgres database.
+type StreamConfig struct {
+	Heartbeat  time.Duration `yaml:"heartbeat" toml:"heartbeat"`
+	ReplaySize int           `yaml:"replay_size" toml:"replay_size"`
+	Bus        string        `yaml:"bus" toml:"bus"`
+}
+
+// StaticConfig selects the files served under /app/static/. An empty Dir serves
+// the ones embedded in the binary.
+type StaticConfig struct {
+	Dir string `yaml:"dir" toml:"dir"`
+}
+
+// TracingConfig selects where OpenTelemetry spans are exported to.
+// Exporter is one of "none", "stdout", "file" or "otlp". The otlp exporter
+// also honours the standard OTEL_EXPORTER_OTLP_* environment variables.
+type TracingConfig struct {
+	Exporter     string  `yaml:"exporter" toml:"exporter"`
+	File         string  `yaml:"file" toml:"file"`
+	OTLPEndpoint string  `yaml:"otlp_endpoint" toml:"otlp_endpoint"`
+	SampleRatio  float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// RateLimitConfig selects where the token buckets of clients are kept.
+// Store is "memory" to count per instance, "postgres" to share the count
+// with every instance on the same Postgres database, or "none" to turn
+// rate limiting off. Policies limit individual routes, keyed by the mux
+// pattern such as "POST /api/login"; other routes are not limited.
+type RateLimitConfig struct {
+	Store    string                     `yaml:"store" toml:"store"`
+	Policies map[string]RateLimitPolicy `yaml:"policies" toml:"policies"`
+}
+
+// RateLimitPolicy gives every client of a route a bucket of Burst
+// requests that refills at Requests per Per. Key is what tells clients
+// apart: "ip", "user" or "api_key"; requests without a user or API key
+// are told apart by IP. On routes keyed by user, Chirpy Red users get
+// RedRequests and RedBurst instead, if set. A zero Burst or RedBurst is
+// the matching request count.
+type RateLimitPolicy struct {
+	Key         string        `yaml:"key" toml:"key"`
+	Requests    int           `yaml:"requests" toml:"requests"`
+	Per         time.Duration `yaml:"per" toml:"per"`
+	Burst       int           `yaml:"burst" toml:"burst"`
+	RedRequests int           `yaml:"red_requests" toml:"red_requests"`
+	RedBurst    int           `yaml:"red_burst" toml:"red_burst"`
+}
+
+// TestSupportConfig enables the routes that reset, seed, snapshot and
+// restore the database for test runs. They are served on their own
+// listener at Addr, which has to be a loopback address.
+type TestSupportConfig struct {
+	Enabled bool
+	Addr    string
+}
+
+// TimeoutFor returns the database deadline for the route registered
+// under pattern.
+func (c DBConfig) TimeoutFor(pattern string) time.Duration {
+	if d, ok := c.RouteTimeouts[pattern]; ok {
+		return d
+	}
+	return c.QueryTimeout
+}
+
+// minSecretLength is the smallest signing key we accept for HS256.
+const minSecretLength = 32
+
+func Default() Config {
+	return Config{
+		Port:     "8080",
+		Platform: "prod",
+		LogLevel: "info",
+		Server: ServerConfig{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		Tokens: TokenConfig{
+			AccessTTL:  time.Hour,
+			RefreshTTL: 60 * 24 * time.Hour,
+		},
+		DB: DBConfig{
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			QueryTimeout:    5 * time.Second,
+			ConnectTimeout:  time.Minute,
+		},
+		Cache: CacheConfig{
+			Backend: "memory",
+			Size:    10000,
+			TTL:     time.Minute,
+		},
+		Stream: StreamConfig{
+			Heartbeat:  15 * time.Second,
+			ReplaySize: 1000,
+			Bus:        "local",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		RateLimit: RateLimitConfig{
+			Store: "memory",
+			Policies: map[string]RateLimitPolicy{
+				// Logins hash the password, which is slow on purpose.
+				"POST /api/login":  {Key: "ip", Requests: 10, Per: time.Minute},
+				"POST /app/login":  {Key: "ip", Requests: 10, Per: time.Minute},
+				"POST /api/users":  {Key: "ip", Requests: 20, Per: time.Hour, Burst: 5},
+				"POST /app/signup": {Key: "ip", Requests: 20, Per: time.Hour, Burst: 5},
+				"POST /api/chirps": {Key: "user", Requests: 30, Per: time.Minute, RedRequests: 120},
+				"POST /app/chirps": {Key: "user", Requests: 30, Per: time.Minute, RedRequests: 120},
+			},
+		},
+		TestSupport: TestSupportConfig{
+			Addr: "127.0.0.1:8089",
+		},
+	}
+}
+
+// Load builds a Config from args (usually os.Args[1:]), the process
+// environment and the config file named by -config or CONFIG_FILE.
+// The result is validated before it is returned.
+func Load(args []string) (Config, error) {
+	cfg, _, err := load(args, os.LookupEnv)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// LoadDB is Load for commands that only talk to the database, such as
+// migrate. It validates just the db settings and also returns the
+// arguments left over after the flags.
+func LoadDB(args []string) (Config, []string, error) {
+	cfg, rest, err := load(args, os.LookupEnv)
+	if err != nil {
+		return Config{}, nil, err
+	}
+	if errs := cfg.validateDB(); len(errs) > 0 {
+		return Config{}, nil, fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return cfg, rest, nil
+}
+
+func load(args []string, lookupEnv func(string) (string, bool)) (Config, []string, error) {
+	// The flags are parsed twice: once up front to find the config file,
+	// and again at the end so they override the file and environment.
+	probe := Default()
+	configFile, _ := lookupEnv("CONFIG_FILE")
+	probeFlags := newFlagSet(&probe, &configFile)
+	if err := probeFlags.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	cfg := Default()
+	if configFile != "" {
+		if err := cfg.loadFile(configFile); err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(lookupEnv); err != nil {
+		return Config{}, nil, err
+	}
+
+	flags := newFlagSet(&cfg, &configFile)
+	if err := flags.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, flags.Args(), nil
+}
+
+func newFlagSet(cfg *Config, configFile *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+	fs.StringVar(configFile, "config", *configFile, "path to a YAML or TOML config file")
+	fs.StringVar(&cfg.Port, "port", cfg.Port, "port to listen on")
+	fs.StringVar(&cfg.Platform, "platform", cfg.Platform, "platform name, \"dev\" allows session cookies over plain HTTP")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "minimum log level: debug, info, warn or error")
+	fs.StringVar(&cfg.DB.URL, "db-url", cfg.DB.URL, "postgres connection string")
+	fs.IntVar(&cfg.DB.MaxOpenConns, "db-max-open-conns", cfg.DB.MaxOpenConns, "maximum open db connections, 0 for unlimited")
+	fs.IntVar(&cfg.DB.MaxIdleConns, "db-max-idle-conns", cfg.DB.MaxIdleConns, "maximum idle db connections")
+	fs.DurationVar(&cfg.DB.ConnMaxLifetime, "db-conn-max-lifetime", cfg.DB.ConnMaxLifetime, "maximum lifetime of a db connection")
+	fs.DurationVar(&cfg.DB.ConnMaxIdleTime, "db-conn-max-idle-time", cfg.DB.ConnMaxIdleTime, "maximum idle time of a db connection")
+	fs.DurationVar(&cfg.DB.ConnectTimeout, "db-connect-timeout", cfg.DB.ConnectTimeout, "how long to retry connecting to the db on startup")
+	fs.BoolVar(&cfg.DB.AutoMigrate, "auto-migrate", cfg.DB.AutoMigrate, "apply pending migrations on startup")
+	fs.DurationVar(&cfg.DB.QueryTimeout, "db-query-timeout", cfg.DB.QueryTimeout, "default per-request database deadline")
+	fs.StringVar(&cfg.Cache.Backend, "cache-backend", cfg.Cache.Backend, "read cache: none, memory or redis")
+	fs.IntVar(&cfg.Cache.Size, "cache-size", cfg.Cache.Size, "maximum entries in the memory cache")
+	fs.DurationVar(&cfg.Cache.TTL, "cache-ttl", cfg.Cache.TTL, "how long cached reads are served")
+	fs.StringVar(&cfg.Cache.RedisURL, "cache-redis-url", cfg.Cache.RedisURL, "redis:// URL of the redis cache")
+	fs.DurationVar(&cfg.Stream.Heartbeat, "stream-heartbeat", cfg.Stream.Heartbeat, "interval between heartbeats on idle event streams and websocket pings")
+	fs.IntVar(&cfg.Stream.ReplaySize, "stream-replay-size", cfg.Stream.ReplaySize, "events kept for clients resuming a stream")
+	fs.StringVar(&cfg.Stream.Bus, "stream-bus", cfg.Stream.Bus, "event bus: local or postgres")
+	fs.StringVar(&cfg.RateLimit.Store, "rate-limit-store", cfg.RateLimit.Store, "rate limit store: none, memory or postgres")
+	fs.StringVar(&cfg.Static.Dir, "static-dir", cfg.Static.Dir, "directory served under /app/static/ instead of the embedded assets")
+	fs.Func("trusted-proxies", "comma separated CIDRs of proxies whose X-Forwarded-For is trusted", func(s string) error {
+		cfg.Server.TrustedProxies = splitList(s)
+		return nil
+	})
+	fs.DurationVar(&cfg.Server.ReadHeaderTimeout, "read-header-timeout", cfg.Server.ReadHeaderTimeout, "time allowed to read request headers")
+	fs.DurationVar(&cfg.Server.ReadTimeout, "read-timeout", cfg.Server.ReadTimeout, "time allowed to read a full request")
+	fs.DurationVar(&cfg.Server.WriteTimeout, "write-timeout", cfg.Server.WriteTimeout, "time allowed to write a response")
+	fs.DurationVar(&cfg.Server.IdleTimeout, "idle-timeout", cfg.Server.IdleTimeout, "keep-alive idle timeout")
+	fs.DurationVar(&cfg.Server.ShutdownTimeout, "shutdown-timeout", cfg.Server.ShutdownTimeout, "time allowed to drain requests on shutdown")
+	fs.DurationVar(&cfg.Tokens.AccessTTL, "access-token-ttl", cfg.Tokens.AccessTTL, "lifetime of access JWTs")
+	fs.DurationVar(&cfg.Tokens.RefreshTTL, "refresh-token-ttl", cfg.Tokens.RefreshTTL, "lifetime of refresh tokens")
+	fs.StringVar(&cfg.Tracing.Exporter, "tracing-exporter", cfg.Tracing.Exporter, "trace exporter: none, stdout, file or otlp")
+	fs.StringVar(&cfg.Tracing.File, "tracing-file", cfg.Tracing.File, "file the file trace exporter writes to")
+	fs.StringVar(&cfg.Tracing.OTLPEndpoint, "tracing-otlp-endpoint", cfg.Tracing.OTLPEndpoint, "host:port of the OTLP/HTTP collector")
+	fs.Float64Var(&cfg.Tracing.SampleRatio, "tracing-sample-ratio", cfg.Tracing.SampleRatio, "fraction of new traces to sample")
+	fs.BoolVar(&cfg.TestSupport.Enabled, "test-mode", cfg.TestSupport.Enabled, "serve the test support routes; never in production")
+	fs.StringVar(&cfg.TestSupport.Addr, "test-support-addr", cfg.TestSupport.Addr, "loopback host:port the test support routes listen on")
+	return fs
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		_, err = toml.Decode(string(data), c)
+	default:
+		return fmt.Errorf("config file %s: unsupported format %q", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	var errs []error
+
+	str := func(key string, dst *string) {
+		if val, ok := lookupEnv(key); ok {
+			*dst = val
+		}
+	}
+	num := func(key string, dst *int) {
+		if val, ok := lookupEnv(key); ok {
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = n
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if val, ok := lookupEnv(key); ok {
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = b
+		}
+	}
+	float := func(key string, dst *float64) {
+		if val, ok := lookupEnv(key); ok {
+			f, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = f
+		}
+	}
+	dur := func(key string, dst *time.Duration) {
+		if val, ok := lookupEnv(key); ok {
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	str("PORT", &c.Port)
+	str("PLATFORM", &c.Platform)
+	str("SECRET", &c.Secret)
+	str("POLKA_KEY", &c.PolkaKey)
+	str("LOG_LEVEL", &c.LogLevel)
+	str("DB_URL", &c.DB.URL)
+	num("DB_MAX_OPEN_CONNS", &c.DB.MaxOpenConns)
+	num("DB_MAX_IDLE_CONNS", &c.DB.MaxIdleConns)
+	dur("DB_CONN_MAX_LIFETIME", &c.DB.ConnMaxLifetime)
+	dur("DB_CONN_MAX_IDLE_TIME", &c.DB.ConnMaxIdleTime)
+	dur("DB_QUERY_TIMEOUT", &c.DB.QueryTimeout)
+	dur("DB_CONNECT_TIMEOUT", &c.DB.ConnectTimeout)
+	boolean("DB_AUTO_MIGRATE", &c.DB.AutoMigrate)
+	str("CACHE_BACKEND", &c.Cache.Backend)
+	num("CACHE_SIZE", &c.Cache.Size)
+	dur("CACHE_TTL", &c.Cache.TTL)
+	str("CACHE_REDIS_URL", &c.Cache.RedisURL)
+	dur("STREAM_HEARTBEAT", &c.Stream.Heartbeat)
+	num("STREAM_REPLAY_SIZE", &c.Stream.ReplaySize)
+	str("STREAM_BUS", &c.Stream.Bus)
+	str("RATE_LIMIT_STORE", &c.RateLimit.Store)
+	str("STATIC_DIR", &c.Static.Dir)
+	if val, ok := lookupEnv("TRUSTED_PROXIES"); ok {
+		c.Server.TrustedProxies = splitList(val)
+	}
+	dur("READ_HEADER_TIMEOUT", &c.Server.ReadHeaderTimeout)
+	dur("READ_TIMEOUT", &c.Server.ReadTimeout)
+	dur("WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	dur("IDLE_TIMEOUT", &c.Server.IdleTimeout)
+	dur("SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+	dur("ACCESS_TOKEN_TTL", &c.Tokens.AccessTTL)
+	dur("REFRESH_TOKEN_TTL", &c.Tokens.RefreshTTL)
+	str("TRACING_EXPORTER", &c.Tracing.Exporter)
+	str("TRACING_FILE", &c.Tracing.File)
+	str("TRACING_OTLP_ENDPOINT", &c.Tracing.OTLPEndpoint)
+	float("TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio)
+	boolean("TEST_MODE", &c.TestSupport.Enabled)
+	str("TEST_SUPPORT_ADDR", &c.TestSupport.Addr)
+
+	return errors.Join(errs...)
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// TrustedProxyPrefixes parses TrustedProxies. A single IP is a prefix
+// covering just that address.
+func (c ServerConfig) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, s := range c.TrustedProxies {
+		if addr, err := netip.ParseAddr(s); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an IP or CIDR", s)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Validate reports every problem with c at once so a misconfigured
+// deployment can be fixed in one go.
+func (c Config) Validate() error {
+	var errs []error
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port %q is not a valid port number", c.Port))
+	}
+	if _, err := c.SlogLevel(); err != nil {
+		errs = append(errs, fmt.Errorf("log level %q is not one of debug, info, warn, error", c.LogLevel))
+	}
+	if c.Secret == "" {
+		errs = append(errs, errors.New("secret must be set"))
+	} else if len(c.Secret) < minSecretLength {
+		errs = append(errs, fmt.Errorf("secret must be at least %d bytes", minSecretLength))
+	}
+	errs = append(errs, c.validateDB()...)
+
+	if _, err := c.Server.TrustedProxyPrefixes(); err != nil {
+		errs = append(errs, err)
+	}
+
+	timeouts := map[string]time.Duration{
+		"read header timeout": c.Server.ReadHeaderTimeout,
+		"read timeout":        c.Server.ReadTimeout,
+		"write timeout":       c.Server.WriteTimeout,
+		"idle timeout":        c.Server.IdleTimeout,
+		"shutdown timeout":    c.Server.ShutdownTimeout,
+	}
+	for name, d := range timeouts {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", name))
+		}
+	}
+
+	if c.Tokens.AccessTTL <= 0 {
+		errs = append(errs, errors.New("access token ttl must be positive"))
+	}
+	if c.Tokens.RefreshTTL <= c.Tokens.AccessTTL {
+		errs = append(errs, errors.New("refresh token ttl must be longer than access token ttl"))
+	}
+
+	switch c.Cache.Backend {
+	case "none":
+	case "memory":
+		if c.Cache.Size <= 0 {
+			errs = append(errs, errors.New("cache size must be positive"))
+		}
+	case "redis":
+		if c.Cache.RedisURL == "" {
+			errs = append(errs, errors.New("cache redis url must be set for the redis backend"))
+		} else if u, err := url.Parse(c.Cache.RedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") {
+			errs = append(errs, errors.New("cache redis url must be a redis:// or rediss:// URL"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("cache backend %q is not one of none, memory, redis", c.Cache.Backend))
+	}
+	if c.Cache.Backend != "none" && c.Cache.TTL <= 0 {
+		errs = append(errs, errors.New("cache ttl must be positive"))
+	}
+
+	if c.Stream.Heartbeat <= 0 {
+		errs = append(errs, errors.New("stream heartbeat must be positive"))
+	}
+	if c.Stream.ReplaySize < 0 {
+		errs = append(errs, errors.New("stream replay size must not be negative"))
+	}
+	switch c.Stream.Bus {
+	case "local":
+	case "postgres":
+		if u, err := url.Parse(c.DB.URL); err == nil && u.Scheme == "sqlite" {
+			errs = append(errs, errors.New("stream bus postgres needs a postgres db url"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("stream bus %q is not one of local, postgres", c.Stream.Bus))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	case "file":
+		if c.Tracing.File == "" {
+			errs = append(errs, errors.New("tracing file must be set for the file exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tracing exporter %q is not one of none, stdout, file, otlp", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing sample ratio must be between 0 and 1"))
+	}
+
+	switch c.RateLimit.Store {
+	case "none", "memory":
+	case "postgres":
+		if u, err := url.Parse(c.DB.URL); err == nil && u.Scheme == "sqlite" {
+			errs = append(errs, errors.New("rate limit store postgres needs a postgres db url"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("rate limit store %q is not one of none, memory, postgres", c.RateLimit.Store))
+	}
+	for _, pattern := range slices.Sorted(maps.Keys(c.RateLimit.Policies)) {
+		errs = append(errs, c.RateLimit.Policies[pattern].validate(pattern)...)
+	}
+
+	if c.TestSupport.Enabled && !isLoopback(c.TestSupport.Addr) {
+		errs = append(errs, fmt.Errorf("test support addr %q is not a loopback host:port", c.TestSupport.Addr))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Quota returns the requests per Per and the burst a client gets, red
+// telling whether it is a Chirpy Red user.
+func (p RateLimitPolicy) Quota(red bool) (requests, burst int) {
+	requests, burst = p.Requests, p.Burst
+	if red && p.RedRequests > 0 {
+		requests, burst = p.RedRequests, p.RedBurst
+	}
+	if burst == 0 {
+		burst = requests
+	}
+	return requests, burst
+}
+
+func (p RateLimitPolicy) validate(pattern string) []error {
+	var errs []error
+	if !slices.Contains([]string{"ip", "user", "api_key"}, p.Key) {
+		errs = append(errs, fmt.Errorf("rate limit key %q for %q is not one of ip, user, api_key", p.Key, pattern))
+	}
+	if p.Requests <= 0 || p.Per <= 0 {
+		errs = append(errs, fmt.Errorf("rate limit for %q must allow a positive number of requests per positive period", pattern))
+	}
+	if p.Burst < 0 || p.RedRequests < 0 || p.RedBurst < 0 {
+		errs = append(errs, fmt.Errorf("rate limit burst and red quotas for %q must not be negative", pattern))
+	}
+	return errs
+}
+
+// isLoopback reports whether addr is a host:port only this machine can
+// connect to.
+func isLoopback(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (c Config) validateDB() []error {
+	var errs []error
+	if c.DB.URL == "" {
+		errs = append(errs, errors.New("db url must be set"))
+	} else if store.IsKeyValueDSN(c.DB.URL) {
+		// A lib/pq key=value string; lib/pq checks it when connecting.
+	} else if u, err := url.Parse(c.DB.URL); err != nil {
+		errs = append(errs, errors.New("db url is malformed"))
+	} else if !slices.Contains([]string{"postgres", "postgresql", "sqlite"}, u.Scheme) {
+		errs = append(errs, fmt.Errorf("db url scheme %q is not postgres or sqlite", u.Scheme))
+	}
+
+	if c.DB.MaxOpenConns < 0 {
+		errs = append(errs, errors.New("db max open conns must not be negative"))
+	}
+	if c.DB.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("db max idle conns must not be negative"))
+	}
+	if c.DB.MaxOpenConns > 0 && c.DB.MaxIdleConns > c.DB.MaxOpenConns {
+		errs = append(errs, errors.New("db max idle conns must not exceed max open conns"))
+	}
+	if c.DB.ConnMaxLifetime < 0 || c.DB.ConnMaxIdleTime < 0 {
+		errs = append(errs, errors.New("db connection lifetimes must not be negative"))
+	}
+	if c.DB.ConnectTimeout <= 0 {
+		errs = append(errs, errors.New("db connect timeout must be positive"))
+	}
+	if c.DB.QueryTimeout <= 0 {
+		errs = append(errs, errors.New("db query timeout must be positive"))
+	}
+	for pattern, d := range c.DB.RouteTimeouts {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("db route timeout for %q must be positive", pattern))
+		}
+	}
+	return errs
+}
+
+// SlogLevel parses LogLevel.
+func (c Config) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(c.LogLevel))
+	return level, err
+}
+
+// LogValue implements slog.LogValuer so a Config can be logged directly.
+// Secrets are masked and the password is stripped from the database URL.
+func (c Config) LogValue() slog.Value {
+	return slog.GroupValue(c.redactedFields()...)
+}
+
+func (c Config) redactedFields() []slog.Attr {
+	fields := []slog.Attr{
+		slog.String("port", c.Port),
+		slog.String("platform", c.Platform),
+		slog.String("secret", redact(c.Secret)),
+		slog.String("polka_key", redact(c.PolkaKey)),
+		slog.String("log_level", c.LogLevel),
+		slog.String("server.trusted_proxies", strings.Join(c.Server.TrustedProxies, ",")),
+		slog.Duration("server.read_header_timeout", c.Server.ReadHeaderTimeout),
+		slog.Duration("server.read_timeout", c.Server.ReadTimeout),
+		slog.Duration("server.write_timeout", c.Server.WriteTimeout),
+		slog.Duration("server.idle_timeout", c.Server.IdleTimeout),
+		slog.Duration("server.shutdown_timeout", c.Server.ShutdownTimeout),
+		slog.Duration("tokens.access_ttl", c.Tokens.AccessTTL),
+		slog.Duration("tokens.refresh_ttl", c.Tokens.RefreshTTL),
+		slog.String("db.url", redactDSN(c.DB.URL)),
+		slog.Int("db.max_open_conns", c.DB.MaxOpenConns),
+		slog.Int("db.max_idle_conns", c.DB.MaxIdleConns),
+		slog.Duration("db.conn_max_lifetime", c.DB.ConnMaxLifetime),
+		slog.Duration("db.conn_max_idle_time", c.DB.ConnMaxIdleTime),
+		slog.Duration("db.connect_timeout", c.DB.ConnectTimeout),
+		slog.Duration("db.query_timeout", c.DB.QueryTimeout),
+		slog.Bool("db.auto_migrate", c.DB.AutoMigrate),
+		slog.String("cache.backend", c.Cache.Backend),
+		slog.Int("cache.size", c.Cache.Size),
+		slog.Duration("cache.ttl", c.Cache.TTL),
+		slog.String("cache.redis_url", redactURL(c.Cache.RedisURL)),
+		slog.Duration("stream.heartbeat", c.Stream.Heartbeat),
+		slog.Int("stream.replay_size", c.Stream.ReplaySize),
+		slog.String("stream.bus", c.Stream.Bus),
+		slog.String("static.dir", c.Static.Dir),
+		slog.String("tracing.exporter", c.Tracing.Exporter),
+		slog.String("tracing.file", c.Tracing.File),
+		slog.String("tracing.otlp_endpoint", c.Tracing.OTLPEndpoint),
+		slog.Float64("tracing.sample_ratio", c.Tracing.SampleRatio),
+		slog.String("rate_limit.store", c.RateLimit.Store),
+		slog.Bool("test_support.enabled", c.TestSupport.Enabled),
+		slog.String("test_support.addr", c.TestSupport.Addr),
+	}
+	for _, pattern := range slices.Sorted(maps.Keys(c.DB.RouteTimeouts)) {
+		fields = append(fields, slog.Duration(fmt.Sprintf("db.route_timeouts[%q]", pattern), c.DB.RouteTimeouts[pattern]))
+	}
+	for _, pattern := range slices.Sorted(maps.Keys(c.RateLimit.Policies)) {
+		p := c.RateLimit.Policies[pattern]
+		fields = append(fields, slog.String(fmt.Sprintf("rate_limit.policies[%q]", pattern),
+			fmt.Sprintf("%d per %s by %s, burst %d, red %d burst %d", p.Requests, p.Per, p.Key, p.Burst, p.RedRequests, p.RedBurst)))
+	}
+	return fields
+}
+
+func redact(s string) string {
+	if s == "" {
+		return "<unset>"
+	}
+	return "<redacted>"
+}
+
+// dsnPassword matches the password in a lib/pq key=value string, quoted or
+// not.
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+func redactDSN(s string) string {
+	if store.IsKeyValueDSN(s) {
+		return dsnPassword.ReplaceAllString(s, "${1}xxxxx")
+	}
+	return redactURL(s)
+}
+
+func redactURL(s string) string {
+	if s == "" {
+		return "<unset>"
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "<redacted>"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,132 @@
+// Package migrate applies the goose migrations embedded in the binary.
+// On Postgres every command that changes the schema holds an advisory lock,
+// so several replicas starting with auto-migrate enabled take turns instead
+// of racing each other. SQLite serializes writers on its own.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/TheMaru/go-http-server/sql/schema"
+	sqliteschema "github.com/TheMaru/go-http-server/sql/sqlite/schema"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// Commands are the subcommands Run understands.
+var Commands = []string{"up", "down", "status", "redo"}
+
+// Migrator wraps a goose provider over the embedded migrations.
+type Migrator struct {
+	provider *goose.Provider
+}
+
+// New loads the migrations for driver, one of the store.Driver* names.
+func New(db *sql.DB, driver string) (*Migrator, error) {
+	var provider *goose.Provider
+	var err error
+	switch driver {
+	case store.DriverPostgres:
+		locker, lockErr := lock.NewPostgresSessionLocker()
+		if lockErr != nil {
+			return nil, fmt.Errorf("creating migration lock: %w", lockErr)
+		}
+		provider, err = goose.NewProvider(goose.DialectPostgres, db, schema.FS,
+			goose.WithSessionLocker(locker),
+		)
+	case store.DriverSQLite:
+		provider, err = goose.NewProvider(goose.DialectSQLite3, db, sqliteschema.FS)
+	default:
+		return nil, fmt.Errorf("no migrations for driver %q", driver)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading migrations: %w", err)
+	}
+	return &Migrator{provider: provider}, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) ([]*goose.MigrationResult, error) {
+	return m.provider.Up(ctx)
+}
+
+// Pending reports whether the database is missing any migration this build
+// ships with.
+func (m *Migrator) Pending(ctx context.Context) (bool, error) {
+	return m.provider.HasPending(ctx)
+}
+
+// Versions returns the version of every embedded migration, oldest first.
+func (m *Migrator) Versions() []int64 {
+	sources := m.provider.ListSources()
+	versions := make([]int64, len(sources))
+	for i, s := range sources {
+		versions[i] = s.Version
+	}
+	return versions
+}
+
+// Run executes one of Commands and writes a human readable report to out.
+func (m *Migrator) Run(ctx context.Context, command string, out io.Writer) error {
+	switch command {
+	case "up":
+		results, err := m.provider.Up(ctx)
+		printResults(out, results)
+		if err == nil && len(results) == 0 {
+			fmt.Fprintln(out, "no pending migrations")
+		}
+		return err
+	case "down":
+		result, err := m.provider.Down(ctx)
+		printResults(out, []*goose.MigrationResult{result})
+		return err
+	case "redo":
+		// goose has no atomic redo, so this is a down followed by an up,
+		// each taking the lock on its own.
+		down, err := m.provider.Down(ctx)
+		printResults(out, []*goose.MigrationResult{down})
+		if err != nil {
+			return err
+		}
+		up, err := m.provider.UpByOne(ctx)
+		printResults(out, []*goose.MigrationResult{up})
+		return err
+	case "status":
+		statuses, err := m.provider.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printStatus(out, statuses)
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, want one of %v", command, Commands)
+	}
+}
+
+func printResults(out io.Writer, results []*goose.MigrationResult) {
+	for _, r := range results {
+		if r != nil && r.Source != nil {
+			fmt.Fprintln(out, r)
+		}
+	}
+}
+
+func printStatus(out io.Writer, statuses []*goose.MigrationStatus) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tMIGRATION\tAPPLIED AT")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.State == goose.StateApplied {
+			applied = s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Source.Version, path.Base(s.Source.Path), applied)
+	}
+	w.Flush()
+}
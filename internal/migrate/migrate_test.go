@@ -0,0 +1,117 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+	_ "github.com/lib/pq"
+)
+
+func newTestMigrator(t *testing.T) *Migrator {
+	t.Helper()
+	// sql.Open never connects, which is all these tests need.
+	db, err := sql.Open("postgres", "postgres://localhost:1/none?sslmode=disable")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := New(db, store.DriverPostgres)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return m
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	versions := newTestMigrator(t).Versions()
+
+	if len(versions) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	if !slices.IsSorted(versions) {
+		t.Errorf("versions %v are not sorted", versions)
+	}
+	for i, v := range versions {
+		if v != int64(i+1) {
+			t.Errorf("migration %d has version %d, want consecutive versions starting at 1", i, v)
+		}
+	}
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	err := newTestMigrator(t).Run(context.Background(), "sideways", io.Discard)
+	if err == nil {
+		t.Error("Run(sideways) error = nil, want an error")
+	}
+}
+
+func TestRunAgainstSQLite(t *testing.T) {
+	ctx := context.Background()
+	driver, dsn, err := store.DriverFor("sqlite://" + filepath.Join(t.TempDir(), "chirpy.db"))
+	if err != nil {
+		t.Fatalf("DriverFor() error = %v", err)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	m, err := New(db, driver)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	pending := func() bool {
+		t.Helper()
+		p, err := m.Pending(ctx)
+		if err != nil {
+			t.Fatalf("Pending() error = %v", err)
+		}
+		return p
+	}
+	run := func(command string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := m.Run(ctx, command, &out); err != nil {
+			t.Fatalf("Run(%s) error = %v", command, err)
+		}
+		return out.String()
+	}
+
+	if !pending() {
+		t.Error("fresh database has no pending migrations")
+	}
+
+	if out := run("up"); strings.Count(out, "OK") != len(m.Versions()) {
+		t.Errorf("up output = %q, want one line per migration", out)
+	}
+	if pending() {
+		t.Error("pending migrations after up")
+	}
+	if out := run("up"); !strings.Contains(out, "no pending migrations") {
+		t.Errorf("second up output = %q", out)
+	}
+
+	if out := run("redo"); !strings.Contains(out, "down") || !strings.Contains(out, "up") {
+		t.Errorf("redo output = %q, want a down and an up", out)
+	}
+	if pending() {
+		t.Error("pending migrations after redo")
+	}
+
+	run("down")
+	if !pending() {
+		t.Error("no pending migrations after down")
+	}
+	if out := run("status"); !strings.Contains(out, "pending") {
+		t.Errorf("status output = %q, want the rolled back migration as pending", out)
+	}
+}
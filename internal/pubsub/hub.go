@@ -0,0 +1,132 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Hub is the in-process Bus. Publishing never blocks: a subscriber that
+// falls behind is dropped and is expected to reconnect and catch up from
+// the replay buffer.
+type Hub struct {
+	mu     sync.Mutex
+	lastID uint64
+	// replay is a ring of the most recent events, oldest at start.
+	replay []Event
+	start  int
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewHub returns a Hub that keeps the last replaySize events for
+// subscribers resuming after a disconnect.
+func NewHub(replaySize int) *Hub {
+	return &Hub{
+		lastID: uint64(time.Now().UnixMicro()),
+		replay: make([]Event, 0, replaySize),
+		subs:   map[*Subscription]struct{}{},
+	}
+}
+
+// Subscription delivers events on C until it is closed, either by Close,
+// by the hub closing, or because the subscriber didn't keep up.
+type Subscription struct {
+	C       <-chan Event
+	c       chan Event
+	hub     *Hub
+	dropped bool
+}
+
+// Publish assigns e an ID, stores it for replay and hands it to every
+// subscriber. It never fails.
+func (h *Hub) Publish(_ context.Context, e Event) error {
+	h.publish(e)
+	return nil
+}
+
+func (h *Hub) publish(e Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	e.ID = h.lastID
+	if len(h.replay) < cap(h.replay) {
+		h.replay = append(h.replay, e)
+	} else if cap(h.replay) > 0 {
+		h.replay[h.start] = e
+		h.start = (h.start + 1) % len(h.replay)
+	}
+
+	for sub := range h.subs {
+		select {
+		case sub.c <- e:
+		default:
+			sub.dropped = true
+			h.remove(sub)
+		}
+	}
+	return e
+}
+
+// Subscribe registers a subscriber with room for buffer undelivered events.
+// It also returns the buffered events after lastID, so nothing published
+// in between is missed or sent twice. A lastID of 0 asks for no replay;
+// one that has already left the buffer gets everything still in it.
+func (h *Hub) Subscribe(lastID uint64, buffer int) (*Subscription, []Event) {
+	c := make(chan Event, buffer)
+	sub := &Subscription{C: c, c: c, hub: h}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(c)
+		return sub, nil
+	}
+	h.subs[sub] = struct{}{}
+
+	if lastID == 0 {
+		return sub, nil
+	}
+	var missed []Event
+	for i := range h.replay {
+		e := h.replay[(h.start+i)%len(h.replay)]
+		if e.ID > lastID {
+			missed = append(missed, e)
+		}
+	}
+	return sub, missed
+}
+
+// Close unsubscribes. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// Dropped reports whether the hub closed the subscription because its
+// buffer was full.
+func (s *Subscription) Dropped() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.dropped
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Close ends every subscription and refuses new ones, so open streams
+// finish and the server can shut down.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		h.remove(sub)
+	}
+}
@@ -0,0 +1,107 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+)
+
+func ids(events []Event) []uint64 {
+	out := make([]uint64, len(events))
+	for i, e := range events {
+		out[i] = e.ID
+	}
+	return out
+}
+
+func TestPublishDelivers(t *testing.T) {
+	h := NewHub(10)
+	sub, missed := h.Subscribe(0, 4)
+	defer sub.Close()
+	if len(missed) != 0 {
+		t.Fatalf("Subscribe(0) replayed %d events, want none", len(missed))
+	}
+
+	published := h.publish(ChirpEvent(ChirpCreated, store.Chirp{Body: "hello"}))
+	got := <-sub.C
+	if got != published || got.Chirp.Body != "hello" {
+		t.Errorf("received %+v, want %+v", got, published)
+	}
+	if next := h.publish(ChirpEvent(ChirpDeleted, store.Chirp{})); next.ID != published.ID+1 {
+		t.Errorf("next ID = %d, want %d", next.ID, published.ID+1)
+	}
+}
+
+func TestSubscribeReplays(t *testing.T) {
+	h := NewHub(3)
+	var all []Event
+	for range 5 {
+		all = append(all, h.publish(ChirpEvent(ChirpCreated, store.Chirp{})))
+	}
+
+	tests := []struct {
+		name   string
+		lastID uint64
+		want   []uint64
+	}{
+		{"No resume", 0, nil},
+		{"Up to date", all[4].ID, nil},
+		{"Missed two", all[2].ID, ids(all[3:])},
+		{"Older than buffer", all[0].ID, ids(all[2:])},
+		{"From before a restart", 1, ids(all[2:])},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, missed := h.Subscribe(tt.lastID, 1)
+			defer sub.Close()
+			got := ids(missed)
+			if len(got) != len(tt.want) {
+				t.Fatalf("replayed %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("replayed %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	h := NewHub(10)
+	slow, _ := h.Subscribe(0, 1)
+	fast, _ := h.Subscribe(0, 3)
+	defer fast.Close()
+
+	for range 2 {
+		h.publish(ChirpEvent(ChirpCreated, store.Chirp{}))
+	}
+
+	<-slow.C
+	if _, ok := <-slow.C; ok {
+		t.Error("slow subscriber still open, want it closed")
+	}
+	if !slow.Dropped() {
+		t.Error("Dropped() = false for the slow subscriber")
+	}
+	if len(fast.C) != 2 || fast.Dropped() {
+		t.Errorf("fast subscriber has %d events, dropped %v; want 2, false", len(fast.C), fast.Dropped())
+	}
+	// Closing a dropped subscription must not panic.
+	slow.Close()
+}
+
+func TestCloseEndsSubscriptions(t *testing.T) {
+	h := NewHub(10)
+	sub, _ := h.Subscribe(0, 1)
+	h.Close()
+	if _, ok := <-sub.C; ok {
+		t.Error("subscription still open after Close")
+	}
+	late, _ := h.Subscribe(0, 1)
+	if _, ok := <-late.C; ok {
+		t.Error("Subscribe after Close returned an open subscription")
+	}
+	// Publishing to a closed hub only records the event.
+	h.publish(ChirpEvent(ChirpCreated, store.Chirp{}))
+}
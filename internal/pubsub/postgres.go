@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	// notifyChannel is the LISTEN/NOTIFY channel events travel on.
+	notifyChannel = "chirpy_events"
+	// maxNotifyPayload is the largest payload Postgres accepts by default.
+	maxNotifyPayload = 7999
+	// listenerPingInterval is how often an idle listener checks its
+	// connection, since a dead one otherwise goes unnoticed.
+	listenerPingInterval = 90 * time.Second
+)
+
+// ChirpLoader fetches a chirp by ID. Postgres uses it for events whose
+// chirp didn't fit into a notification.
+type ChirpLoader func(ctx context.Context, id uuid.UUID) (store.Chirp, error)
+
+// Postgres is a Bus that reaches every instance connected to the same
+// database. Publish sends a NOTIFY, and Run LISTENs and hands what arrives,
+// including this instance's own events, to the local Hub its subscribers
+// are on.
+type Postgres struct {
+	*Hub
+	db        *sql.DB
+	dsn       string
+	loadChirp ChirpLoader
+}
+
+// NewPostgres returns a Postgres bus delivering to hub. dsn is the
+// connection string the listener opens its own connection with.
+func NewPostgres(db *sql.DB, dsn string, hub *Hub, loadChirp ChirpLoader) *Postgres {
+	return &Postgres{Hub: hub, db: db, dsn: dsn, loadChirp: loadChirp}
+}
+
+// notification is the NOTIFY payload. Chirp is left out, and only ChirpID
+// sent, when the chirp would make the payload too large.
+type notification struct {
+	Type    string       `json:"type"`
+	UserID  uuid.UUID    `json:"user_id"`
+	Chirp   *store.Chirp `json:"chirp,omitempty"`
+	ChirpID uuid.UUID    `json:"chirp_id,omitzero"`
+}
+
+func encodeNotification(e Event) (string, error) {
+	n := notification{Type: e.Type, UserID: e.UserID}
+	if e.Chirp.ID != uuid.Nil {
+		n.Chirp = &e.Chirp
+	}
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return "", err
+	}
+	if len(payload) <= maxNotifyPayload {
+		return string(payload), nil
+	}
+	if n.Chirp == nil {
+		return "", fmt.Errorf("%s event too large to notify", e.Type)
+	}
+	n.Chirp, n.ChirpID = nil, e.Chirp.ID
+	payload, err = json.Marshal(n)
+	return string(payload), err
+}
+
+// decodeNotification turns a payload back into an event, loading the chirp
+// if it had been left out. A chirp that is gone by then, as for a delete,
+// is passed on with just its ID and author.
+func (p *Postgres) decodeNotification(ctx context.Context, payload string) (Event, error) {
+	var n notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		return Event{}, err
+	}
+	e := Event{Type: n.Type, UserID: n.UserID}
+	switch {
+	case n.Chirp != nil:
+		e.Chirp = *n.Chirp
+	case n.ChirpID != uuid.Nil:
+		chirp, err := p.loadChirp(ctx, n.ChirpID)
+		if errors.Is(err, store.ErrNotFound) {
+			chirp = store.Chirp{ID: n.ChirpID, UserID: n.UserID}
+		} else if err != nil {
+			return Event{}, fmt.Errorf("loading chirp %s: %w", n.ChirpID, err)
+		}
+		e.Chirp = chirp
+	}
+	return e, nil
+}
+
+// Publish notifies every listening instance. The event reaches local
+// subscribers once it comes back through Run.
+func (p *Postgres) Publish(ctx context.Context, e Event) error {
+	payload, err := encodeNotification(e)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", notifyChannel, payload)
+	return err
+}
+
+// Run listens until ctx is done. The listener reconnects on its own;
+// whatever was sent while it was away is lost, so every reconnect is
+// announced to subscribers as a Resync event.
+func (p *Postgres) Run(ctx context.Context) error {
+	listener := pq.NewListener(p.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			slog.Warn("event listener disconnected", "error", err)
+		case pq.ListenerEventReconnected:
+			slog.Info("event listener reconnected")
+		case pq.ListenerEventConnectionAttemptFailed:
+			slog.Warn("event listener reconnect failed", "error", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(notifyChannel); err != nil {
+		return fmt.Errorf("listening on %s: %w", notifyChannel, err)
+	}
+
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			if n == nil {
+				p.Hub.Publish(ctx, Event{Type: Resync})
+				continue
+			}
+			e, err := p.decodeNotification(ctx, n.Extra)
+			if err != nil {
+				slog.Error("dropping event notification", "payload", n.Extra, "error", err)
+				continue
+			}
+			p.Hub.Publish(ctx, e)
+		case <-ping.C:
+			go listener.Ping()
+		}
+	}
+}
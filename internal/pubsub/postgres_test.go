@@ -0,0 +1,140 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+)
+
+func TestNotificationRoundTrip(t *testing.T) {
+	author := uuid.New()
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	small := store.Chirp{ID: uuid.New(), CreatedAt: created, UpdatedAt: created, Body: "hello", UserID: author}
+	large := small
+	large.ID = uuid.New()
+	large.Body = strings.Repeat("a", maxNotifyPayload)
+	gone := store.Chirp{ID: uuid.New(), Body: strings.Repeat("b", maxNotifyPayload), UserID: author}
+
+	var loaded []uuid.UUID
+	p := &Postgres{loadChirp: func(_ context.Context, id uuid.UUID) (store.Chirp, error) {
+		loaded = append(loaded, id)
+		if id == large.ID {
+			return large, nil
+		}
+		return store.Chirp{}, store.ErrNotFound
+	}}
+
+	tests := []struct {
+		name     string
+		event    Event
+		want     Event
+		wantLoad bool
+	}{
+		{"Chirp inline", ChirpEvent(ChirpCreated, small), ChirpEvent(ChirpCreated, small), false},
+		{"Chirp too large", ChirpEvent(ChirpCreated, large), ChirpEvent(ChirpCreated, large), true},
+		{
+			"Chirp too large and gone",
+			ChirpEvent(ChirpCreated, gone),
+			ChirpEvent(ChirpCreated, store.Chirp{ID: gone.ID, UserID: author}),
+			true,
+		},
+		{
+			"Deletion without body",
+			ChirpEvent(ChirpDeleted, gone),
+			Event{Type: ChirpDeleted, Chirp: store.Chirp{ID: gone.ID, UserID: author}, UserID: author},
+			false,
+		},
+		{"User event", Event{Type: UserUpgraded, UserID: author}, Event{Type: UserUpgraded, UserID: author}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loaded = nil
+			payload, err := encodeNotification(tt.event)
+			if err != nil {
+				t.Fatalf("encodeNotification() error = %v", err)
+			}
+			if len(payload) > maxNotifyPayload {
+				t.Errorf("payload is %d bytes, want at most %d", len(payload), maxNotifyPayload)
+			}
+			got, err := p.decodeNotification(context.Background(), payload)
+			if err != nil {
+				t.Fatalf("decodeNotification() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("decoded %+v, want %+v", got, tt.want)
+			}
+			if (len(loaded) > 0) != tt.wantLoad {
+				t.Errorf("loaded chirps %v, want a load: %v", loaded, tt.wantLoad)
+			}
+		})
+	}
+}
+
+func TestDecodeNotificationLoadError(t *testing.T) {
+	p := &Postgres{loadChirp: func(context.Context, uuid.UUID) (store.Chirp, error) {
+		return store.Chirp{}, errors.New("connection refused")
+	}}
+	payload, err := encodeNotification(ChirpEvent(ChirpCreated, store.Chirp{ID: uuid.New(), Body: strings.Repeat("a", maxNotifyPayload)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.decodeNotification(context.Background(), payload); err == nil {
+		t.Error("decodeNotification() succeeded although the chirp couldn't be loaded")
+	}
+}
+
+// TestPostgresDelivers checks events go through a real database named by
+// TEST_DB_URL, between two buses as if on two instances.
+func TestPostgresDelivers(t *testing.T) {
+	dbURL := os.Getenv("TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DB_URL not set")
+	}
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	noLoad := func(context.Context, uuid.UUID) (store.Chirp, error) { return store.Chirp{}, store.ErrNotFound }
+	sender := NewPostgres(db, dbURL, NewHub(10), noLoad)
+	receiver := NewPostgres(db, dbURL, NewHub(10), noLoad)
+	sub, _ := receiver.Subscribe(0, 4)
+	defer sub.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go receiver.Run(ctx)
+
+	want := Event{Type: UserUpgraded, UserID: uuid.New()}
+	// The listener may not be up yet, so keep sending until one arrives.
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+	for {
+		if err := sender.Publish(ctx, want); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+		select {
+		case got := <-sub.C:
+			if got.Type == Resync {
+				continue
+			}
+			if got.Type != want.Type || got.UserID != want.UserID {
+				t.Errorf("received %+v, want %+v", got, want)
+			}
+			return
+		case <-ticker.C:
+		case <-timeout:
+			t.Fatal("no event received")
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Package pubsub carries domain events, such as a chirp being created, from
+// the code that causes them to everything that reacts to them: event
+// streams, WebSocket connections and caches.
+package pubsub
+
+import (
+	"context"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+// Event types.
+const (
+	ChirpCreated = "chirp_created"
+	ChirpDeleted = "chirp_deleted"
+	UserUpgraded = "user_upgraded"
+	UserUpdated  = "user_updated"
+	// Resync tells subscribers they may have missed events, because the
+	// bus lost its connection or all data was reset. Caches should drop
+	// everything.
+	Resync = "resync"
+)
+
+// Event is a single change. Chirp is set for chirp events; UserID is the
+// user the event is about, the author for chirp events. IDs are assigned
+// on delivery and increase monotonically, also across restarts, because
+// the first one is taken from the clock.
+type Event struct {
+	ID     uint64
+	Type   string
+	Chirp  store.Chirp
+	UserID uuid.UUID
+}
+
+// ChirpEvent returns a chirp event of the given type for c. Deletion
+// events keep only the chirp's ID and author: the chirp may have been
+// hidden for what it says, and its body must not reach subscribers or the
+// replay buffer.
+func ChirpEvent(typ string, c store.Chirp) Event {
+	if typ == ChirpDeleted {
+		c = store.Chirp{ID: c.ID, UserID: c.UserID}
+	}
+	return Event{Type: typ, Chirp: c, UserID: c.UserID}
+}
+
+// Bus delivers published events to its subscribers. Hub does so within
+// this process, Postgres to every instance sharing the database.
+type Bus interface {
+	Publish(ctx context.Context, e Event) error
+	// Subscribe behaves like Hub.Subscribe.
+	Subscribe(lastID uint64, buffer int) (*Subscription, []Event)
+	// Close ends every subscription.
+	Close()
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many takes pass between sweeps of the full buckets.
+const sweepEvery = 1000
+
+// Memory is a Store that keeps the buckets of this instance in a map.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]bucket
+	takes   int
+	// now is the clock, swapped out by tests.
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will have refilled. A full bucket is the
+	// same as none, so it can be dropped from then on.
+	full time.Time
+}
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]bucket{}, now: time.Now}
+}
+
+func (m *Memory) Take(_ context.Context, key string, l Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.takes++
+	if m.takes%sweepEvery == 0 {
+		m.sweep(now)
+	}
+
+	tokens := float64(l.Burst)
+	if b, ok := m.buckets[key]; ok {
+		tokens = min(tokens, b.tokens+now.Sub(b.updated).Seconds()*l.Rate)
+	}
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	m.buckets[key] = bucket{
+		tokens:  tokens,
+		updated: now,
+		full:    now.Add(seconds((float64(l.Burst) - tokens) / l.Rate)),
+	}
+	return result(allowed, tokens, l), nil
+}
+
+func (m *Memory) Reset(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.buckets)
+	return nil
+}
+
+// sweep drops the buckets that have refilled by now.
+func (m *Memory) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+}
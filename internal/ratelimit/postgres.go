@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// sweepInterval is how often Run deletes the buckets that have refilled.
+const sweepInterval = time.Minute
+
+// Postgres is a Store keeping the buckets in the rate_limit_buckets table,
+// so every instance on the same database shares them. The refill is
+// computed with the database clock, which the instances agree on even
+// when theirs drift apart.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// takeQuery takes a token from the bucket of $1, refilled at $2 tokens a
+// second up to $3, and returns what is left. A missing bucket is created
+// full. If the refilled bucket holds less than a token the update is
+// skipped and no row is returned.
+const takeQuery = `
+INSERT INTO rate_limit_buckets AS b (key, tokens, updated_at, full_at)
+VALUES ($1, $3::float8 - 1, now(), now() + make_interval(secs => 1 / $2::float8))
+ON CONFLICT (key) DO UPDATE SET
+  tokens = LEAST($3::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $2::float8) - 1,
+  updated_at = now(),
+  full_at = now() + make_interval(secs =>
+    ($3::float8 - LEAST($3::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $2::float8) + 1) / $2::float8)
+WHERE LEAST($3::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $2::float8) >= 1
+RETURNING tokens`
+
+// peekQuery returns the tokens in the bucket of $1 as of now, for a take
+// that found too few.
+const peekQuery = `
+SELECT LEAST($3::float8, tokens + EXTRACT(EPOCH FROM now() - updated_at) * $2::float8)
+FROM rate_limit_buckets WHERE key = $1`
+
+func (p *Postgres) Take(ctx context.Context, key string, l Limit) (Result, error) {
+	// A denied take can find its bucket swept away if it refilled in
+	// between, in which case the second try gets a fresh one.
+	for range 2 {
+		var tokens float64
+		err := p.db.QueryRowContext(ctx, takeQuery, key, l.Rate, l.Burst).Scan(&tokens)
+		if err == nil {
+			return result(true, tokens, l), nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return Result{}, fmt.Errorf("taking token: %w", err)
+		}
+		err = p.db.QueryRowContext(ctx, peekQuery, key, l.Rate, l.Burst).Scan(&tokens)
+		if err == nil {
+			return result(false, tokens, l), nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return Result{}, fmt.Errorf("reading bucket: %w", err)
+		}
+	}
+	return Result{}, errors.New("taking token: bucket keeps disappearing")
+}
+
+func (p *Postgres) Reset(ctx context.Context) error {
+	if _, err := p.db.ExecContext(ctx, `DELETE FROM rate_limit_buckets`); err != nil {
+		return fmt.Errorf("resetting buckets: %w", err)
+	}
+	return nil
+}
+
+// Run deletes the buckets that have refilled until ctx is done, as they
+// are no different from missing ones. A failed sweep is retried at the
+// next interval.
+func (p *Postgres) Run(ctx context.Context) error {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := p.db.ExecContext(ctx, `DELETE FROM rate_limit_buckets WHERE full_at <= now()`); err != nil && ctx.Err() == nil {
+				slog.Warn("sweeping rate limit buckets", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Package ratelimit meters requests with token buckets. Every client key
+// has a bucket that refills at a steady rate up to its size, and each
+// request takes a token out of it. The in-process Memory store counts for
+// one instance; the Postgres store keeps the buckets in the database so a
+// limit holds across every instance sharing it.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit describes a bucket: it gains Rate tokens a second and holds at
+// most Burst of them. A new bucket starts full.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the state of a bucket after a request tried to take a token.
+type Result struct {
+	Allowed bool
+	// Remaining is how many whole tokens are left.
+	Remaining int
+	// RetryAfter is how long until the next token, for a denied request.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store keeps the buckets. Take is atomic per key, so concurrent requests
+// of one client never take the same token twice.
+type Store interface {
+	Take(ctx context.Context, key string, l Limit) (Result, error)
+	// Reset empties the store, refilling every bucket.
+	Reset(ctx context.Context) error
+}
+
+// result describes a bucket holding tokens after the take.
+func result(allowed bool, tokens float64, l Limit) Result {
+	res := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(l.Burst) - tokens) / l.Rate),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / l.Rate)
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(max(s, 0) * float64(time.Second))
+}
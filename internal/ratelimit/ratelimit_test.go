@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// testStore checks the behaviour every Store shares. advance lets at
+// least d pass on the store's clock.
+func testStore(t *testing.T, s Store, advance func(d time.Duration)) {
+	ctx := context.Background()
+	if err := s.Reset(ctx); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	// Ten tokens a second, so one every 100ms, and two at most.
+	l := Limit{Rate: 10, Burst: 2}
+
+	take := func(key string) Result {
+		t.Helper()
+		res, err := s.Take(ctx, key, l)
+		if err != nil {
+			t.Fatalf("Take(%s) error = %v", key, err)
+		}
+		return res
+	}
+
+	if res := take("a"); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("first Take(a) = %+v, want allowed with 1 remaining", res)
+	}
+	if res := take("a"); !res.Allowed || res.Remaining != 0 || res.Reset <= 0 || res.Reset > 200*time.Millisecond {
+		t.Errorf("second Take(a) = %+v, want allowed with 0 remaining, full within 200ms", res)
+	}
+	res := take("a")
+	if res.Allowed || res.Remaining != 0 {
+		t.Errorf("third Take(a) = %+v, want denied", res)
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > 100*time.Millisecond {
+		t.Errorf("third Take(a) retry after = %v, want within 100ms", res.RetryAfter)
+	}
+	if res := take("b"); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("Take(b) = %+v, want a bucket of its own", res)
+	}
+
+	advance(150 * time.Millisecond)
+	if res := take("a"); !res.Allowed {
+		t.Errorf("Take(a) after refill = %+v, want allowed", res)
+	}
+
+	if err := s.Reset(ctx); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if res := take("a"); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("Take(a) after Reset = %+v, want a full bucket", res)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	testStore(t, m, func(d time.Duration) { now = now.Add(d) })
+}
+
+func TestMemorySweep(t *testing.T) {
+	m := NewMemory()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+	l := Limit{Rate: 1, Burst: 5}
+
+	m.Take(ctx, "idle", l)
+	now = now.Add(time.Minute)
+	for range sweepEvery - 1 {
+		m.Take(ctx, "busy", l)
+	}
+	if _, ok := m.buckets["idle"]; ok {
+		t.Error("refilled bucket survived the sweep")
+	}
+	if _, ok := m.buckets["busy"]; !ok {
+		t.Error("draining bucket was swept")
+	}
+}
+
+// TestPostgres runs the store against a real database named by
+// TEST_DB_URL, which must be migrated. Its buckets are reset first.
+func TestPostgres(t *testing.T) {
+	dbURL := os.Getenv("TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DB_URL not set")
+	}
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	testStore(t, NewPostgres(db), time.Sleep)
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+const MaxChirpLength = 140
+
+// maxThreadDepth caps how many of a reply's ancestors GetThread loads.
+const maxThreadDepth = 50
+
+// CreateChirp posts a chirp with its profanity masked. Chirps the filter
+// caught go to the moderation queue as well.
+func (s *Service) CreateChirp(ctx context.Context, userID uuid.UUID, body string) (store.Chirp, error) {
+	return s.ReplyToChirp(ctx, userID, uuid.Nil, body)
+}
+
+// ReplyToChirp posts a chirp in reply to parentID like CreateChirp does,
+// or one that starts a thread if parentID is uuid.Nil. A parent the author
+// can't see is ErrNotFound.
+func (s *Service) ReplyToChirp(ctx context.Context, userID, parentID uuid.UUID, body string) (store.Chirp, error) {
+	if len(body) > MaxChirpLength {
+		return store.Chirp{}, ErrChirpTooLong
+	}
+	filtered, flagged := filterProfanity(body)
+	arg := store.CreateChirpParams{Body: filtered, UserID: userID, ParentID: parentID}
+	if !flagged && parentID == uuid.Nil {
+		return s.store.CreateChirp(ctx, arg)
+	}
+
+	var chirp store.Chirp
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		if parentID != uuid.Nil {
+			parent, err := tx.GetChirpByID(ctx, parentID)
+			if err != nil {
+				return err
+			}
+			if parent.HiddenAt.Valid && parent.UserID != userID {
+				return store.ErrNotFound
+			}
+		}
+		var err error
+		chirp, err = tx.CreateChirp(ctx, arg)
+		if err != nil || !flagged {
+			return err
+		}
+		_, err = tx.CreateReport(ctx, store.CreateReportParams{
+			ChirpID: chirp.ID,
+			Reason:  ReasonProfanity,
+			Details: "Flagged by the profanity filter",
+		})
+		return err
+	})
+	return chirp, err
+}
+
+// ListChirps returns all chirps, oldest first, or only those of authorID
+// unless it is uuid.Nil. Hidden chirps are left out, except for the
+// viewer's own; viewerID is uuid.Nil for anonymous viewers.
+func (s *Service) ListChirps(ctx context.Context, viewerID, authorID uuid.UUID) ([]store.Chirp, error) {
+	var chirps []store.Chirp
+	var err error
+	if authorID == uuid.Nil {
+		chirps, err = s.store.GetChirpsAsc(ctx)
+	} else {
+		chirps, err = s.store.GetChirpsByAuthor(ctx, authorID)
+	}
+	if err != nil || viewerID == uuid.Nil || (authorID != uuid.Nil && authorID != viewerID) {
+		return chirps, err
+	}
+
+	hidden, err := s.store.GetHiddenChirpsByAuthor(ctx, viewerID)
+	if err != nil || len(hidden) == 0 {
+		return chirps, err
+	}
+	chirps = append(slices.Clone(chirps), hidden...)
+	slices.SortFunc(chirps, func(a, b store.Chirp) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID.String(), b.ID.String())
+	})
+	return chirps, nil
+}
+
+// ListChirpPage returns up to limit chirps newest first, like ListChirps
+// does oldest first. Unless cursorID is uuid.Nil, the page continues past
+// that chirp, the last of the previous page; a cursor the viewer can't
+// see is ErrNotFound.
+func (s *Service) ListChirpPage(ctx context.Context, viewerID, authorID, cursorID uuid.UUID, limit int) ([]store.Chirp, error) {
+	arg := store.ChirpPageParams{AuthorID: authorID, ViewerID: viewerID, Limit: limit}
+	if cursorID != uuid.Nil {
+		cursor, err := s.GetChirp(ctx, viewerID, cursorID)
+		if err != nil {
+			return nil, err
+		}
+		arg.Cursor = &cursor
+	}
+	return s.store.GetChirpPage(ctx, arg)
+}
+
+// CountChirps counts the chirps of authorID that viewerID, uuid.Nil for
+// anonymous viewers, gets to see.
+func (s *Service) CountChirps(ctx context.Context, viewerID, authorID uuid.UUID) (int, error) {
+	n, err := s.store.CountChirpsByAuthor(ctx, authorID)
+	if err != nil || viewerID == authorID {
+		return n, err
+	}
+	// Few chirps get hidden, so they can be loaded to be left out.
+	hidden, err := s.store.GetHiddenChirpsByAuthor(ctx, authorID)
+	return n - len(hidden), err
+}
+
+// GetChirp returns a chirp to viewerID, who is uuid.Nil for anonymous
+// viewers. Hidden chirps are only found by their author.
+func (s *Service) GetChirp(ctx context.Context, viewerID, id uuid.UUID) (store.Chirp, error) {
+	chirp, err := s.store.GetChirpByID(ctx, id)
+	if err == nil && chirp.HiddenAt.Valid && chirp.UserID != viewerID {
+		return store.Chirp{}, store.ErrNotFound
+	}
+	return chirp, err
+}
+
+// Thread is a chirp together with the chirps around it that the viewer
+// gets to see.
+type Thread struct {
+	// Ancestors are the chirps Chirp replies to, the first of the thread
+	// first. They start below the first one the viewer can't see.
+	Ancestors []store.Chirp
+	Chirp     store.Chirp
+	// Replies are the direct replies to Chirp, oldest first.
+	Replies []store.Chirp
+}
+
+// GetThread returns the chirp id as GetChirp does, along with its
+// ancestors and its replies. Hidden chirps are left out, except for the
+// viewer's own.
+func (s *Service) GetThread(ctx context.Context, viewerID, id uuid.UUID) (Thread, error) {
+	chirp, err := s.GetChirp(ctx, viewerID, id)
+	if err != nil {
+		return Thread{}, err
+	}
+	thread := Thread{Chirp: chirp}
+
+	for parentID := chirp.ParentID; parentID.Valid && len(thread.Ancestors) < maxThreadDepth; {
+		parent, err := s.GetChirp(ctx, viewerID, parentID.UUID)
+		if errors.Is(err, store.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return Thread{}, err
+		}
+		thread.Ancestors = append(thread.Ancestors, parent)
+		parentID = parent.ParentID
+	}
+	slices.Reverse(thread.Ancestors)
+
+	thread.Replies, err = s.listReplies(ctx, viewerID, id)
+	if err != nil {
+		return Thread{}, err
+	}
+	return thread, nil
+}
+
+// ListReplies returns the direct replies to the chirp id, oldest first.
+// Hidden replies are left out, except for the viewer's own, and a chirp
+// the viewer can't see is ErrNotFound.
+func (s *Service) ListReplies(ctx context.Context, viewerID, id uuid.UUID) ([]store.Chirp, error) {
+	if _, err := s.GetChirp(ctx, viewerID, id); err != nil {
+		return nil, err
+	}
+	return s.listReplies(ctx, viewerID, id)
+}
+
+func (s *Service) listReplies(ctx context.Context, viewerID, id uuid.UUID) ([]store.Chirp, error) {
+	replies, err := s.store.GetChirpReplies(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	visible := []store.Chirp{}
+	for _, r := range replies {
+		if !r.HiddenAt.Valid || r.UserID == viewerID {
+			visible = append(visible, r)
+		}
+	}
+	return visible, nil
+}
+
+// DeleteChirp deletes a chirp on behalf of userID. The ownership check and
+// the delete share a transaction, so the chirp can't change hands or
+// disappear in between. It returns the chirp as it was before the delete.
+func (s *Service) DeleteChirp(ctx context.Context, userID, chirpID uuid.UUID) (store.Chirp, error) {
+	var deleted store.Chirp
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		chirp, err := tx.GetChirpByID(ctx, chirpID)
+		if err != nil {
+			return err
+		}
+		if chirp.UserID != userID {
+			return ErrForbidden
+		}
+		deleted = chirp
+		return tx.DeleteChirp(ctx, chirpID)
+	})
+	if err != nil {
+		return store.Chirp{}, err
+	}
+	return deleted, nil
+}
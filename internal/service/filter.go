@@ -1,8 +1,10 @@
-package main
+package service
 
 import "strings"
 
-func filterProfanity(msg string) string {
+// filterProfanity masks the banned words in msg and reports whether it
+// found any.
+func filterProfanity(msg string) (string, bool) {
 	var filteredWords = map[string]struct{}{
 		"kerfuffle": {},
 		"sharbert":  {},
@@ -10,12 +12,14 @@ func filterProfanity(msg string) string {
 	}
 
 	words := strings.Split(msg, " ")
+	flagged := false
 	for i, word := range words {
 		_, isInList := filteredWords[strings.ToLower(word)]
 		if isInList {
 			words[i] = "****"
+			flagged = true
 		}
 	}
 
-	return strings.Join(words, " ")
+	return strings.Join(words, " "), flagged
 }
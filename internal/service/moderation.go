@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+// Reasons a chirp can be reported for. ReasonProfanity is also what the
+// profanity filter files its reports under.
+const (
+	ReasonSpam       = "spam"
+	ReasonHarassment = "harassment"
+	ReasonHate       = "hate"
+	ReasonViolence   = "violence"
+	ReasonProfanity  = "profanity"
+	ReasonOther      = "other"
+)
+
+var ReportReasons = []string{ReasonSpam, ReasonHarassment, ReasonHate, ReasonViolence, ReasonProfanity, ReasonOther}
+
+// Resolutions record how a moderator closed a report.
+const (
+	ResolutionDismissed       = "dismissed"
+	ResolutionChirpHidden     = "chirp_hidden"
+	ResolutionAuthorSuspended = "author_suspended"
+)
+
+const MaxReportDetailsLength = 500
+
+// QueueItem is a report together with the chirp it is about.
+type QueueItem struct {
+	Report store.Report
+	Chirp  store.Chirp
+}
+
+// ReportChirp files a report by reporterID. Users can't report their own
+// chirps, chirps hidden from them or the same chirp twice.
+func (s *Service) ReportChirp(ctx context.Context, reporterID, chirpID uuid.UUID, reason, details string) (store.Report, error) {
+	if !slices.Contains(ReportReasons, reason) {
+		return store.Report{}, fmt.Errorf("%w: %q", ErrInvalidReason, reason)
+	}
+	if len(details) > MaxReportDetailsLength {
+		return store.Report{}, ErrReportTooLong
+	}
+
+	var report store.Report
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		chirp, err := tx.GetChirpByID(ctx, chirpID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case chirp.UserID == reporterID:
+			return ErrOwnChirp
+		case chirp.HiddenAt.Valid:
+			return store.ErrNotFound
+		}
+		report, err = tx.CreateReport(ctx, store.CreateReportParams{
+			ChirpID:    chirpID,
+			ReporterID: uuid.NullUUID{UUID: reporterID, Valid: true},
+			Reason:     reason,
+			Details:    details,
+		})
+		return err
+	})
+	return report, err
+}
+
+// ModerationQueue returns up to limit reports with status, or the
+// unresolved ones if status is empty, oldest first.
+func (s *Service) ModerationQueue(ctx context.Context, status string, limit int) ([]QueueItem, error) {
+	if status != "" && !slices.Contains([]string{store.ReportOpen, store.ReportClaimed, store.ReportResolved}, status) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+	var items []QueueItem
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		reports, err := tx.ListReports(ctx, status, limit)
+		if err != nil {
+			return err
+		}
+		items = make([]QueueItem, len(reports))
+		for i, r := range reports {
+			items[i].Report = r
+			if items[i].Chirp, err = tx.GetChirpByID(ctx, r.ChirpID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// ClaimReport assigns an open report to moderatorID, so other moderators
+// leave it alone.
+func (s *Service) ClaimReport(ctx context.Context, moderatorID, reportID uuid.UUID) (store.Report, error) {
+	return s.moderate(ctx, moderatorID, reportID, func(tx store.Store, _ store.Report) error {
+		return tx.ClaimReport(ctx, reportID, moderatorID)
+	})
+}
+
+// DismissReport resolves a report without acting on the chirp.
+func (s *Service) DismissReport(ctx context.Context, moderatorID, reportID uuid.UUID) (store.Report, error) {
+	return s.moderate(ctx, moderatorID, reportID, func(tx store.Store, _ store.Report) error {
+		return tx.ResolveReport(ctx, store.ResolveReportParams{
+			ID:          reportID,
+			ModeratorID: moderatorID,
+			Resolution:  ResolutionDismissed,
+		})
+	})
+}
+
+// HideReportedChirp hides the reported chirp from everyone but its author
+// and resolves every open report on it. It returns the report and the
+// hidden chirp.
+func (s *Service) HideReportedChirp(ctx context.Context, moderatorID, reportID uuid.UUID) (store.Report, store.Chirp, error) {
+	var chirp store.Chirp
+	report, err := s.moderate(ctx, moderatorID, reportID, func(tx store.Store, r store.Report) error {
+		if err := tx.SetChirpHidden(ctx, r.ChirpID, true); err != nil {
+			return err
+		}
+		_, err := tx.ResolveChirpReports(ctx, store.ResolveChirpReportsParams{
+			ChirpID:     r.ChirpID,
+			ModeratorID: moderatorID,
+			Resolution:  ResolutionChirpHidden,
+		})
+		if err != nil {
+			return err
+		}
+		chirp, err = tx.GetChirpByID(ctx, r.ChirpID)
+		return err
+	})
+	return report, chirp, err
+}
+
+// SuspendReportedAuthor suspends the author of the reported chirp, as
+// Suspend does, and resolves the report. Moderators can't suspend
+// authors whose role is at or above their own.
+func (s *Service) SuspendReportedAuthor(ctx context.Context, moderatorID, reportID uuid.UUID) (store.Report, uuid.UUID, error) {
+	var authorID uuid.UUID
+	report, err := s.moderate(ctx, moderatorID, reportID, func(tx store.Store, r store.Report) error {
+		chirp, err := tx.GetChirpByID(ctx, r.ChirpID)
+		if err != nil {
+			return err
+		}
+		if chirp.UserID == moderatorID {
+			return ErrSelfAction
+		}
+		moderator, err := tx.GetUserByID(ctx, moderatorID)
+		if err != nil {
+			return err
+		}
+		author, err := tx.GetUserByID(ctx, chirp.UserID)
+		if err != nil {
+			return err
+		}
+		if slices.Index(Roles, author.Role) >= slices.Index(Roles, moderator.Role) {
+			return ErrTargetPrivileged
+		}
+		if err := suspend(ctx, tx, chirp.UserID); err != nil {
+			return err
+		}
+		authorID = chirp.UserID
+		return tx.ResolveReport(ctx, store.ResolveReportParams{
+			ID:          reportID,
+			ModeratorID: moderatorID,
+			Resolution:  ResolutionAuthorSuspended,
+		})
+	})
+	return report, authorID, err
+}
+
+// moderate runs act on a report that is still open or claimed by
+// moderatorID, and returns the report as act left it.
+func (s *Service) moderate(ctx context.Context, moderatorID, reportID uuid.UUID, act func(store.Store, store.Report) error) (store.Report, error) {
+	var report store.Report
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		r, err := tx.GetReport(ctx, reportID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case r.Status == store.ReportResolved:
+			return ErrReportResolved
+		case r.Status == store.ReportClaimed && r.ModeratorID.UUID != moderatorID:
+			return ErrReportClaimed
+		}
+		if err := act(tx, r); err != nil {
+			return err
+		}
+		report, err = tx.GetReport(ctx, reportID)
+		return err
+	})
+	return report, err
+}
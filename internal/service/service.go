@@ -0,0 +1,138 @@
+// Package service holds Chirpy's business logic. HTTP handlers, CLI
+// commands and background workers all call into a Service instead of
+// talking to the store directly, so multi-step operations run in one
+// transaction no matter who triggers them.
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/auth"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var tracer = otel.Tracer("github.com/TheMaru/go-http-server/internal/service")
+
+var (
+	// ErrInvalidCredentials is returned by Login for an unknown email or a
+	// wrong password alike, so callers can't tell which one it was.
+	ErrInvalidCredentials = errors.New("incorrect email or password")
+	// ErrForbidden is returned when a user acts on something they don't own.
+	ErrForbidden = errors.New("forbidden")
+	// ErrChirpTooLong is returned for chirps over MaxChirpLength.
+	ErrChirpTooLong = errors.New("chirp is too long")
+	// ErrTokenRevoked and ErrTokenExpired are returned by Refresh.
+	ErrTokenRevoked = errors.New("refresh token revoked")
+	ErrTokenExpired = errors.New("refresh token expired")
+	// ErrInvalidRole is returned for a role that isn't one of the
+	// store.Role constants.
+	ErrInvalidRole = errors.New("invalid role")
+	// ErrPasswordRequired is returned by BootstrapAdmin when it has to
+	// create the admin but wasn't given a password, and by ResetPassword
+	// without a new password.
+	ErrPasswordRequired = errors.New("password required")
+	// ErrSuspended is returned by Login and Refresh for suspended users.
+	ErrSuspended = errors.New("account suspended")
+	// ErrPasswordResetRequired is returned by Login when an admin forced a
+	// password reset, which only ResetPassword gets past.
+	ErrPasswordResetRequired = errors.New("password reset required")
+	// ErrSelfAction is returned when an admin tries to suspend or delete
+	// their own account.
+	ErrSelfAction = errors.New("can't do this to your own account")
+	// ErrTargetPrivileged is returned when a moderator tries to suspend a
+	// user whose role is at or above their own.
+	ErrTargetPrivileged = errors.New("can't do this to a user with the same or a higher role")
+	// ErrInvalidReason, ErrReportTooLong and ErrOwnChirp are returned by
+	// ReportChirp.
+	ErrInvalidReason = errors.New("invalid report reason")
+	ErrReportTooLong = errors.New("report details are too long")
+	ErrOwnChirp      = errors.New("can't report your own chirp")
+	// ErrInvalidStatus is returned for a report status that isn't one of
+	// the store.Report constants.
+	ErrInvalidStatus = errors.New("invalid report status")
+	// ErrReportClaimed and ErrReportResolved are returned when a moderator
+	// acts on a report another moderator claimed or that is closed.
+	ErrReportClaimed  = errors.New("report claimed by another moderator")
+	ErrReportResolved = errors.New("report already resolved")
+	// ErrAuditTampered is returned by VerifyAuditLog for an audit log that
+	// was changed after the fact.
+	ErrAuditTampered = errors.New("audit log tampered with")
+	// ErrUnknownTable and ErrInvalidFixtures are returned by the test
+	// support calls.
+	ErrUnknownTable    = errors.New("unknown table")
+	ErrInvalidFixtures = errors.New("invalid fixtures")
+)
+
+const (
+	// maxTxAttempts bounds how often WithTx runs a transaction that keeps
+	// losing serialization conflicts.
+	maxTxAttempts = 5
+	txRetryBase   = 10 * time.Millisecond
+)
+
+type Config struct {
+	Secret          string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+type Service struct {
+	store store.Store
+	cfg   Config
+}
+
+func New(s store.Store, cfg Config) *Service {
+	return &Service{store: s, cfg: cfg}
+}
+
+// WithTx runs fn in a transaction and runs it again, after a short jittered
+// backoff, when the database aborted it with a serialization failure. fn may
+// therefore run more than once and must not have side effects outside the
+// store it is given.
+func (s *Service) WithTx(ctx context.Context, fn func(store.Store) error) error {
+	for attempt := 1; ; attempt++ {
+		err := s.store.InTx(ctx, fn)
+		if !errors.Is(err, store.ErrSerialization) || attempt == maxTxAttempts {
+			return err
+		}
+
+		backoff := txRetryBase << (attempt - 1)
+		backoff += rand.N(backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// hashPassword and checkPasswordHash wrap the auth helpers in spans;
+// argon2 is deliberately slow and tends to dominate login and signup.
+func hashPassword(ctx context.Context, password string) (string, error) {
+	_, span := tracer.Start(ctx, "auth.HashPassword")
+	defer span.End()
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return hash, err
+}
+
+func checkPasswordHash(ctx context.Context, password, hash string) (bool, error) {
+	_, span := tracer.Start(ctx, "auth.CheckPasswordHash")
+	defer span.End()
+	match, err := auth.CheckPasswordHash(password, hash)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(attribute.Bool("auth.match", match))
+	return match, err
+}
@@ -0,0 +1,1018 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/auth"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func newTestService(s store.Store) *Service {
+	return New(s, Config{
+		Secret:          testSecret,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: 24 * time.Hour,
+	})
+}
+
+// conflictingStore fails the first conflicts transactions the way a
+// database does when two serializable transactions collide.
+type conflictingStore struct {
+	*store.Memory
+	conflicts int
+	attempts  int
+}
+
+func (c *conflictingStore) InTx(ctx context.Context, fn func(store.Store) error) error {
+	c.attempts++
+	if c.attempts <= c.conflicts {
+		return store.ErrSerialization
+	}
+	return c.Memory.InTx(ctx, fn)
+}
+
+func TestWithTxRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		conflicts    int
+		wantAttempts int
+		wantErr      error
+	}{
+		{
+			name:         "No conflict",
+			conflicts:    0,
+			wantAttempts: 1,
+		},
+		{
+			name:         "Succeeds after conflicts",
+			conflicts:    2,
+			wantAttempts: 3,
+		},
+		{
+			name:         "Gives up",
+			conflicts:    100,
+			wantAttempts: maxTxAttempts,
+			wantErr:      store.ErrSerialization,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &conflictingStore{Memory: store.NewMemory(), conflicts: tt.conflicts}
+			err := newTestService(s).WithTx(context.Background(), func(store.Store) error { return nil })
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("WithTx() error = %v, want %v", err, tt.wantErr)
+			}
+			if s.attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", s.attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	svc := newTestService(s)
+	user, err := svc.CreateUser(ctx, "walt@example.com", "heisenberg")
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		wantErr  error
+	}{
+		{
+			name:     "Correct password",
+			email:    "walt@example.com",
+			password: "heisenberg",
+		},
+		{
+			name:     "Wrong password",
+			email:    "walt@example.com",
+			password: "mr. white",
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "Unknown email",
+			email:    "jesse@example.com",
+			password: "heisenberg",
+			wantErr:  ErrInvalidCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := svc.Login(ctx, tt.email, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Login() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			userID, err := auth.ValidateJWT(session.AccessToken, testSecret)
+			if err != nil || userID != user.ID {
+				t.Errorf("access token is for %v (err %v), want %v", userID, err, user.ID)
+			}
+			stored, err := s.GetRefreshToken(ctx, session.RefreshToken)
+			if err != nil || stored.UserID != user.ID {
+				t.Errorf("refresh token not stored for the user: %+v, %v", stored, err)
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	svc := newTestService(s)
+	user, _ := svc.CreateUser(ctx, "skyler@example.com", "pw")
+
+	create := func(token string, expiresIn time.Duration) {
+		t.Helper()
+		_, err := s.CreateRefreshToken(ctx, store.CreateRefreshTokenParams{
+			Token:     token,
+			UserID:    user.ID,
+			ExpiresAt: time.Now().Add(expiresIn),
+		})
+		if err != nil {
+			t.Fatalf("CreateRefreshToken() error = %v", err)
+		}
+	}
+	create("valid", time.Hour)
+	create("expired", -time.Hour)
+	create("revoked", time.Hour)
+	if _, err := svc.Revoke(ctx, "revoked"); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+
+	tests := []struct {
+		token   string
+		wantErr error
+	}{
+		{token: "valid"},
+		{token: "expired", wantErr: ErrTokenExpired},
+		{token: "revoked", wantErr: ErrTokenRevoked},
+		{token: "unknown", wantErr: store.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			accessToken, _, err := svc.Refresh(ctx, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Refresh() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && accessToken == "" {
+				t.Error("Refresh() returned an empty access token")
+			}
+		})
+	}
+}
+
+func TestCreateChirp(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	user, _ := svc.CreateUser(ctx, "hank@example.com", "pw")
+
+	chirp, err := svc.CreateChirp(ctx, user.ID, "what a Kerfuffle this is")
+	if err != nil {
+		t.Fatalf("CreateChirp() error = %v", err)
+	}
+	if chirp.Body != "what a **** this is" {
+		t.Errorf("body = %q, want profanity filtered", chirp.Body)
+	}
+	queue, _ := svc.ModerationQueue(ctx, "", 10)
+	if len(queue) != 1 || queue[0].Chirp.ID != chirp.ID || queue[0].Report.Reason != ReasonProfanity {
+		t.Errorf("moderation queue = %+v, want the filtered chirp", queue)
+	}
+	if _, err := svc.CreateChirp(ctx, user.ID, "all clean"); err != nil {
+		t.Fatalf("CreateChirp() error = %v", err)
+	}
+	if queue, _ := svc.ModerationQueue(ctx, "", 10); len(queue) != 1 {
+		t.Errorf("moderation queue has %d reports after a clean chirp, want 1", len(queue))
+	}
+
+	_, err = svc.CreateChirp(ctx, user.ID, strings.Repeat("a", MaxChirpLength+1))
+	if !errors.Is(err, ErrChirpTooLong) {
+		t.Errorf("CreateChirp(too long) error = %v, want %v", err, ErrChirpTooLong)
+	}
+}
+
+func TestDeleteChirp(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	owner, _ := svc.CreateUser(ctx, "gus@example.com", "pw")
+	other, _ := svc.CreateUser(ctx, "hector@example.com", "pw")
+	chirp, err := svc.CreateChirp(ctx, owner.ID, "los pollos")
+	if err != nil {
+		t.Fatalf("CreateChirp() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		userID  uuid.UUID
+		chirpID uuid.UUID
+		wantErr error
+	}{
+		{
+			name:    "Someone else's chirp",
+			userID:  other.ID,
+			chirpID: chirp.ID,
+			wantErr: ErrForbidden,
+		},
+		{
+			name:    "Own chirp",
+			userID:  owner.ID,
+			chirpID: chirp.ID,
+		},
+		{
+			name:    "Already deleted",
+			userID:  owner.ID,
+			chirpID: chirp.ID,
+			wantErr: store.ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted, err := svc.DeleteChirp(ctx, tt.userID, tt.chirpID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("DeleteChirp() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && deleted.ID != chirp.ID {
+				t.Errorf("DeleteChirp() returned chirp %v, want %v", deleted.ID, chirp.ID)
+			}
+		})
+	}
+}
+
+func TestThreads(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	svc := newTestService(s)
+	walt, _ := svc.CreateUser(ctx, "walt@example.com", "pw")
+	jesse, _ := svc.CreateUser(ctx, "jesse@example.com", "pw")
+
+	reply := func(userID, parentID uuid.UUID, body string) store.Chirp {
+		t.Helper()
+		c, err := svc.ReplyToChirp(ctx, userID, parentID, body)
+		if err != nil {
+			t.Fatalf("ReplyToChirp(%s) error = %v", body, err)
+		}
+		return c
+	}
+	root := reply(walt.ID, uuid.Nil, "we need to cook")
+	answer := reply(jesse.ID, root.ID, "yeah science")
+	last := reply(walt.ID, answer.ID, "say my name")
+	if answer.ParentID.UUID != root.ID || last.ParentID.UUID != answer.ID {
+		t.Fatalf("replies = %+v and %+v, want them chained to %s", answer, last, root.ID)
+	}
+	flagged := reply(jesse.ID, root.ID, "what a Kerfuffle")
+	if queue, _ := svc.ModerationQueue(ctx, "", 10); len(queue) != 1 || queue[0].Chirp.ParentID.UUID != root.ID {
+		t.Errorf("moderation queue = %+v, want the filtered reply", queue)
+	}
+	if _, err := svc.ReplyToChirp(ctx, jesse.ID, uuid.New(), "hello?"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("ReplyToChirp(unknown parent) error = %v, want %v", err, store.ErrNotFound)
+	}
+
+	ids := func(chirps []store.Chirp) []uuid.UUID {
+		ids := []uuid.UUID{}
+		for _, c := range chirps {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+	check := func(name string, viewerID, id uuid.UUID, ancestors, replies []uuid.UUID) {
+		t.Helper()
+		thread, err := svc.GetThread(ctx, viewerID, id)
+		if err != nil {
+			t.Fatalf("%s: GetThread() error = %v", name, err)
+		}
+		if got := ids(thread.Ancestors); !slices.Equal(got, ancestors) {
+			t.Errorf("%s: ancestors = %v, want %v", name, got, ancestors)
+		}
+		// Replies created in the same instant are ordered by ID.
+		got := ids(thread.Replies)
+		if len(got) != len(replies) || slices.ContainsFunc(replies, func(id uuid.UUID) bool { return !slices.Contains(got, id) }) {
+			t.Errorf("%s: replies = %v, want %v", name, got, replies)
+		}
+	}
+	check("Root", uuid.Nil, root.ID, []uuid.UUID{}, []uuid.UUID{answer.ID, flagged.ID})
+	check("Leaf", uuid.Nil, last.ID, []uuid.UUID{root.ID, answer.ID}, []uuid.UUID{})
+
+	if err := s.SetChirpHidden(ctx, answer.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	check("Hidden ancestor", uuid.Nil, last.ID, []uuid.UUID{}, []uuid.UUID{})
+	check("Own hidden ancestor", jesse.ID, last.ID, []uuid.UUID{root.ID, answer.ID}, []uuid.UUID{})
+	check("Hidden reply", walt.ID, root.ID, []uuid.UUID{}, []uuid.UUID{flagged.ID})
+	check("Own hidden reply", jesse.ID, root.ID, []uuid.UUID{}, []uuid.UUID{answer.ID, flagged.ID})
+	if _, err := svc.ReplyToChirp(ctx, walt.ID, answer.ID, "no"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("ReplyToChirp(hidden parent) error = %v, want %v", err, store.ErrNotFound)
+	}
+	reply(jesse.ID, answer.ID, "still here")
+	if _, err := svc.GetThread(ctx, walt.ID, answer.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetThread(hidden) error = %v, want %v", err, store.ErrNotFound)
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	existing, _ := svc.CreateUser(ctx, "marie@example.com", "pw")
+
+	tests := []struct {
+		name        string
+		email       string
+		password    string
+		wantCreated bool
+		wantErr     error
+	}{
+		{name: "Promotes existing user", email: "marie@example.com"},
+		{name: "Creates missing user", email: "admin@example.com", password: "secret", wantCreated: true},
+		{name: "Needs a password to create", email: "nobody@example.com", wantErr: ErrPasswordRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin, created, err := svc.BootstrapAdmin(ctx, tt.email, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("BootstrapAdmin() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if created != tt.wantCreated || admin.Role != store.RoleAdmin || admin.Email != tt.email {
+				t.Errorf("BootstrapAdmin() = %q %q created %v, want an admin created %v", admin.Email, admin.Role, created, tt.wantCreated)
+			}
+		})
+	}
+
+	if _, err := svc.Login(ctx, "admin@example.com", "secret"); err != nil {
+		t.Errorf("created admin can't log in: %v", err)
+	}
+	if err := svc.SetRole(ctx, existing.ID, "root"); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("SetRole(root) error = %v, want %v", err, ErrInvalidRole)
+	}
+}
+
+func TestSuspend(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	admin, _ := svc.CreateUser(ctx, "admin@example.com", "pw")
+	user, _ := svc.CreateUser(ctx, "tuco@example.com", "pw")
+	session, err := svc.Login(ctx, "tuco@example.com", "pw")
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+
+	if err := svc.Suspend(ctx, admin.ID, admin.ID); !errors.Is(err, ErrSelfAction) {
+		t.Errorf("Suspend(self) error = %v, want %v", err, ErrSelfAction)
+	}
+	if err := svc.Suspend(ctx, admin.ID, user.ID); err != nil {
+		t.Fatalf("Suspend() error = %v", err)
+	}
+	if _, err := svc.Login(ctx, "tuco@example.com", "pw"); !errors.Is(err, ErrSuspended) {
+		t.Errorf("Login() while suspended error = %v, want %v", err, ErrSuspended)
+	}
+	if _, _, err := svc.Refresh(ctx, session.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Refresh() of old session error = %v, want %v", err, ErrTokenRevoked)
+	}
+
+	if err := svc.Unsuspend(ctx, user.ID); err != nil {
+		t.Fatalf("Unsuspend() error = %v", err)
+	}
+	if _, err := svc.Login(ctx, "tuco@example.com", "pw"); err != nil {
+		t.Errorf("Login() after unsuspend error = %v", err)
+	}
+}
+
+func TestRefreshSuspended(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	svc := newTestService(s)
+	user, _ := svc.CreateUser(ctx, "lalo@example.com", "pw")
+	session, err := svc.Login(ctx, "lalo@example.com", "pw")
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	// Suspend without revoking, as a suspension racing a login might.
+	if err := s.SetUserSuspended(ctx, user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := svc.Refresh(ctx, session.RefreshToken); !errors.Is(err, ErrSuspended) {
+		t.Errorf("Refresh() error = %v, want %v", err, ErrSuspended)
+	}
+}
+
+func TestForcePasswordReset(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	user, _ := svc.CreateUser(ctx, "saul@example.com", "old")
+	stale, err := svc.ForcePasswordReset(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("ForcePasswordReset() error = %v", err)
+	}
+	token, err := svc.ForcePasswordReset(ctx, user.ID)
+	if err != nil || token == stale {
+		t.Fatalf("ForcePasswordReset() again = %q, %v, want a new token", token, err)
+	}
+
+	// The steps run in order. Knowing the old password is no longer
+	// enough: that may be what leaked.
+	tests := []struct {
+		name        string
+		password    string
+		resetToken  string
+		newPassword string
+		wantErr     error
+	}{
+		{name: "Old password", password: "old", wantErr: ErrPasswordResetRequired},
+		{name: "Old password as token", resetToken: "old", newPassword: "mine", wantErr: ErrInvalidCredentials},
+		{name: "Replaced token", resetToken: stale, newPassword: "mine", wantErr: ErrInvalidCredentials},
+		{name: "Token without new password", resetToken: token, wantErr: ErrPasswordRequired},
+		{name: "Token", resetToken: token, newPassword: "new"},
+		{name: "Token again", resetToken: token, newPassword: "mine", wantErr: ErrInvalidCredentials},
+		{name: "New password", password: "new"},
+		{name: "Old password after reset", password: "old", wantErr: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var session Session
+			var err error
+			if tt.password != "" {
+				session, err = svc.Login(ctx, "saul@example.com", tt.password)
+			} else {
+				session, err = svc.ResetPassword(ctx, "saul@example.com", tt.resetToken, tt.newPassword)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && session.User.PasswordResetRequired {
+				t.Error("session user still needs a password reset")
+			}
+		})
+	}
+}
+
+func TestResetPasswordWithoutReset(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	svc.CreateUser(ctx, "kim@example.com", "pw")
+	if _, err := svc.ResetPassword(ctx, "kim@example.com", "", "new"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("ResetPassword() error = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestUserDetails(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	admin, _ := svc.CreateUser(ctx, "admin@example.com", "pw")
+	user, _ := svc.CreateUser(ctx, "mike@example.com", "pw")
+	svc.CreateChirp(ctx, user.ID, "half measures")
+	svc.Login(ctx, "mike@example.com", "pw")
+	svc.Login(ctx, "mike@example.com", "pw")
+
+	d, err := svc.UserDetails(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("UserDetails() error = %v", err)
+	}
+	if d.User.ID != user.ID || d.ChirpCount != 1 || len(d.Sessions) != 2 {
+		t.Errorf("UserDetails() = %v with %d chirps and %d sessions, want 1 and 2", d.User.ID, d.ChirpCount, len(d.Sessions))
+	}
+
+	if n, err := svc.RevokeSessions(ctx, user.ID); n != 2 || err != nil {
+		t.Errorf("RevokeSessions() = %d, %v, want 2", n, err)
+	}
+	if _, err := svc.RevokeSessions(ctx, uuid.New()); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("RevokeSessions(unknown) error = %v, want %v", err, store.ErrNotFound)
+	}
+
+	if err := svc.DeleteUser(ctx, admin.ID, admin.ID); !errors.Is(err, ErrSelfAction) {
+		t.Errorf("DeleteUser(self) error = %v, want %v", err, ErrSelfAction)
+	}
+	if err := svc.DeleteUser(ctx, admin.ID, user.ID); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+	if _, err := svc.UserDetails(ctx, user.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("UserDetails(deleted) error = %v, want %v", err, store.ErrNotFound)
+	}
+}
+
+func TestReportChirp(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	author, _ := svc.CreateUser(ctx, "jesse@example.com", "pw")
+	reporter, _ := svc.CreateUser(ctx, "jane@example.com", "pw")
+	chirp, _ := svc.CreateChirp(ctx, author.ID, "yeah science")
+
+	tests := []struct {
+		name     string
+		reporter uuid.UUID
+		chirpID  uuid.UUID
+		reason   string
+		details  string
+		wantErr  error
+	}{
+		{name: "Report", reporter: reporter.ID, chirpID: chirp.ID, reason: ReasonSpam},
+		{name: "Twice", reporter: reporter.ID, chirpID: chirp.ID, reason: ReasonOther, wantErr: store.ErrConflict},
+		{name: "Own chirp", reporter: author.ID, chirpID: chirp.ID, reason: ReasonSpam, wantErr: ErrOwnChirp},
+		{name: "Unknown reason", reporter: reporter.ID, chirpID: chirp.ID, reason: "boring", wantErr: ErrInvalidReason},
+		{name: "Long details", reporter: reporter.ID, chirpID: chirp.ID, reason: ReasonSpam, details: strings.Repeat("a", MaxReportDetailsLength+1), wantErr: ErrReportTooLong},
+		{name: "Unknown chirp", reporter: reporter.ID, chirpID: uuid.New(), reason: ReasonSpam, wantErr: store.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := svc.ReportChirp(ctx, tt.reporter, tt.chirpID, tt.reason, tt.details)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReportChirp() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (report.Status != store.ReportOpen || report.ReporterID.UUID != tt.reporter) {
+				t.Errorf("ReportChirp() = %+v", report)
+			}
+		})
+	}
+}
+
+func TestModeration(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	author, _ := svc.CreateUser(ctx, "krazy8@example.com", "pw")
+	reporter, _ := svc.CreateUser(ctx, "badger@example.com", "pw")
+	mod, _ := svc.CreateUser(ctx, "mod@example.com", "pw")
+	other, _ := svc.CreateUser(ctx, "othermod@example.com", "pw")
+	admin, _ := svc.CreateUser(ctx, "admin@example.com", "pw")
+	for id, role := range map[uuid.UUID]string{mod.ID: store.RoleModerator, other.ID: store.RoleModerator, admin.ID: store.RoleAdmin} {
+		if err := svc.SetRole(ctx, id, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reportBy := func(authorID uuid.UUID, body string) store.Report {
+		t.Helper()
+		chirp, err := svc.CreateChirp(ctx, authorID, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := svc.ReportChirp(ctx, reporter.ID, chirp.ID, ReasonHarassment, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	report := func(body string) store.Report { return reportBy(author.ID, body) }
+
+	dismissed := report("first")
+	if r, err := svc.ClaimReport(ctx, mod.ID, dismissed.ID); err != nil || r.Status != store.ReportClaimed {
+		t.Fatalf("ClaimReport() = %+v, %v", r, err)
+	}
+	if _, err := svc.ClaimReport(ctx, other.ID, dismissed.ID); !errors.Is(err, ErrReportClaimed) {
+		t.Errorf("ClaimReport(claimed) error = %v, want %v", err, ErrReportClaimed)
+	}
+	if r, err := svc.DismissReport(ctx, mod.ID, dismissed.ID); err != nil || r.Resolution != ResolutionDismissed {
+		t.Fatalf("DismissReport() = %+v, %v", r, err)
+	}
+	if _, err := svc.DismissReport(ctx, mod.ID, dismissed.ID); !errors.Is(err, ErrReportResolved) {
+		t.Errorf("DismissReport(resolved) error = %v, want %v", err, ErrReportResolved)
+	}
+
+	hidden := report("second")
+	_, chirp, err := svc.HideReportedChirp(ctx, mod.ID, hidden.ID)
+	if err != nil || !chirp.HiddenAt.Valid {
+		t.Fatalf("HideReportedChirp() = %+v, %v", chirp, err)
+	}
+	if _, err := svc.GetChirp(ctx, reporter.ID, chirp.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetChirp(hidden) by others error = %v, want %v", err, store.ErrNotFound)
+	}
+	if _, err := svc.GetChirp(ctx, author.ID, chirp.ID); err != nil {
+		t.Errorf("GetChirp(hidden) by author error = %v", err)
+	}
+	if _, err := svc.ReportChirp(ctx, other.ID, chirp.ID, ReasonSpam, ""); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("ReportChirp(hidden) error = %v, want %v", err, store.ErrNotFound)
+	}
+
+	for _, target := range []store.User{admin, other} {
+		privileged := reportBy(target.ID, "from "+target.Email)
+		if _, _, err := svc.SuspendReportedAuthor(ctx, mod.ID, privileged.ID); !errors.Is(err, ErrTargetPrivileged) {
+			t.Errorf("SuspendReportedAuthor(%s) error = %v, want %v", target.Email, err, ErrTargetPrivileged)
+		}
+		if _, err := svc.Login(ctx, target.Email, "pw"); err != nil {
+			t.Errorf("Login() of %s error = %v, want them left alone", target.Email, err)
+		}
+		if _, err := svc.DismissReport(ctx, mod.ID, privileged.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	suspended := report("third")
+	if _, _, err := svc.SuspendReportedAuthor(ctx, author.ID, suspended.ID); !errors.Is(err, ErrSelfAction) {
+		t.Errorf("SuspendReportedAuthor(own chirp) error = %v, want %v", err, ErrSelfAction)
+	}
+	r, authorID, err := svc.SuspendReportedAuthor(ctx, mod.ID, suspended.ID)
+	if err != nil || authorID != author.ID || r.Resolution != ResolutionAuthorSuspended {
+		t.Fatalf("SuspendReportedAuthor() = %+v, %v, %v", r, authorID, err)
+	}
+	if _, err := svc.Login(ctx, "krazy8@example.com", "pw"); !errors.Is(err, ErrSuspended) {
+		t.Errorf("Login() of suspended author error = %v, want %v", err, ErrSuspended)
+	}
+
+	if queue, err := svc.ModerationQueue(ctx, "", 10); err != nil || len(queue) != 0 {
+		t.Errorf("ModerationQueue() = %d reports, %v, want none left", len(queue), err)
+	}
+	if queue, _ := svc.ModerationQueue(ctx, store.ReportResolved, 10); len(queue) != 5 {
+		t.Errorf("ModerationQueue(resolved) = %d reports, want 5", len(queue))
+	}
+	if _, err := svc.ModerationQueue(ctx, "lost", 10); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("ModerationQueue(lost) error = %v, want %v", err, ErrInvalidStatus)
+	}
+}
+
+func TestListChirpsHidden(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	svc := newTestService(s)
+	author, _ := svc.CreateUser(ctx, "tortuga@example.com", "pw")
+	viewer, _ := svc.CreateUser(ctx, "viewer@example.com", "pw")
+	first, _ := svc.CreateChirp(ctx, author.ID, "first")
+	hidden, _ := svc.CreateChirp(ctx, author.ID, "hidden")
+	last, _ := svc.CreateChirp(ctx, author.ID, "last")
+	if err := s.SetChirpHidden(ctx, hidden.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chirps made in the same microsecond are ordered by ID.
+	inOrder := func(chirps ...store.Chirp) []uuid.UUID {
+		slices.SortFunc(chirps, func(a, b store.Chirp) int {
+			if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+				return c
+			}
+			return strings.Compare(a.ID.String(), b.ID.String())
+		})
+		var ids []uuid.UUID
+		for _, c := range chirps {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name     string
+		viewerID uuid.UUID
+		authorID uuid.UUID
+		want     []uuid.UUID
+	}{
+		{"Anonymous", uuid.Nil, uuid.Nil, inOrder(first, last)},
+		{"Someone else", viewer.ID, author.ID, inOrder(first, last)},
+		{"Author on timeline", author.ID, uuid.Nil, inOrder(first, hidden, last)},
+		{"Author on profile", author.ID, author.ID, inOrder(first, hidden, last)},
+		{"Author on other profile", author.ID, viewer.ID, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chirps, err := svc.ListChirps(ctx, tt.viewerID, tt.authorID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []uuid.UUID
+			for _, c := range chirps {
+				got = append(got, c.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ListChirps() = %v, want %v", got, tt.want)
+			}
+
+			page, err := svc.ListChirpPage(ctx, tt.viewerID, tt.authorID, uuid.Nil, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = nil
+			for _, c := range page {
+				got = append(got, c.ID)
+			}
+			slices.Reverse(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ListChirpPage() reversed = %v, want %v", got, tt.want)
+			}
+
+			if tt.authorID == uuid.Nil {
+				return
+			}
+			if n, err := svc.CountChirps(ctx, tt.viewerID, tt.authorID); err != nil || n != len(tt.want) {
+				t.Errorf("CountChirps() = %d, %v, want %d", n, err, len(tt.want))
+			}
+		})
+	}
+
+	if _, err := svc.ListChirpPage(ctx, viewer.ID, uuid.Nil, hidden.ID, 10); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("ListChirpPage(after someone else's hidden chirp) error = %v, want %v", err, store.ErrNotFound)
+	}
+	all := inOrder(first, hidden, last)
+	want := all[:slices.Index(all, hidden.ID)]
+	slices.Reverse(want)
+	page, err := svc.ListChirpPage(ctx, author.ID, uuid.Nil, hidden.ID, 10)
+	var got []uuid.UUID
+	for _, c := range page {
+		got = append(got, c.ID)
+	}
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("ListChirpPage(after own hidden chirp) = %v, %v, want the chirps before it, %v", got, err, want)
+	}
+}
+
+func TestRecordAudit(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	actor := uuid.New()
+
+	first, err := svc.RecordAudit(ctx, AuditRecord{Action: "login", Outcome: AuditSuccess, ActorID: actor, TargetUserID: actor, IP: "192.0.2.1", Details: map[string]string{"email": "hank@example.com"}})
+	if err != nil {
+		t.Fatalf("RecordAudit() error = %v", err)
+	}
+	if first.Seq != 1 || first.PrevHash != "" || first.Hash == "" || first.Details != `{"email":"hank@example.com"}` || first.ActorID.UUID != actor {
+		t.Errorf("first event = %+v", first)
+	}
+	second, err := svc.RecordAudit(ctx, AuditRecord{Action: "polka_upgrade", Outcome: AuditFailure, ActorService: "polka"})
+	if err != nil {
+		t.Fatalf("RecordAudit() error = %v", err)
+	}
+	if second.Seq != 2 || second.PrevHash != first.Hash || second.Details != "{}" || second.ActorID.Valid || second.TargetUserID.Valid {
+		t.Errorf("second event = %+v, want it chained to %s", second, first.Hash)
+	}
+
+	events, err := svc.AuditEvents(ctx, store.AuditEventFilter{ActorID: uuid.NullUUID{UUID: actor, Valid: true}, Limit: 10})
+	if err != nil || len(events) != 1 || events[0].Seq != 1 {
+		t.Errorf("AuditEvents(actor) = %+v, %v", events, err)
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	for _, action := range []string{"login", "update_user", "logout"} {
+		if _, err := svc.RecordAudit(ctx, AuditRecord{Action: action, Outcome: AuditSuccess, ActorID: uuid.New()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	original, err := svc.store.AuditEventsAfter(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each case copies the log into a fresh store, doctored by tamper.
+	tests := []struct {
+		name    string
+		tamper  func(events []store.AuditEvent) []store.AuditEvent
+		wantErr error
+	}{
+		{"Intact", func(events []store.AuditEvent) []store.AuditEvent { return events }, nil},
+		{"Edited", func(events []store.AuditEvent) []store.AuditEvent {
+			events[1].Outcome = AuditDenied
+			return events
+		}, ErrAuditTampered},
+		{"Edited and rehashed", func(events []store.AuditEvent) []store.AuditEvent {
+			events[1].Action = "nothing"
+			events[1].Hash = auditHash(events[1])
+			return events
+		}, ErrAuditTampered},
+		{"Removed", func(events []store.AuditEvent) []store.AuditEvent {
+			return slices.Delete(events, 1, 2)
+		}, ErrAuditTampered},
+		{"Removed first", func(events []store.AuditEvent) []store.AuditEvent {
+			return events[1:]
+		}, ErrAuditTampered},
+		{"Renumbered", func(events []store.AuditEvent) []store.AuditEvent {
+			events = slices.Delete(events, 1, 2)
+			events[1].Seq = 2
+			return events
+		}, ErrAuditTampered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := store.NewMemory()
+			for _, e := range tt.tamper(slices.Clone(original)) {
+				if err := s.AppendAuditEvent(ctx, e); err != nil {
+					t.Fatal(err)
+				}
+			}
+			v, err := newTestService(s).VerifyAuditLog(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyAuditLog() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (v.Events != 3 || v.Head != original[2].Hash) {
+				t.Errorf("VerifyAuditLog() = %+v, want 3 events up to %s", v, original[2].Hash)
+			}
+		})
+	}
+}
+
+func TestResetTables(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		tables  []string
+		want    TestSummary
+		wantErr error
+	}{
+		{
+			name:   "All",
+			tables: nil,
+			want: TestSummary{
+				store.TableUsers:         {Deleted: 2},
+				store.TableChirps:        {Deleted: 1},
+				store.TableRefreshTokens: {Deleted: 1},
+				store.TableReports:       {Deleted: 1},
+			},
+		},
+		{
+			name:   "Chirps take their reports along",
+			tables: []string{store.TableChirps},
+			want:   TestSummary{store.TableChirps: {Deleted: 1}, store.TableReports: {Deleted: 1}},
+		},
+		{
+			name:   "Refresh tokens",
+			tables: []string{store.TableRefreshTokens},
+			want:   TestSummary{store.TableRefreshTokens: {Deleted: 1}},
+		},
+		{
+			name:    "Audit log",
+			tables:  []string{"audit_events"},
+			wantErr: ErrUnknownTable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(store.NewMemory())
+			author, _ := svc.CreateUser(ctx, "walt@example.com", "pw")
+			reporter, _ := svc.CreateUser(ctx, "hank@example.com", "pw")
+			chirp, _ := svc.CreateChirp(ctx, author.ID, "say my name")
+			if _, err := svc.ReportChirp(ctx, reporter.ID, chirp.ID, ReasonHarassment, ""); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := svc.Login(ctx, "walt@example.com", "pw"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := svc.RecordAudit(ctx, AuditRecord{Action: "login", Outcome: AuditSuccess}); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := svc.ResetTables(ctx, tt.tables)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResetTables() error = %v, want %v", err, tt.wantErr)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("ResetTables() = %v, want %v", got, tt.want)
+			}
+			if v, err := svc.VerifyAuditLog(ctx); err != nil || v.Events != 1 {
+				t.Errorf("VerifyAuditLog() = %+v, %v, want the audit log kept", v, err)
+			}
+		})
+	}
+}
+
+const testFixtures = `
+users:
+  - email: gus@example.com
+    password: pollos
+    role: admin
+  - email: tuco@example.com
+    password: tight
+    suspended: true
+    created_at: 2024-05-01T12:00:00Z
+chirps:
+  - author: gus@example.com
+    body: los pollos hermanos
+  - id: 4f2d3a0e-9a5b-4c1e-8d7f-0b1c2d3e4f50
+    author: tuco@example.com
+    body: tight tight tight
+    hidden: true
+`
+
+func TestSeedFixtures(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	if _, err := svc.CreateUser(ctx, "lydia@example.com", "stevia"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ParseFixtures(strings.NewReader(testFixtures))
+	if err != nil {
+		t.Fatalf("ParseFixtures() error = %v", err)
+	}
+	got, err := svc.SeedFixtures(ctx, f)
+	if err != nil {
+		t.Fatalf("SeedFixtures() error = %v", err)
+	}
+	want := TestSummary{
+		store.TableUsers:         {Deleted: 1, Inserted: 2},
+		store.TableChirps:        {Inserted: 2},
+		store.TableRefreshTokens: {},
+		store.TableReports:       {},
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("SeedFixtures() = %v, want %v", got, want)
+	}
+
+	session, err := svc.Login(ctx, "gus@example.com", "pollos")
+	if err != nil || session.User.Role != store.RoleAdmin || !session.User.CreatedAt.Equal(fixtureEpoch) {
+		t.Fatalf("Login(gus) = %+v, %v, want an admin created at %v", session.User, err, fixtureEpoch)
+	}
+	if _, err := svc.Login(ctx, "tuco@example.com", "tight"); !errors.Is(err, ErrSuspended) {
+		t.Errorf("Login(tuco) error = %v, want %v", err, ErrSuspended)
+	}
+	hidden, err := svc.GetChirp(ctx, uuid.Nil, uuid.MustParse("4f2d3a0e-9a5b-4c1e-8d7f-0b1c2d3e4f50"))
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetChirp(hidden) = %+v, %v, want it hidden", hidden, err)
+	}
+
+	// Seeding again replaces the rows with the very same ones.
+	before, _ := svc.Snapshot(ctx)
+	if _, err := svc.SeedFixtures(ctx, f); err != nil {
+		t.Fatalf("SeedFixtures(again) error = %v", err)
+	}
+	after, _ := svc.Snapshot(ctx)
+	for i := range before.Chirps {
+		if before.Chirps[i] != after.Chirps[i] {
+			t.Errorf("chirp %d = %+v, was %+v", i, after.Chirps[i], before.Chirps[i])
+		}
+	}
+	// Tuco's created_at puts him before Gus.
+	if len(after.Users) != 2 || after.Users[1].ID != session.User.ID {
+		t.Errorf("users after seeding again = %+v, want gus second with ID %s", after.Users, session.User.ID)
+	}
+}
+
+func TestInvalidFixtures(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{"Not YAML", "users: [\n"},
+		{"Unknown key", "follows:\n  - follower: gus@example.com\n"},
+		{"No email", "users:\n  - password: pw\n"},
+		{"No password", "users:\n  - email: gus@example.com\n"},
+		{"Duplicate email", "users:\n  - {email: gus@example.com, password: pw}\n  - {email: gus@example.com, password: pw}\n"},
+		{"Unknown role", "users:\n  - {email: gus@example.com, password: pw, role: boss}\n"},
+		{"Unknown author", "chirps:\n  - {author: gus@example.com, body: hi}\n"},
+		{"No body", "users:\n  - {email: gus@example.com, password: pw}\nchirps:\n  - {author: gus@example.com}\n"},
+		{"Duplicate ID", "users:\n  - {email: gus@example.com, password: pw}\nchirps:\n  - {id: 4f2d3a0e-9a5b-4c1e-8d7f-0b1c2d3e4f50, author: gus@example.com, body: a}\n  - {id: 4f2d3a0e-9a5b-4c1e-8d7f-0b1c2d3e4f50, author: gus@example.com, body: b}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(store.NewMemory())
+			f, err := ParseFixtures(strings.NewReader(tt.yaml))
+			if err == nil {
+				_, err = svc.SeedFixtures(context.Background(), f)
+			}
+			if !errors.Is(err, ErrInvalidFixtures) {
+				t.Errorf("error = %v, want %v", err, ErrInvalidFixtures)
+			}
+		})
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(store.NewMemory())
+	walt, _ := svc.CreateUser(ctx, "walt@example.com", "pw")
+	kept, _ := svc.CreateChirp(ctx, walt.ID, "i am the danger")
+
+	snap, err := svc.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	jesse, _ := svc.CreateUser(ctx, "jesse@example.com", "pw")
+	svc.CreateChirp(ctx, jesse.ID, "yeah science")
+	svc.DeleteChirp(ctx, walt.ID, kept.ID)
+
+	got, err := svc.Restore(ctx, snap)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	want := TestSummary{
+		store.TableUsers:         {Deleted: 2, Inserted: 1},
+		store.TableChirps:        {Deleted: 1, Inserted: 1},
+		store.TableRefreshTokens: {},
+		store.TableReports:       {},
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("Restore() = %v, want %v", got, want)
+	}
+	if c, err := svc.GetChirp(ctx, uuid.Nil, kept.ID); err != nil || c != kept {
+		t.Errorf("GetChirp(restored) = %+v, %v, want %+v", c, err, kept)
+	}
+	if _, err := svc.GetUser(ctx, jesse.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetUser(jesse) error = %v, want %v", err, store.ErrNotFound)
+	}
+}
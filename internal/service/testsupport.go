@@ -0,0 +1,318 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// TableChange counts the rows a test support call deleted from and
+// inserted into a table.
+type TableChange struct {
+	Deleted  int
+	Inserted int
+}
+
+// TestSummary is the change to each of store.Tables.
+type TestSummary map[string]TableChange
+
+// tableDependents are the tables whose rows reference a table's rows and
+// so can't outlive them.
+var tableDependents = map[string][]string{
+	store.TableUsers:  {store.TableChirps, store.TableRefreshTokens, store.TableReports},
+	store.TableChirps: {store.TableReports},
+}
+
+// ResetTables empties tables, each one of store.Tables, together with the
+// tables referencing them. No tables means all of them. The audit log
+// is never touched.
+func (s *Service) ResetTables(ctx context.Context, tables []string) (TestSummary, error) {
+	if len(tables) == 0 {
+		tables = store.Tables
+	}
+	reset := map[string]bool{}
+	for _, table := range tables {
+		if !slices.Contains(store.Tables, table) {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownTable, table)
+		}
+		reset[table] = true
+		for _, dep := range tableDependents[table] {
+			reset[dep] = true
+		}
+	}
+
+	var summary TestSummary
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		var err error
+		summary, err = resetTables(ctx, tx, reset)
+		return err
+	})
+	return summary, err
+}
+
+// resetTables clears the tables in reset, the referencing ones first, so
+// no cascade deletes rows behind the counts' back.
+func resetTables(ctx context.Context, tx store.Store, reset map[string]bool) (TestSummary, error) {
+	summary := TestSummary{}
+	for _, table := range slices.Backward(store.Tables) {
+		if !reset[table] {
+			continue
+		}
+		n, err := tx.ClearTable(ctx, table)
+		if err != nil {
+			return nil, fmt.Errorf("clearing %s: %w", table, err)
+		}
+		summary[table] = TableChange{Deleted: n}
+	}
+	return summary, nil
+}
+
+// Snapshot returns every row of store.Tables, read in one transaction.
+func (s *Service) Snapshot(ctx context.Context) (store.Dump, error) {
+	var d store.Dump
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		var err error
+		d, err = tx.Dump(ctx)
+		return err
+	})
+	return d, err
+}
+
+// TakeSnapshot saves every row of store.Tables under name, replacing an
+// earlier snapshot of that name, and returns them. Snapshots are kept in
+// the database, so they survive restarts and every instance sharing it
+// can restore them.
+func (s *Service) TakeSnapshot(ctx context.Context, name string) (store.Dump, error) {
+	var d store.Dump
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		var err error
+		if d, err = tx.Dump(ctx); err != nil {
+			return err
+		}
+		return tx.SaveSnapshot(ctx, name, d)
+	})
+	return d, err
+}
+
+// RestoreSnapshot replaces the rows of store.Tables with those of the
+// snapshot saved under name, or returns store.ErrNotFound. The snapshot
+// is kept, so it can be restored again.
+func (s *Service) RestoreSnapshot(ctx context.Context, name string) (TestSummary, error) {
+	var summary TestSummary
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		d, err := tx.GetSnapshot(ctx, name)
+		if err != nil {
+			return err
+		}
+		summary, err = restore(ctx, tx, d)
+		return err
+	})
+	return summary, err
+}
+
+// DeleteSnapshot removes the snapshot saved under name, or returns
+// store.ErrNotFound.
+func (s *Service) DeleteSnapshot(ctx context.Context, name string) error {
+	return s.store.DeleteSnapshot(ctx, name)
+}
+
+// Restore replaces the rows of store.Tables with those of d.
+func (s *Service) Restore(ctx context.Context, d store.Dump) (TestSummary, error) {
+	var summary TestSummary
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		var err error
+		summary, err = restore(ctx, tx, d)
+		return err
+	})
+	return summary, err
+}
+
+func restore(ctx context.Context, tx store.Store, d store.Dump) (TestSummary, error) {
+	all := map[string]bool{}
+	for _, table := range store.Tables {
+		all[table] = true
+	}
+	summary, err := resetTables(ctx, tx, all)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Load(ctx, d); err != nil {
+		return nil, fmt.Errorf("loading rows: %w", err)
+	}
+	for table, n := range map[string]int{
+		store.TableUsers:         len(d.Users),
+		store.TableChirps:        len(d.Chirps),
+		store.TableRefreshTokens: len(d.RefreshTokens),
+		store.TableReports:       len(d.Reports),
+	} {
+		change := summary[table]
+		change.Inserted = n
+		summary[table] = change
+	}
+	return summary, nil
+}
+
+// Fixtures are rows to seed the database with, read from YAML by
+// ParseFixtures. Rows without an ID get one derived from their email or
+// position, and rows without a created_at are a second apart in document
+// order, so the same document always seeds the same rows.
+type Fixtures struct {
+	Users  []UserFixture  `yaml:"users"`
+	Chirps []ChirpFixture `yaml:"chirps"`
+}
+
+// UserFixture is a user to seed. Role defaults to store.RoleUser.
+type UserFixture struct {
+	ID          uuid.UUID `yaml:"id"`
+	Email       string    `yaml:"email"`
+	Password    string    `yaml:"password"`
+	Role        string    `yaml:"role"`
+	IsChirpyRed bool      `yaml:"is_chirpy_red"`
+	Suspended   bool      `yaml:"suspended"`
+	CreatedAt   time.Time `yaml:"created_at"`
+}
+
+// ChirpFixture is a chirp to seed. Author is the email of one of the
+// users.
+type ChirpFixture struct {
+	ID        uuid.UUID `yaml:"id"`
+	Author    string    `yaml:"author"`
+	Body      string    `yaml:"body"`
+	Hidden    bool      `yaml:"hidden"`
+	CreatedAt time.Time `yaml:"created_at"`
+}
+
+var (
+	// fixtureNamespace seeds the IDs derived for fixtures.
+	fixtureNamespace = uuid.MustParse("6f1d4a52-93c1-4d0e-8f57-2b9a1c0e7d34")
+	// fixtureEpoch is when the first fixture without a created_at was
+	// created.
+	fixtureEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// ParseFixtures reads fixtures from YAML. Unknown keys are an error, so a
+// typo doesn't quietly seed less than intended.
+func ParseFixtures(r io.Reader) (Fixtures, error) {
+	var f Fixtures
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return Fixtures{}, fmt.Errorf("%w: %w", ErrInvalidFixtures, err)
+	}
+	return f, nil
+}
+
+// SeedFixtures replaces the rows of store.Tables with the fixtures.
+func (s *Service) SeedFixtures(ctx context.Context, f Fixtures) (TestSummary, error) {
+	d, err := f.dump(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Restore(ctx, d)
+}
+
+// dump turns the fixtures into rows, hashing the passwords on the way.
+func (f Fixtures) dump(ctx context.Context) (store.Dump, error) {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidFixtures, fmt.Sprintf(format, args...))
+	}
+	var d store.Dump
+	ids := map[uuid.UUID]bool{}
+	authors := map[string]uuid.UUID{}
+	at := fixtureEpoch
+
+	for i, u := range f.Users {
+		switch {
+		case u.Email == "":
+			return store.Dump{}, invalid("user %d has no email", i+1)
+		case u.Password == "":
+			return store.Dump{}, invalid("user %s has no password", u.Email)
+		case authors[u.Email] != uuid.Nil:
+			return store.Dump{}, invalid("user %s is there twice", u.Email)
+		}
+		if u.Role == "" {
+			u.Role = store.RoleUser
+		}
+		if !slices.Contains(Roles, u.Role) {
+			return store.Dump{}, invalid("user %s has unknown role %q", u.Email, u.Role)
+		}
+		if u.ID == uuid.Nil {
+			u.ID = uuid.NewSHA1(fixtureNamespace, []byte("user:"+u.Email))
+		}
+		if ids[u.ID] {
+			return store.Dump{}, invalid("id %s is there twice", u.ID)
+		}
+		hashed, err := hashPassword(ctx, u.Password)
+		if err != nil {
+			return store.Dump{}, fmt.Errorf("hashing password: %w", err)
+		}
+
+		created := fixtureTime(u.CreatedAt, at)
+		d.Users = append(d.Users, store.User{
+			ID:             u.ID,
+			CreatedAt:      created,
+			UpdatedAt:      created,
+			Email:          u.Email,
+			HashedPassword: hashed,
+			IsChirpyRed:    u.IsChirpyRed,
+			Role:           u.Role,
+			SuspendedAt:    nullTimeIf(u.Suspended, created),
+		})
+		ids[u.ID] = true
+		authors[u.Email] = u.ID
+		at = at.Add(time.Second)
+	}
+
+	for i, c := range f.Chirps {
+		author, ok := authors[c.Author]
+		switch {
+		case !ok:
+			return store.Dump{}, invalid("chirp %d is by %q, who isn't one of the users", i+1, c.Author)
+		case c.Body == "":
+			return store.Dump{}, invalid("chirp %d has no body", i+1)
+		}
+		if c.ID == uuid.Nil {
+			c.ID = uuid.NewSHA1(fixtureNamespace, []byte("chirp:"+strconv.Itoa(i)))
+		}
+		if ids[c.ID] {
+			return store.Dump{}, invalid("id %s is there twice", c.ID)
+		}
+
+		created := fixtureTime(c.CreatedAt, at)
+		d.Chirps = append(d.Chirps, store.Chirp{
+			ID:        c.ID,
+			CreatedAt: created,
+			UpdatedAt: created,
+			Body:      c.Body,
+			UserID:    author,
+			HiddenAt:  nullTimeIf(c.Hidden, created),
+		})
+		ids[c.ID] = true
+		at = at.Add(time.Second)
+	}
+	return d, nil
+}
+
+// fixtureTime is t, or def if t is unset, at the precision the stores keep.
+func fixtureTime(t, def time.Time) time.Time {
+	if t.IsZero() {
+		t = def
+	}
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+func nullTimeIf(set bool, t time.Time) sql.NullTime {
+	if !set {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t, Valid: true}
+}
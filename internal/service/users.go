@@ -0,0 +1,366 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/auth"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+// Session is what a successful login hands back to the client.
+type Session struct {
+	User         store.User
+	AccessToken  string
+	RefreshToken string
+}
+
+func (s *Service) CreateUser(ctx context.Context, email, password string) (store.User, error) {
+	hashed, err := hashPassword(ctx, password)
+	if err != nil {
+		return store.User{}, fmt.Errorf("hashing password: %w", err)
+	}
+	return s.store.CreateUser(ctx, store.CreateUserParams{
+		Email:          email,
+		HashedPassword: hashed,
+	})
+}
+
+func (s *Service) GetUser(ctx context.Context, id uuid.UUID) (store.User, error) {
+	return s.store.GetUserByID(ctx, id)
+}
+
+func (s *Service) UpdateUser(ctx context.Context, id uuid.UUID, email, password string) (store.User, error) {
+	hashed, err := hashPassword(ctx, password)
+	if err != nil {
+		return store.User{}, fmt.Errorf("hashing password: %w", err)
+	}
+	return s.store.UpdateUser(ctx, store.UpdateUserParams{
+		ID:             id,
+		Email:          email,
+		HashedPassword: hashed,
+	})
+}
+
+// Login checks the password and opens a session. Users whose password
+// reset an admin forced get ErrPasswordResetRequired and have to go
+// through ResetPassword instead.
+func (s *Service) Login(ctx context.Context, email, password string) (Session, error) {
+	user, err := s.store.GetUserByEmail(ctx, email)
+	if err != nil {
+		return Session{}, credentialsError(err)
+	}
+
+	// argon2 is slow, so the password is checked before the transaction
+	// opens rather than while holding it.
+	match, err := checkPasswordHash(ctx, password, user.HashedPassword)
+	if err != nil {
+		return Session{}, fmt.Errorf("checking password: %w", err)
+	}
+	if !match {
+		return Session{}, ErrInvalidCredentials
+	}
+	return s.openSession(ctx, user.ID, "", "")
+}
+
+// ResetPassword is how users whose password reset an admin forced get
+// back in: instead of the old password, which may be what leaked, it takes
+// the reset token ForcePasswordReset handed out, sets newPassword and
+// opens a session. The token works once.
+func (s *Service) ResetPassword(ctx context.Context, email, resetToken, newPassword string) (Session, error) {
+	if newPassword == "" {
+		return Session{}, ErrPasswordRequired
+	}
+	user, err := s.store.GetUserByEmail(ctx, email)
+	if err != nil {
+		return Session{}, credentialsError(err)
+	}
+	tokenHash := hashResetToken(resetToken)
+	if !user.PasswordResetRequired || subtle.ConstantTimeCompare([]byte(tokenHash), []byte(user.PasswordResetTokenHash.String)) != 1 {
+		return Session{}, ErrInvalidCredentials
+	}
+	newHash, err := hashPassword(ctx, newPassword)
+	if err != nil {
+		return Session{}, fmt.Errorf("hashing password: %w", err)
+	}
+	return s.openSession(ctx, user.ID, tokenHash, newHash)
+}
+
+// openSession stores a refresh token for the user and signs an access
+// token. With a resetTokenHash, which must still be the user's, it also
+// sets the password to newHash and clears the reset. The refresh token is
+// stored before the access token is signed, so a client never receives a
+// JWT whose refresh token was lost.
+func (s *Service) openSession(ctx context.Context, id uuid.UUID, resetTokenHash, newHash string) (Session, error) {
+	var user store.User
+	var refreshToken string
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		// Re-read the user so a concurrent delete makes the login fail
+		// instead of leaving a token for a user that no longer exists.
+		current, err := tx.GetUserByID(ctx, id)
+		if err != nil {
+			return credentialsError(err)
+		}
+		switch {
+		case current.SuspendedAt.Valid:
+			return ErrSuspended
+		case resetTokenHash == "" && current.PasswordResetRequired:
+			return ErrPasswordResetRequired
+		case resetTokenHash != "" && current.PasswordResetTokenHash.String != resetTokenHash:
+			// Used by a concurrent reset, or replaced by a new one.
+			return ErrInvalidCredentials
+		}
+		if resetTokenHash != "" {
+			current, err = tx.UpdateUser(ctx, store.UpdateUserParams{
+				ID:             current.ID,
+				Email:          current.Email,
+				HashedPassword: newHash,
+			})
+			if err != nil {
+				return err
+			}
+			if err := tx.SetPasswordReset(ctx, current.ID, ""); err != nil {
+				return err
+			}
+			current.PasswordResetRequired = false
+			current.PasswordResetTokenHash = sql.NullString{}
+		}
+		token, err := auth.MakeRefreshToken()
+		if err != nil {
+			return fmt.Errorf("generating refresh token: %w", err)
+		}
+		_, err = tx.CreateRefreshToken(ctx, store.CreateRefreshTokenParams{
+			Token:     token,
+			UserID:    current.ID,
+			ExpiresAt: time.Now().UTC().Add(s.cfg.RefreshTokenTTL),
+		})
+		if err != nil {
+			return err
+		}
+		user, refreshToken = current, token
+		return nil
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	accessToken, err := auth.MakeJWT(user.ID, s.cfg.Secret, s.cfg.AccessTokenTTL)
+	if err != nil {
+		return Session{}, fmt.Errorf("signing access token: %w", err)
+	}
+	return Session{User: user, AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+func credentialsError(err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+	return err
+}
+
+// Refresh signs a new access token for a valid refresh token and returns
+// it together with the token's user.
+func (s *Service) Refresh(ctx context.Context, refreshToken string) (string, uuid.UUID, error) {
+	token, err := s.store.GetRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return "", uuid.Nil, err
+	}
+	if token.RevokedAt.Valid {
+		return "", token.UserID, ErrTokenRevoked
+	}
+	if token.ExpiresAt.Before(time.Now()) {
+		return "", token.UserID, ErrTokenExpired
+	}
+	user, err := s.store.GetUserByID(ctx, token.UserID)
+	if err != nil {
+		return "", token.UserID, err
+	}
+	if user.SuspendedAt.Valid {
+		return "", token.UserID, ErrSuspended
+	}
+
+	accessToken, err := auth.MakeJWT(token.UserID, s.cfg.Secret, s.cfg.AccessTokenTTL)
+	if err != nil {
+		return "", token.UserID, fmt.Errorf("signing access token: %w", err)
+	}
+	return accessToken, token.UserID, nil
+}
+
+// Revoke revokes the refresh token and returns whose it was.
+func (s *Service) Revoke(ctx context.Context, refreshToken string) (uuid.UUID, error) {
+	token, err := s.store.GetRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return token.UserID, s.store.RevokeToken(ctx, refreshToken)
+}
+
+// UpgradeUser grants Chirpy Red after a payment went through.
+func (s *Service) UpgradeUser(ctx context.Context, id uuid.UUID) error {
+	return s.store.GrantChirpyRedToUser(ctx, id)
+}
+
+// Roles lists the valid roles, least privileged first.
+var Roles = []string{store.RoleUser, store.RoleModerator, store.RoleAdmin}
+
+// SetRole gives the user role, one of the store.Role constants.
+func (s *Service) SetRole(ctx context.Context, id uuid.UUID, role string) error {
+	if !slices.Contains(Roles, role) {
+		return fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+	return s.store.SetUserRole(ctx, id, role)
+}
+
+// BootstrapAdmin makes the user with email an admin, creating it with
+// password first if there is none. It returns the user and whether it was
+// created.
+func (s *Service) BootstrapAdmin(ctx context.Context, email, password string) (store.User, bool, error) {
+	var hashed string
+	if password != "" {
+		var err error
+		if hashed, err = hashPassword(ctx, password); err != nil {
+			return store.User{}, false, fmt.Errorf("hashing password: %w", err)
+		}
+	}
+
+	var admin store.User
+	var created bool
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		u, err := tx.GetUserByEmail(ctx, email)
+		created = errors.Is(err, store.ErrNotFound)
+		switch {
+		case created && hashed == "":
+			return ErrPasswordRequired
+		case created:
+			u, err = tx.CreateUser(ctx, store.CreateUserParams{Email: email, HashedPassword: hashed})
+			if err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		}
+		if err := tx.SetUserRole(ctx, u.ID, store.RoleAdmin); err != nil {
+			return err
+		}
+		admin, err = tx.GetUserByID(ctx, u.ID)
+		return err
+	})
+	return admin, created, err
+}
+
+// UserDetails is what admins see of a user.
+type UserDetails struct {
+	User       store.User
+	ChirpCount int
+	// Sessions are the user's refresh tokens, newest first.
+	Sessions []store.RefreshToken
+}
+
+// SearchUsers finds up to limit users whose email contains query.
+func (s *Service) SearchUsers(ctx context.Context, query string, limit int) ([]store.User, error) {
+	return s.store.SearchUsers(ctx, query, limit)
+}
+
+func (s *Service) UserDetails(ctx context.Context, id uuid.UUID) (UserDetails, error) {
+	var d UserDetails
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		var err error
+		if d.User, err = tx.GetUserByID(ctx, id); err != nil {
+			return err
+		}
+		if d.ChirpCount, err = tx.CountChirpsByAuthor(ctx, id); err != nil {
+			return err
+		}
+		d.Sessions, err = tx.GetRefreshTokensByUser(ctx, id)
+		return err
+	})
+	return d, err
+}
+
+// Suspend locks the user out: their sessions are revoked, and logins,
+// refreshes and access tokens are turned away until Unsuspend. actorID
+// is the admin asking, who can't suspend themselves.
+func (s *Service) Suspend(ctx context.Context, actorID, id uuid.UUID) error {
+	if actorID == id {
+		return ErrSelfAction
+	}
+	return s.WithTx(ctx, func(tx store.Store) error {
+		return suspend(ctx, tx, id)
+	})
+}
+
+func suspend(ctx context.Context, tx store.Store, id uuid.UUID) error {
+	if err := tx.SetUserSuspended(ctx, id, true); err != nil {
+		return err
+	}
+	_, err := tx.RevokeUserTokens(ctx, id)
+	return err
+}
+
+func (s *Service) Unsuspend(ctx context.Context, id uuid.UUID) error {
+	return s.store.SetUserSuspended(ctx, id, false)
+}
+
+// ForcePasswordReset revokes the user's sessions and makes them set a new
+// password through ResetPassword. It returns the one-time reset token for
+// the admin to pass on; only its hash is kept, and a later reset replaces
+// it.
+func (s *Service) ForcePasswordReset(ctx context.Context, id uuid.UUID) (string, error) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", fmt.Errorf("generating reset token: %w", err)
+	}
+	err = s.WithTx(ctx, func(tx store.Store) error {
+		if err := tx.SetPasswordReset(ctx, id, hashResetToken(token)); err != nil {
+			return err
+		}
+		_, err := tx.RevokeUserTokens(ctx, id)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RevokeSessions revokes all of the user's refresh tokens and returns how
+// many were still live.
+func (s *Service) RevokeSessions(ctx context.Context, id uuid.UUID) (int, error) {
+	var n int
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		if _, err := tx.GetUserByID(ctx, id); err != nil {
+			return err
+		}
+		var err error
+		n, err = tx.RevokeUserTokens(ctx, id)
+		return err
+	})
+	return n, err
+}
+
+// SetChirpyRed grants or takes away Chirpy Red regardless of payments.
+func (s *Service) SetChirpyRed(ctx context.Context, id uuid.UUID, red bool) error {
+	return s.store.SetUserChirpyRed(ctx, id, red)
+}
+
+// DeleteUser deletes the user together with their chirps and tokens.
+// actorID is the admin asking, who can't delete themselves.
+func (s *Service) DeleteUser(ctx context.Context, actorID, id uuid.UUID) error {
+	if actorID == id {
+		return ErrSelfAction
+	}
+	return s.store.DeleteUser(ctx, id)
+}
@@ -0,0 +1,263 @@
+// Package static serves a fixed set of web assets. Everything is read into
+// memory when the server is built, so exactly the regular files found below
+// the root at startup are served: no directory listings, no dotfiles, no
+// symlinks leading elsewhere.
+package static
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ManifestName is the file listing the fingerprinted name of every
+	// asset, keyed by its plain name.
+	ManifestName = "manifest.json"
+	// hashLength is how many hex digits of the content hash go into a
+	// fingerprinted name.
+	hashLength = 8
+	// immutableCacheControl lets clients keep fingerprinted files for good:
+	// new content gets a new name.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+)
+
+// encodings are the precompressed variants looked for next to every file,
+// in order of preference.
+var encodings = []struct{ ext, name string }{
+	{".br", "br"},
+	{".gz", "gzip"},
+}
+
+// variant is one representation of an asset.
+type variant struct {
+	encoding string // "" for the uncompressed file
+	data     []byte
+	etag     string
+}
+
+type asset struct {
+	contentType string
+	modTime     time.Time
+	// variants holds the precompressed ones in order of preference, then
+	// the uncompressed file.
+	variants []variant
+}
+
+type route struct {
+	asset       *asset
+	fingerprint bool
+}
+
+// Server is an http.Handler for the assets of one file system.
+type Server struct {
+	routes   map[string]route
+	manifest map[string]string
+}
+
+// New reads every servable file of fsys. A file.gz or file.br next to file
+// is its precompressed variant rather than an asset of its own.
+func New(fsys fs.FS) (*Server, error) {
+	type file struct {
+		data    []byte
+		modTime time.Time
+	}
+	files := map[string]file{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		files[name] = file{data: data, modTime: info.ModTime()}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading assets: %w", err)
+	}
+	if _, ok := files[ManifestName]; ok {
+		return nil, fmt.Errorf("reading assets: %s is generated and must not exist", ManifestName)
+	}
+
+	s := &Server{routes: map[string]route{}, manifest: map[string]string{}}
+	for name, f := range files {
+		if isVariant(name, func(base string) bool { _, ok := files[base]; return ok }) {
+			continue
+		}
+		a := &asset{contentType: contentType(name, f.data), modTime: f.modTime}
+		for _, enc := range encodings {
+			if v, ok := files[name+enc.ext]; ok {
+				a.variants = append(a.variants, variant{encoding: enc.name, data: v.data, etag: etag(v.data)})
+			}
+		}
+		a.variants = append(a.variants, variant{data: f.data, etag: etag(f.data)})
+
+		fingerprinted := fingerprint(name, f.data)
+		s.routes[name] = route{asset: a}
+		s.routes[fingerprinted] = route{asset: a, fingerprint: true}
+		s.manifest[name] = fingerprinted
+	}
+
+	manifest, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	s.routes[ManifestName] = route{asset: &asset{
+		contentType: "application/json",
+		variants:    []variant{{data: manifest, etag: etag(manifest)}},
+	}}
+	return s, nil
+}
+
+// isVariant reports whether name is a precompressed variant of a file
+// that exists.
+func isVariant(name string, exists func(string) bool) bool {
+	for _, enc := range encodings {
+		if base, ok := strings.CutSuffix(name, enc.ext); ok && exists(base) {
+			return true
+		}
+	}
+	return false
+}
+
+func contentType(name string, data []byte) string {
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(data)
+}
+
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// fingerprint puts a hash of data into name: assets/logo.png becomes
+// assets/logo.1a2b3c4d.png.
+func fingerprint(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])[:hashLength]
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// Path returns the fingerprinted name of the asset name, or name itself if
+// there is no such asset.
+func (s *Server) Path(name string) string {
+	if fingerprinted, ok := s.manifest[name]; ok {
+		return fingerprinted
+	}
+	return name
+}
+
+// Has reports whether name, plain or fingerprinted, is an asset.
+func (s *Server) Has(name string) bool {
+	_, ok := s.routes[name]
+	return ok
+}
+
+// ServeHTTP serves the asset at the request path, which should already
+// have the mount prefix stripped. A path ending in a slash serves the
+// index.html in that directory.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	rt, ok := s.routes[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	a := rt.asset
+	v := a.negotiate(r.Header.Get("Accept-Encoding"))
+	h := w.Header()
+	h.Set("Content-Type", a.contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("ETag", v.etag)
+	if len(a.variants) > 1 {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if v.encoding != "" {
+		h.Set("Content-Encoding", v.encoding)
+	}
+	if rt.fingerprint {
+		h.Set("Cache-Control", immutableCacheControl)
+	} else {
+		// Plain names may change content at any deploy, so clients have
+		// to revalidate, which the ETag keeps cheap.
+		h.Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(w, r, "", a.modTime, bytes.NewReader(v.data))
+}
+
+// negotiate picks the most preferred variant the Accept-Encoding header
+// allows, falling back to the uncompressed file.
+func (a *asset) negotiate(acceptEncoding string) variant {
+	accepted := acceptedEncodings(acceptEncoding)
+	for _, v := range a.variants[:len(a.variants)-1] {
+		if accepted(v.encoding) {
+			return v
+		}
+	}
+	return a.variants[len(a.variants)-1]
+}
+
+// acceptedEncodings parses an Accept-Encoding header into a func
+// reporting whether an encoding is acceptable. Quality values are only
+// told apart as zero or not.
+func acceptedEncodings(header string) func(string) bool {
+	allowed := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		ok := true
+		for _, param := range strings.Split(params, ";") {
+			key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				f, err := strconv.ParseFloat(val, 64)
+				ok = err == nil && f > 0
+			}
+		}
+		allowed[name] = ok
+	}
+	return func(encoding string) bool {
+		if ok, listed := allowed[encoding]; listed {
+			return ok
+		}
+		return allowed["*"]
+	}
+}
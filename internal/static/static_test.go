@@ -0,0 +1,223 @@
+package static
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	s, err := New(fstest.MapFS{
+		"index.html":          {Data: []byte("<h1>home</h1>")},
+		"app.js":              {Data: []byte("console.log('plain')")},
+		"app.js.gz":           {Data: []byte("gzipped")},
+		"app.js.br":           {Data: []byte("brotli")},
+		"style.css":           {Data: []byte("body {}")},
+		"backup.tar.gz":       {Data: []byte("not a variant")},
+		"assets/logo.png":     {Data: []byte("\x89PNG\r\n\x1a\n")},
+		"assets/index.html":   {Data: []byte("<h1>assets</h1>")},
+		"docs/readme.txt":     {Data: []byte("hello")},
+		".env":                {Data: []byte("SECRET=hunter2")},
+		".git/config":         {Data: []byte("[core]")},
+		"docs/.hidden/secret": {Data: []byte("psst")},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return s
+}
+
+func get(s http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServe(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		path        string
+		wantStatus  int
+		wantBody    string
+		wantType    string
+		wantCaching string
+	}{
+		{"/", http.StatusOK, "<h1>home</h1>", "text/html; charset=utf-8", "no-cache"},
+		{"/index.html", http.StatusOK, "<h1>home</h1>", "text/html; charset=utf-8", "no-cache"},
+		{"/style.css", http.StatusOK, "body {}", "text/css; charset=utf-8", "no-cache"},
+		{"/assets/logo.png", http.StatusOK, "\x89PNG\r\n\x1a\n", "image/png", "no-cache"},
+		{"/assets/", http.StatusOK, "<h1>assets</h1>", "text/html; charset=utf-8", "no-cache"},
+		{"/backup.tar.gz", http.StatusOK, "not a variant", "application/gzip", "no-cache"},
+		{"/" + s.Path("style.css"), http.StatusOK, "body {}", "text/css; charset=utf-8", immutableCacheControl},
+		{"/docs/", http.StatusNotFound, "", "", ""},
+		{"/docs", http.StatusNotFound, "", "", ""},
+		{"/.env", http.StatusNotFound, "", "", ""},
+		{"/.git/config", http.StatusNotFound, "", "", ""},
+		{"/docs/.hidden/secret", http.StatusNotFound, "", "", ""},
+		{"/../static.go", http.StatusNotFound, "", "", ""},
+		{"/app.js.gz", http.StatusNotFound, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := get(s, tt.path, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.wantCaching {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.wantCaching)
+			}
+			if rec.Header().Get("ETag") == "" {
+				t.Error("no ETag")
+			}
+		})
+	}
+}
+
+func TestServeNegotiatesEncoding(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		acceptEncoding string
+		wantEncoding   string
+		wantBody       string
+	}{
+		{"", "", "console.log('plain')"},
+		{"gzip", "gzip", "gzipped"},
+		{"gzip, deflate, br", "br", "brotli"},
+		{"br;q=0, gzip;q=0.5", "gzip", "gzipped"},
+		{"*", "br", "brotli"},
+		{"*, br;q=0", "gzip", "gzipped"},
+		{"identity", "", "console.log('plain')"},
+	}
+	etags := map[string]string{}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			rec := get(s, "/app.js", http.Header{"Accept-Encoding": {tt.acceptEncoding}})
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "text/javascript; charset=utf-8" {
+				t.Errorf("Content-Type = %q, want the type of the uncompressed file", got)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			etag := rec.Header().Get("ETag")
+			if prev, ok := etags[tt.wantEncoding]; ok && prev != etag {
+				t.Errorf("ETag = %s, want %s as for the same encoding before", etag, prev)
+			}
+			etags[tt.wantEncoding] = etag
+		})
+	}
+	if len(etags) != 3 || etags[""] == etags["gzip"] || etags["gzip"] == etags["br"] {
+		t.Errorf("ETags %v are not distinct per encoding", etags)
+	}
+}
+
+func TestServeConditional(t *testing.T) {
+	s := newTestServer(t)
+	etag := get(s, "/style.css", nil).Header().Get("ETag")
+
+	rec := get(s, "/style.css", http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("status = %d, want 304", rec.Code)
+	}
+	rec = get(s, "/style.css", http.Header{"If-None-Match": {`"stale"`}})
+	if rec.Code != http.StatusOK {
+		t.Errorf("status with a stale ETag = %d, want 200", rec.Code)
+	}
+}
+
+func TestServeRejectsWrites(t *testing.T) {
+	s := newTestServer(t)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/index.html", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want 405", rec.Code)
+	}
+	if got := rec.Header().Get("Allow"); got != "GET, HEAD" {
+		t.Errorf("Allow = %q, want GET, HEAD", got)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	s := newTestServer(t)
+	rec := get(s, "/"+ManifestName, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var manifest map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &manifest); err != nil {
+		t.Fatalf("manifest is not JSON: %v", err)
+	}
+	if len(manifest) != 7 {
+		t.Errorf("manifest has %d entries, want one per asset: %v", len(manifest), manifest)
+	}
+	fingerprinted := manifest["assets/logo.png"]
+	if fingerprinted != s.Path("assets/logo.png") {
+		t.Errorf("manifest says %q, Path says %q", fingerprinted, s.Path("assets/logo.png"))
+	}
+	if !regexp.MustCompile(`^assets/logo\.[0-9a-f]{8}\.png$`).MatchString(fingerprinted) {
+		t.Errorf("fingerprinted name = %q, want assets/logo.<hash>.png", fingerprinted)
+	}
+	if _, ok := manifest["app.js.gz"]; ok {
+		t.Error("manifest lists a precompressed variant")
+	}
+	if got := s.Path("missing.css"); got != "missing.css" {
+		t.Errorf("Path(missing.css) = %q, want it unchanged", got)
+	}
+}
+
+func TestNewRejectsManifest(t *testing.T) {
+	if _, err := New(fstest.MapFS{ManifestName: {Data: []byte("{}")}}); err == nil {
+		t.Error("New() accepted a file named like the generated manifest")
+	}
+}
+
+func TestNewSkipsSymlinks(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "index.html"), []byte("home"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "secret.txt")); err != nil {
+		t.Skipf("creating symlink: %v", err)
+	}
+
+	s, err := New(os.DirFS(root))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if rec := get(s, "/secret.txt", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("symlink served with status %d, want 404", rec.Code)
+	}
+	if rec := get(s, "/", nil); rec.Code != http.StatusOK {
+		t.Errorf("index served with status %d, want 200", rec.Code)
+	}
+}
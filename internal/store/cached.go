@@ -0,0 +1,398 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/cache"
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cached is a read-through Store. GetUserByID, GetChirpByID and the chirp
+// timelines are served from the cache when possible, and concurrent misses
+// for the same key share a single query. Writes drop the entries they made
+// stale once they are durable: right away outside a transaction, after the
+// commit inside one. Cache errors are logged and treated as misses, so a
+// broken cache slows Chirpy down but doesn't take it down.
+type Cached struct {
+	Store
+	cache cache.Cache
+	ttl   time.Duration
+	group singleflight.Group
+	// gen is bumped on every invalidation so a load that raced with a
+	// write doesn't put the value it read before the write back.
+	gen atomic.Uint64
+}
+
+func NewCached(s Store, c cache.Cache, ttl time.Duration) *Cached {
+	return &Cached{Store: s, cache: c, ttl: ttl}
+}
+
+const allChirpsKey = "chirps"
+
+func userKey(id uuid.UUID) string         { return "user:" + id.String() }
+func chirpKey(id uuid.UUID) string        { return "chirp:" + id.String() }
+func authorChirpsKey(id uuid.UUID) string { return "chirps:author:" + id.String() }
+
+func (s *Cached) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	return readThrough(ctx, s, userKey(id), func(ctx context.Context) (User, error) {
+		return s.Store.GetUserByID(ctx, id)
+	})
+}
+
+func (s *Cached) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	return readThrough(ctx, s, chirpKey(id), func(ctx context.Context) (Chirp, error) {
+		return s.Store.GetChirpByID(ctx, id)
+	})
+}
+
+func (s *Cached) GetChirpsAsc(ctx context.Context) ([]Chirp, error) {
+	return readThrough(ctx, s, allChirpsKey, s.Store.GetChirpsAsc)
+}
+
+func (s *Cached) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	return readThrough(ctx, s, authorChirpsKey(userID), func(ctx context.Context) ([]Chirp, error) {
+		return s.Store.GetChirpsByAuthor(ctx, userID)
+	})
+}
+
+// loadTimeout bounds a load, which doesn't stop when the caller that
+// started it gives up.
+const loadTimeout = 10 * time.Second
+
+// readThrough returns the cached value under key, or loads, caches and
+// returns it. Callers joining an in-flight load share it, so it runs
+// detached from the first caller's cancellation and deadline, under
+// loadTimeout instead; each caller still stops waiting when its own
+// context is done.
+func readThrough[T any](ctx context.Context, s *Cached, key string, load func(context.Context) (T, error)) (T, error) {
+	data, ok, err := s.cache.Get(ctx, key)
+	if err != nil {
+		slog.WarnContext(ctx, "reading cache", "key", key, "error", err)
+	}
+	if ok {
+		var v T
+		if err := json.Unmarshal(data, &v); err == nil {
+			return v, nil
+		}
+	}
+
+	loaded := s.group.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		gen := s.gen.Load()
+		v, err := load(ctx)
+		if err != nil {
+			return v, err
+		}
+		if s.gen.Load() == gen {
+			s.fill(ctx, key, v)
+		}
+		return v, nil
+	})
+	select {
+	case res := <-loaded:
+		return res.Val.(T), res.Err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+func (s *Cached) fill(ctx context.Context, key string, v any) {
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = s.cache.Set(ctx, key, data, s.ttl)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "filling cache", "key", key, "error", err)
+	}
+}
+
+func (s *Cached) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
+	w := &invalidator{Store: s.Store}
+	u, err := w.UpdateUser(ctx, arg)
+	s.invalidate(ctx, w)
+	return u, err
+}
+
+func (s *Cached) GrantChirpyRedToUser(ctx context.Context, id uuid.UUID) error {
+	w := &invalidator{Store: s.Store}
+	err := w.GrantChirpyRedToUser(ctx, id)
+	s.invalidate(ctx, w)
+	return err
+}
+
+func (s *Cached) SetUserRole(ctx context.Context, id uuid.UUID, role string) error {
+	w := &invalidator{Store: s.Store}
+	err := w.SetUserRole(ctx, id, role)
+	s.invalidate(ctx, w)
+	return err
+}
+
+func (s *Cached) SetUserSuspended(ctx context.Context, id uuid.UUID, suspended bool) error {
+	w := &invalidator{Store: s.Store}
+	err := w.SetUserSuspended(ctx, id, suspended)
+	s.invalidate(ctx, w)
+	return err
+}
+
+func (s *Cached) SetPasswordReset(ctx context.Context, id uuid.UUID, tokenHash string) error {
+	w := &invalidator{Store: s.Store}
+	err := w.SetPasswordReset(ctx, id, tokenHash)
+	s.invalidate(ctx, w)
+	return err
+}
+
+func (s *Cached) SetUserChirpyRed(ctx context.Context, id uuid.UUID, red bool) error {
+	w := &invalidator{Store: s.Store}
+	err := w.SetUserChirpyRed(ctx, id, red)
+	s.invalidate(ctx, w)
+	return err
+}
+
+func (s *Cached) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	w := &invalidator{Store: s.Store}
+	err := w.DeleteUser(ctx, id)
+	s.invalidate(ctx, w)
+	return err
+}
+
+func (s *Cached) DeleteAllUsers(ctx context.Context) error {
+	w := &invalidator{Store: s.Store}
+	err := w.DeleteAllUsers(ctx)
+	s.invalidate(ctx, w)
+	return err
+}
+
+func (s *Cached) ClearTable(ctx context.Context, table string) (int, error) {
+	w := &invalidator{Store: s.Store}
+	n, err := w.ClearTable(ctx, table)
+	s.invalidate(ctx, w)
+	return n, err
+}
+
+func (s *Cached) Load(ctx context.Context, d Dump) error {
+	w := &invalidator{Store: s.Store}
+	err := w.Load(ctx, d)
+	s.invalidate(ctx, w)
+	return err
+}
+
+func (s *Cached) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
+	w := &invalidator{Store: s.Store}
+	c, err := w.CreateChirp(ctx, arg)
+	s.invalidate(ctx, w)
+	return c, err
+}
+
+func (s *Cached) SetChirpHidden(ctx context.Context, id uuid.UUID, hidden bool) error {
+	w := &invalidator{Store: s.Store}
+	err := w.SetChirpHidden(ctx, id, hidden)
+	s.invalidate(ctx, w)
+	return err
+}
+
+func (s *Cached) DeleteChirp(ctx context.Context, id uuid.UUID) error {
+	w := &invalidator{Store: s.Store}
+	err := w.DeleteChirp(ctx, id)
+	s.invalidate(ctx, w)
+	return err
+}
+
+// InvalidateUser, InvalidateChirp and Clear drop entries after changes
+// this Cached didn't see, such as writes made by another instance.
+func (s *Cached) InvalidateUser(ctx context.Context, id uuid.UUID) {
+	s.invalidate(ctx, &invalidator{keys: []string{userKey(id)}})
+}
+
+func (s *Cached) InvalidateChirp(ctx context.Context, c Chirp) {
+	s.invalidate(ctx, &invalidator{keys: []string{chirpKey(c.ID), allChirpsKey, authorChirpsKey(c.UserID)}})
+}
+
+func (s *Cached) Clear(ctx context.Context) {
+	s.invalidate(ctx, &invalidator{clear: true})
+}
+
+// InTx reads straight from the transaction, so uncommitted rows never
+// reach the cache, and invalidates only after the commit succeeded.
+func (s *Cached) InTx(ctx context.Context, fn func(Store) error) error {
+	var w *invalidator
+	err := s.Store.InTx(ctx, func(tx Store) error {
+		// A fresh invalidator per attempt, in case the caller retries.
+		w = &invalidator{Store: tx}
+		return fn(w)
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidate(ctx, w)
+	return nil
+}
+
+// invalidate drops the keys w collected. It runs after the write is
+// durable, so it ignores the caller's cancellation: giving up here would
+// leave stale entries behind until their TTL runs out.
+func (s *Cached) invalidate(ctx context.Context, w *invalidator) {
+	if len(w.keys) == 0 && !w.clear {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	s.gen.Add(1)
+	for _, key := range w.keys {
+		s.group.Forget(key)
+	}
+
+	var err error
+	if w.clear {
+		err = s.cache.Clear(ctx)
+	} else {
+		err = s.cache.Delete(ctx, w.keys...)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "invalidating cache", "keys", w.keys, "clear", w.clear, "error", err)
+	}
+}
+
+// invalidator wraps the writes of a Store and records which cache keys
+// each successful write made stale.
+type invalidator struct {
+	Store
+	keys  []string
+	clear bool
+}
+
+func (w *invalidator) InTx(ctx context.Context, fn func(Store) error) error {
+	return fn(w)
+}
+
+func (w *invalidator) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
+	u, err := w.Store.UpdateUser(ctx, arg)
+	if err == nil {
+		w.keys = append(w.keys, userKey(arg.ID))
+	}
+	return u, err
+}
+
+func (w *invalidator) GrantChirpyRedToUser(ctx context.Context, id uuid.UUID) error {
+	err := w.Store.GrantChirpyRedToUser(ctx, id)
+	if err == nil {
+		w.keys = append(w.keys, userKey(id))
+	}
+	return err
+}
+
+func (w *invalidator) SetUserRole(ctx context.Context, id uuid.UUID, role string) error {
+	err := w.Store.SetUserRole(ctx, id, role)
+	if err == nil {
+		w.keys = append(w.keys, userKey(id))
+	}
+	return err
+}
+
+func (w *invalidator) SetUserSuspended(ctx context.Context, id uuid.UUID, suspended bool) error {
+	err := w.Store.SetUserSuspended(ctx, id, suspended)
+	if err == nil {
+		w.keys = append(w.keys, userKey(id))
+	}
+	return err
+}
+
+func (w *invalidator) SetPasswordReset(ctx context.Context, id uuid.UUID, tokenHash string) error {
+	err := w.Store.SetPasswordReset(ctx, id, tokenHash)
+	if err == nil {
+		w.keys = append(w.keys, userKey(id))
+	}
+	return err
+}
+
+func (w *invalidator) SetUserChirpyRed(ctx context.Context, id uuid.UUID, red bool) error {
+	err := w.Store.SetUserChirpyRed(ctx, id, red)
+	if err == nil {
+		w.keys = append(w.keys, userKey(id))
+	}
+	return err
+}
+
+// DeleteUser clears everything, because the cascade takes chirps along
+// whose keys aren't known here.
+func (w *invalidator) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	err := w.Store.DeleteUser(ctx, id)
+	if err == nil {
+		w.clear = true
+	}
+	return err
+}
+
+func (w *invalidator) DeleteAllUsers(ctx context.Context) error {
+	err := w.Store.DeleteAllUsers(ctx)
+	if err == nil {
+		w.clear = true
+	}
+	return err
+}
+
+// ClearTable and Load touch rows whose keys aren't known here, so they
+// clear everything too. Load clears even when it fails, because outside a
+// transaction it may have inserted some rows before failing.
+func (w *invalidator) ClearTable(ctx context.Context, table string) (int, error) {
+	n, err := w.Store.ClearTable(ctx, table)
+	if err == nil {
+		w.clear = true
+	}
+	return n, err
+}
+
+func (w *invalidator) Load(ctx context.Context, d Dump) error {
+	err := w.Store.Load(ctx, d)
+	w.clear = true
+	return err
+}
+
+func (w *invalidator) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
+	c, err := w.Store.CreateChirp(ctx, arg)
+	if err == nil {
+		w.keys = append(w.keys, allChirpsKey, authorChirpsKey(c.UserID))
+	}
+	return c, err
+}
+
+// SetChirpHidden and DeleteChirp look the chirp up first to learn whose
+// timeline it drops out of. DeleteChirp looks up the replies as well,
+// since the delete unsets their parent.
+func (w *invalidator) SetChirpHidden(ctx context.Context, id uuid.UUID, hidden bool) error {
+	c, lookupErr := w.Store.GetChirpByID(ctx, id)
+	err := w.Store.SetChirpHidden(ctx, id, hidden)
+	if err == nil {
+		w.chirpChanged(id, c, lookupErr)
+	}
+	return err
+}
+
+func (w *invalidator) DeleteChirp(ctx context.Context, id uuid.UUID) error {
+	c, lookupErr := w.Store.GetChirpByID(ctx, id)
+	replies, repliesErr := w.Store.GetChirpReplies(ctx, id)
+	err := w.Store.DeleteChirp(ctx, id)
+	if err != nil {
+		return err
+	}
+	w.chirpChanged(id, c, lookupErr)
+	for _, r := range replies {
+		w.chirpChanged(r.ID, r, nil)
+	}
+	if repliesErr != nil {
+		w.clear = true
+	}
+	return nil
+}
+
+func (w *invalidator) chirpChanged(id uuid.UUID, c Chirp, lookupErr error) {
+	w.keys = append(w.keys, chirpKey(id), allChirpsKey)
+	if lookupErr == nil {
+		w.keys = append(w.keys, authorChirpsKey(c.UserID))
+	}
+}
@@ -0,0 +1,389 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/cache"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/TheMaru/go-http-server/internal/store/storetest"
+	"github.com/google/uuid"
+)
+
+func TestCached(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewCached(store.NewMemory(), cache.NewLRU(100), time.Minute)
+	})
+}
+
+// countingStore counts the reads that reach it and can hold them until
+// release is closed.
+type countingStore struct {
+	store.Store
+	reads   atomic.Int32
+	release chan struct{}
+}
+
+func (s *countingStore) wait() {
+	s.reads.Add(1)
+	if s.release != nil {
+		<-s.release
+	}
+}
+
+func (s *countingStore) GetUserByID(ctx context.Context, id uuid.UUID) (store.User, error) {
+	s.wait()
+	return s.Store.GetUserByID(ctx, id)
+}
+
+func (s *countingStore) GetChirpByID(ctx context.Context, id uuid.UUID) (store.Chirp, error) {
+	s.wait()
+	return s.Store.GetChirpByID(ctx, id)
+}
+
+func (s *countingStore) GetChirpsAsc(ctx context.Context) ([]store.Chirp, error) {
+	s.wait()
+	return s.Store.GetChirpsAsc(ctx)
+}
+
+func (s *countingStore) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]store.Chirp, error) {
+	s.wait()
+	return s.Store.GetChirpsByAuthor(ctx, userID)
+}
+
+func TestCachedServesRepeatReadsFromCache(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	u, _ := mem.CreateUser(ctx, store.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+	c, _ := mem.CreateChirp(ctx, store.CreateChirpParams{Body: "hi", UserID: u.ID})
+
+	counting := &countingStore{Store: mem}
+	s := store.NewCached(counting, cache.NewLRU(100), time.Minute)
+	for range 3 {
+		if _, err := s.GetUserByID(ctx, u.ID); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetChirpByID(ctx, c.ID)
+		if err != nil || got.Body != "hi" {
+			t.Fatalf("GetChirpByID() = %+v, %v", got, err)
+		}
+		if chirps, err := s.GetChirpsAsc(ctx); err != nil || len(chirps) != 1 {
+			t.Fatalf("GetChirpsAsc() = %v, %v", chirps, err)
+		}
+		if _, err := s.GetChirpsByAuthor(ctx, u.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := counting.reads.Load(); n != 4 {
+		t.Errorf("store saw %d reads, want 4", n)
+	}
+
+	// Misses are not cached.
+	for range 2 {
+		_, err := s.GetChirpByID(ctx, uuid.New())
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("GetChirpByID(unknown) error = %v, want ErrNotFound", err)
+		}
+	}
+	if n := counting.reads.Load(); n != 6 {
+		t.Errorf("store saw %d reads, want 6", n)
+	}
+}
+
+func TestCachedInvalidatesOnWrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(ctx context.Context, s store.Store, u store.User, c store.Chirp) error
+		check func(t *testing.T, s store.Store, u store.User, c store.Chirp)
+	}{
+		{
+			name: "UpdateUser",
+			write: func(ctx context.Context, s store.Store, u store.User, _ store.Chirp) error {
+				_, err := s.UpdateUser(ctx, store.UpdateUserParams{ID: u.ID, Email: "new@example.com", HashedPassword: "y"})
+				return err
+			},
+			check: func(t *testing.T, s store.Store, u store.User, _ store.Chirp) {
+				got, _ := s.GetUserByID(context.Background(), u.ID)
+				if got.Email != "new@example.com" {
+					t.Errorf("GetUserByID().Email = %q, want the updated email", got.Email)
+				}
+			},
+		},
+		{
+			name: "GrantChirpyRed",
+			write: func(ctx context.Context, s store.Store, u store.User, _ store.Chirp) error {
+				return s.GrantChirpyRedToUser(ctx, u.ID)
+			},
+			check: func(t *testing.T, s store.Store, u store.User, _ store.Chirp) {
+				got, _ := s.GetUserByID(context.Background(), u.ID)
+				if !got.IsChirpyRed {
+					t.Error("GetUserByID().IsChirpyRed = false after the upgrade")
+				}
+			},
+		},
+		{
+			name: "SetUserRole",
+			write: func(ctx context.Context, s store.Store, u store.User, _ store.Chirp) error {
+				return s.SetUserRole(ctx, u.ID, store.RoleModerator)
+			},
+			check: func(t *testing.T, s store.Store, u store.User, _ store.Chirp) {
+				got, _ := s.GetUserByID(context.Background(), u.ID)
+				if got.Role != store.RoleModerator {
+					t.Errorf("GetUserByID().Role = %q, want the new role", got.Role)
+				}
+			},
+		},
+		{
+			name: "SetUserSuspended",
+			write: func(ctx context.Context, s store.Store, u store.User, _ store.Chirp) error {
+				return s.SetUserSuspended(ctx, u.ID, true)
+			},
+			check: func(t *testing.T, s store.Store, u store.User, _ store.Chirp) {
+				got, _ := s.GetUserByID(context.Background(), u.ID)
+				if !got.SuspendedAt.Valid {
+					t.Error("GetUserByID().SuspendedAt is unset after the suspension")
+				}
+			},
+		},
+		{
+			name: "DeleteUser",
+			write: func(ctx context.Context, s store.Store, u store.User, _ store.Chirp) error {
+				return s.DeleteUser(ctx, u.ID)
+			},
+			check: func(t *testing.T, s store.Store, u store.User, c store.Chirp) {
+				if _, err := s.GetUserByID(context.Background(), u.ID); !errors.Is(err, store.ErrNotFound) {
+					t.Errorf("GetUserByID(deleted) error = %v, want ErrNotFound", err)
+				}
+				if _, err := s.GetChirpByID(context.Background(), c.ID); !errors.Is(err, store.ErrNotFound) {
+					t.Errorf("GetChirpByID(of deleted user) error = %v, want ErrNotFound", err)
+				}
+				if all, _ := s.GetChirpsAsc(context.Background()); len(all) != 0 {
+					t.Errorf("timeline has %d chirps, want none", len(all))
+				}
+			},
+		},
+		{
+			name: "CreateChirp",
+			write: func(ctx context.Context, s store.Store, u store.User, _ store.Chirp) error {
+				_, err := s.CreateChirp(ctx, store.CreateChirpParams{Body: "second", UserID: u.ID})
+				return err
+			},
+			check: func(t *testing.T, s store.Store, u store.User, _ store.Chirp) {
+				all, _ := s.GetChirpsAsc(context.Background())
+				mine, _ := s.GetChirpsByAuthor(context.Background(), u.ID)
+				if len(all) != 2 || len(mine) != 2 {
+					t.Errorf("timelines have %d and %d chirps, want 2 each", len(all), len(mine))
+				}
+			},
+		},
+		{
+			name: "DeleteChirp",
+			write: func(ctx context.Context, s store.Store, _ store.User, c store.Chirp) error {
+				return s.DeleteChirp(ctx, c.ID)
+			},
+			check: func(t *testing.T, s store.Store, u store.User, c store.Chirp) {
+				_, err := s.GetChirpByID(context.Background(), c.ID)
+				if !errors.Is(err, store.ErrNotFound) {
+					t.Errorf("GetChirpByID(deleted) error = %v, want ErrNotFound", err)
+				}
+				all, _ := s.GetChirpsAsc(context.Background())
+				mine, _ := s.GetChirpsByAuthor(context.Background(), u.ID)
+				if len(all) != 0 || len(mine) != 0 {
+					t.Errorf("timelines have %d and %d chirps, want none", len(all), len(mine))
+				}
+			},
+		},
+		{
+			name: "SetChirpHidden",
+			write: func(ctx context.Context, s store.Store, _ store.User, c store.Chirp) error {
+				return s.SetChirpHidden(ctx, c.ID, true)
+			},
+			check: func(t *testing.T, s store.Store, u store.User, c store.Chirp) {
+				got, _ := s.GetChirpByID(context.Background(), c.ID)
+				if !got.HiddenAt.Valid {
+					t.Error("GetChirpByID().HiddenAt is unset after hiding")
+				}
+				all, _ := s.GetChirpsAsc(context.Background())
+				mine, _ := s.GetChirpsByAuthor(context.Background(), u.ID)
+				if len(all) != 0 || len(mine) != 0 {
+					t.Errorf("timelines have %d and %d chirps, want none", len(all), len(mine))
+				}
+			},
+		},
+		{
+			name: "DeleteAllUsers",
+			write: func(ctx context.Context, s store.Store, _ store.User, _ store.Chirp) error {
+				return s.DeleteAllUsers(ctx)
+			},
+			check: func(t *testing.T, s store.Store, u store.User, _ store.Chirp) {
+				_, err := s.GetUserByID(context.Background(), u.ID)
+				if !errors.Is(err, store.ErrNotFound) {
+					t.Errorf("GetUserByID(deleted) error = %v, want ErrNotFound", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, inTx := range []bool{false, true} {
+			name := tt.name
+			if inTx {
+				name += "InTx"
+			}
+			t.Run(name, func(t *testing.T) {
+				ctx := context.Background()
+				s := store.NewCached(store.NewMemory(), cache.NewLRU(100), time.Minute)
+				u, _ := s.CreateUser(ctx, store.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+				c, _ := s.CreateChirp(ctx, store.CreateChirpParams{Body: "first", UserID: u.ID})
+
+				// Warm every key the write could touch.
+				s.GetUserByID(ctx, u.ID)
+				s.GetChirpByID(ctx, c.ID)
+				s.GetChirpsAsc(ctx)
+				s.GetChirpsByAuthor(ctx, u.ID)
+
+				var err error
+				if inTx {
+					err = s.InTx(ctx, func(tx store.Store) error { return tt.write(ctx, tx, u, c) })
+				} else {
+					err = tt.write(ctx, s, u, c)
+				}
+				if err != nil {
+					t.Fatalf("write error = %v", err)
+				}
+				tt.check(t, s, u, c)
+			})
+		}
+	}
+}
+
+func TestCachedSkipsUncommittedReads(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewCached(store.NewMemory(), cache.NewLRU(100), time.Minute)
+	u, _ := s.CreateUser(ctx, store.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+
+	rollback := errors.New("rollback")
+	err := s.InTx(ctx, func(tx store.Store) error {
+		if _, err := tx.CreateChirp(ctx, store.CreateChirpParams{Body: "never", UserID: u.ID}); err != nil {
+			return err
+		}
+		if chirps, _ := tx.GetChirpsAsc(ctx); len(chirps) != 1 {
+			t.Errorf("GetChirpsAsc() in tx = %d chirps, want 1", len(chirps))
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("InTx() error = %v, want %v", err, rollback)
+	}
+	if chirps, _ := s.GetChirpsAsc(ctx); len(chirps) != 0 {
+		t.Errorf("GetChirpsAsc() after rollback = %d chirps, want 0", len(chirps))
+	}
+}
+
+func TestCachedCollapsesConcurrentMisses(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	u, _ := mem.CreateUser(ctx, store.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+
+	counting := &countingStore{Store: mem, release: make(chan struct{})}
+	s := store.NewCached(counting, cache.NewLRU(100), time.Minute)
+
+	const callers = 10
+	var wg sync.WaitGroup
+	var started sync.WaitGroup
+	started.Add(callers)
+	for range callers {
+		wg.Go(func() {
+			started.Done()
+			if _, err := s.GetUserByID(ctx, u.ID); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	started.Wait()
+	// Give the callers a moment to pile up behind the first query.
+	time.Sleep(20 * time.Millisecond)
+	close(counting.release)
+	wg.Wait()
+
+	if n := counting.reads.Load(); n != 1 {
+		t.Errorf("store saw %d reads, want 1", n)
+	}
+}
+
+func TestCachedLoadOutlivesFirstCaller(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	u, _ := mem.CreateUser(ctx, store.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+
+	counting := &countingStore{Store: mem, release: make(chan struct{})}
+	s := store.NewCached(counting, cache.NewLRU(100), time.Minute)
+
+	firstCtx, cancel := context.WithCancel(ctx)
+	first := make(chan error, 1)
+	go func() {
+		_, err := s.GetUserByID(firstCtx, u.ID)
+		first <- err
+	}()
+	for counting.reads.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan error, 1)
+	go func() {
+		_, err := s.GetUserByID(ctx, u.ID)
+		second <- err
+	}()
+	// Give the second caller a moment to join the load.
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-first:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("first caller error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("first caller still waiting for the load after giving up")
+	}
+	close(counting.release)
+	if err := <-second; err != nil {
+		t.Errorf("second caller error = %v, want the load to finish for it", err)
+	}
+	if _, err := s.GetUserByID(ctx, u.ID); err != nil || counting.reads.Load() != 1 {
+		t.Errorf("GetUserByID() after the load = %v with %d store reads, want it cached", err, counting.reads.Load())
+	}
+}
+
+// brokenCache fails every call.
+type brokenCache struct{}
+
+var errCacheDown = errors.New("cache down")
+
+func (brokenCache) Get(context.Context, string) ([]byte, bool, error) {
+	return nil, false, errCacheDown
+}
+func (brokenCache) Set(context.Context, string, []byte, time.Duration) error {
+	return errCacheDown
+}
+func (brokenCache) Delete(context.Context, ...string) error { return errCacheDown }
+func (brokenCache) Clear(context.Context) error             { return errCacheDown }
+
+func TestCachedSurvivesBrokenCache(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewCached(store.NewMemory(), brokenCache{}, time.Minute)
+	u, err := s.CreateUser(ctx, store.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GrantChirpyRedToUser(ctx, u.ID); err != nil {
+		t.Errorf("GrantChirpyRedToUser() error = %v, want the write to succeed", err)
+	}
+	got, err := s.GetUserByID(ctx, u.ID)
+	if err != nil || !got.IsChirpyRed {
+		t.Errorf("GetUserByID() = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Driver names as registered with database/sql.
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// sqlitePragmas are added to every SQLite DSN unless the URL sets the same
+// pragma. Without foreign_keys the ON DELETE CASCADE clauses are ignored,
+// and without busy_timeout concurrent writers fail instead of waiting.
+var sqlitePragmas = []string{"foreign_keys(1)", "busy_timeout(5000)", "journal_mode(WAL)"}
+
+// DriverFor picks the driver for dbURL by its scheme. postgres:// and
+// postgresql:// URLs are passed to lib/pq as they are, sqlite://path opens
+// the SQLite database file at path. A libpq key=value string such as
+// "host=localhost dbname=chirpy" has no scheme and goes to lib/pq too.
+func DriverFor(dbURL string) (driver, dsn string, err error) {
+	scheme, rest, ok := strings.Cut(dbURL, "://")
+	if !ok {
+		if IsKeyValueDSN(dbURL) {
+			return DriverPostgres, dbURL, nil
+		}
+		return "", "", fmt.Errorf("db url %q has no scheme", dbURL)
+	}
+
+	switch scheme {
+	case "postgres", "postgresql":
+		return DriverPostgres, dbURL, nil
+	case "sqlite":
+		path, rawQuery, _ := strings.Cut(rest, "?")
+		if path == "" {
+			return "", "", fmt.Errorf("sqlite url %q has no file path", dbURL)
+		}
+		query, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return "", "", fmt.Errorf("sqlite url %q: %w", dbURL, err)
+		}
+		for _, pragma := range sqlitePragmas {
+			name, _, _ := strings.Cut(pragma, "(")
+			if !slices.ContainsFunc(query["_pragma"], func(p string) bool {
+				return strings.HasPrefix(p, name)
+			}) {
+				query.Add("_pragma", pragma)
+			}
+		}
+		if !query.Has("_txlock") {
+			query.Set("_txlock", "immediate")
+		}
+		return DriverSQLite, "file:" + path + "?" + query.Encode(), nil
+	default:
+		return "", "", fmt.Errorf("db url scheme %q is not postgres or sqlite", scheme)
+	}
+}
+
+// IsKeyValueDSN reports whether dsn is a libpq key=value connection string
+// rather than a URL.
+func IsKeyValueDSN(dsn string) bool {
+	return !strings.Contains(dsn, "://") && strings.Contains(dsn, "=")
+}
+
+// New returns the Store for driver on top of db.
+func New(driver string, db *sql.DB, opts ...Option) (Store, error) {
+	switch driver {
+	case DriverPostgres:
+		return NewPostgres(db, opts...), nil
+	case DriverSQLite:
+		return NewSQLite(db, opts...), nil
+	default:
+		return nil, fmt.Errorf("no store for driver %q", driver)
+	}
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDriverFor(t *testing.T) {
+	tests := []struct {
+		name        string
+		dbURL       string
+		wantDriver  string
+		wantPath    string
+		wantPragmas []string
+		wantErr     bool
+	}{
+		{
+			name:       "Postgres",
+			dbURL:      "postgres://user:pw@localhost:5432/chirpy?sslmode=disable",
+			wantDriver: DriverPostgres,
+		},
+		{
+			name:       "Postgresql alias",
+			dbURL:      "postgresql://localhost/chirpy",
+			wantDriver: DriverPostgres,
+		},
+		{
+			name:       "Postgres key=value",
+			dbURL:      "host=localhost port=5432 dbname=chirpy sslmode=disable",
+			wantDriver: DriverPostgres,
+		},
+		{
+			name:        "SQLite relative path",
+			dbURL:       "sqlite://chirpy.db",
+			wantDriver:  DriverSQLite,
+			wantPath:    "file:chirpy.db",
+			wantPragmas: []string{"foreign_keys(1)", "busy_timeout(5000)", "journal_mode(WAL)"},
+		},
+		{
+			name:        "SQLite keeps explicit pragmas",
+			dbURL:       "sqlite:///tmp/chirpy.db?_pragma=journal_mode(DELETE)",
+			wantDriver:  DriverSQLite,
+			wantPath:    "file:/tmp/chirpy.db",
+			wantPragmas: []string{"journal_mode(DELETE)", "foreign_keys(1)", "busy_timeout(5000)"},
+		},
+		{
+			name:    "SQLite without path",
+			dbURL:   "sqlite://",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown scheme",
+			dbURL:   "mysql://localhost/chirpy",
+			wantErr: true,
+		},
+		{
+			name:    "No scheme",
+			dbURL:   "chirpy.db",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, dsn, err := DriverFor(tt.dbURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DriverFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if driver != tt.wantDriver {
+				t.Errorf("driver = %q, want %q", driver, tt.wantDriver)
+			}
+			if driver == DriverPostgres {
+				if dsn != tt.dbURL {
+					t.Errorf("dsn = %q, want the url unchanged", dsn)
+				}
+				return
+			}
+
+			path, rawQuery, _ := strings.Cut(dsn, "?")
+			if path != tt.wantPath {
+				t.Errorf("path = %q, want %q", path, tt.wantPath)
+			}
+			query, err := url.ParseQuery(rawQuery)
+			if err != nil {
+				t.Fatalf("parsing dsn query: %v", err)
+			}
+			if got := strings.Join(query["_pragma"], ","); got != strings.Join(tt.wantPragmas, ",") {
+				t.Errorf("pragmas = %s, want %s", got, strings.Join(tt.wantPragmas, ","))
+			}
+			if query.Get("_txlock") != "immediate" {
+				t.Errorf("_txlock = %q, want immediate", query.Get("_txlock"))
+			}
+		})
+	}
+}
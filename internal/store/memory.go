@@ -0,0 +1,887 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Memory is a Store that keeps everything in maps. It enforces the same
+// constraints as the Postgres schema: unique emails and tokens, chirps and
+// tokens must reference an existing user, and deleting users cascades.
+type Memory struct {
+	mu            sync.RWMutex
+	users         map[uuid.UUID]User
+	chirps        map[uuid.UUID]Chirp
+	refreshTokens map[string]RefreshToken
+	reports       map[uuid.UUID]Report
+	// auditEvents is ordered by Seq.
+	auditEvents []AuditEvent
+	// snapshots are encoded as the SQL stores keep them, so a saved Dump
+	// shares nothing with the caller's.
+	snapshots map[string]string
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:         map[uuid.UUID]User{},
+		chirps:        map[uuid.UUID]Chirp{},
+		refreshTokens: map[string]RefreshToken{},
+		reports:       map[uuid.UUID]Report{},
+		snapshots:     map[string]string{},
+	}
+}
+
+// InTx runs fn against a copy of the data while holding the write lock, and
+// swaps the copy in if fn succeeds. Transactions are therefore fully
+// serialized and never fail with ErrSerialization.
+func (m *Memory) InTx(ctx context.Context, fn func(Store) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &Memory{
+		users:         maps.Clone(m.users),
+		chirps:        maps.Clone(m.chirps),
+		refreshTokens: maps.Clone(m.refreshTokens),
+		reports:       maps.Clone(m.reports),
+		auditEvents:   slices.Clone(m.auditEvents),
+		snapshots:     maps.Clone(m.snapshots),
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	m.users, m.chirps, m.refreshTokens, m.reports = tx.users, tx.chirps, tx.refreshTokens, tx.reports
+	m.auditEvents, m.snapshots = tx.auditEvents, tx.snapshots
+	return nil
+}
+
+func (m *Memory) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(arg.Email, uuid.Nil) {
+		return User{}, ErrConflict
+	}
+
+	ts := now()
+	u := User{
+		ID:             uuid.New(),
+		CreatedAt:      ts,
+		UpdatedAt:      ts,
+		Email:          arg.Email,
+		HashedPassword: arg.HashedPassword,
+		Role:           RoleUser,
+	}
+	m.users[u.ID] = u
+	return u, nil
+}
+
+func (m *Memory) emailTaken(email string, except uuid.UUID) bool {
+	for _, u := range m.users {
+		if u.Email == email && u.ID != except {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (m *Memory) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[arg.ID]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	if m.emailTaken(arg.Email, arg.ID) {
+		return User{}, ErrConflict
+	}
+
+	u.Email = arg.Email
+	u.HashedPassword = arg.HashedPassword
+	u.UpdatedAt = now()
+	m.users[u.ID] = u
+	return u, nil
+}
+
+func (m *Memory) GrantChirpyRedToUser(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.IsChirpyRed = true
+	u.UpdatedAt = now()
+	m.users[id] = u
+	return nil
+}
+
+func (m *Memory) SetUserRole(ctx context.Context, id uuid.UUID, role string) error {
+	return m.updateUser(ctx, id, func(u *User) { u.Role = role })
+}
+
+func (m *Memory) SearchUsers(ctx context.Context, query string, limit int) ([]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := []User{}
+	for _, u := range m.users {
+		if strings.Contains(strings.ToLower(u.Email), strings.ToLower(query)) {
+			users = append(users, u)
+		}
+	}
+	slices.SortFunc(users, func(a, b User) int { return cmp.Compare(a.Email, b.Email) })
+	return users[:min(limit, len(users))], nil
+}
+
+func (m *Memory) SetUserSuspended(ctx context.Context, id uuid.UUID, suspended bool) error {
+	return m.updateUser(ctx, id, func(u *User) { u.SuspendedAt = nowIf(suspended) })
+}
+
+func (m *Memory) SetPasswordReset(ctx context.Context, id uuid.UUID, tokenHash string) error {
+	return m.updateUser(ctx, id, func(u *User) {
+		u.PasswordResetRequired = tokenHash != ""
+		u.PasswordResetTokenHash = sql.NullString{String: tokenHash, Valid: tokenHash != ""}
+	})
+}
+
+func (m *Memory) SetUserChirpyRed(ctx context.Context, id uuid.UUID, red bool) error {
+	return m.updateUser(ctx, id, func(u *User) { u.IsChirpyRed = red })
+}
+
+// updateUser applies change to the user and bumps UpdatedAt.
+func (m *Memory) updateUser(ctx context.Context, id uuid.UUID, change func(*User)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	change(&u)
+	u.UpdatedAt = now()
+	m.users[id] = u
+	return nil
+}
+
+func (m *Memory) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.users, id)
+	maps.DeleteFunc(m.chirps, func(_ uuid.UUID, c Chirp) bool { return c.UserID == id })
+	maps.DeleteFunc(m.refreshTokens, func(_ string, t RefreshToken) bool { return t.UserID == id })
+	m.orphanReplies()
+	m.dropOrphanReports()
+	for rid, r := range m.reports {
+		if r.ReporterID.UUID == id {
+			r.ReporterID = uuid.NullUUID{}
+		}
+		if r.ModeratorID.UUID == id {
+			r.ModeratorID = uuid.NullUUID{}
+		}
+		m.reports[rid] = r
+	}
+	return nil
+}
+
+func (m *Memory) DeleteAllUsers(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.users)
+	clear(m.chirps)
+	clear(m.refreshTokens)
+	clear(m.reports)
+	return nil
+}
+
+func (m *Memory) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
+	if err := ctx.Err(); err != nil {
+		return Chirp{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return Chirp{}, ErrNotFound
+	}
+	if _, ok := m.chirps[arg.ParentID]; arg.ParentID != uuid.Nil && !ok {
+		return Chirp{}, ErrNotFound
+	}
+
+	ts := now()
+	c := Chirp{
+		ID:        uuid.New(),
+		CreatedAt: ts,
+		UpdatedAt: ts,
+		Body:      arg.Body,
+		UserID:    arg.UserID,
+		ParentID:  uuid.NullUUID{UUID: arg.ParentID, Valid: arg.ParentID != uuid.Nil},
+	}
+	m.chirps[c.ID] = c
+	return c, nil
+}
+
+func (m *Memory) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	if err := ctx.Err(); err != nil {
+		return Chirp{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.chirps[id]
+	if !ok {
+		return Chirp{}, ErrNotFound
+	}
+	return c, nil
+}
+
+func (m *Memory) GetChirpsAsc(ctx context.Context) ([]Chirp, error) {
+	return m.filterChirps(ctx, func(c Chirp) bool { return !c.HiddenAt.Valid })
+}
+
+func (m *Memory) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	return m.filterChirps(ctx, func(c Chirp) bool { return c.UserID == userID && !c.HiddenAt.Valid })
+}
+
+func (m *Memory) GetHiddenChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	return m.filterChirps(ctx, func(c Chirp) bool { return c.UserID == userID && c.HiddenAt.Valid })
+}
+
+func (m *Memory) GetChirpReplies(ctx context.Context, parentID uuid.UUID) ([]Chirp, error) {
+	return m.filterChirps(ctx, func(c Chirp) bool { return c.ParentID.Valid && c.ParentID.UUID == parentID })
+}
+
+func (m *Memory) GetChirpPage(ctx context.Context, arg ChirpPageParams) ([]Chirp, error) {
+	chirps, err := m.filterChirps(ctx, func(c Chirp) bool {
+		return (arg.AuthorID == uuid.Nil || c.UserID == arg.AuthorID) &&
+			(!c.HiddenAt.Valid || c.UserID == arg.ViewerID) &&
+			(arg.Cursor == nil || c.CreatedAt.Before(arg.Cursor.CreatedAt) ||
+				c.CreatedAt.Equal(arg.Cursor.CreatedAt) && c.ID.String() < arg.Cursor.ID.String())
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(chirps)
+	return chirps[:min(arg.Limit, len(chirps))], nil
+}
+
+func (m *Memory) SetChirpHidden(ctx context.Context, id uuid.UUID, hidden bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.chirps[id]
+	if !ok {
+		return ErrNotFound
+	}
+	c.HiddenAt = nowIf(hidden)
+	c.UpdatedAt = now()
+	m.chirps[id] = c
+	return nil
+}
+
+func (m *Memory) filterChirps(ctx context.Context, keep func(Chirp) bool) ([]Chirp, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chirps := []Chirp{}
+	for _, c := range m.chirps {
+		if keep(c) {
+			chirps = append(chirps, c)
+		}
+	}
+	slices.SortFunc(chirps, func(a, b Chirp) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID.String(), b.ID.String())
+	})
+	return chirps, nil
+}
+
+func (m *Memory) DeleteChirp(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.chirps[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.chirps, id)
+	m.orphanReplies()
+	m.dropOrphanReports()
+	return nil
+}
+
+// orphanReplies unsets the parent of replies to chirps that are gone, as
+// the ON DELETE SET NULL on chirps.parent_id does.
+func (m *Memory) orphanReplies() {
+	for id, c := range m.chirps {
+		if _, ok := m.chirps[c.ParentID.UUID]; c.ParentID.Valid && !ok {
+			c.ParentID = uuid.NullUUID{}
+			m.chirps[id] = c
+		}
+	}
+}
+
+// dropOrphanReports deletes the reports on chirps that are gone, as the
+// cascade on reports.chirp_id does.
+func (m *Memory) dropOrphanReports() {
+	maps.DeleteFunc(m.reports, func(_ uuid.UUID, r Report) bool {
+		_, ok := m.chirps[r.ChirpID]
+		return !ok
+	})
+}
+
+func (m *Memory) CountChirpsByAuthor(ctx context.Context, userID uuid.UUID) (int, error) {
+	chirps, err := m.filterChirps(ctx, func(c Chirp) bool { return c.UserID == userID })
+	return len(chirps), err
+}
+
+func (m *Memory) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return RefreshToken{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	if _, ok := m.refreshTokens[arg.Token]; ok {
+		return RefreshToken{}, ErrConflict
+	}
+
+	ts := now()
+	t := RefreshToken{
+		Token:     arg.Token,
+		CreatedAt: ts,
+		UpdatedAt: ts,
+		UserID:    arg.UserID,
+		ExpiresAt: arg.ExpiresAt.UTC().Truncate(time.Microsecond),
+	}
+	m.refreshTokens[t.Token] = t
+	return t, nil
+}
+
+func (m *Memory) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return RefreshToken{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.refreshTokens[token]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	return t, nil
+}
+
+func (m *Memory) RevokeToken(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.refreshTokens[token]
+	if !ok {
+		return ErrNotFound
+	}
+	ts := now()
+	t.UpdatedAt = ts
+	t.RevokedAt = sql.NullTime{Time: ts, Valid: true}
+	m.refreshTokens[token] = t
+	return nil
+}
+
+func (m *Memory) GetRefreshTokensByUser(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tokens := []RefreshToken{}
+	for _, t := range m.refreshTokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b RefreshToken) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Token, b.Token)
+	})
+	return tokens, nil
+}
+
+func (m *Memory) RevokeUserTokens(ctx context.Context, userID uuid.UUID) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ts := now()
+	revoked := 0
+	for token, t := range m.refreshTokens {
+		if t.UserID == userID && !t.RevokedAt.Valid {
+			t.UpdatedAt = ts
+			t.RevokedAt = sql.NullTime{Time: ts, Valid: true}
+			m.refreshTokens[token] = t
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (m *Memory) CreateReport(ctx context.Context, arg CreateReportParams) (Report, error) {
+	if err := ctx.Err(); err != nil {
+		return Report{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.chirps[arg.ChirpID]; !ok {
+		return Report{}, ErrNotFound
+	}
+	if arg.ReporterID.Valid {
+		if _, ok := m.users[arg.ReporterID.UUID]; !ok {
+			return Report{}, ErrNotFound
+		}
+		for _, r := range m.reports {
+			if r.ChirpID == arg.ChirpID && r.ReporterID == arg.ReporterID {
+				return Report{}, ErrConflict
+			}
+		}
+	}
+
+	ts := now()
+	r := Report{
+		ID:         uuid.New(),
+		CreatedAt:  ts,
+		UpdatedAt:  ts,
+		ChirpID:    arg.ChirpID,
+		ReporterID: arg.ReporterID,
+		Reason:     arg.Reason,
+		Details:    arg.Details,
+		Status:     ReportOpen,
+	}
+	m.reports[r.ID] = r
+	return r, nil
+}
+
+func (m *Memory) GetReport(ctx context.Context, id uuid.UUID) (Report, error) {
+	if err := ctx.Err(); err != nil {
+		return Report{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.reports[id]
+	if !ok {
+		return Report{}, ErrNotFound
+	}
+	return r, nil
+}
+
+func (m *Memory) ListReports(ctx context.Context, status string, limit int) ([]Report, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	reports := []Report{}
+	for _, r := range m.reports {
+		if r.Status == status || (status == "" && r.Status != ReportResolved) {
+			reports = append(reports, r)
+		}
+	}
+	slices.SortFunc(reports, func(a, b Report) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID.String(), b.ID.String())
+	})
+	return reports[:min(limit, len(reports))], nil
+}
+
+func (m *Memory) ClaimReport(ctx context.Context, id, moderatorID uuid.UUID) error {
+	return oneReport(m.updateReports(ctx, moderatorID, func(r Report) bool { return r.ID == id }, func(r *Report) {
+		r.Status = ReportClaimed
+		r.ClaimedAt = sql.NullTime{Time: r.UpdatedAt, Valid: true}
+	}))
+}
+
+func (m *Memory) ResolveReport(ctx context.Context, arg ResolveReportParams) error {
+	return oneReport(m.updateReports(ctx, arg.ModeratorID, func(r Report) bool { return r.ID == arg.ID }, resolve(arg.Resolution)))
+}
+
+func (m *Memory) ResolveChirpReports(ctx context.Context, arg ResolveChirpReportsParams) (int, error) {
+	return m.updateReports(ctx, arg.ModeratorID, func(r Report) bool {
+		return r.ChirpID == arg.ChirpID && r.Status != ReportResolved
+	}, resolve(arg.Resolution))
+}
+
+func resolve(resolution string) func(*Report) {
+	return func(r *Report) {
+		r.Status = ReportResolved
+		r.Resolution = resolution
+		r.ResolvedAt = sql.NullTime{Time: r.UpdatedAt, Valid: true}
+	}
+}
+
+func oneReport(n int, err error) error {
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// updateReports applies change to the reports that match, on behalf of
+// moderatorID, and returns how many there were.
+func (m *Memory) updateReports(ctx context.Context, moderatorID uuid.UUID, match func(Report) bool, change func(*Report)) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[moderatorID]; !ok {
+		return 0, ErrNotFound
+	}
+	ts := now()
+	n := 0
+	for id, r := range m.reports {
+		if !match(r) {
+			continue
+		}
+		r.UpdatedAt = ts
+		r.ModeratorID = uuid.NullUUID{UUID: moderatorID, Valid: true}
+		change(&r)
+		m.reports[id] = r
+		n++
+	}
+	return n, nil
+}
+
+func (m *Memory) AppendAuditEvent(ctx context.Context, e AuditEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, found := m.auditIndex(e.Seq)
+	if found {
+		return ErrConflict
+	}
+	m.auditEvents = slices.Insert(m.auditEvents, i, e)
+	return nil
+}
+
+func (m *Memory) ChainAuditEvent(ctx context.Context, e AuditEvent, hash func(AuditEvent) string) (AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return AuditEvent{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.Seq, e.PrevHash = 1, ""
+	if n := len(m.auditEvents); n > 0 {
+		last := m.auditEvents[n-1]
+		e.Seq, e.PrevHash = last.Seq+1, last.Hash
+	}
+	e.Hash = hash(e)
+	m.auditEvents = append(m.auditEvents, e)
+	return e, nil
+}
+
+// auditIndex finds the event with seq, or where it would go.
+func (m *Memory) auditIndex(seq int64) (int, bool) {
+	return slices.BinarySearchFunc(m.auditEvents, seq, func(e AuditEvent, seq int64) int {
+		return cmp.Compare(e.Seq, seq)
+	})
+}
+
+func (m *Memory) LastAuditEvent(ctx context.Context) (AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return AuditEvent{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.auditEvents) == 0 {
+		return AuditEvent{}, ErrNotFound
+	}
+	return m.auditEvents[len(m.auditEvents)-1], nil
+}
+
+func (m *Memory) ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := []AuditEvent{}
+	for _, e := range slices.Backward(m.auditEvents) {
+		if len(events) == filter.Limit {
+			break
+		}
+		switch {
+		case filter.Action != "" && e.Action != filter.Action,
+			filter.Outcome != "" && e.Outcome != filter.Outcome,
+			filter.ActorID.Valid && e.ActorID != filter.ActorID,
+			filter.TargetUserID.Valid && e.TargetUserID != filter.TargetUserID,
+			filter.Since.Valid && e.CreatedAt.Before(filter.Since.Time),
+			filter.Until.Valid && !e.CreatedAt.Before(filter.Until.Time),
+			filter.BeforeSeq != 0 && e.Seq >= filter.BeforeSeq:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (m *Memory) AuditEventsAfter(ctx context.Context, afterSeq int64, limit int) ([]AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i, found := m.auditIndex(afterSeq)
+	if found {
+		i++
+	}
+	return slices.Clone(m.auditEvents[i:min(i+limit, len(m.auditEvents))]), nil
+}
+
+func (m *Memory) ClearTable(ctx context.Context, table string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch table {
+	case TableUsers:
+		n := len(m.users)
+		clear(m.users)
+		clear(m.chirps)
+		clear(m.refreshTokens)
+		clear(m.reports)
+		return n, nil
+	case TableChirps:
+		n := len(m.chirps)
+		clear(m.chirps)
+		clear(m.reports)
+		return n, nil
+	case TableRefreshTokens:
+		n := len(m.refreshTokens)
+		clear(m.refreshTokens)
+		return n, nil
+	case TableReports:
+		n := len(m.reports)
+		clear(m.reports)
+		return n, nil
+	default:
+		return 0, errUnknownTable(table)
+	}
+}
+
+func (m *Memory) Dump(ctx context.Context) (Dump, error) {
+	if err := ctx.Err(); err != nil {
+		return Dump{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	d := Dump{
+		Users:         slices.SortedFunc(maps.Values(m.users), func(a, b User) int { return byAge(a.CreatedAt, b.CreatedAt, a.ID.String(), b.ID.String()) }),
+		Chirps:        slices.SortedFunc(maps.Values(m.chirps), func(a, b Chirp) int { return byAge(a.CreatedAt, b.CreatedAt, a.ID.String(), b.ID.String()) }),
+		RefreshTokens: slices.SortedFunc(maps.Values(m.refreshTokens), func(a, b RefreshToken) int { return byAge(a.CreatedAt, b.CreatedAt, a.Token, b.Token) }),
+		Reports:       slices.SortedFunc(maps.Values(m.reports), func(a, b Report) int { return byAge(a.CreatedAt, b.CreatedAt, a.ID.String(), b.ID.String()) }),
+	}
+	return d, nil
+}
+
+// byAge orders rows oldest first, ties broken by key.
+func byAge(a, b time.Time, aKey, bKey string) int {
+	return cmp.Or(a.Compare(b), strings.Compare(aKey, bKey))
+}
+
+func (m *Memory) Load(ctx context.Context, d Dump) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range d.Users {
+		if _, ok := m.users[u.ID]; ok || m.emailTaken(u.Email, uuid.Nil) {
+			return ErrConflict
+		}
+		m.users[u.ID] = u
+	}
+	for _, c := range d.Chirps {
+		if _, ok := m.users[c.UserID]; !ok {
+			return ErrNotFound
+		}
+		if _, ok := m.chirps[c.ParentID.UUID]; c.ParentID.Valid && !ok {
+			return ErrNotFound
+		}
+		if _, ok := m.chirps[c.ID]; ok {
+			return ErrConflict
+		}
+		m.chirps[c.ID] = c
+	}
+	for _, t := range d.RefreshTokens {
+		if _, ok := m.users[t.UserID]; !ok {
+			return ErrNotFound
+		}
+		if _, ok := m.refreshTokens[t.Token]; ok {
+			return ErrConflict
+		}
+		m.refreshTokens[t.Token] = t
+	}
+	for _, r := range d.Reports {
+		if _, ok := m.chirps[r.ChirpID]; !ok {
+			return ErrNotFound
+		}
+		for _, ref := range []uuid.NullUUID{r.ReporterID, r.ModeratorID} {
+			if _, ok := m.users[ref.UUID]; ref.Valid && !ok {
+				return ErrNotFound
+			}
+		}
+		if _, ok := m.reports[r.ID]; ok {
+			return ErrConflict
+		}
+		for _, other := range m.reports {
+			if r.ReporterID.Valid && other.ChirpID == r.ChirpID && other.ReporterID == r.ReporterID {
+				return ErrConflict
+			}
+		}
+		m.reports[r.ID] = r
+	}
+	return nil
+}
+
+func (m *Memory) SaveSnapshot(ctx context.Context, name string, d Dump) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := encodeSnapshot(d)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots[name] = data
+	return nil
+}
+
+func (m *Memory) GetSnapshot(ctx context.Context, name string) (Dump, error) {
+	if err := ctx.Err(); err != nil {
+		return Dump{}, err
+	}
+	m.mu.RLock()
+	data, ok := m.snapshots[name]
+	m.mu.RUnlock()
+	if !ok {
+		return Dump{}, ErrNotFound
+	}
+	return decodeSnapshot(data)
+}
+
+func (m *Memory) DeleteSnapshot(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.snapshots[name]; !ok {
+		return ErrNotFound
+	}
+	delete(m.snapshots, name)
+	return nil
+}
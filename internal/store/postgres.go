@@ -0,0 +1,518 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/TheMaru/go-http-server/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Postgres implements Store on top of the sqlc generated queries.
+type Postgres struct {
+	db   *sql.DB // nil when bound to a transaction
+	opts options
+	q    *database.Queries
+}
+
+func NewPostgres(db *sql.DB, opts ...Option) *Postgres {
+	o := newOptions(opts)
+	return &Postgres{db: db, opts: o, q: database.New(o.wrap(db))}
+}
+
+func (p *Postgres) InTx(ctx context.Context, fn func(Store) error) error {
+	if p.db == nil {
+		return fn(p)
+	}
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return pgError(err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&Postgres{opts: p.opts, q: database.New(p.opts.wrap(tx))}); err != nil {
+		return err
+	}
+	return pgError(tx.Commit())
+}
+
+// pgError translates driver errors into the store's sentinel errors while
+// keeping the original error in the chain. Serialization failures and
+// deadlocks both mean "try again".
+func pgError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Name() {
+		case "unique_violation":
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		case "foreign_key_violation":
+			return fmt.Errorf("%w: %w", ErrNotFound, err)
+		case "serialization_failure", "deadlock_detected":
+			return fmt.Errorf("%w: %w", ErrSerialization, err)
+		}
+	}
+	return err
+}
+
+func rowsAffected(n int64, err error) error {
+	if err != nil {
+		return pgError(err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func userFromDB(u database.User) User {
+	return User{
+		ID:                     u.ID,
+		CreatedAt:              u.CreatedAt,
+		UpdatedAt:              u.UpdatedAt,
+		Email:                  u.Email,
+		HashedPassword:         u.HashedPassword,
+		IsChirpyRed:            u.IsChirpyRed,
+		Role:                   u.Role,
+		SuspendedAt:            u.SuspendedAt,
+		PasswordResetRequired:  u.PasswordResetRequired,
+		PasswordResetTokenHash: u.PasswordResetTokenHash,
+	}
+}
+
+func chirpFromDB(c database.Chirp) Chirp {
+	return Chirp{
+		ID:        c.ID,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+		Body:      c.Body,
+		UserID:    c.UserID,
+		HiddenAt:  c.HiddenAt,
+		ParentID:  c.ParentID,
+	}
+}
+
+func chirpsFromDB(dbChirps []database.Chirp) []Chirp {
+	chirps := make([]Chirp, len(dbChirps))
+	for i, c := range dbChirps {
+		chirps[i] = chirpFromDB(c)
+	}
+	return chirps
+}
+
+func refreshTokenFromDB(t database.RefreshToken) RefreshToken {
+	return RefreshToken{
+		Token:     t.Token,
+		CreatedAt: t.CreatedAt,
+		UpdatedAt: t.UpdatedAt,
+		UserID:    t.UserID,
+		ExpiresAt: t.ExpiresAt,
+		RevokedAt: t.RevokedAt,
+	}
+}
+
+func (p *Postgres) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	u, err := p.q.CreateUser(ctx, database.CreateUserParams{
+		Email:          arg.Email,
+		HashedPassword: arg.HashedPassword,
+	})
+	return userFromDB(u), pgError(err)
+}
+
+func (p *Postgres) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	u, err := p.q.GetUserByEmail(ctx, email)
+	return userFromDB(u), pgError(err)
+}
+
+func (p *Postgres) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	u, err := p.q.GetUserByID(ctx, id)
+	return userFromDB(u), pgError(err)
+}
+
+func (p *Postgres) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
+	u, err := p.q.UpdateUser(ctx, database.UpdateUserParams{
+		Email:          arg.Email,
+		HashedPassword: arg.HashedPassword,
+		ID:             arg.ID,
+	})
+	return userFromDB(u), pgError(err)
+}
+
+func (p *Postgres) GrantChirpyRedToUser(ctx context.Context, id uuid.UUID) error {
+	return rowsAffected(p.q.GrantChirpyRedToUser(ctx, id))
+}
+
+func (p *Postgres) SetUserRole(ctx context.Context, id uuid.UUID, role string) error {
+	return rowsAffected(p.q.SetUserRole(ctx, database.SetUserRoleParams{Role: role, ID: id}))
+}
+
+func (p *Postgres) SearchUsers(ctx context.Context, query string, limit int) ([]User, error) {
+	dbUsers, err := p.q.SearchUsersByEmail(ctx, database.SearchUsersByEmailParams{
+		Query:   likePattern(query),
+		MaxRows: int32(limit),
+	})
+	users := make([]User, len(dbUsers))
+	for i, u := range dbUsers {
+		users[i] = userFromDB(u)
+	}
+	return users, pgError(err)
+}
+
+func (p *Postgres) SetUserSuspended(ctx context.Context, id uuid.UUID, suspended bool) error {
+	return rowsAffected(p.q.SetUserSuspended(ctx, database.SetUserSuspendedParams{
+		SuspendedAt: nowIf(suspended),
+		ID:          id,
+	}))
+}
+
+func (p *Postgres) SetPasswordReset(ctx context.Context, id uuid.UUID, tokenHash string) error {
+	return rowsAffected(p.q.SetPasswordReset(ctx, database.SetPasswordResetParams{
+		PasswordResetRequired:  tokenHash != "",
+		PasswordResetTokenHash: sql.NullString{String: tokenHash, Valid: tokenHash != ""},
+		ID:                     id,
+	}))
+}
+
+func (p *Postgres) SetUserChirpyRed(ctx context.Context, id uuid.UUID, red bool) error {
+	return rowsAffected(p.q.SetUserChirpyRed(ctx, database.SetUserChirpyRedParams{IsChirpyRed: red, ID: id}))
+}
+
+func (p *Postgres) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	return rowsAffected(p.q.DeleteUser(ctx, id))
+}
+
+func (p *Postgres) DeleteAllUsers(ctx context.Context) error {
+	_, err := p.q.DeleteAllUsers(ctx)
+	return pgError(err)
+}
+
+func (p *Postgres) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
+	c, err := p.q.CreateChirp(ctx, database.CreateChirpParams{
+		Body:     arg.Body,
+		UserID:   arg.UserID,
+		ParentID: uuid.NullUUID{UUID: arg.ParentID, Valid: arg.ParentID != uuid.Nil},
+	})
+	return chirpFromDB(c), pgError(err)
+}
+
+func (p *Postgres) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	c, err := p.q.GetChirpByID(ctx, id)
+	return chirpFromDB(c), pgError(err)
+}
+
+func (p *Postgres) GetChirpsAsc(ctx context.Context) ([]Chirp, error) {
+	chirps, err := p.q.GetChirpsAsc(ctx)
+	return chirpsFromDB(chirps), pgError(err)
+}
+
+func (p *Postgres) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	chirps, err := p.q.GetChirpsByAuthor(ctx, userID)
+	return chirpsFromDB(chirps), pgError(err)
+}
+
+func (p *Postgres) GetHiddenChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	chirps, err := p.q.GetHiddenChirpsByAuthor(ctx, userID)
+	return chirpsFromDB(chirps), pgError(err)
+}
+
+func (p *Postgres) GetChirpReplies(ctx context.Context, parentID uuid.UUID) ([]Chirp, error) {
+	chirps, err := p.q.GetChirpReplies(ctx, uuid.NullUUID{UUID: parentID, Valid: true})
+	return chirpsFromDB(chirps), pgError(err)
+}
+
+func (p *Postgres) GetChirpPage(ctx context.Context, arg ChirpPageParams) ([]Chirp, error) {
+	params := database.GetChirpPageParams{
+		AuthorID: uuid.NullUUID{UUID: arg.AuthorID, Valid: arg.AuthorID != uuid.Nil},
+		ViewerID: uuid.NullUUID{UUID: arg.ViewerID, Valid: arg.ViewerID != uuid.Nil},
+		MaxRows:  int32(arg.Limit),
+	}
+	if arg.Cursor != nil {
+		params.BeforeCreatedAt = sql.NullTime{Time: arg.Cursor.CreatedAt, Valid: true}
+		params.BeforeID = uuid.NullUUID{UUID: arg.Cursor.ID, Valid: true}
+	}
+	chirps, err := p.q.GetChirpPage(ctx, params)
+	return chirpsFromDB(chirps), pgError(err)
+}
+
+func (p *Postgres) SetChirpHidden(ctx context.Context, id uuid.UUID, hidden bool) error {
+	return rowsAffected(p.q.SetChirpHidden(ctx, database.SetChirpHiddenParams{ID: id, HiddenAt: nowIf(hidden)}))
+}
+
+func (p *Postgres) DeleteChirp(ctx context.Context, id uuid.UUID) error {
+	return rowsAffected(p.q.DeleteChirp(ctx, id))
+}
+
+func (p *Postgres) CountChirpsByAuthor(ctx context.Context, userID uuid.UUID) (int, error) {
+	n, err := p.q.CountChirpsByAuthor(ctx, userID)
+	return int(n), pgError(err)
+}
+
+func (p *Postgres) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
+	t, err := p.q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{
+		Token:     arg.Token,
+		UserID:    arg.UserID,
+		ExpiresAt: arg.ExpiresAt,
+	})
+	return refreshTokenFromDB(t), pgError(err)
+}
+
+func (p *Postgres) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
+	t, err := p.q.GetRefreshToken(ctx, token)
+	return refreshTokenFromDB(t), pgError(err)
+}
+
+func (p *Postgres) RevokeToken(ctx context.Context, token string) error {
+	return rowsAffected(p.q.RevokeToken(ctx, token))
+}
+
+func (p *Postgres) GetRefreshTokensByUser(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	dbTokens, err := p.q.GetRefreshTokensByUser(ctx, userID)
+	tokens := make([]RefreshToken, len(dbTokens))
+	for i, t := range dbTokens {
+		tokens[i] = refreshTokenFromDB(t)
+	}
+	return tokens, pgError(err)
+}
+
+func (p *Postgres) RevokeUserTokens(ctx context.Context, userID uuid.UUID) (int, error) {
+	n, err := p.q.RevokeUserTokens(ctx, userID)
+	return int(n), pgError(err)
+}
+
+func (p *Postgres) CreateReport(ctx context.Context, arg CreateReportParams) (Report, error) {
+	r, err := p.q.CreateReport(ctx, database.CreateReportParams{
+		ChirpID:    arg.ChirpID,
+		ReporterID: arg.ReporterID,
+		Reason:     arg.Reason,
+		Details:    arg.Details,
+	})
+	return Report(r), pgError(err)
+}
+
+func (p *Postgres) GetReport(ctx context.Context, id uuid.UUID) (Report, error) {
+	r, err := p.q.GetReport(ctx, id)
+	return Report(r), pgError(err)
+}
+
+func (p *Postgres) ListReports(ctx context.Context, status string, limit int) ([]Report, error) {
+	dbReports, err := p.q.ListReports(ctx, database.ListReportsParams{Status: status, MaxRows: int32(limit)})
+	reports := make([]Report, len(dbReports))
+	for i, r := range dbReports {
+		reports[i] = Report(r)
+	}
+	return reports, pgError(err)
+}
+
+func (p *Postgres) ClaimReport(ctx context.Context, id, moderatorID uuid.UUID) error {
+	return rowsAffected(p.q.ClaimReport(ctx, database.ClaimReportParams{
+		ID:          id,
+		ModeratorID: uuid.NullUUID{UUID: moderatorID, Valid: true},
+	}))
+}
+
+func (p *Postgres) ResolveReport(ctx context.Context, arg ResolveReportParams) error {
+	return rowsAffected(p.q.ResolveReport(ctx, database.ResolveReportParams{
+		ID:          arg.ID,
+		ModeratorID: uuid.NullUUID{UUID: arg.ModeratorID, Valid: true},
+		Resolution:  arg.Resolution,
+	}))
+}
+
+func (p *Postgres) ResolveChirpReports(ctx context.Context, arg ResolveChirpReportsParams) (int, error) {
+	n, err := p.q.ResolveChirpReports(ctx, database.ResolveChirpReportsParams{
+		ChirpID:     arg.ChirpID,
+		ModeratorID: uuid.NullUUID{UUID: arg.ModeratorID, Valid: true},
+		Resolution:  arg.Resolution,
+	})
+	return int(n), pgError(err)
+}
+
+func (p *Postgres) AppendAuditEvent(ctx context.Context, e AuditEvent) error {
+	return pgError(p.q.AppendAuditEvent(ctx, database.AppendAuditEventParams{
+		Seq:          e.Seq,
+		CreatedAt:    e.CreatedAt,
+		Action:       e.Action,
+		Outcome:      e.Outcome,
+		ActorID:      e.ActorID,
+		ActorService: e.ActorService,
+		TargetUserID: e.TargetUserID,
+		Ip:           e.IP,
+		Details:      e.Details,
+		PrevHash:     e.PrevHash,
+		Hash:         e.Hash,
+	}))
+}
+
+// ChainAuditEvent locks audit_events against other appends, though not
+// against reads, for a transaction of its own. That transaction is read
+// committed, so the newest event it reads once it has the lock is the
+// newest one committed. Bound to a transaction already, it relies on that
+// transaction being serializable instead.
+func (p *Postgres) ChainAuditEvent(ctx context.Context, e AuditEvent, hash func(AuditEvent) string) (AuditEvent, error) {
+	if p.db == nil {
+		return chainAuditEvent(ctx, p, e, hash)
+	}
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return AuditEvent{}, pgError(err)
+	}
+	defer tx.Rollback()
+
+	txStore := &Postgres{opts: p.opts, q: database.New(p.opts.wrap(tx))}
+	if err := txStore.q.LockAuditEvents(ctx); err != nil {
+		return AuditEvent{}, pgError(err)
+	}
+	if e, err = chainAuditEvent(ctx, txStore, e, hash); err != nil {
+		return AuditEvent{}, err
+	}
+	return e, pgError(tx.Commit())
+}
+
+func (p *Postgres) LastAuditEvent(ctx context.Context) (AuditEvent, error) {
+	e, err := p.q.LastAuditEvent(ctx)
+	return auditEventFromDB(e), pgError(err)
+}
+
+func (p *Postgres) ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error) {
+	dbEvents, err := p.q.ListAuditEvents(ctx, database.ListAuditEventsParams{
+		Action:       filter.Action,
+		Outcome:      filter.Outcome,
+		ActorID:      filter.ActorID,
+		TargetUserID: filter.TargetUserID,
+		Since:        filter.Since,
+		Until:        filter.Until,
+		BeforeSeq:    filter.BeforeSeq,
+		MaxRows:      int32(filter.Limit),
+	})
+	return auditEventsFromDB(dbEvents), pgError(err)
+}
+
+func (p *Postgres) AuditEventsAfter(ctx context.Context, afterSeq int64, limit int) ([]AuditEvent, error) {
+	dbEvents, err := p.q.AuditEventsAfter(ctx, database.AuditEventsAfterParams{AfterSeq: afterSeq, MaxRows: int32(limit)})
+	return auditEventsFromDB(dbEvents), pgError(err)
+}
+
+func auditEventFromDB(e database.AuditEvent) AuditEvent {
+	return AuditEvent{
+		Seq:          e.Seq,
+		CreatedAt:    e.CreatedAt,
+		Action:       e.Action,
+		Outcome:      e.Outcome,
+		ActorID:      e.ActorID,
+		ActorService: e.ActorService,
+		TargetUserID: e.TargetUserID,
+		IP:           e.Ip,
+		Details:      e.Details,
+		PrevHash:     e.PrevHash,
+		Hash:         e.Hash,
+	}
+}
+
+func auditEventsFromDB(dbEvents []database.AuditEvent) []AuditEvent {
+	events := make([]AuditEvent, len(dbEvents))
+	for i, e := range dbEvents {
+		events[i] = auditEventFromDB(e)
+	}
+	return events
+}
+
+func (p *Postgres) ClearTable(ctx context.Context, table string) (int, error) {
+	var n int64
+	var err error
+	switch table {
+	case TableUsers:
+		n, err = p.q.DeleteAllUsers(ctx)
+	case TableChirps:
+		n, err = p.q.DeleteAllChirps(ctx)
+	case TableRefreshTokens:
+		n, err = p.q.DeleteAllRefreshTokens(ctx)
+	case TableReports:
+		n, err = p.q.DeleteAllReports(ctx)
+	default:
+		return 0, errUnknownTable(table)
+	}
+	return int(n), pgError(err)
+}
+
+func (p *Postgres) Dump(ctx context.Context) (Dump, error) {
+	var d Dump
+	users, err := p.q.ListAllUsers(ctx)
+	if err != nil {
+		return Dump{}, pgError(err)
+	}
+	for _, u := range users {
+		d.Users = append(d.Users, userFromDB(u))
+	}
+	chirps, err := p.q.ListAllChirps(ctx)
+	if err != nil {
+		return Dump{}, pgError(err)
+	}
+	d.Chirps = chirpsFromDB(chirps)
+	tokens, err := p.q.ListAllRefreshTokens(ctx)
+	if err != nil {
+		return Dump{}, pgError(err)
+	}
+	for _, t := range tokens {
+		d.RefreshTokens = append(d.RefreshTokens, refreshTokenFromDB(t))
+	}
+	reports, err := p.q.ListAllReports(ctx)
+	if err != nil {
+		return Dump{}, pgError(err)
+	}
+	for _, r := range reports {
+		d.Reports = append(d.Reports, Report(r))
+	}
+	return d, nil
+}
+
+func (p *Postgres) Load(ctx context.Context, d Dump) error {
+	for _, u := range d.Users {
+		if err := p.q.RestoreUser(ctx, database.RestoreUserParams(u)); err != nil {
+			return pgError(err)
+		}
+	}
+	for _, c := range d.Chirps {
+		if err := p.q.RestoreChirp(ctx, database.RestoreChirpParams(c)); err != nil {
+			return pgError(err)
+		}
+	}
+	for _, t := range d.RefreshTokens {
+		if err := p.q.RestoreRefreshToken(ctx, database.RestoreRefreshTokenParams(t)); err != nil {
+			return pgError(err)
+		}
+	}
+	for _, r := range d.Reports {
+		if err := p.q.RestoreReport(ctx, database.RestoreReportParams(r)); err != nil {
+			return pgError(err)
+		}
+	}
+	return nil
+}
+
+func (p *Postgres) SaveSnapshot(ctx context.Context, name string, d Dump) error {
+	data, err := encodeSnapshot(d)
+	if err != nil {
+		return err
+	}
+	return pgError(p.q.SaveTestSnapshot(ctx, database.SaveTestSnapshotParams{Name: name, Dump: data}))
+}
+
+func (p *Postgres) GetSnapshot(ctx context.Context, name string) (Dump, error) {
+	data, err := p.q.GetTestSnapshot(ctx, name)
+	if err != nil {
+		return Dump{}, pgError(err)
+	}
+	return decodeSnapshot(data)
+}
+
+func (p *Postgres) DeleteSnapshot(ctx context.Context, name string) error {
+	return rowsAffected(p.q.DeleteTestSnapshot(ctx, name))
+}
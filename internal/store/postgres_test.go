@@ -0,0 +1,35 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/TheMaru/go-http-server/internal/store/storetest"
+	_ "github.com/lib/pq"
+)
+
+// TestPostgres runs the suite against a migrated database named by
+// TEST_DB_URL. Every subtest starts by deleting all users, so never point it
+// at a database you care about.
+func TestPostgres(t *testing.T) {
+	dbURL := os.Getenv("TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DB_URL not set")
+	}
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s := store.NewPostgres(db)
+		if err := s.DeleteAllUsers(context.Background()); err != nil {
+			t.Fatalf("clearing database: %v", err)
+		}
+		return s
+	})
+}
@@ -0,0 +1,506 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/TheMaru/go-http-server/internal/database/sqlitedb"
+	"github.com/google/uuid"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLite implements Store on top of the sqlc queries generated from
+// sql/sqlite. SQLite has no gen_random_uuid() or NOW(), so IDs and
+// timestamps are made here instead of in the queries.
+type SQLite struct {
+	db   *sql.DB // nil when bound to a transaction
+	opts options
+	q    *sqlitedb.Queries
+}
+
+func NewSQLite(db *sql.DB, opts ...Option) *SQLite {
+	o := newOptions(opts)
+	return &SQLite{db: db, opts: o, q: sqlitedb.New(o.wrap(db))}
+}
+
+// InTx relies on the _txlock=immediate DSN setting from DriverFor: taking
+// the write lock up front makes every transaction serializable, and waiting
+// writers queue on busy_timeout instead of failing halfway through.
+func (s *SQLite) InTx(ctx context.Context, fn func(Store) error) error {
+	if s.db == nil {
+		return fn(s)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&SQLite{opts: s.opts, q: sqlitedb.New(s.opts.wrap(tx))}); err != nil {
+		return err
+	}
+	return sqliteError(tx.Commit())
+}
+
+// sqliteError is pgError for SQLite result codes. Foreign keys are only
+// enforced when the connection enabled them, which DriverFor takes care of.
+func sqliteError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		switch liteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+			return fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		// Extended busy codes share the primary code in the low byte.
+		if liteErr.Code()&0xff == sqlite3.SQLITE_BUSY {
+			return fmt.Errorf("%w: %w", ErrSerialization, err)
+		}
+	}
+	return err
+}
+
+func sqliteRowsAffected(n int64, err error) error {
+	if err != nil {
+		return sqliteError(err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	u, err := s.q.CreateUser(ctx, sqlitedb.CreateUserParams{
+		ID:             uuid.New(),
+		Now:            now(),
+		Email:          arg.Email,
+		HashedPassword: arg.HashedPassword,
+	})
+	return User(u), sqliteError(err)
+}
+
+func (s *SQLite) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	u, err := s.q.GetUserByEmail(ctx, email)
+	return User(u), sqliteError(err)
+}
+
+func (s *SQLite) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	u, err := s.q.GetUserByID(ctx, id)
+	return User(u), sqliteError(err)
+}
+
+func (s *SQLite) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
+	u, err := s.q.UpdateUser(ctx, sqlitedb.UpdateUserParams{
+		Email:          arg.Email,
+		HashedPassword: arg.HashedPassword,
+		Now:            now(),
+		ID:             arg.ID,
+	})
+	return User(u), sqliteError(err)
+}
+
+func (s *SQLite) GrantChirpyRedToUser(ctx context.Context, id uuid.UUID) error {
+	return sqliteRowsAffected(s.q.GrantChirpyRedToUser(ctx, sqlitedb.GrantChirpyRedToUserParams{
+		Now: now(),
+		ID:  id,
+	}))
+}
+
+func (s *SQLite) SetUserRole(ctx context.Context, id uuid.UUID, role string) error {
+	return sqliteRowsAffected(s.q.SetUserRole(ctx, sqlitedb.SetUserRoleParams{
+		Role: role,
+		Now:  now(),
+		ID:   id,
+	}))
+}
+
+func (s *SQLite) SearchUsers(ctx context.Context, query string, limit int) ([]User, error) {
+	dbUsers, err := s.q.SearchUsersByEmail(ctx, sqlitedb.SearchUsersByEmailParams{
+		Query:   sql.NullString{String: likePattern(query), Valid: true},
+		MaxRows: int64(limit),
+	})
+	users := make([]User, len(dbUsers))
+	for i, u := range dbUsers {
+		users[i] = User(u)
+	}
+	return users, sqliteError(err)
+}
+
+func (s *SQLite) SetUserSuspended(ctx context.Context, id uuid.UUID, suspended bool) error {
+	return sqliteRowsAffected(s.q.SetUserSuspended(ctx, sqlitedb.SetUserSuspendedParams{
+		SuspendedAt: nowIf(suspended),
+		Now:         now(),
+		ID:          id,
+	}))
+}
+
+func (s *SQLite) SetPasswordReset(ctx context.Context, id uuid.UUID, tokenHash string) error {
+	return sqliteRowsAffected(s.q.SetPasswordReset(ctx, sqlitedb.SetPasswordResetParams{
+		PasswordResetRequired:  tokenHash != "",
+		PasswordResetTokenHash: sql.NullString{String: tokenHash, Valid: tokenHash != ""},
+		Now:                    now(),
+		ID:                     id,
+	}))
+}
+
+func (s *SQLite) SetUserChirpyRed(ctx context.Context, id uuid.UUID, red bool) error {
+	return sqliteRowsAffected(s.q.SetUserChirpyRed(ctx, sqlitedb.SetUserChirpyRedParams{
+		IsChirpyRed: red,
+		Now:         now(),
+		ID:          id,
+	}))
+}
+
+func (s *SQLite) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	return sqliteRowsAffected(s.q.DeleteUser(ctx, id))
+}
+
+func (s *SQLite) DeleteAllUsers(ctx context.Context) error {
+	_, err := s.q.DeleteAllUsers(ctx)
+	return sqliteError(err)
+}
+
+func (s *SQLite) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
+	c, err := s.q.CreateChirp(ctx, sqlitedb.CreateChirpParams{
+		ID:       uuid.New(),
+		Now:      now(),
+		Body:     arg.Body,
+		UserID:   arg.UserID,
+		ParentID: uuid.NullUUID{UUID: arg.ParentID, Valid: arg.ParentID != uuid.Nil},
+	})
+	return Chirp(c), sqliteError(err)
+}
+
+func (s *SQLite) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	c, err := s.q.GetChirpByID(ctx, id)
+	return Chirp(c), sqliteError(err)
+}
+
+func (s *SQLite) GetChirpsAsc(ctx context.Context) ([]Chirp, error) {
+	chirps, err := s.q.GetChirpsAsc(ctx)
+	return chirpsFromSQLite(chirps), sqliteError(err)
+}
+
+func (s *SQLite) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	chirps, err := s.q.GetChirpsByAuthor(ctx, userID)
+	return chirpsFromSQLite(chirps), sqliteError(err)
+}
+
+func (s *SQLite) GetChirpReplies(ctx context.Context, parentID uuid.UUID) ([]Chirp, error) {
+	chirps, err := s.q.GetChirpReplies(ctx, uuid.NullUUID{UUID: parentID, Valid: true})
+	return chirpsFromSQLite(chirps), sqliteError(err)
+}
+
+func (s *SQLite) GetChirpPage(ctx context.Context, arg ChirpPageParams) ([]Chirp, error) {
+	params := sqlitedb.GetChirpPageParams{ViewerID: arg.ViewerID, MaxRows: int64(arg.Limit)}
+	if arg.AuthorID != uuid.Nil {
+		params.AuthorID = arg.AuthorID
+	}
+	if arg.Cursor != nil {
+		params.BeforeCreatedAt = arg.Cursor.CreatedAt
+		params.BeforeID = arg.Cursor.ID
+	}
+	chirps, err := s.q.GetChirpPage(ctx, params)
+	return chirpsFromSQLite(chirps), sqliteError(err)
+}
+
+func chirpsFromSQLite(dbChirps []sqlitedb.Chirp) []Chirp {
+	chirps := make([]Chirp, len(dbChirps))
+	for i, c := range dbChirps {
+		chirps[i] = Chirp(c)
+	}
+	return chirps
+}
+
+func (s *SQLite) GetHiddenChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	chirps, err := s.q.GetHiddenChirpsByAuthor(ctx, userID)
+	return chirpsFromSQLite(chirps), sqliteError(err)
+}
+
+func (s *SQLite) SetChirpHidden(ctx context.Context, id uuid.UUID, hidden bool) error {
+	return sqliteRowsAffected(s.q.SetChirpHidden(ctx, sqlitedb.SetChirpHiddenParams{
+		HiddenAt: nowIf(hidden),
+		Now:      now(),
+		ID:       id,
+	}))
+}
+
+func (s *SQLite) DeleteChirp(ctx context.Context, id uuid.UUID) error {
+	return sqliteRowsAffected(s.q.DeleteChirp(ctx, id))
+}
+
+func (s *SQLite) CountChirpsByAuthor(ctx context.Context, userID uuid.UUID) (int, error) {
+	n, err := s.q.CountChirpsByAuthor(ctx, userID)
+	return int(n), sqliteError(err)
+}
+
+func (s *SQLite) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
+	t, err := s.q.CreateRefreshToken(ctx, sqlitedb.CreateRefreshTokenParams{
+		Token:     arg.Token,
+		Now:       now(),
+		UserID:    arg.UserID,
+		ExpiresAt: arg.ExpiresAt.UTC(),
+	})
+	return RefreshToken(t), sqliteError(err)
+}
+
+func (s *SQLite) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
+	t, err := s.q.GetRefreshToken(ctx, token)
+	return RefreshToken(t), sqliteError(err)
+}
+
+func (s *SQLite) RevokeToken(ctx context.Context, token string) error {
+	return sqliteRowsAffected(s.q.RevokeToken(ctx, sqlitedb.RevokeTokenParams{
+		Now:   now(),
+		Token: token,
+	}))
+}
+
+func (s *SQLite) GetRefreshTokensByUser(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	dbTokens, err := s.q.GetRefreshTokensByUser(ctx, userID)
+	tokens := make([]RefreshToken, len(dbTokens))
+	for i, t := range dbTokens {
+		tokens[i] = RefreshToken(t)
+	}
+	return tokens, sqliteError(err)
+}
+
+func (s *SQLite) RevokeUserTokens(ctx context.Context, userID uuid.UUID) (int, error) {
+	n, err := s.q.RevokeUserTokens(ctx, sqlitedb.RevokeUserTokensParams{
+		Now:    now(),
+		UserID: userID,
+	})
+	return int(n), sqliteError(err)
+}
+
+func (s *SQLite) CreateReport(ctx context.Context, arg CreateReportParams) (Report, error) {
+	r, err := s.q.CreateReport(ctx, sqlitedb.CreateReportParams{
+		ID:         uuid.New(),
+		Now:        now(),
+		ChirpID:    arg.ChirpID,
+		ReporterID: arg.ReporterID,
+		Reason:     arg.Reason,
+		Details:    arg.Details,
+	})
+	return Report(r), sqliteError(err)
+}
+
+func (s *SQLite) GetReport(ctx context.Context, id uuid.UUID) (Report, error) {
+	r, err := s.q.GetReport(ctx, id)
+	return Report(r), sqliteError(err)
+}
+
+func (s *SQLite) ListReports(ctx context.Context, status string, limit int) ([]Report, error) {
+	dbReports, err := s.q.ListReports(ctx, sqlitedb.ListReportsParams{Status: status, MaxRows: int64(limit)})
+	reports := make([]Report, len(dbReports))
+	for i, r := range dbReports {
+		reports[i] = Report(r)
+	}
+	return reports, sqliteError(err)
+}
+
+func (s *SQLite) ClaimReport(ctx context.Context, id, moderatorID uuid.UUID) error {
+	return sqliteRowsAffected(s.q.ClaimReport(ctx, sqlitedb.ClaimReportParams{
+		Now:         now(),
+		ModeratorID: uuid.NullUUID{UUID: moderatorID, Valid: true},
+		ID:          id,
+	}))
+}
+
+func (s *SQLite) ResolveReport(ctx context.Context, arg ResolveReportParams) error {
+	return sqliteRowsAffected(s.q.ResolveReport(ctx, sqlitedb.ResolveReportParams{
+		Now:         now(),
+		ModeratorID: uuid.NullUUID{UUID: arg.ModeratorID, Valid: true},
+		Resolution:  arg.Resolution,
+		ID:          arg.ID,
+	}))
+}
+
+func (s *SQLite) ResolveChirpReports(ctx context.Context, arg ResolveChirpReportsParams) (int, error) {
+	n, err := s.q.ResolveChirpReports(ctx, sqlitedb.ResolveChirpReportsParams{
+		Now:         now(),
+		ModeratorID: uuid.NullUUID{UUID: arg.ModeratorID, Valid: true},
+		Resolution:  arg.Resolution,
+		ChirpID:     arg.ChirpID,
+	})
+	return int(n), sqliteError(err)
+}
+
+func (s *SQLite) AppendAuditEvent(ctx context.Context, e AuditEvent) error {
+	return sqliteError(s.q.AppendAuditEvent(ctx, sqlitedb.AppendAuditEventParams{
+		Seq:          e.Seq,
+		CreatedAt:    e.CreatedAt,
+		Action:       e.Action,
+		Outcome:      e.Outcome,
+		ActorID:      e.ActorID,
+		ActorService: e.ActorService,
+		TargetUserID: e.TargetUserID,
+		Ip:           e.IP,
+		Details:      e.Details,
+		PrevHash:     e.PrevHash,
+		Hash:         e.Hash,
+	}))
+}
+
+// ChainAuditEvent appends in a transaction of its own, which takes the
+// write lock up front like every SQLite transaction here.
+func (s *SQLite) ChainAuditEvent(ctx context.Context, e AuditEvent, hash func(AuditEvent) string) (AuditEvent, error) {
+	err := s.InTx(ctx, func(tx Store) error {
+		var err error
+		e, err = chainAuditEvent(ctx, tx, e, hash)
+		return err
+	})
+	return e, err
+}
+
+func (s *SQLite) LastAuditEvent(ctx context.Context) (AuditEvent, error) {
+	e, err := s.q.LastAuditEvent(ctx)
+	return auditEventFromSQLite(e), sqliteError(err)
+}
+
+func (s *SQLite) ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error) {
+	dbEvents, err := s.q.ListAuditEvents(ctx, sqlitedb.ListAuditEventsParams{
+		Action:       filter.Action,
+		Outcome:      filter.Outcome,
+		ActorID:      filter.ActorID,
+		TargetUserID: filter.TargetUserID,
+		Since:        filter.Since,
+		Until:        filter.Until,
+		BeforeSeq:    filter.BeforeSeq,
+		MaxRows:      int64(filter.Limit),
+	})
+	return auditEventsFromSQLite(dbEvents), sqliteError(err)
+}
+
+func (s *SQLite) AuditEventsAfter(ctx context.Context, afterSeq int64, limit int) ([]AuditEvent, error) {
+	dbEvents, err := s.q.AuditEventsAfter(ctx, sqlitedb.AuditEventsAfterParams{AfterSeq: afterSeq, MaxRows: int64(limit)})
+	return auditEventsFromSQLite(dbEvents), sqliteError(err)
+}
+
+func auditEventFromSQLite(e sqlitedb.AuditEvent) AuditEvent {
+	return AuditEvent{
+		Seq:          e.Seq,
+		CreatedAt:    e.CreatedAt,
+		Action:       e.Action,
+		Outcome:      e.Outcome,
+		ActorID:      e.ActorID,
+		ActorService: e.ActorService,
+		TargetUserID: e.TargetUserID,
+		IP:           e.Ip,
+		Details:      e.Details,
+		PrevHash:     e.PrevHash,
+		Hash:         e.Hash,
+	}
+}
+
+func auditEventsFromSQLite(dbEvents []sqlitedb.AuditEvent) []AuditEvent {
+	events := make([]AuditEvent, len(dbEvents))
+	for i, e := range dbEvents {
+		events[i] = auditEventFromSQLite(e)
+	}
+	return events
+}
+
+func (s *SQLite) ClearTable(ctx context.Context, table string) (int, error) {
+	var n int64
+	var err error
+	switch table {
+	case TableUsers:
+		n, err = s.q.DeleteAllUsers(ctx)
+	case TableChirps:
+		n, err = s.q.DeleteAllChirps(ctx)
+	case TableRefreshTokens:
+		n, err = s.q.DeleteAllRefreshTokens(ctx)
+	case TableReports:
+		n, err = s.q.DeleteAllReports(ctx)
+	default:
+		return 0, errUnknownTable(table)
+	}
+	return int(n), sqliteError(err)
+}
+
+func (s *SQLite) Dump(ctx context.Context) (Dump, error) {
+	var d Dump
+	users, err := s.q.ListAllUsers(ctx)
+	if err != nil {
+		return Dump{}, sqliteError(err)
+	}
+	for _, u := range users {
+		d.Users = append(d.Users, User(u))
+	}
+	chirps, err := s.q.ListAllChirps(ctx)
+	if err != nil {
+		return Dump{}, sqliteError(err)
+	}
+	d.Chirps = chirpsFromSQLite(chirps)
+	tokens, err := s.q.ListAllRefreshTokens(ctx)
+	if err != nil {
+		return Dump{}, sqliteError(err)
+	}
+	for _, t := range tokens {
+		d.RefreshTokens = append(d.RefreshTokens, RefreshToken(t))
+	}
+	reports, err := s.q.ListAllReports(ctx)
+	if err != nil {
+		return Dump{}, sqliteError(err)
+	}
+	for _, r := range reports {
+		d.Reports = append(d.Reports, Report(r))
+	}
+	return d, nil
+}
+
+func (s *SQLite) Load(ctx context.Context, d Dump) error {
+	for _, u := range d.Users {
+		if err := s.q.RestoreUser(ctx, sqlitedb.RestoreUserParams(u)); err != nil {
+			return sqliteError(err)
+		}
+	}
+	for _, c := range d.Chirps {
+		if err := s.q.RestoreChirp(ctx, sqlitedb.RestoreChirpParams(c)); err != nil {
+			return sqliteError(err)
+		}
+	}
+	for _, t := range d.RefreshTokens {
+		if err := s.q.RestoreRefreshToken(ctx, sqlitedb.RestoreRefreshTokenParams(t)); err != nil {
+			return sqliteError(err)
+		}
+	}
+	for _, r := range d.Reports {
+		if err := s.q.RestoreReport(ctx, sqlitedb.RestoreReportParams(r)); err != nil {
+			return sqliteError(err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLite) SaveSnapshot(ctx context.Context, name string, d Dump) error {
+	data, err := encodeSnapshot(d)
+	if err != nil {
+		return err
+	}
+	return sqliteError(s.q.SaveTestSnapshot(ctx, sqlitedb.SaveTestSnapshotParams{Name: name, Now: now(), Dump: data}))
+}
+
+func (s *SQLite) GetSnapshot(ctx context.Context, name string) (Dump, error) {
+	data, err := s.q.GetTestSnapshot(ctx, name)
+	if err != nil {
+		return Dump{}, sqliteError(err)
+	}
+	return decodeSnapshot(data)
+}
+
+func (s *SQLite) DeleteSnapshot(ctx context.Context, name string) error {
+	return sqliteRowsAffected(s.q.DeleteTestSnapshot(ctx, name))
+}
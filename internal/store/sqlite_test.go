@@ -0,0 +1,64 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/migrate"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/TheMaru/go-http-server/internal/store/storetest"
+)
+
+func TestSQLite(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewSQLite(newSQLiteDB(t))
+	})
+}
+
+func TestSQLiteAuditEventsAppendOnly(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteDB(t)
+	s := store.NewSQLite(db)
+	e := store.AuditEvent{Seq: 1, CreatedAt: time.Now().UTC(), Action: "login", Outcome: "success", Details: "{}", Hash: "h1"}
+	if err := s.AppendAuditEvent(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stmt := range []string{
+		"UPDATE audit_events SET outcome = 'failure'",
+		"DELETE FROM audit_events",
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err == nil {
+			t.Errorf("%s succeeded, want it refused", stmt)
+		}
+	}
+	if last, err := s.LastAuditEvent(ctx); err != nil || last.Outcome != "success" {
+		t.Errorf("LastAuditEvent() = %+v, %v, want the event untouched", last, err)
+	}
+}
+
+// newSQLiteDB opens a migrated database in a temporary directory.
+func newSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+	driver, dsn, err := store.DriverFor("sqlite://" + filepath.Join(t.TempDir(), "chirpy.db"))
+	if err != nil {
+		t.Fatalf("DriverFor() error = %v", err)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, driver)
+	if err != nil {
+		t.Fatalf("migrate.New() error = %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("applying migrations: %v", err)
+	}
+	return db
+}
@@ -0,0 +1,416 @@
+// Package store is the persistence boundary of Chirpy. Handlers only talk to
+// the Store interface, which has a Postgres implementation wrapping the sqlc
+// queries and an in-memory one for tests and local experiments. Both must
+// pass the suite in storetest.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/database"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotFound is returned when a row does not exist, including when a
+	// write references a user that does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write violates a uniqueness rule, such
+	// as two users sharing an email.
+	ErrConflict = errors.New("already exists")
+	// ErrSerialization is returned when the database aborted a transaction
+	// because it conflicted with a concurrent one. Running it again may
+	// succeed.
+	ErrSerialization = errors.New("transaction conflict")
+)
+
+// Statuses of a report. Reports start out open, are claimed by the
+// moderator working on them and end up resolved.
+const (
+	ReportOpen     = "open"
+	ReportClaimed  = "claimed"
+	ReportResolved = "resolved"
+)
+
+// Roles a user can have. New users get RoleUser.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+type User struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Email          string
+	HashedPassword string
+	IsChirpyRed    bool
+	Role           string
+	// SuspendedAt is set while an admin has suspended the user.
+	SuspendedAt sql.NullTime
+	// PasswordResetRequired makes the next login change the password,
+	// which takes the reset token whose SHA-256 hash, hex encoded, is
+	// PasswordResetTokenHash.
+	PasswordResetRequired  bool
+	PasswordResetTokenHash sql.NullString
+}
+
+type Chirp struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+	// HiddenAt is set while a moderator has hidden the chirp.
+	HiddenAt sql.NullTime
+	// ParentID is the chirp this one replies to. It is unset for chirps
+	// that start a thread and for replies whose parent was deleted.
+	ParentID uuid.NullUUID
+}
+
+// Report flags a chirp for the moderators.
+type Report struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	ChirpID   uuid.UUID
+	// ReporterID is unset for reports the profanity filter filed.
+	ReporterID uuid.NullUUID
+	Reason     string
+	Details    string
+	Status     string
+	// ModeratorID is who claimed or resolved the report.
+	ModeratorID uuid.NullUUID
+	ClaimedAt   sql.NullTime
+	ResolvedAt  sql.NullTime
+	Resolution  string
+}
+
+// AuditEvent is an entry of the append-only audit log. Hash covers the
+// event and PrevHash, the hash of the event before it, which chains the
+// log together.
+type AuditEvent struct {
+	Seq          int64
+	CreatedAt    time.Time
+	Action       string
+	Outcome      string
+	ActorID      uuid.NullUUID
+	ActorService string
+	TargetUserID uuid.NullUUID
+	IP           string
+	// Details is a JSON object.
+	Details  string
+	PrevHash string
+	Hash     string
+}
+
+type RefreshToken struct {
+	Token     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	RevokedAt sql.NullTime
+}
+
+type CreateUserParams struct {
+	Email          string
+	HashedPassword string
+}
+
+type UpdateUserParams struct {
+	Email          string
+	HashedPassword string
+	ID             uuid.UUID
+}
+
+// CreateChirpParams describes a new chirp. ParentID is the chirp it
+// replies to, or uuid.Nil for a chirp that starts a thread.
+type CreateChirpParams struct {
+	Body     string
+	UserID   uuid.UUID
+	ParentID uuid.UUID
+}
+
+// ChirpPageParams picks a page of chirps. AuthorID limits it to one
+// author unless it is uuid.Nil. Hidden chirps are left out, except for
+// ViewerID's. Cursor, unless nil, is the last chirp of the previous page.
+type ChirpPageParams struct {
+	AuthorID uuid.UUID
+	ViewerID uuid.UUID
+	Cursor   *Chirp
+	Limit    int
+}
+
+type CreateReportParams struct {
+	ChirpID    uuid.UUID
+	ReporterID uuid.NullUUID
+	Reason     string
+	Details    string
+}
+
+type ResolveReportParams struct {
+	ID          uuid.UUID
+	ModeratorID uuid.UUID
+	Resolution  string
+}
+
+type ResolveChirpReportsParams struct {
+	ChirpID     uuid.UUID
+	ModeratorID uuid.UUID
+	Resolution  string
+}
+
+// AuditEventFilter narrows ListAuditEvents. Zero fields match everything.
+type AuditEventFilter struct {
+	Action       string
+	Outcome      string
+	ActorID      uuid.NullUUID
+	TargetUserID uuid.NullUUID
+	Since        sql.NullTime
+	Until        sql.NullTime
+	// BeforeSeq pages back through the log: only events older than it.
+	BeforeSeq int64
+	Limit     int
+}
+
+type CreateRefreshTokenParams struct {
+	Token     string
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+}
+
+type Store interface {
+	UserStore
+	ChirpStore
+	RefreshTokenStore
+	ReportStore
+	AuditStore
+	TestSupportStore
+	// InTx runs fn in a serializable transaction, passing it a Store bound
+	// to that transaction. The transaction commits when fn returns nil and
+	// rolls back otherwise. Calling InTx on a Store that is already bound to
+	// a transaction just runs fn in it.
+	InTx(ctx context.Context, fn func(Store) error) error
+}
+
+type UserStore interface {
+	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (User, error)
+	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
+	GrantChirpyRedToUser(ctx context.Context, id uuid.UUID) error
+	// SetUserRole expects one of the Role constants.
+	SetUserRole(ctx context.Context, id uuid.UUID, role string) error
+	// SearchUsers returns up to limit users whose email contains query,
+	// ignoring case, ordered by email.
+	SearchUsers(ctx context.Context, query string, limit int) ([]User, error)
+	SetUserSuspended(ctx context.Context, id uuid.UUID, suspended bool) error
+	// SetPasswordReset makes the user's next login set a new password
+	// with the reset token hashed to tokenHash. An empty tokenHash clears
+	// the reset.
+	SetPasswordReset(ctx context.Context, id uuid.UUID, tokenHash string) error
+	SetUserChirpyRed(ctx context.Context, id uuid.UUID, red bool) error
+	// DeleteUser and DeleteAllUsers remove users together with their
+	// chirps and refresh tokens.
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+	DeleteAllUsers(ctx context.Context) error
+}
+
+type ChirpStore interface {
+	CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error)
+	GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error)
+	// GetChirpsAsc and GetChirpsByAuthor return chirps oldest first, ties
+	// broken by ID, leaving out hidden chirps, which
+	// GetHiddenChirpsByAuthor returns. GetChirpByID returns them all.
+	GetChirpsAsc(ctx context.Context) ([]Chirp, error)
+	GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error)
+	GetHiddenChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error)
+	// GetChirpReplies returns the replies to a chirp oldest first, hidden
+	// ones included.
+	GetChirpReplies(ctx context.Context, parentID uuid.UUID) ([]Chirp, error)
+	// GetChirpPage returns up to Limit chirps newest first, ties broken
+	// by ID.
+	GetChirpPage(ctx context.Context, arg ChirpPageParams) ([]Chirp, error)
+	SetChirpHidden(ctx context.Context, id uuid.UUID, hidden bool) error
+	DeleteChirp(ctx context.Context, id uuid.UUID) error
+	CountChirpsByAuthor(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+type RefreshTokenStore interface {
+	CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error)
+	GetRefreshToken(ctx context.Context, token string) (RefreshToken, error)
+	RevokeToken(ctx context.Context, token string) error
+	// GetRefreshTokensByUser returns the user's tokens, newest first.
+	GetRefreshTokensByUser(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error)
+	// RevokeUserTokens revokes the user's tokens that aren't revoked yet
+	// and returns how many there were.
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+// ReportStore keeps the moderation queue. A reporter can report a chirp
+// only once; a second report is ErrConflict.
+type ReportStore interface {
+	CreateReport(ctx context.Context, arg CreateReportParams) (Report, error)
+	GetReport(ctx context.Context, id uuid.UUID) (Report, error)
+	// ListReports returns up to limit reports with status, or the ones
+	// not resolved yet if status is empty, oldest first.
+	ListReports(ctx context.Context, status string, limit int) ([]Report, error)
+	ClaimReport(ctx context.Context, id, moderatorID uuid.UUID) error
+	ResolveReport(ctx context.Context, arg ResolveReportParams) error
+	// ResolveChirpReports resolves every unresolved report on a chirp and
+	// returns how many there were.
+	ResolveChirpReports(ctx context.Context, arg ResolveChirpReportsParams) (int, error)
+}
+
+// AuditStore keeps the audit log. Events can only be appended: the SQL
+// schemas refuse to update or delete them.
+type AuditStore interface {
+	// AppendAuditEvent stores e as given. A Seq that is taken already is
+	// ErrConflict.
+	AppendAuditEvent(ctx context.Context, e AuditEvent) error
+	// ChainAuditEvent appends e after the newest event: it sets e's Seq
+	// and PrevHash, then its Hash with hash, and returns the stored event.
+	// Concurrent calls queue on a lock held only while appending, so they
+	// don't conflict.
+	ChainAuditEvent(ctx context.Context, e AuditEvent, hash func(AuditEvent) string) (AuditEvent, error)
+	// LastAuditEvent returns the event with the highest Seq, or ErrNotFound
+	// while the log is empty.
+	LastAuditEvent(ctx context.Context) (AuditEvent, error)
+	// ListAuditEvents returns up to filter.Limit matching events, newest
+	// first.
+	ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error)
+	// AuditEventsAfter returns up to limit events with a Seq above
+	// afterSeq, oldest first.
+	AuditEventsAfter(ctx context.Context, afterSeq int64, limit int) ([]AuditEvent, error)
+}
+
+// Tables test support can clear and load, each listed before the tables
+// referencing it. The audit log isn't one of them.
+const (
+	TableUsers         = "users"
+	TableChirps        = "chirps"
+	TableRefreshTokens = "refresh_tokens"
+	TableReports       = "reports"
+)
+
+var Tables = []string{TableUsers, TableChirps, TableRefreshTokens, TableReports}
+
+// Dump holds the rows of the Tables.
+type Dump struct {
+	Users         []User
+	Chirps        []Chirp
+	RefreshTokens []RefreshToken
+	Reports       []Report
+}
+
+// TestSupportStore resets and seeds the database for test runs.
+type TestSupportStore interface {
+	// ClearTable deletes every row of table, one of Tables, and returns how
+	// many there were. Rows of other tables referencing them go the way
+	// DeleteUser and DeleteChirp take them.
+	ClearTable(ctx context.Context, table string) (int, error)
+	// Dump returns every row of the Tables, oldest first.
+	Dump(ctx context.Context) (Dump, error)
+	// Load inserts the rows of d as they are, IDs and timestamps included.
+	// A row that exists already is ErrConflict, one referencing a row that
+	// doesn't ErrNotFound. Run it in a transaction to load all or nothing.
+	Load(ctx context.Context, d Dump) error
+	// SaveSnapshot keeps d under name, replacing an earlier snapshot of
+	// that name. Snapshots aren't one of the Tables, so clearing and
+	// loading leaves them alone. GetSnapshot and DeleteSnapshot return
+	// ErrNotFound for a name that was never saved.
+	SaveSnapshot(ctx context.Context, name string, d Dump) error
+	GetSnapshot(ctx context.Context, name string) (Dump, error)
+	DeleteSnapshot(ctx context.Context, name string) error
+}
+
+// encodeSnapshot and decodeSnapshot turn a Dump into the JSON the stores
+// keep snapshots as, and back.
+func encodeSnapshot(d Dump) (string, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return "", fmt.Errorf("encoding snapshot: %w", err)
+	}
+	return string(data), nil
+}
+
+func decodeSnapshot(data string) (Dump, error) {
+	var d Dump
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		return Dump{}, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	return d, nil
+}
+
+// errUnknownTable is returned by ClearTable for a table not in Tables.
+func errUnknownTable(table string) error {
+	return fmt.Errorf("unknown table %q", table)
+}
+
+// Option configures the SQL backed stores.
+type Option func(*options)
+
+type options struct {
+	wrap func(database.DBTX) database.DBTX
+}
+
+// WithQueryWrapper wraps the handle queries run on, both the pool and each
+// transaction, e.g. to trace every query.
+func WithQueryWrapper(wrap func(database.DBTX) database.DBTX) Option {
+	return func(o *options) { o.wrap = wrap }
+}
+
+func newOptions(opts []Option) options {
+	o := options{wrap: func(db database.DBTX) database.DBTX { return db }}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// chainAuditEvent links e to the newest event of s, if there is one, and
+// appends it. The caller makes sure nothing is appended in between.
+func chainAuditEvent(ctx context.Context, s AuditStore, e AuditEvent, hash func(AuditEvent) string) (AuditEvent, error) {
+	e.Seq, e.PrevHash = 1, ""
+	last, err := s.LastAuditEvent(ctx)
+	switch {
+	case err == nil:
+		e.Seq, e.PrevHash = last.Seq+1, last.Hash
+	case !errors.Is(err, ErrNotFound):
+		return AuditEvent{}, err
+	}
+	e.Hash = hash(e)
+	if err := s.AppendAuditEvent(ctx, e); err != nil {
+		return AuditEvent{}, err
+	}
+	return e, nil
+}
+
+// likePattern escapes the wildcards in s for a LIKE ... ESCAPE '\'
+// pattern.
+func likePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// nowIf is the timestamp column of a flag such as SuspendedAt: the
+// current time while set, NULL otherwise.
+func nowIf(set bool) sql.NullTime {
+	if !set {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: now(), Valid: true}
+}
+
+// now is the timestamp the stores stamp on rows. Postgres keeps microsecond
+// precision, so the in-memory store truncates to match.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
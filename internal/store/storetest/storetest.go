@@ -0,0 +1,1091 @@
+// Package storetest is the conformance suite every store.Store
+// implementation has to pass, so the in-memory store can stand in for
+// Postgres in handler tests.
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+// Run runs the suite. newStore must return an empty store for every call.
+func Run(t *testing.T, newStore func(t *testing.T) store.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s store.Store)
+	}{
+		{"CreateAndGetUser", testCreateAndGetUser},
+		{"DuplicateEmail", testDuplicateEmail},
+		{"UpdateUser", testUpdateUser},
+		{"GrantChirpyRed", testGrantChirpyRed},
+		{"SetUserRole", testSetUserRole},
+		{"SearchUsers", testSearchUsers},
+		{"UserFlags", testUserFlags},
+		{"DeleteUserCascades", testDeleteUserCascades},
+		{"CreateAndGetChirp", testCreateAndGetChirp},
+		{"ChirpOrdering", testChirpOrdering},
+		{"ChirpPage", testChirpPage},
+		{"DeleteChirp", testDeleteChirp},
+		{"HiddenChirps", testHiddenChirps},
+		{"ChirpReplies", testChirpReplies},
+		{"Reports", testReports},
+		{"ReportsCascade", testReportsCascade},
+		{"RefreshTokens", testRefreshTokens},
+		{"UserTokens", testUserTokens},
+		{"DeleteAllUsersCascades", testDeleteAllUsersCascades},
+		{"ClearAndLoad", testClearAndLoad},
+		{"Snapshots", testSnapshots},
+		{"AuditEvents", testAuditEvents},
+		{"ChainAuditEvents", testChainAuditEvents},
+		{"InTxCommits", testInTxCommits},
+		{"InTxRollsBack", testInTxRollsBack},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func wantErr(t *testing.T, op string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s error = %v, want %v", op, err, want)
+	}
+}
+
+func mustCreateUser(t *testing.T, s store.Store, email string) store.User {
+	t.Helper()
+	u, err := s.CreateUser(context.Background(), store.CreateUserParams{
+		Email:          email,
+		HashedPassword: "hash-" + email,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser(%s) error = %v", email, err)
+	}
+	return u
+}
+
+func mustCreateChirp(t *testing.T, s store.Store, userID uuid.UUID, body string) store.Chirp {
+	t.Helper()
+	c, err := s.CreateChirp(context.Background(), store.CreateChirpParams{Body: body, UserID: userID})
+	if err != nil {
+		t.Fatalf("CreateChirp(%s) error = %v", body, err)
+	}
+	return c
+}
+
+func testCreateAndGetUser(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	created := mustCreateUser(t, s, "walt@example.com")
+
+	if created.ID == uuid.Nil {
+		t.Error("CreateUser() returned a nil ID")
+	}
+	if created.CreatedAt.IsZero() || !created.CreatedAt.Equal(created.UpdatedAt) {
+		t.Errorf("CreateUser() timestamps = %v / %v", created.CreatedAt, created.UpdatedAt)
+	}
+	if created.IsChirpyRed {
+		t.Error("new users must not be Chirpy Red")
+	}
+	if created.Role != store.RoleUser {
+		t.Errorf("CreateUser() role = %q, want %q", created.Role, store.RoleUser)
+	}
+
+	byEmail, err := s.GetUserByEmail(ctx, "walt@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail() error = %v", err)
+	}
+	if byEmail.ID != created.ID || byEmail.HashedPassword != "hash-walt@example.com" {
+		t.Errorf("GetUserByEmail() = %+v, want %+v", byEmail, created)
+	}
+
+	byID, err := s.GetUserByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID() error = %v", err)
+	}
+	if byID.Email != created.Email {
+		t.Errorf("GetUserByID() email = %q, want %q", byID.Email, created.Email)
+	}
+
+	_, err = s.GetUserByEmail(ctx, "nobody@example.com")
+	wantErr(t, "GetUserByEmail(unknown)", err, store.ErrNotFound)
+	_, err = s.GetUserByID(ctx, uuid.New())
+	wantErr(t, "GetUserByID(unknown)", err, store.ErrNotFound)
+}
+
+func testDuplicateEmail(t *testing.T, s store.Store) {
+	mustCreateUser(t, s, "jesse@example.com")
+	_, err := s.CreateUser(context.Background(), store.CreateUserParams{
+		Email:          "jesse@example.com",
+		HashedPassword: "other",
+	})
+	wantErr(t, "CreateUser(duplicate)", err, store.ErrConflict)
+}
+
+func testUpdateUser(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "saul@example.com")
+	mustCreateUser(t, s, "kim@example.com")
+
+	updated, err := s.UpdateUser(ctx, store.UpdateUserParams{
+		ID:             u.ID,
+		Email:          "jimmy@example.com",
+		HashedPassword: "new-hash",
+	})
+	if err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+	if updated.Email != "jimmy@example.com" || updated.HashedPassword != "new-hash" {
+		t.Errorf("UpdateUser() = %+v", updated)
+	}
+	if !updated.CreatedAt.Equal(u.CreatedAt) || updated.UpdatedAt.Before(u.UpdatedAt) {
+		t.Errorf("UpdateUser() timestamps = %v / %v", updated.CreatedAt, updated.UpdatedAt)
+	}
+	if _, err := s.GetUserByEmail(ctx, "saul@example.com"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("old email still resolves, error = %v", err)
+	}
+
+	_, err = s.UpdateUser(ctx, store.UpdateUserParams{ID: u.ID, Email: "kim@example.com", HashedPassword: "x"})
+	wantErr(t, "UpdateUser(taken email)", err, store.ErrConflict)
+
+	_, err = s.UpdateUser(ctx, store.UpdateUserParams{ID: uuid.New(), Email: "ghost@example.com", HashedPassword: "x"})
+	wantErr(t, "UpdateUser(unknown)", err, store.ErrNotFound)
+}
+
+func testGrantChirpyRed(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "gus@example.com")
+
+	if err := s.GrantChirpyRedToUser(ctx, u.ID); err != nil {
+		t.Fatalf("GrantChirpyRedToUser() error = %v", err)
+	}
+	got, err := s.GetUserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID() error = %v", err)
+	}
+	if !got.IsChirpyRed {
+		t.Error("user is not Chirpy Red after upgrade")
+	}
+
+	wantErr(t, "GrantChirpyRedToUser(unknown)", s.GrantChirpyRedToUser(ctx, uuid.New()), store.ErrNotFound)
+}
+
+func testSetUserRole(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "hank@example.com")
+
+	if err := s.SetUserRole(ctx, u.ID, store.RoleAdmin); err != nil {
+		t.Fatalf("SetUserRole() error = %v", err)
+	}
+	got, err := s.GetUserByEmail(ctx, u.Email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail() error = %v", err)
+	}
+	if got.Role != store.RoleAdmin {
+		t.Errorf("role = %q, want %q", got.Role, store.RoleAdmin)
+	}
+
+	wantErr(t, "SetUserRole(unknown)", s.SetUserRole(ctx, uuid.New(), store.RoleAdmin), store.ErrNotFound)
+}
+
+func testSearchUsers(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	for _, email := range []string{"walter@example.com", "waltjunior@example.com", "skyler@example.com", "100%_off@example.com"} {
+		mustCreateUser(t, s, email)
+	}
+
+	tests := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{"walt", 10, []string{"walter@example.com", "waltjunior@example.com"}},
+		{"WALTER", 10, []string{"walter@example.com"}},
+		{"example.com", 2, []string{"100%_off@example.com", "skyler@example.com"}},
+		{"%", 10, []string{"100%_off@example.com"}},
+		{"_", 10, []string{"100%_off@example.com"}},
+		{"nobody", 10, nil},
+	}
+	for _, tt := range tests {
+		users, err := s.SearchUsers(ctx, tt.query, tt.limit)
+		if err != nil {
+			t.Fatalf("SearchUsers(%q) error = %v", tt.query, err)
+		}
+		var got []string
+		for _, u := range users {
+			got = append(got, u.Email)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("SearchUsers(%q, %d) = %v, want %v", tt.query, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func testUserFlags(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "lydia@example.com")
+	if u.SuspendedAt.Valid || u.PasswordResetRequired {
+		t.Fatalf("new user = %+v, want neither suspended nor due a password reset", u)
+	}
+
+	steps := []struct {
+		name  string
+		write func(id uuid.UUID) error
+		check func(u store.User) bool
+	}{
+		{"Suspend", func(id uuid.UUID) error { return s.SetUserSuspended(ctx, id, true) }, func(u store.User) bool { return u.SuspendedAt.Valid }},
+		{"Unsuspend", func(id uuid.UUID) error { return s.SetUserSuspended(ctx, id, false) }, func(u store.User) bool { return !u.SuspendedAt.Valid }},
+		{"RequireReset", func(id uuid.UUID) error { return s.SetPasswordReset(ctx, id, "c0ffee") }, func(u store.User) bool {
+			return u.PasswordResetRequired && u.PasswordResetTokenHash.String == "c0ffee"
+		}},
+		{"ClearReset", func(id uuid.UUID) error { return s.SetPasswordReset(ctx, id, "") }, func(u store.User) bool {
+			return !u.PasswordResetRequired && !u.PasswordResetTokenHash.Valid
+		}},
+		{"GrantRed", func(id uuid.UUID) error { return s.SetUserChirpyRed(ctx, id, true) }, func(u store.User) bool { return u.IsChirpyRed }},
+		{"RevokeRed", func(id uuid.UUID) error { return s.SetUserChirpyRed(ctx, id, false) }, func(u store.User) bool { return !u.IsChirpyRed }},
+	}
+	for _, step := range steps {
+		if err := step.write(u.ID); err != nil {
+			t.Fatalf("%s error = %v", step.name, err)
+		}
+		got, err := s.GetUserByID(ctx, u.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID() error = %v", err)
+		}
+		if !step.check(got) {
+			t.Errorf("after %s user = %+v", step.name, got)
+		}
+		wantErr(t, step.name+"(unknown)", step.write(uuid.New()), store.ErrNotFound)
+	}
+}
+
+func testDeleteUserCascades(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "tuco@example.com")
+	other := mustCreateUser(t, s, "hector@example.com")
+	c := mustCreateChirp(t, s, u.ID, "delete me")
+	kept := mustCreateChirp(t, s, other.ID, "keep me")
+	_, err := s.CreateRefreshToken(ctx, store.CreateRefreshTokenParams{Token: "tuco-token", UserID: u.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken() error = %v", err)
+	}
+
+	if n, err := s.CountChirpsByAuthor(ctx, u.ID); err != nil || n != 1 {
+		t.Errorf("CountChirpsByAuthor() = %d, %v, want 1", n, err)
+	}
+	if err := s.DeleteUser(ctx, u.ID); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+
+	_, err = s.GetUserByID(ctx, u.ID)
+	wantErr(t, "GetUserByID(deleted)", err, store.ErrNotFound)
+	_, err = s.GetChirpByID(ctx, c.ID)
+	wantErr(t, "GetChirpByID(of deleted user)", err, store.ErrNotFound)
+	_, err = s.GetRefreshToken(ctx, "tuco-token")
+	wantErr(t, "GetRefreshToken(of deleted user)", err, store.ErrNotFound)
+	if _, err := s.GetChirpByID(ctx, kept.ID); err != nil {
+		t.Errorf("GetChirpByID(other user) error = %v", err)
+	}
+	if n, err := s.CountChirpsByAuthor(ctx, u.ID); err != nil || n != 0 {
+		t.Errorf("CountChirpsByAuthor(deleted) = %d, %v, want 0", n, err)
+	}
+	wantErr(t, "DeleteUser(again)", s.DeleteUser(ctx, u.ID), store.ErrNotFound)
+}
+
+func testCreateAndGetChirp(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "mike@example.com")
+
+	created := mustCreateChirp(t, s, u.ID, "no half measures")
+	if created.ID == uuid.Nil || created.UserID != u.ID || created.Body != "no half measures" {
+		t.Errorf("CreateChirp() = %+v", created)
+	}
+
+	got, err := s.GetChirpByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetChirpByID() error = %v", err)
+	}
+	if got.Body != created.Body || !got.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("GetChirpByID() = %+v, want %+v", got, created)
+	}
+
+	_, err = s.GetChirpByID(ctx, uuid.New())
+	wantErr(t, "GetChirpByID(unknown)", err, store.ErrNotFound)
+
+	_, err = s.CreateChirp(ctx, store.CreateChirpParams{Body: "orphan", UserID: uuid.New()})
+	wantErr(t, "CreateChirp(unknown user)", err, store.ErrNotFound)
+}
+
+func testChirpOrdering(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	a := mustCreateUser(t, s, "a@example.com")
+	b := mustCreateUser(t, s, "b@example.com")
+
+	for i, author := range []uuid.UUID{a.ID, b.ID, a.ID, b.ID, a.ID} {
+		mustCreateChirp(t, s, author, string(rune('a'+i)))
+	}
+
+	all, err := s.GetChirpsAsc(ctx)
+	if err != nil {
+		t.Fatalf("GetChirpsAsc() error = %v", err)
+	}
+	if len(all) != 5 {
+		t.Fatalf("GetChirpsAsc() returned %d chirps, want 5", len(all))
+	}
+	assertOrdered(t, "GetChirpsAsc", all)
+
+	byA, err := s.GetChirpsByAuthor(ctx, a.ID)
+	if err != nil {
+		t.Fatalf("GetChirpsByAuthor() error = %v", err)
+	}
+	if len(byA) != 3 {
+		t.Fatalf("GetChirpsByAuthor() returned %d chirps, want 3", len(byA))
+	}
+	for _, c := range byA {
+		if c.UserID != a.ID {
+			t.Errorf("GetChirpsByAuthor() returned chirp by %s", c.UserID)
+		}
+	}
+	assertOrdered(t, "GetChirpsByAuthor", byA)
+
+	none, err := s.GetChirpsByAuthor(ctx, uuid.New())
+	if err != nil || len(none) != 0 {
+		t.Errorf("GetChirpsByAuthor(unknown) = %v, %v, want empty", none, err)
+	}
+}
+
+func testChirpPage(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	a := mustCreateUser(t, s, "a@example.com")
+	b := mustCreateUser(t, s, "b@example.com")
+
+	var chirps []store.Chirp
+	for i, author := range []uuid.UUID{a.ID, b.ID, a.ID, b.ID, a.ID} {
+		chirps = append(chirps, mustCreateChirp(t, s, author, string(rune('a'+i))))
+	}
+	if err := s.SetChirpHidden(ctx, chirps[2].ID, true); err != nil {
+		t.Fatalf("SetChirpHidden() error = %v", err)
+	}
+	all, err := s.GetChirpsAsc(ctx)
+	if err != nil {
+		t.Fatalf("GetChirpsAsc() error = %v", err)
+	}
+	// The chirps may share a created_at, so the order to expect is the
+	// one GetChirpsAsc settled on.
+	newest := slices.Clone(all)
+	slices.Reverse(newest)
+
+	// pages reads page after page and returns the chirps in order.
+	pages := func(arg store.ChirpPageParams) []store.Chirp {
+		t.Helper()
+		var got []store.Chirp
+		for {
+			page, err := s.GetChirpPage(ctx, arg)
+			if err != nil {
+				t.Fatalf("GetChirpPage() error = %v", err)
+			}
+			if len(page) > arg.Limit {
+				t.Fatalf("GetChirpPage() returned %d chirps, want at most %d", len(page), arg.Limit)
+			}
+			if len(page) == 0 {
+				return got
+			}
+			got = append(got, page...)
+			arg.Cursor = &page[len(page)-1]
+		}
+	}
+	ids := func(chirps []store.Chirp) []uuid.UUID {
+		var ids []uuid.UUID
+		for _, c := range chirps {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	if got := pages(store.ChirpPageParams{Limit: 2}); !slices.Equal(ids(got), ids(newest)) {
+		t.Errorf("pages of everything = %v, want %v", ids(got), ids(newest))
+	}
+	byB := slices.DeleteFunc(slices.Clone(newest), func(c store.Chirp) bool { return c.UserID != b.ID })
+	if got := pages(store.ChirpPageParams{AuthorID: b.ID, Limit: 1}); !slices.Equal(ids(got), ids(byB)) {
+		t.Errorf("pages of b = %v, want %v", ids(got), ids(byB))
+	}
+	got := pages(store.ChirpPageParams{AuthorID: a.ID, ViewerID: a.ID, Limit: 2})
+	if len(got) != 3 || !slices.Contains(ids(got), chirps[2].ID) {
+		t.Errorf("pages of a seen by a = %v, want all 3 including the hidden one", ids(got))
+	}
+	if got := pages(store.ChirpPageParams{ViewerID: b.ID, Limit: 10}); slices.Contains(ids(got), chirps[2].ID) {
+		t.Errorf("pages seen by b = %v, want a's hidden chirp left out", ids(got))
+	}
+}
+
+func assertOrdered(t *testing.T, op string, chirps []store.Chirp) {
+	t.Helper()
+	for i := 1; i < len(chirps); i++ {
+		prev, cur := chirps[i-1], chirps[i]
+		if cur.CreatedAt.Before(prev.CreatedAt) ||
+			(cur.CreatedAt.Equal(prev.CreatedAt) && cur.ID.String() < prev.ID.String()) {
+			t.Errorf("%s() is not ordered by created_at, id at index %d", op, i)
+		}
+	}
+}
+
+func testDeleteChirp(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "hank@example.com")
+	c := mustCreateChirp(t, s, u.ID, "minerals")
+
+	if err := s.DeleteChirp(ctx, c.ID); err != nil {
+		t.Fatalf("DeleteChirp() error = %v", err)
+	}
+	_, err := s.GetChirpByID(ctx, c.ID)
+	wantErr(t, "GetChirpByID(deleted)", err, store.ErrNotFound)
+	wantErr(t, "DeleteChirp(again)", s.DeleteChirp(ctx, c.ID), store.ErrNotFound)
+}
+
+func testHiddenChirps(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "lydia@example.com")
+	shown := mustCreateChirp(t, s, u.ID, "stevia")
+	hidden := mustCreateChirp(t, s, u.ID, "methylamine")
+
+	if err := s.SetChirpHidden(ctx, hidden.ID, true); err != nil {
+		t.Fatalf("SetChirpHidden() error = %v", err)
+	}
+	got, err := s.GetChirpByID(ctx, hidden.ID)
+	if err != nil || !got.HiddenAt.Valid {
+		t.Errorf("GetChirpByID(hidden) = %+v, %v, want it with HiddenAt", got, err)
+	}
+	ids := func(chirps []store.Chirp) []uuid.UUID {
+		var ids []uuid.UUID
+		for _, c := range chirps {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+	all, _ := s.GetChirpsAsc(ctx)
+	mine, _ := s.GetChirpsByAuthor(ctx, u.ID)
+	hiddenOnes, _ := s.GetHiddenChirpsByAuthor(ctx, u.ID)
+	want := []uuid.UUID{shown.ID}
+	if !slices.Equal(ids(all), want) || !slices.Equal(ids(mine), want) {
+		t.Errorf("timelines = %v and %v, want only %v", ids(all), ids(mine), want)
+	}
+	if !slices.Equal(ids(hiddenOnes), []uuid.UUID{hidden.ID}) {
+		t.Errorf("GetHiddenChirpsByAuthor() = %v, want %v", ids(hiddenOnes), hidden.ID)
+	}
+	if n, _ := s.CountChirpsByAuthor(ctx, u.ID); n != 2 {
+		t.Errorf("CountChirpsByAuthor() = %d, want hidden chirps counted too", n)
+	}
+
+	if err := s.SetChirpHidden(ctx, hidden.ID, false); err != nil {
+		t.Fatalf("SetChirpHidden(false) error = %v", err)
+	}
+	if all, _ := s.GetChirpsAsc(ctx); len(all) != 2 {
+		t.Errorf("GetChirpsAsc() after unhiding = %d chirps, want 2", len(all))
+	}
+	wantErr(t, "SetChirpHidden(unknown)", s.SetChirpHidden(ctx, uuid.New(), true), store.ErrNotFound)
+}
+
+func testChirpReplies(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	walt := mustCreateUser(t, s, "walt@example.com")
+	jesse := mustCreateUser(t, s, "jesse@example.com")
+	parent := mustCreateChirp(t, s, walt.ID, "we need to cook")
+	if parent.ParentID.Valid {
+		t.Errorf("CreateChirp() ParentID = %v, want it unset", parent.ParentID)
+	}
+	reply := func(body string) store.Chirp {
+		t.Helper()
+		c, err := s.CreateChirp(ctx, store.CreateChirpParams{Body: body, UserID: jesse.ID, ParentID: parent.ID})
+		if err != nil || c.ParentID != (uuid.NullUUID{UUID: parent.ID, Valid: true}) {
+			t.Fatalf("CreateChirp(reply) = %+v, %v, want a reply to %s", c, err, parent.ID)
+		}
+		return c
+	}
+	first, second := reply("yeah science"), reply("yo")
+	if err := s.SetChirpHidden(ctx, second.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.CreateChirp(ctx, store.CreateChirpParams{Body: "orphan", UserID: jesse.ID, ParentID: uuid.New()})
+	wantErr(t, "CreateChirp(unknown parent)", err, store.ErrNotFound)
+
+	replies, err := s.GetChirpReplies(ctx, parent.ID)
+	ids := []uuid.UUID{}
+	for _, c := range replies {
+		ids = append(ids, c.ID)
+	}
+	if err != nil || len(ids) != 2 || !slices.Contains(ids, first.ID) || !slices.Contains(ids, second.ID) {
+		t.Errorf("GetChirpReplies() = %v, %v, want %s and the hidden %s", ids, err, first.ID, second.ID)
+	}
+	if replies, err := s.GetChirpReplies(ctx, first.ID); err != nil || len(replies) != 0 {
+		t.Errorf("GetChirpReplies(no replies) = %+v, %v, want none", replies, err)
+	}
+
+	d, err := s.Dump(ctx)
+	if err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	if _, err := s.ClearTable(ctx, store.TableUsers); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(ctx, d); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got, err := s.GetChirpByID(ctx, first.ID); err != nil || got.ParentID != first.ParentID {
+		t.Errorf("GetChirpByID(after load) = %+v, %v, want a reply to %s", got, err, parent.ID)
+	}
+
+	// Replies outlive their parent and start a thread of their own.
+	if err := s.DeleteChirp(ctx, parent.ID); err != nil {
+		t.Fatalf("DeleteChirp(parent) error = %v", err)
+	}
+	if got, err := s.GetChirpByID(ctx, first.ID); err != nil || got.ParentID.Valid {
+		t.Errorf("GetChirpByID(orphaned reply) = %+v, %v, want it without a parent", got, err)
+	}
+	if replies, err := s.GetChirpReplies(ctx, parent.ID); err != nil || len(replies) != 0 {
+		t.Errorf("GetChirpReplies(deleted parent) = %+v, %v, want none", replies, err)
+	}
+}
+
+func testReports(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	author := mustCreateUser(t, s, "todd@example.com")
+	reporter := mustCreateUser(t, s, "kenny@example.com")
+	mod := mustCreateUser(t, s, "mod@example.com")
+	c := mustCreateChirp(t, s, author.ID, "spider")
+	other := mustCreateChirp(t, s, author.ID, "tarantula")
+	by := uuid.NullUUID{UUID: reporter.ID, Valid: true}
+
+	first, err := s.CreateReport(ctx, store.CreateReportParams{ChirpID: c.ID, ReporterID: by, Reason: "harassment", Details: "mean"})
+	if err != nil {
+		t.Fatalf("CreateReport() error = %v", err)
+	}
+	if first.Status != store.ReportOpen || first.ReporterID != by || first.Reason != "harassment" || first.Details != "mean" {
+		t.Errorf("CreateReport() = %+v", first)
+	}
+	_, err = s.CreateReport(ctx, store.CreateReportParams{ChirpID: c.ID, ReporterID: by, Reason: "spam"})
+	wantErr(t, "CreateReport(twice)", err, store.ErrConflict)
+	_, err = s.CreateReport(ctx, store.CreateReportParams{ChirpID: uuid.New(), ReporterID: by, Reason: "spam"})
+	wantErr(t, "CreateReport(unknown chirp)", err, store.ErrNotFound)
+	// Filter reports have no reporter and don't clash with each other.
+	for range 2 {
+		if _, err := s.CreateReport(ctx, store.CreateReportParams{ChirpID: c.ID, Reason: "profanity"}); err != nil {
+			t.Fatalf("CreateReport(no reporter) error = %v", err)
+		}
+	}
+	second, err := s.CreateReport(ctx, store.CreateReportParams{ChirpID: other.ID, ReporterID: by, Reason: "spam"})
+	if err != nil {
+		t.Fatalf("CreateReport(other chirp) error = %v", err)
+	}
+
+	if err := s.ClaimReport(ctx, first.ID, mod.ID); err != nil {
+		t.Fatalf("ClaimReport() error = %v", err)
+	}
+	got, err := s.GetReport(ctx, first.ID)
+	if err != nil || got.Status != store.ReportClaimed || got.ModeratorID.UUID != mod.ID || !got.ClaimedAt.Valid {
+		t.Errorf("GetReport(claimed) = %+v, %v", got, err)
+	}
+	wantErr(t, "ClaimReport(unknown)", s.ClaimReport(ctx, uuid.New(), mod.ID), store.ErrNotFound)
+
+	err = s.ResolveReport(ctx, store.ResolveReportParams{ID: second.ID, ModeratorID: mod.ID, Resolution: "dismissed"})
+	if err != nil {
+		t.Fatalf("ResolveReport() error = %v", err)
+	}
+	got, _ = s.GetReport(ctx, second.ID)
+	if got.Status != store.ReportResolved || got.Resolution != "dismissed" || !got.ResolvedAt.Valid {
+		t.Errorf("GetReport(resolved) = %+v", got)
+	}
+
+	unresolved, _ := s.ListReports(ctx, "", 10)
+	if len(unresolved) != 3 || unresolved[0].ID != first.ID {
+		t.Errorf("ListReports(unresolved) = %d reports, want 3 starting with the oldest", len(unresolved))
+	}
+	if open, _ := s.ListReports(ctx, store.ReportOpen, 1); len(open) != 1 || open[0].Status != store.ReportOpen {
+		t.Errorf("ListReports(open, 1) = %+v, want one open report", open)
+	}
+
+	n, err := s.ResolveChirpReports(ctx, store.ResolveChirpReportsParams{ChirpID: c.ID, ModeratorID: mod.ID, Resolution: "chirp_hidden"})
+	if err != nil || n != 3 {
+		t.Errorf("ResolveChirpReports() = %d, %v, want 3", n, err)
+	}
+	n, err = s.ResolveChirpReports(ctx, store.ResolveChirpReportsParams{ChirpID: c.ID, ModeratorID: mod.ID, Resolution: "chirp_hidden"})
+	if err != nil || n != 0 {
+		t.Errorf("ResolveChirpReports(again) = %d, %v, want 0", n, err)
+	}
+	if resolved, _ := s.ListReports(ctx, store.ReportResolved, 10); len(resolved) != 4 {
+		t.Errorf("ListReports(resolved) = %d reports, want 4", len(resolved))
+	}
+}
+
+func testReportsCascade(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	author := mustCreateUser(t, s, "gus@example.com")
+	reporter := mustCreateUser(t, s, "hector@example.com")
+	c := mustCreateChirp(t, s, author.ID, "los pollos")
+	deleted := mustCreateChirp(t, s, author.ID, "hermanos")
+	r, err := s.CreateReport(ctx, store.CreateReportParams{ChirpID: c.ID, ReporterID: uuid.NullUUID{UUID: reporter.ID, Valid: true}, Reason: "other"})
+	if err != nil {
+		t.Fatalf("CreateReport() error = %v", err)
+	}
+	gone, err := s.CreateReport(ctx, store.CreateReportParams{ChirpID: deleted.ID, Reason: "profanity"})
+	if err != nil {
+		t.Fatalf("CreateReport() error = %v", err)
+	}
+
+	if err := s.DeleteChirp(ctx, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetReport(ctx, gone.ID)
+	wantErr(t, "GetReport(on deleted chirp)", err, store.ErrNotFound)
+
+	if err := s.DeleteUser(ctx, reporter.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetReport(ctx, r.ID)
+	if err != nil || got.ReporterID.Valid {
+		t.Errorf("GetReport(of deleted reporter) = %+v, %v, want it kept without reporter", got, err)
+	}
+
+	if err := s.DeleteUser(ctx, author.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetReport(ctx, r.ID)
+	wantErr(t, "GetReport(of deleted author)", err, store.ErrNotFound)
+}
+
+func testRefreshTokens(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "skyler@example.com")
+	expires := time.Now().UTC().Add(time.Hour)
+
+	created, err := s.CreateRefreshToken(ctx, store.CreateRefreshTokenParams{
+		Token:     "token-1",
+		UserID:    u.ID,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken() error = %v", err)
+	}
+	if created.RevokedAt.Valid {
+		t.Error("new refresh token is already revoked")
+	}
+	if d := created.ExpiresAt.Sub(expires); d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("ExpiresAt = %v, want %v", created.ExpiresAt, expires)
+	}
+
+	_, err = s.CreateRefreshToken(ctx, store.CreateRefreshTokenParams{Token: "token-1", UserID: u.ID, ExpiresAt: expires})
+	wantErr(t, "CreateRefreshToken(duplicate)", err, store.ErrConflict)
+	_, err = s.CreateRefreshToken(ctx, store.CreateRefreshTokenParams{Token: "token-2", UserID: uuid.New(), ExpiresAt: expires})
+	wantErr(t, "CreateRefreshToken(unknown user)", err, store.ErrNotFound)
+
+	if err := s.RevokeToken(ctx, "token-1"); err != nil {
+		t.Fatalf("RevokeToken() error = %v", err)
+	}
+	got, err := s.GetRefreshToken(ctx, "token-1")
+	if err != nil {
+		t.Fatalf("GetRefreshToken() error = %v", err)
+	}
+	if !got.RevokedAt.Valid || got.UserID != u.ID {
+		t.Errorf("GetRefreshToken() = %+v, want revoked token of %s", got, u.ID)
+	}
+
+	_, err = s.GetRefreshToken(ctx, "missing")
+	wantErr(t, "GetRefreshToken(unknown)", err, store.ErrNotFound)
+	wantErr(t, "RevokeToken(unknown)", s.RevokeToken(ctx, "missing"), store.ErrNotFound)
+}
+
+func testUserTokens(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "marie@example.com")
+	other := mustCreateUser(t, s, "hank@example.com")
+	for _, token := range []string{"m-1", "m-2", "m-3"} {
+		_, err := s.CreateRefreshToken(ctx, store.CreateRefreshTokenParams{Token: token, UserID: u.ID, ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatalf("CreateRefreshToken() error = %v", err)
+		}
+	}
+	_, err := s.CreateRefreshToken(ctx, store.CreateRefreshTokenParams{Token: "h-1", UserID: other.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken() error = %v", err)
+	}
+	if err := s.RevokeToken(ctx, "m-1"); err != nil {
+		t.Fatalf("RevokeToken() error = %v", err)
+	}
+
+	tokens, err := s.GetRefreshTokensByUser(ctx, u.ID)
+	if err != nil || len(tokens) != 3 {
+		t.Fatalf("GetRefreshTokensByUser() = %d tokens, %v, want 3", len(tokens), err)
+	}
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i].CreatedAt.After(tokens[i-1].CreatedAt) {
+			t.Errorf("GetRefreshTokensByUser() not newest first: %v", tokens)
+		}
+	}
+
+	n, err := s.RevokeUserTokens(ctx, u.ID)
+	if err != nil || n != 2 {
+		t.Errorf("RevokeUserTokens() = %d, %v, want the 2 live tokens", n, err)
+	}
+	tokens, _ = s.GetRefreshTokensByUser(ctx, u.ID)
+	for _, token := range tokens {
+		if !token.RevokedAt.Valid {
+			t.Errorf("token %s still live", token.Token)
+		}
+	}
+	if got, _ := s.GetRefreshToken(ctx, "h-1"); got.RevokedAt.Valid {
+		t.Error("RevokeUserTokens() revoked another user's token")
+	}
+	if n, err := s.RevokeUserTokens(ctx, u.ID); err != nil || n != 0 {
+		t.Errorf("RevokeUserTokens(again) = %d, %v, want 0", n, err)
+	}
+}
+
+func testDeleteAllUsersCascades(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "todd@example.com")
+	c := mustCreateChirp(t, s, u.ID, "cascade me")
+	_, err := s.CreateRefreshToken(ctx, store.CreateRefreshTokenParams{
+		Token:     "cascade-token",
+		UserID:    u.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken() error = %v", err)
+	}
+
+	if err := s.DeleteAllUsers(ctx); err != nil {
+		t.Fatalf("DeleteAllUsers() error = %v", err)
+	}
+
+	_, err = s.GetUserByID(ctx, u.ID)
+	wantErr(t, "GetUserByID(after reset)", err, store.ErrNotFound)
+	_, err = s.GetChirpByID(ctx, c.ID)
+	wantErr(t, "GetChirpByID(after reset)", err, store.ErrNotFound)
+	_, err = s.GetRefreshToken(ctx, "cascade-token")
+	wantErr(t, "GetRefreshToken(after reset)", err, store.ErrNotFound)
+
+	chirps, err := s.GetChirpsAsc(ctx)
+	if err != nil || len(chirps) != 0 {
+		t.Errorf("GetChirpsAsc(after reset) = %v, %v, want empty", chirps, err)
+	}
+}
+
+func testClearAndLoad(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	author := mustCreateUser(t, s, "jesse@example.com")
+	reporter := mustCreateUser(t, s, "skinny@example.com")
+	c := mustCreateChirp(t, s, author.ID, "yeah science")
+	if err := s.SetUserSuspended(ctx, author.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetPasswordReset(ctx, author.ID, "c0ffee"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetChirpHidden(ctx, c.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateRefreshToken(ctx, store.CreateRefreshTokenParams{Token: "dump-token", UserID: author.ID, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.CreateReport(ctx, store.CreateReportParams{ChirpID: c.ID, ReporterID: uuid.NullUUID{UUID: reporter.ID, Valid: true}, Reason: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := s.Dump(ctx)
+	if err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	if len(d.Users) != 2 || len(d.Chirps) != 1 || len(d.RefreshTokens) != 1 || len(d.Reports) != 1 {
+		t.Fatalf("Dump() = %+v, want 2 users and a chirp, token and report", d)
+	}
+	wantErr(t, "Load(existing rows)", s.Load(ctx, d), store.ErrConflict)
+
+	tests := []struct {
+		table string
+		want  int
+	}{
+		{store.TableChirps, 1},
+		// The chirp took its report along.
+		{store.TableReports, 0},
+		{store.TableUsers, 2},
+		{store.TableRefreshTokens, 0},
+	}
+	for _, tt := range tests {
+		if n, err := s.ClearTable(ctx, tt.table); err != nil || n != tt.want {
+			t.Errorf("ClearTable(%s) = %d, %v, want %d", tt.table, n, err, tt.want)
+		}
+	}
+	if _, err := s.ClearTable(ctx, "audit_events"); err == nil {
+		t.Error("ClearTable(audit_events) error = nil, want an error")
+	}
+
+	if err := s.Load(ctx, d); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	u, err := s.GetUserByID(ctx, author.ID)
+	if err != nil || u.Email != author.Email || !u.CreatedAt.Equal(author.CreatedAt) || !u.SuspendedAt.Valid || u.PasswordResetTokenHash.String != "c0ffee" {
+		t.Errorf("GetUserByID(after load) = %+v, %v, want %s suspended and due a reset", u, err, author.Email)
+	}
+	got, err := s.GetChirpByID(ctx, c.ID)
+	if err != nil || got.Body != c.Body || !got.HiddenAt.Valid {
+		t.Errorf("GetChirpByID(after load) = %+v, %v, want it hidden", got, err)
+	}
+	if tok, err := s.GetRefreshToken(ctx, "dump-token"); err != nil || tok.UserID != author.ID {
+		t.Errorf("GetRefreshToken(after load) = %+v, %v", tok, err)
+	}
+	if rep, err := s.GetReport(ctx, r.ID); err != nil || rep.ReporterID.UUID != reporter.ID {
+		t.Errorf("GetReport(after load) = %+v, %v", rep, err)
+	}
+
+	orphan := store.Dump{Chirps: []store.Chirp{{ID: uuid.New(), CreatedAt: time.Now(), UpdatedAt: time.Now(), Body: "orphan", UserID: uuid.New()}}}
+	wantErr(t, "Load(chirp of unknown user)", s.Load(ctx, orphan), store.ErrNotFound)
+}
+
+func testInTxCommits(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	var created store.User
+	err := s.InTx(ctx, func(tx store.Store) error {
+		var err error
+		created, err = tx.CreateUser(ctx, store.CreateUserParams{Email: "lydia@example.com", HashedPassword: "x"})
+		if err != nil {
+			return err
+		}
+		// Nested calls join the outer transaction.
+		return tx.InTx(ctx, func(inner store.Store) error {
+			_, err := inner.CreateChirp(ctx, store.CreateChirpParams{Body: "stevia", UserID: created.ID})
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatalf("InTx() error = %v", err)
+	}
+
+	if _, err := s.GetUserByID(ctx, created.ID); err != nil {
+		t.Errorf("GetUserByID(after commit) error = %v", err)
+	}
+	chirps, err := s.GetChirpsByAuthor(ctx, created.ID)
+	if err != nil || len(chirps) != 1 {
+		t.Errorf("GetChirpsByAuthor(after commit) = %v, %v, want one chirp", chirps, err)
+	}
+}
+
+func testInTxRollsBack(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "tuco@example.com")
+	c := mustCreateChirp(t, s, u.ID, "tight tight tight")
+	errAbort := errors.New("abort")
+
+	err := s.InTx(ctx, func(tx store.Store) error {
+		if _, err := tx.CreateUser(ctx, store.CreateUserParams{Email: "hector@example.com", HashedPassword: "x"}); err != nil {
+			return err
+		}
+		if err := tx.DeleteChirp(ctx, c.ID); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	wantErr(t, "InTx()", err, errAbort)
+
+	_, err = s.GetUserByEmail(ctx, "hector@example.com")
+	wantErr(t, "GetUserByEmail(after rollback)", err, store.ErrNotFound)
+	if _, err := s.GetChirpByID(ctx, c.ID); err != nil {
+		t.Errorf("GetChirpByID(after rollback) error = %v, want the chirp back", err)
+	}
+}
+
+// testAuditEvents can't expect an empty log: the SQL stores never delete
+// events, so it only looks at the ones it appended.
+func testSnapshots(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	author := mustCreateUser(t, s, "hank@example.com")
+	c := mustCreateChirp(t, s, author.ID, "minerals")
+	if err := s.SetChirpHidden(ctx, c.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	d, err := s.Dump(ctx)
+	if err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+
+	_, err = s.GetSnapshot(ctx, "seeded")
+	wantErr(t, "GetSnapshot(unknown)", err, store.ErrNotFound)
+	if err := s.SaveSnapshot(ctx, "seeded", store.Dump{}); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	if err := s.SaveSnapshot(ctx, "seeded", d); err != nil {
+		t.Fatalf("SaveSnapshot(again) error = %v", err)
+	}
+	// Snapshots outlive the rows they were taken of.
+	if _, err := s.ClearTable(ctx, store.TableUsers); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetSnapshot(ctx, "seeded")
+	if err != nil {
+		t.Fatalf("GetSnapshot() error = %v", err)
+	}
+	if len(got.Users) != 1 || len(got.Chirps) != 1 || got.Users[0].Email != author.Email || !got.Users[0].CreatedAt.Equal(author.CreatedAt) {
+		t.Fatalf("GetSnapshot() = %+v, want the replacing dump", got)
+	}
+	if err := s.Load(ctx, got); err != nil {
+		t.Fatalf("Load(snapshot) error = %v", err)
+	}
+	if chirp, err := s.GetChirpByID(ctx, c.ID); err != nil || chirp.Body != c.Body || !chirp.HiddenAt.Valid {
+		t.Errorf("GetChirpByID(after load) = %+v, %v, want it hidden", chirp, err)
+	}
+
+	if err := s.DeleteSnapshot(ctx, "seeded"); err != nil {
+		t.Fatalf("DeleteSnapshot() error = %v", err)
+	}
+	wantErr(t, "DeleteSnapshot(deleted)", s.DeleteSnapshot(ctx, "seeded"), store.ErrNotFound)
+}
+
+func testAuditEvents(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	var base int64
+	if last, err := s.LastAuditEvent(ctx); err == nil {
+		base = last.Seq
+	} else {
+		wantErr(t, "LastAuditEvent(empty)", err, store.ErrNotFound)
+	}
+
+	actor := uuid.NullUUID{UUID: uuid.New(), Valid: true}
+	target := uuid.NullUUID{UUID: uuid.New(), Valid: true}
+	ts := time.Now().UTC().Truncate(time.Microsecond)
+	events := []store.AuditEvent{
+		{Seq: base + 1, CreatedAt: ts, Action: "login", Outcome: "success", ActorID: actor, TargetUserID: actor, IP: "192.0.2.1", Details: `{"email":"saul@example.com"}`},
+		{Seq: base + 2, CreatedAt: ts.Add(time.Second), Action: "login", Outcome: "failure", IP: "192.0.2.2", Details: `{}`},
+		{Seq: base + 3, CreatedAt: ts.Add(2 * time.Second), Action: "polka_upgrade", Outcome: "success", ActorService: "polka", TargetUserID: target, Details: `{}`},
+	}
+	prev := ""
+	for i := range events {
+		events[i].PrevHash, events[i].Hash = prev, uuid.NewString()
+		prev = events[i].Hash
+		if err := s.AppendAuditEvent(ctx, events[i]); err != nil {
+			t.Fatalf("AppendAuditEvent(%d) error = %v", events[i].Seq, err)
+		}
+	}
+	dup := events[2]
+	dup.Hash = uuid.NewString()
+	wantErr(t, "AppendAuditEvent(taken seq)", s.AppendAuditEvent(ctx, dup), store.ErrConflict)
+
+	// Resetting the users leaves the log alone.
+	if err := s.DeleteAllUsers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	last, err := s.LastAuditEvent(ctx)
+	if err != nil {
+		t.Fatalf("LastAuditEvent() error = %v", err)
+	}
+	got, want := last, events[2]
+	got.CreatedAt, want.CreatedAt = time.Time{}, time.Time{}
+	if got != want || !last.CreatedAt.Equal(events[2].CreatedAt) {
+		t.Errorf("LastAuditEvent() = %+v, want %+v", last, events[2])
+	}
+
+	seqs := func(events []store.AuditEvent) []int64 {
+		var seqs []int64
+		for _, e := range events {
+			seqs = append(seqs, e.Seq)
+		}
+		return seqs
+	}
+	since := sql.NullTime{Time: ts, Valid: true}
+	tests := []struct {
+		name   string
+		filter store.AuditEventFilter
+		want   []int64
+	}{
+		{"All", store.AuditEventFilter{}, []int64{base + 3, base + 2, base + 1}},
+		{"Action", store.AuditEventFilter{Action: "login"}, []int64{base + 2, base + 1}},
+		{"Outcome", store.AuditEventFilter{Outcome: "failure"}, []int64{base + 2}},
+		{"Actor", store.AuditEventFilter{ActorID: actor}, []int64{base + 1}},
+		{"Target", store.AuditEventFilter{TargetUserID: target}, []int64{base + 3}},
+		{"Until", store.AuditEventFilter{Until: sql.NullTime{Time: ts.Add(time.Second), Valid: true}}, []int64{base + 1}},
+		{"Page", store.AuditEventFilter{BeforeSeq: base + 3, Limit: 1}, []int64{base + 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Since keeps events of earlier runs out.
+			tt.filter.Since = since
+			if tt.filter.Limit == 0 {
+				tt.filter.Limit = 10
+			}
+			got, err := s.ListAuditEvents(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("ListAuditEvents() error = %v", err)
+			}
+			if !slices.Equal(seqs(got), tt.want) {
+				t.Errorf("ListAuditEvents() = %v, want %v", seqs(got), tt.want)
+			}
+		})
+	}
+
+	after, err := s.AuditEventsAfter(ctx, base, 2)
+	if err != nil || !slices.Equal(seqs(after), []int64{base + 1, base + 2}) {
+		t.Errorf("AuditEventsAfter(%d, 2) = %v, %v", base, seqs(after), err)
+	}
+	after, err = s.AuditEventsAfter(ctx, base+2, 10)
+	if err != nil || !slices.Equal(seqs(after), []int64{base + 3}) {
+		t.Errorf("AuditEventsAfter(%d, 10) = %v, %v", base+2, seqs(after), err)
+	}
+}
+
+// testChainAuditEvents appends events from many goroutines at once and
+// checks they form one unbroken chain. Like testAuditEvents, it only looks
+// at the events it appended.
+func testChainAuditEvents(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	var base store.AuditEvent
+	if last, err := s.LastAuditEvent(ctx); err == nil {
+		base = last
+	}
+	hash := func(e store.AuditEvent) string {
+		return fmt.Sprintf("%d<-%s", e.Seq, e.PrevHash)
+	}
+
+	const appends = 20
+	var wg sync.WaitGroup
+	for i := range appends {
+		wg.Go(func() {
+			e, err := s.ChainAuditEvent(ctx, store.AuditEvent{
+				CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+				Action:    "login",
+				Outcome:   "success",
+				IP:        fmt.Sprintf("192.0.2.%d", i),
+				Details:   "{}",
+			}, hash)
+			if err != nil {
+				t.Errorf("ChainAuditEvent() error = %v", err)
+			} else if e.Hash != hash(e) {
+				t.Errorf("ChainAuditEvent() = %+v, want it hashed", e)
+			}
+		})
+	}
+	wg.Wait()
+
+	events, err := s.AuditEventsAfter(ctx, base.Seq, appends+1)
+	if err != nil {
+		t.Fatalf("AuditEventsAfter() error = %v", err)
+	}
+	if len(events) != appends {
+		t.Fatalf("AuditEventsAfter() returned %d events, want %d", len(events), appends)
+	}
+	prev := base
+	for _, e := range events {
+		if e.Seq != prev.Seq+1 || e.PrevHash != prev.Hash || e.Hash != hash(e) {
+			t.Errorf("event %+v doesn't chain to %+v", e, prev)
+		}
+		prev = e
+	}
+}
@@ -2,21 +2,24 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
-func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
+func respondWithError(w http.ResponseWriter, r *http.Request, code int, msg string, err error) {
 	type errorResponse struct {
 		Error string `json:"error"`
 	}
 
+	attrs := append(requestAttrs(r), slog.Int("status", code), slog.String("msg", msg))
 	if err != nil {
-		log.Println(err)
+		attrs = append(attrs, slog.Any("error", err))
 	}
 
 	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+		slog.Error("responding with 5XX error", attrs...)
+	} else if err != nil {
+		slog.Info("responding with error", attrs...)
 	}
 	respondWithJSON(w, code, errorResponse{Error: msg})
 }
@@ -25,7 +28,7 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		slog.Error("marshalling JSON", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMiddlewareLogRequests(t *testing.T) {
+	userID := uuid.New()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		setRequestUser(r, userID)
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})
+
+	tests := []struct {
+		name     string
+		headerID string
+		wantID   func(got string) bool
+	}{
+		{
+			name:     "Client request ID is kept",
+			headerID: "abc-123",
+			wantID:   func(got string) bool { return got == "abc-123" },
+		},
+		{
+			name:   "Missing request ID is generated",
+			wantID: func(got string) bool { return uuid.Validate(got) == nil },
+		},
+		{
+			name:     "Oversized request ID is replaced",
+			headerID: string(bytes.Repeat([]byte("a"), maxRequestIDLength+1)),
+			wantID:   func(got string) bool { return uuid.Validate(got) == nil },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			handler := middlewareLogRequests(slog.New(slog.NewJSONHandler(&buf, nil)), mux)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/things/42", nil)
+			if tt.headerID != "" {
+				req.Header.Set(requestIDHeader, tt.headerID)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			gotID := rec.Header().Get(requestIDHeader)
+			if !tt.wantID(gotID) {
+				t.Errorf("response %s = %q", requestIDHeader, gotID)
+			}
+
+			var entry map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("access log is not JSON: %v\n%s", err, buf.String())
+			}
+			want := map[string]any{
+				"request_id": gotID,
+				"method":     "GET",
+				"route":      "GET /api/things/{id}",
+				"status":     float64(http.StatusTeapot),
+				"bytes":      float64(len("short and stout")),
+				"user_id":    userID.String(),
+			}
+			for key, val := range want {
+				if entry[key] != val {
+					t.Errorf("access log %s = %v, want %v", key, entry[key], val)
+				}
+			}
+		})
+	}
+}
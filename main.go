@@ -1,60 +1,422 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/TheMaru/go-http-server/internal/database"
+	"github.com/TheMaru/go-http-server/internal/cache"
+	"github.com/TheMaru/go-http-server/internal/config"
+	"github.com/TheMaru/go-http-server/internal/migrate"
+	"github.com/TheMaru/go-http-server/internal/ratelimit"
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/static"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/TheMaru/go-http-server/web"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
 func main() {
+	cmd, what := run, "server"
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			cmd, what = runMigrate, "migrate"
+		case "create-admin":
+			cmd, what = runCreateAdmin, "create-admin"
+		case "verify-audit":
+			cmd, what = runVerifyAudit, "verify-audit"
+		}
+	}
+	if err := cmd(); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		slog.Error(what+" failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
 	godotenv.Load()
-	const port = "8080"
 
-	dbURL := os.Getenv("DB_URL")
-	db, err := sql.Open("postgres", dbURL)
+	conf, err := config.Load(os.Args[1:])
+	if err != nil {
+		return err
+	}
+	level, _ := conf.SlogLevel()
+	slog.SetDefault(newLogger(level))
+	slog.Info("loaded config", "config", conf)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := setupTracing(ctx, conf.Tracing)
+	if err != nil {
+		return fmt.Errorf("setting up tracing: %w", err)
+	}
+
+	db, driver, err := openDB(ctx, conf.DB)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			slog.Error("closing db", "error", err)
+		}
+	}()
+
+	migrator, err := migrate.New(db, driver)
+	if err != nil {
+		return err
+	}
+	if conf.DB.AutoMigrate {
+		if err := autoMigrate(ctx, migrator); err != nil {
+			return err
+		}
+	}
+
+	dbStore, err := store.New(driver, db, store.WithQueryWrapper(traceQueries(driver)))
+	if err != nil {
+		return err
+	}
+	chirpStore, closeCache, err := withCache(ctx, dbStore, conf.Cache)
+	if err != nil {
+		return err
+	}
+	defer closeCache()
+
+	workers := newWorkerGroup()
+	events := newEventBus(conf.Stream, db, conf.DB.URL, dbStore, workers)
+	if cached, ok := chirpStore.(*store.Cached); ok && conf.Stream.Bus == "postgres" {
+		workers.Go("cache-invalidation", invalidateCache(events, cached))
+	}
+
+	assets, err := openAssets(conf.Static.Dir)
+	if err != nil {
+		return err
+	}
+	pages, err := loadPages(assets)
 	if err != nil {
-		log.Printf("db err: %v", err)
-		log.Fatal("no connection to db")
+		return err
+	}
+
+	trustedProxies, err := conf.Server.TrustedProxyPrefixes()
+	if err != nil {
+		return err
 	}
 
 	mux := http.NewServeMux()
 	apiCfg := apiConfig{
-		dbQueries: database.New(db),
-		secret:    os.Getenv("SECRET"),
-		polkaKey:  os.Getenv("POLKA_KEY"),
+		service: service.New(chirpStore, service.Config{
+			Secret:          conf.Secret,
+			AccessTokenTTL:  conf.Tokens.AccessTTL,
+			RefreshTokenTTL: conf.Tokens.RefreshTTL,
+		}),
+		metrics:         newMetrics(db),
+		events:          events,
+		secret:          conf.Secret,
+		polkaKey:        conf.PolkaKey,
+		streamHeartbeat: conf.Stream.Heartbeat,
+		pages:           pages,
+		secureCookies:   conf.Platform != "dev",
+		refreshTokenTTL: conf.Tokens.RefreshTTL,
+		auditLog:        slog.Default().With("log", "audit"),
+		limiter:         newRateLimiter(conf.RateLimit, db, workers),
+		rateLimits:      conf.RateLimit.Policies,
+		trustedProxies:  trustedProxies,
 	}
 
 	server := &http.Server{
-		Handler: mux,
-		Addr:    ":" + port,
+		Handler:           rootHandler(mux, apiCfg.metrics, slog.Default()),
+		Addr:              ":" + conf.Port,
+		ReadHeaderTimeout: conf.Server.ReadHeaderTimeout,
+		ReadTimeout:       conf.Server.ReadTimeout,
+		WriteTimeout:      conf.Server.WriteTimeout,
+		IdleTimeout:       conf.Server.IdleTimeout,
 	}
+	// Shutdown waits for open requests, so event streams have to be told
+	// to finish.
+	server.RegisterOnShutdown(apiCfg.events.Close)
+
+	route(mux, fileserverRoute, http.StripPrefix("/app/static", assets))
+	route(mux, "/app/", movedAssets(assets))
+	route(mux, "GET /metrics", apiCfg.metrics.handler())
+
+	// handle registers a route whose queries share the route's deadline,
+	// behind the route's rate limit.
+	handle := func(pattern string, handler http.HandlerFunc) {
+		route(mux, pattern, withDeadline(conf.DB.TimeoutFor(pattern), apiCfg.rateLimited(pattern, handler)))
+	}
+
+	handle("GET /api/healthz", healthzHandler)
+	handle("GET /api/livez", livezHandler)
+	handle("GET /api/readyz", readyzHandler([]readinessCheck{
+		dbCheck(db),
+		migrationCheck(migrator),
+		workersCheck(workers),
+	}))
 
-	fs := http.FileServer(http.Dir("."))
-	mux.Handle("/app/", apiCfg.middlewareMetricsInc(http.StripPrefix("/app", fs)))
+	// Routes declare who may call them; see principal.go.
+	handle("GET /api/chirps", apiCfg.optionalUser(apiCfg.getChirpsHandler))
+	handle("GET /api/chirps/{chirpID}", apiCfg.optionalUser(apiCfg.getChirpByIDHandler))
+	handle("GET /api/chirps/{chirpID}/replies", apiCfg.optionalUser(apiCfg.getChirpRepliesHandler))
+	// Streams and sockets stay open indefinitely, so they get no route
+	// deadline.
+	route(mux, "GET /api/chirps/stream", http.HandlerFunc(apiCfg.streamChirpsHandler))
+	route(mux, "GET /api/ws", withQueryToken(apiCfg.requireUser(apiCfg.wsHandler)))
+	handle("POST /api/chirps", apiCfg.requireUser(apiCfg.createChirpHandler))
+	handle("DELETE /api/chirps/{chirpID}", apiCfg.requireUser(apiCfg.deleteChirpHandler))
+	handle("POST /api/chirps/{chirpID}/report", apiCfg.requireUser(apiCfg.reportChirpHandler))
 
-	mux.HandleFunc("GET /api/healthz", healthzHandler)
+	handle("POST /api/login", apiCfg.loginHandler)
+	handle("POST /api/refresh", apiCfg.withCookieAuth(refreshCookie, apiCfg.refreshHandler))
+	handle("POST /api/revoke", apiCfg.withCookieAuth(refreshCookie, apiCfg.revokeHandler))
+	handle("POST /api/logout", apiCfg.withCookieAuth(refreshCookie, apiCfg.logoutHandler))
+	handle("POST /api/polka/webhooks", apiCfg.requireScope(scopeWebhooks, apiCfg.polkaWebhookHandler))
 
-	mux.HandleFunc("GET /api/chirps", apiCfg.getChirpsHandler)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.getChirpByIDHandler)
-	mux.HandleFunc("POST /api/chirps", apiCfg.createChirpHandler)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.deleteChirpHandler)
+	handle("POST /api/users", apiCfg.addUserHandler)
+	handle("PUT /api/users", apiCfg.requireUser(apiCfg.updateUserHandler))
 
-	mux.HandleFunc("POST /api/login", apiCfg.loginHandler)
-	mux.HandleFunc("POST /api/refresh", apiCfg.refreshHandler)
-	mux.HandleFunc("POST /api/revoke", apiCfg.revokeHandler)
-	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.polkaWebhookHandler)
+	// The web UI. Its forms post back to it and carry a CSRF token.
+	handle("GET /app/{$}", apiCfg.uiTimelineHandler)
+	handle("GET /app/chirps/{chirpID}", apiCfg.uiChirpHandler)
+	handle("GET /app/users/{userID}", apiCfg.uiProfileHandler)
+	handle("GET /app/login", apiCfg.uiLoginPageHandler)
+	handle("POST /app/login", apiCfg.uiLoginHandler)
+	handle("GET /app/signup", apiCfg.uiSignupPageHandler)
+	handle("POST /app/signup", apiCfg.uiSignupHandler)
+	handle("POST /app/logout", apiCfg.uiLogoutHandler)
+	handle("POST /app/chirps", apiCfg.uiPostChirpHandler)
+	handle("POST /app/chirps/{chirpID}/delete", apiCfg.uiDeleteChirpHandler)
 
-	mux.HandleFunc("POST /api/users", apiCfg.addUserHandler)
-	mux.HandleFunc("PUT /api/users", apiCfg.updateUserHandler)
+	// Admin routes need a staff role; see rolePermissions.
+	handle("GET /admin/metrics", apiCfg.audited("view_metrics", apiCfg.requireScope(scopeMetrics, apiCfg.metricsHandler)))
+	handle("GET /admin/users", apiCfg.audited("search_users", apiCfg.requireAdmin(apiCfg.adminSearchUsersHandler)))
+	handle("GET /admin/users/{userID}", apiCfg.audited("view_user", apiCfg.requireAdmin(apiCfg.adminGetUserHandler)))
+	handle("POST /admin/users/{userID}/suspend", apiCfg.audited("suspend_user", apiCfg.requireAdmin(apiCfg.adminSuspendUserHandler)))
+	handle("POST /admin/users/{userID}/unsuspend", apiCfg.audited("unsuspend_user", apiCfg.requireAdmin(apiCfg.adminUnsuspendUserHandler)))
+	handle("POST /admin/users/{userID}/password-reset", apiCfg.audited("force_password_reset", apiCfg.requireAdmin(apiCfg.adminPasswordResetHandler)))
+	handle("POST /admin/users/{userID}/revoke-sessions", apiCfg.audited("revoke_sessions", apiCfg.requireAdmin(apiCfg.adminRevokeSessionsHandler)))
+	handle("PUT /admin/users/{userID}/chirpy-red", apiCfg.audited("set_chirpy_red", apiCfg.requireAdmin(apiCfg.adminSetChirpyRedHandler)))
+	handle("DELETE /admin/users/{userID}", apiCfg.audited("delete_user", apiCfg.requireAdmin(apiCfg.adminDeleteUserHandler)))
+	handle("GET /admin/audit", apiCfg.audited("view_audit_log", apiCfg.requireAdmin(apiCfg.adminAuditHandler)))
+	handle("GET /admin/moderation", apiCfg.audited("view_moderation_queue", apiCfg.requireScope(scopeModerate, apiCfg.moderationQueueHandler)))
+	handle("POST /admin/moderation/{reportID}/claim", apiCfg.audited("claim_report", apiCfg.requireScope(scopeModerate, apiCfg.claimReportHandler)))
+	handle("POST /admin/moderation/{reportID}/resolve", apiCfg.audited("resolve_report", apiCfg.requireScope(scopeModerate, apiCfg.resolveReportHandler)))
+	handle("POST /admin/moderation/{reportID}/hide-chirp", apiCfg.audited("hide_chirp", apiCfg.requireScope(scopeModerate, apiCfg.hideReportedChirpHandler)))
+	handle("POST /admin/moderation/{reportID}/suspend-author", apiCfg.audited("suspend_author", apiCfg.requireScope(scopeModerate, apiCfg.suspendReportedAuthorHandler)))
 
-	mux.HandleFunc("POST /admin/reset", apiCfg.resetHitsHandler)
-	mux.HandleFunc("GET /admin/metrics", apiCfg.metricsHandler)
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("serving", "port", conf.Port)
+	servers := []listening{{server, ln}}
+
+	// The test support routes get a loopback listener of their own, so
+	// they are never reachable through the public one.
+	if conf.TestSupport.Enabled {
+		testMux := http.NewServeMux()
+		apiCfg.registerTestSupport(func(pattern string, handler http.HandlerFunc) {
+			testMux.Handle(pattern, withDeadline(conf.DB.TimeoutFor(pattern), handler))
+		})
+		testServer := &http.Server{
+			Handler:           middlewareLogRequests(slog.Default(), testMux),
+			Addr:              conf.TestSupport.Addr,
+			ReadHeaderTimeout: conf.Server.ReadHeaderTimeout,
+			ReadTimeout:       conf.Server.ReadTimeout,
+			WriteTimeout:      conf.Server.WriteTimeout,
+			IdleTimeout:       conf.Server.IdleTimeout,
+		}
+		testLn, err := net.Listen("tcp", testServer.Addr)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		slog.Warn("test mode: serving test support routes", "addr", conf.TestSupport.Addr)
+		servers = append(servers, listening{testServer, testLn})
+	}
 
-	log.Printf("Serving on port: %s\n", port)
-	log.Fatal(server.ListenAndServe())
+	// A second signal while draining kills the process.
+	context.AfterFunc(ctx, stop)
+	if err := serve(ctx, conf.Server.ShutdownTimeout, workers, servers...); err != nil {
+		return err
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), conf.Server.ShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("flushing traces", "error", err)
+	}
+
+	slog.Info("server stopped")
+	return nil
+}
+
+// rootHandler wraps mux in the middlewares every request goes through.
+func rootHandler(mux *http.ServeMux, m *metrics, logger *slog.Logger) http.Handler {
+	return middlewareTrace(middlewareLogRequests(logger, m.middleware(mux)))
+}
+
+// route registers handler on mux under pattern, naming the request's
+// trace span after it.
+func route(mux *http.ServeMux, pattern string, handler http.Handler) {
+	mux.Handle(pattern, nameRouteSpan(handler))
+}
+
+// listening is a server and the listener it accepts connections on.
+type listening struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// serve runs every server until one of them fails or ctx is done, then
+// shuts them down. Shutdown stops accepting new requests and lets
+// in-flight ones finish; only then are the workers stopped, so the
+// draining requests still get their events delivered and caches
+// invalidated.
+func serve(ctx context.Context, shutdownTimeout time.Duration, workers *workerGroup, servers ...listening) error {
+	serverErr := make(chan error, len(servers))
+	for _, s := range servers {
+		go func() {
+			serverErr <- s.server.Serve(s.listener)
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			workers.Stop(context.Background())
+			return err
+		}
+	case <-ctx.Done():
+		slog.Info("shutdown signal received, draining connections")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("server shutdown", "addr", s.listener.Addr().String(), "error", err)
+		}
+	}
+	if err := workers.Stop(shutdownCtx); err != nil {
+		slog.Error("stopping workers", "error", err)
+	}
+	return nil
+}
+
+// withCache puts the configured read-through cache in front of s. The
+// returned func releases the cache's connections.
+func withCache(ctx context.Context, s store.Store, conf config.CacheConfig) (store.Store, func(), error) {
+	switch conf.Backend {
+	case "memory":
+		return store.NewCached(s, cache.NewLRU(conf.Size), conf.TTL), func() {}, nil
+	case "redis":
+		c, err := cache.NewRedis(conf.RedisURL, "chirpy:")
+		if err != nil {
+			return nil, nil, fmt.Errorf("cache redis url: %w", err)
+		}
+		// Reads fall back to the database while redis is away, so an
+		// unreachable server is worth a warning but not a failed start.
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := c.Ping(pingCtx); err != nil {
+			slog.Warn("redis cache unreachable", "error", err)
+		}
+		closeCache := func() {
+			if err := c.Close(); err != nil {
+				slog.Error("closing redis cache", "error", err)
+			}
+		}
+		return store.NewCached(s, c, conf.TTL), closeCache, nil
+	default:
+		return s, func() {}, nil
+	}
+}
+
+// newRateLimiter returns the rate limit store conf selects, or nil if rate
+// limiting is off. The postgres store sweeps refilled buckets on workers.
+func newRateLimiter(conf config.RateLimitConfig, db *sql.DB, workers *workerGroup) ratelimit.Store {
+	switch conf.Store {
+	case "memory":
+		return ratelimit.NewMemory()
+	case "postgres":
+		limiter := ratelimit.NewPostgres(db)
+		workers.Go("rate-limit-sweeper", limiter.Run)
+		return limiter
+	default:
+		return nil
+	}
+}
+
+// openAssets returns the server for the files under /app/static/: those
+// in dir, or the embedded ones if dir is empty.
+func openAssets(dir string) (*static.Server, error) {
+	fsys := web.Public()
+	if dir != "" {
+		fsys = os.DirFS(dir)
+	}
+	return static.New(fsys)
+}
+
+// movedAssets redirects requests for the assets' old URLs, from before
+// they moved under /app/static/ to make room for the web UI, and answers
+// the other unknown paths under /app/ with 404. The old index.html is now
+// the timeline.
+func movedAssets(assets *static.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/app/")
+		get := r.Method == http.MethodGet || r.Method == http.MethodHead
+		var target string
+		switch {
+		case get && name == "index.html":
+			target = "/app/"
+		case get && assets.Has(name):
+			target = fileserverRoute + name
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+// openDB opens the pool described by conf and waits until the database
+// answers. It also returns the driver the URL selected.
+func openDB(ctx context.Context, conf config.DBConfig) (*sql.DB, string, error) {
+	driver, dsn, err := store.DriverFor(conf.URL)
+	if err != nil {
+		return nil, "", err
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, "", fmt.Errorf("no connection to db: %w", err)
+	}
+	db.SetMaxOpenConns(conf.MaxOpenConns)
+	db.SetMaxIdleConns(conf.MaxIdleConns)
+	db.SetConnMaxLifetime(conf.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(conf.ConnMaxIdleTime)
+
+	if err := waitForDB(ctx, db, conf.ConnectTimeout); err != nil {
+		db.Close()
+		return nil, "", err
+	}
+	return db, driver, nil
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestServeDrainsBeforeStoppingWorkers sends the process SIGTERM while a
+// request is open and checks the workers keep running until the server
+// has drained it.
+func TestServeDrainsBeforeStoppingWorkers(t *testing.T) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+
+	workers := newWorkerGroup()
+	workerStopped := make(chan struct{})
+	workers.Go("probe", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(workerStopped)
+		return nil
+	})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var stoppedEarly bool
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		select {
+		case <-workerStopped:
+			stoppedEarly = true
+		default:
+		}
+		w.Write([]byte("done"))
+	})}
+	shuttingDown := make(chan struct{})
+	server.RegisterOnShutdown(func() { close(shuttingDown) })
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, 10*time.Second, workers, listening{server, ln}) }()
+
+	body := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		body <- string(b)
+	}()
+	<-started
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	<-shuttingDown
+	select {
+	case <-workerStopped:
+		t.Fatal("workers stopped while a request was still open")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if got := <-body; got != "done" {
+		t.Errorf("open request got %q, want it to finish", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve() error = %v", err)
+	}
+	if stoppedEarly {
+		t.Error("workers stopped before the open request finished")
+	}
+	select {
+	case <-workerStopped:
+	default:
+		t.Error("workers still running after serve returned")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "chirpy"
+
+// fileserverRoute is the mux pattern of the static file server.
+const fileserverRoute = "/app/static/"
+
+// isAppRoute reports whether the mux pattern route is part of the web UI,
+// its assets included. Requests to those are what the admin page calls
+// visits.
+func isAppRoute(route string) bool {
+	_, path, ok := strings.Cut(route, " ")
+	if !ok {
+		path = route
+	}
+	return strings.HasPrefix(path, "/app/")
+}
+
+type metrics struct {
+	registry      *prometheus.Registry
+	requests      *prometheus.CounterVec
+	latency       *prometheus.HistogramVec
+	inFlight      prometheus.Gauge
+	chirpsCreated prometheus.Counter
+	logins        *prometheus.CounterVec
+	webhooks      *prometheus.CounterVec
+}
+
+func newMetrics(db *sql.DB) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route pattern and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests currently being served.",
+		}),
+		chirpsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "chirps_created_total",
+			Help:      "Chirps successfully created.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "logins_total",
+			Help:      "Login attempts by result.",
+		}, []string{"result"}),
+		webhooks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "webhooks_total",
+			Help:      "Incoming webhooks by provider and outcome.",
+		}, []string{"provider", "outcome"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.latency,
+		m.inFlight,
+		m.chirpsCreated,
+		m.logins,
+		m.webhooks,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, metricsNamespace))
+	}
+
+	return m
+}
+
+// handler serves the registry in the Prometheus exposition format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// middleware records request count, latency and in-flight requests. It has
+// to wrap the mux so the route pattern is known once the request returns.
+func (m *metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		m.latency.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// appHits sums the requests served by the web UI and its assets.
+func (m *metrics) appHits() (int, error) {
+	routes, err := m.appRoutes()
+	if err != nil {
+		return 0, err
+	}
+	var hits float64
+	for _, n := range routes {
+		hits += n
+	}
+	return int(hits), nil
+}
+
+// resetAppHits drops the series of the web UI routes so the visit count
+// starts over.
+func (m *metrics) resetAppHits() error {
+	routes, err := m.appRoutes()
+	if err != nil {
+		return err
+	}
+	for route := range routes {
+		m.requests.DeletePartialMatch(prometheus.Labels{"route": route})
+	}
+	return nil
+}
+
+// appRoutes returns the request count of every web UI route that has been
+// requested, by route.
+func (m *metrics) appRoutes() (map[string]float64, error) {
+	families, err := m.registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	routes := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != metricsNamespace+"_http_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "route" && isAppRoute(label.GetValue()) {
+					routes[label.GetValue()] += metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return routes, nil
+}
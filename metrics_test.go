@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	m := newMetrics(nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(fileserverRoute, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("static"))
+	})
+	mux.HandleFunc("GET /app/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("timeline"))
+	})
+	mux.HandleFunc("GET /api/chirps", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := m.middleware(mux)
+
+	for _, path := range []string{"/app/", "/app/static/style.css", "/app/static/assets/logo.png", "/api/chirps", "/nope"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	hits, err := m.appHits()
+	if err != nil {
+		t.Fatalf("appHits() error = %v", err)
+	}
+	if hits != 3 {
+		t.Errorf("appHits() = %d, want 3", hits)
+	}
+
+	rec := httptest.NewRecorder()
+	m.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`chirpy_http_requests_total{method="GET",route="GET /api/chirps",status="204"} 1`,
+		`chirpy_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`chirpy_http_request_duration_seconds_count{method="GET",route="/app/static/"} 2`,
+		`chirpy_http_requests_in_flight 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics is missing %q", want)
+		}
+	}
+
+	if err := m.resetAppHits(); err != nil {
+		t.Fatalf("resetAppHits() error = %v", err)
+	}
+	if hits, _ := m.appHits(); hits != 0 {
+		t.Errorf("appHits() after reset = %d, want 0", hits)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/TheMaru/go-http-server/internal/config"
+	"github.com/TheMaru/go-http-server/internal/migrate"
+	"github.com/joho/godotenv"
+)
+
+// runMigrate implements "chirpy migrate [flags] up|down|status|redo". It
+// reads the same config sources as the server but only needs the db
+// settings.
+func runMigrate() error {
+	godotenv.Load()
+
+	conf, args, err := config.LoadDB(os.Args[2:])
+	if err != nil {
+		return err
+	}
+	level, _ := conf.SlogLevel()
+	slog.SetDefault(newLogger(level))
+
+	if len(args) != 1 {
+		return errors.New("usage: chirpy migrate [flags] " + strings.Join(migrate.Commands, "|"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, driver, err := openDB(ctx, conf.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db, driver)
+	if err != nil {
+		return err
+	}
+	return migrator.Run(ctx, args[0], os.Stdout)
+}
+
+// autoMigrate applies pending migrations on boot. Replicas starting at the
+// same time queue up on the advisory lock, and all but the first find
+// nothing left to do.
+func autoMigrate(ctx context.Context, migrator *migrate.Migrator) error {
+	results, err := migrator.Up(ctx)
+	for _, r := range results {
+		slog.Info("applied migration",
+			"version", r.Source.Version,
+			"direction", r.Direction,
+			"duration", r.Duration,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("applying migrations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultQueueLimit = 50
+	maxQueueLimit     = 200
+)
+
+type reportResp struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	// ReporterID is null for reports the profanity filter filed.
+	ReporterID  *uuid.UUID `json:"reporter_id"`
+	Reason      string     `json:"reason"`
+	Details     string     `json:"details"`
+	Status      string     `json:"status"`
+	ModeratorID *uuid.UUID `json:"moderator_id"`
+	ClaimedAt   *time.Time `json:"claimed_at"`
+	ResolvedAt  *time.Time `json:"resolved_at"`
+	Resolution  string     `json:"resolution"`
+	// Chirp is only filled in for the moderation queue.
+	Chirp *chirpResp `json:"chirp,omitempty"`
+}
+
+func newReportResp(r store.Report) reportResp {
+	return reportResp{
+		ID:          r.ID,
+		CreatedAt:   r.CreatedAt,
+		UpdatedAt:   r.UpdatedAt,
+		ChirpID:     r.ChirpID,
+		ReporterID:  uuidOrNil(r.ReporterID),
+		Reason:      r.Reason,
+		Details:     r.Details,
+		Status:      r.Status,
+		ModeratorID: uuidOrNil(r.ModeratorID),
+		ClaimedAt:   timeOrNil(r.ClaimedAt),
+		ResolvedAt:  timeOrNil(r.ResolvedAt),
+		Resolution:  r.Resolution,
+	}
+}
+
+func uuidOrNil(id uuid.NullUUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+	return &id.UUID
+}
+
+// reportChirpHandler files a report on someone else's chirp.
+func (cfg *apiConfig) reportChirpHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, "Not a valid uuid", err)
+		return
+	}
+	var params struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	report, err := cfg.service.ReportChirp(r.Context(), p.UserID, chirpID, params.Reason, params.Details)
+	switch {
+	case errors.Is(err, service.ErrInvalidReason):
+		respondWithError(w, r, http.StatusBadRequest, "reason must be one of "+strings.Join(service.ReportReasons, ", "), err)
+		return
+	case errors.Is(err, service.ErrReportTooLong):
+		respondWithError(w, r, http.StatusBadRequest, "details can be at most "+strconv.Itoa(service.MaxReportDetailsLength)+" characters long", err)
+		return
+	case errors.Is(err, service.ErrOwnChirp):
+		respondWithError(w, r, http.StatusForbidden, "Can't report your own chirp", err)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		respondWithError(w, r, http.StatusNotFound, "Chirp not found", err)
+		return
+	case errors.Is(err, store.ErrConflict):
+		respondWithError(w, r, http.StatusConflict, "Already reported", err)
+		return
+	case err != nil:
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't report chirp", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, newReportResp(report))
+}
+
+// moderationReportID parses the report ID of an /admin/moderation route.
+func moderationReportID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(r.PathValue("reportID"))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, "Not a valid uuid", err)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func respondWithModerationError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		respondWithError(w, r, http.StatusNotFound, "Report not found", err)
+	case errors.Is(err, service.ErrReportClaimed):
+		respondWithError(w, r, http.StatusConflict, "Report claimed by another moderator", err)
+	case errors.Is(err, service.ErrReportResolved):
+		respondWithError(w, r, http.StatusConflict, "Report already resolved", err)
+	case errors.Is(err, service.ErrSelfAction):
+		respondWithError(w, r, http.StatusForbidden, "Moderators can't do this to their own account", err)
+	case errors.Is(err, service.ErrTargetPrivileged):
+		respondWithError(w, r, http.StatusForbidden, "Moderators can't suspend moderators or admins", err)
+	default:
+		respondWithDBError(w, r, http.StatusInternalServerError, msg, err)
+	}
+}
+
+// moderationQueueHandler lists reports with their chirps, oldest first.
+// Without a status it lists the ones still waiting for a moderator.
+func (cfg *apiConfig) moderationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultQueueLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxQueueLimit {
+			respondWithError(w, r, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxQueueLimit), err)
+			return
+		}
+		limit = n
+	}
+
+	queue, err := cfg.service.ModerationQueue(r.Context(), r.URL.Query().Get("status"), limit)
+	if errors.Is(err, service.ErrInvalidStatus) {
+		respondWithError(w, r, http.StatusBadRequest, "status must be open, claimed or resolved", err)
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't load moderation queue", err)
+		return
+	}
+	res := make([]reportResp, len(queue))
+	for i, item := range queue {
+		res[i] = newReportResp(item.Report)
+		chirp := newChirpResp(item.Chirp)
+		res[i].Chirp = &chirp
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+func (cfg *apiConfig) claimReportHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
+	id, ok := moderationReportID(w, r)
+	if !ok {
+		return
+	}
+	report, err := cfg.service.ClaimReport(r.Context(), p.UserID, id)
+	if err != nil {
+		respondWithModerationError(w, r, "Couldn't claim report", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newReportResp(report))
+}
+
+// resolveReportHandler closes a report without acting on the chirp.
+func (cfg *apiConfig) resolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
+	id, ok := moderationReportID(w, r)
+	if !ok {
+		return
+	}
+	report, err := cfg.service.DismissReport(r.Context(), p.UserID, id)
+	if err != nil {
+		respondWithModerationError(w, r, "Couldn't resolve report", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newReportResp(report))
+}
+
+func (cfg *apiConfig) hideReportedChirpHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
+	id, ok := moderationReportID(w, r)
+	if !ok {
+		return
+	}
+	report, chirp, err := cfg.service.HideReportedChirp(r.Context(), p.UserID, id)
+	if err != nil {
+		respondWithModerationError(w, r, "Couldn't hide chirp", err)
+		return
+	}
+	// For everyone but its author the chirp is gone, so streams and
+	// caches treat it as deleted.
+	cfg.publish(r, pubsub.ChirpEvent(pubsub.ChirpDeleted, chirp))
+	respondWithJSON(w, http.StatusOK, newReportResp(report))
+}
+
+func (cfg *apiConfig) suspendReportedAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
+	id, ok := moderationReportID(w, r)
+	if !ok {
+		return
+	}
+	report, authorID, err := cfg.service.SuspendReportedAuthor(r.Context(), p.UserID, id)
+	if err != nil {
+		respondWithModerationError(w, r, "Couldn't suspend author", err)
+		return
+	}
+	cfg.publish(r, pubsub.Event{Type: pubsub.UserUpdated, UserID: authorID})
+	respondWithJSON(w, http.StatusOK, newReportResp(report))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/store"
+)
+
+func TestModerationHandlers(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	_, modToken := newTestUser(t, cfg, store.RoleModerator, time.Hour)
+	_, otherModToken := newTestUser(t, cfg, store.RoleModerator, time.Hour)
+	_, userToken := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	author, authorToken := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	reporter, _ := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	admin, adminToken := newTestUser(t, cfg, store.RoleAdmin, time.Hour)
+	hidden, err := cfg.service.CreateChirp(t.Context(), author.ID, "i am the one who knocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dismissed, _ := cfg.service.CreateChirp(t.Context(), author.ID, "say my name")
+	suspended, _ := cfg.service.CreateChirp(t.Context(), author.ID, "tread lightly")
+	privileged, _ := cfg.service.CreateChirp(t.Context(), admin.ID, "we're done when i say we're done")
+	reports := map[string]string{}
+	for name, chirp := range map[string]store.Chirp{"hidden": hidden, "dismissed": dismissed, "suspended": suspended, "privileged": privileged} {
+		r, err := cfg.service.ReportChirp(t.Context(), reporter.ID, chirp.ID, service.ReasonHarassment, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		reports[name] = "/admin/moderation/" + r.ID.String()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/whoami", cfg.requireUser(whoami))
+	mux.HandleFunc("GET /api/chirps", cfg.optionalUser(cfg.getChirpsHandler))
+	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.optionalUser(cfg.getChirpByIDHandler))
+	mux.HandleFunc("POST /api/chirps/{chirpID}/report", cfg.requireUser(cfg.reportChirpHandler))
+	mux.HandleFunc("GET /admin/moderation", cfg.requireScope(scopeModerate, cfg.moderationQueueHandler))
+	mux.HandleFunc("POST /admin/moderation/{reportID}/claim", cfg.requireScope(scopeModerate, cfg.claimReportHandler))
+	mux.HandleFunc("POST /admin/moderation/{reportID}/resolve", cfg.requireScope(scopeModerate, cfg.resolveReportHandler))
+	mux.HandleFunc("POST /admin/moderation/{reportID}/hide-chirp", cfg.requireScope(scopeModerate, cfg.hideReportedChirpHandler))
+	mux.HandleFunc("POST /admin/moderation/{reportID}/suspend-author", cfg.requireScope(scopeModerate, cfg.suspendReportedAuthorHandler))
+
+	report := "/api/chirps/" + hidden.ID.String() + "/report"
+
+	// The steps run in order, each on the state the last one left.
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{name: "Report anonymously", method: http.MethodPost, path: report, body: `{"reason":"spam"}`, wantCode: http.StatusUnauthorized},
+		{name: "Report", method: http.MethodPost, path: report, body: `{"reason":"spam","details":"buy blue"}`, token: userToken, wantCode: http.StatusCreated, wantBody: `"status":"open"`},
+		{name: "Report twice", method: http.MethodPost, path: report, body: `{"reason":"other"}`, token: userToken, wantCode: http.StatusConflict},
+		{name: "Report own chirp", method: http.MethodPost, path: report, body: `{"reason":"spam"}`, token: authorToken, wantCode: http.StatusForbidden},
+		{name: "Report unknown reason", method: http.MethodPost, path: report, body: `{"reason":"boring"}`, token: userToken, wantCode: http.StatusBadRequest},
+		{name: "Report long details", method: http.MethodPost, path: report, body: `{"reason":"spam","details":"` + strings.Repeat("a", service.MaxReportDetailsLength+1) + `"}`, token: userToken, wantCode: http.StatusBadRequest},
+		{name: "Queue as user", method: http.MethodGet, path: "/admin/moderation", token: userToken, wantCode: http.StatusForbidden},
+		{name: "Queue", method: http.MethodGet, path: "/admin/moderation", token: modToken, wantCode: http.StatusOK, wantBody: `"body":"i am the one who knocks"`},
+		{name: "Queue with bad status", method: http.MethodGet, path: "/admin/moderation?status=lost", token: modToken, wantCode: http.StatusBadRequest},
+		{name: "Claim", method: http.MethodPost, path: reports["dismissed"] + "/claim", token: modToken, wantCode: http.StatusOK, wantBody: `"status":"claimed"`},
+		{name: "Claimed by someone else", method: http.MethodPost, path: reports["dismissed"] + "/resolve", token: otherModToken, wantCode: http.StatusConflict},
+		{name: "Resolve", method: http.MethodPost, path: reports["dismissed"] + "/resolve", token: modToken, wantCode: http.StatusOK, wantBody: `"resolution":"dismissed"`},
+		{name: "Resolve again", method: http.MethodPost, path: reports["dismissed"] + "/resolve", token: modToken, wantCode: http.StatusConflict},
+		{name: "Resolve unknown", method: http.MethodPost, path: "/admin/moderation/3311741c-680c-4546-99f3-fc9efac2036c/resolve", token: modToken, wantCode: http.StatusNotFound},
+		{name: "Hide chirp", method: http.MethodPost, path: reports["hidden"] + "/hide-chirp", token: modToken, wantCode: http.StatusOK, wantBody: `"resolution":"chirp_hidden"`},
+		{name: "Hidden from others", method: http.MethodGet, path: "/api/chirps/" + hidden.ID.String(), token: userToken, wantCode: http.StatusNotFound},
+		{name: "Hidden from list", method: http.MethodGet, path: "/api/chirps", wantCode: http.StatusOK, wantBody: `"body":"say my name"`},
+		{name: "Shown to author", method: http.MethodGet, path: "/api/chirps/" + hidden.ID.String(), token: authorToken, wantCode: http.StatusOK, wantBody: `"hidden":true,"notice"`},
+		{name: "Report hidden", method: http.MethodPost, path: report, body: `{"reason":"hate"}`, token: modToken, wantCode: http.StatusNotFound},
+		{name: "Suspend author", method: http.MethodPost, path: reports["suspended"] + "/suspend-author", token: modToken, wantCode: http.StatusOK, wantBody: `"resolution":"author_suspended"`},
+		{name: "Suspended author", method: http.MethodGet, path: "/api/whoami", token: authorToken, wantCode: http.StatusForbidden},
+		{name: "Suspend admin", method: http.MethodPost, path: reports["privileged"] + "/suspend-author", token: modToken, wantCode: http.StatusForbidden},
+		{name: "Admin not suspended", method: http.MethodGet, path: "/api/whoami", token: adminToken, wantCode: http.StatusOK},
+		{name: "Resolve admin report", method: http.MethodPost, path: reports["privileged"] + "/resolve", token: modToken, wantCode: http.StatusOK},
+		{name: "Queue empty", method: http.MethodGet, path: "/admin/moderation", token: modToken, wantCode: http.StatusOK, wantBody: `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantCode, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/auth"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+// Scopes name what a principal may do beyond acting as itself.
+const (
+	// scopeWebhooks lets Polka announce payment events.
+	scopeWebhooks = "webhooks:polka"
+	// scopeMetrics lets staff read the admin metrics page.
+	scopeMetrics = "metrics:read"
+	// scopeModerate lets staff work the moderation queue.
+	scopeModerate = "moderation"
+	// scopeAdmin opens the admin routes.
+	scopeAdmin = "admin"
+)
+
+// rolePermissions is the permission matrix: the scopes a user holds by
+// role. Roles are looked up on every request rather than carried in the
+// access token, so a demotion takes effect at once.
+var rolePermissions = map[string][]string{
+	store.RoleUser:      nil,
+	store.RoleModerator: {scopeMetrics, scopeModerate},
+	store.RoleAdmin:     {scopeMetrics, scopeModerate, scopeAdmin},
+}
+
+// Principal is who a request acts for: a user holding an access token,
+// or a service holding an API key.
+type Principal struct {
+	// UserID, Role and ChirpyRed are set for users only.
+	UserID    uuid.UUID
+	Role      string
+	ChirpyRed bool
+	// Service names the owner of an API key, and is empty for users.
+	Service string
+	Scopes  []string
+	// Via is how the credential came in: "bearer", "cookie" or "api_key".
+	Via string
+	// ExpiresAt is when the credential runs out. API keys don't.
+	ExpiresAt time.Time
+}
+
+// IsUser reports whether p is a logged in user rather than a service.
+func (p Principal) IsUser() bool {
+	return p.UserID != uuid.Nil
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+var (
+	errNoCredentials  = errors.New("no credentials")
+	errBadCredentials = errors.New("invalid credentials")
+	errBadCSRF        = errors.New("missing or invalid CSRF token")
+	// errSuspended and errPasswordReset turn away the access tokens of
+	// users an admin locked out after the tokens were signed.
+	errSuspended     = errors.New("account suspended")
+	errPasswordReset = errors.New("password reset required")
+)
+
+type principalKey struct{}
+
+// principalFrom returns the principal the auth middleware found for r.
+func principalFrom(r *http.Request) (Principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// authenticate finds the principal of r: an access token or API key in
+// the Authorization header, or else the access token in the session
+// cookie. Cookies are sent by browsers on their own, so a request
+// authenticated by one that changes anything must prove with the CSRF
+// header that it comes from our own pages.
+func (cfg *apiConfig) authenticate(r *http.Request) (Principal, error) {
+	if r.Header.Get("Authorization") != "" {
+		if key, err := auth.GetAPIKey(r.Header); err == nil {
+			return cfg.apiKeyPrincipal(key)
+		}
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			return Principal{}, errBadCredentials
+		}
+		return cfg.tokenPrincipal(r.Context(), token, "bearer")
+	}
+	c, err := r.Cookie(accessCookie)
+	if err != nil || c.Value == "" {
+		return Principal{}, errNoCredentials
+	}
+	if !isSafeMethod(r.Method) && !validCSRF(r, r.Header.Get(csrfHeader)) {
+		return Principal{}, errBadCSRF
+	}
+	return cfg.tokenPrincipal(r.Context(), c.Value, "cookie")
+}
+
+// tokenPrincipal validates an access token and looks up its user's role.
+// A token of a deleted user is as good as a forged one, and suspended
+// users and users who have to reset their password get nowhere with one.
+func (cfg *apiConfig) tokenPrincipal(ctx context.Context, token, via string) (Principal, error) {
+	userID, expiresAt, err := auth.ValidateJWTWithExpiry(token, cfg.secret)
+	if err != nil {
+		return Principal{}, errors.Join(errBadCredentials, err)
+	}
+	user, err := cfg.service.GetUser(ctx, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return Principal{}, errors.Join(errBadCredentials, err)
+	}
+	if err != nil {
+		return Principal{}, fmt.Errorf("looking up user: %w", err)
+	}
+	switch {
+	case user.SuspendedAt.Valid:
+		return Principal{}, errSuspended
+	case user.PasswordResetRequired:
+		return Principal{}, errPasswordReset
+	}
+	return Principal{
+		UserID:    userID,
+		Role:      user.Role,
+		ChirpyRed: user.IsChirpyRed,
+		Scopes:    rolePermissions[user.Role],
+		Via:       via,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func (cfg *apiConfig) apiKeyPrincipal(key string) (Principal, error) {
+	if cfg.polkaKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(cfg.polkaKey)) == 1 {
+		return Principal{Service: "polka", Scopes: []string{scopeWebhooks}, Via: "api_key"}, nil
+	}
+	return Principal{}, errBadCredentials
+}
+
+// withPrincipal authenticates r, unless an outer middleware already did,
+// and returns it with the principal in its context.
+func (cfg *apiConfig) withPrincipal(r *http.Request) (*http.Request, Principal, error) {
+	if p, ok := principalFrom(r); ok {
+		return r, p, nil
+	}
+	p, err := cfg.authenticate(r)
+	if err != nil {
+		return r, Principal{}, err
+	}
+	if p.IsUser() {
+		setRequestUser(r, p.UserID)
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p)), p, nil
+}
+
+func respondWithAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errNoCredentials):
+		respondWithError(w, r, http.StatusUnauthorized, "Not logged in", err)
+	case errors.Is(err, errBadCSRF):
+		respondWithError(w, r, http.StatusForbidden, "Missing or invalid CSRF token", err)
+	case errors.Is(err, errBadCredentials):
+		respondWithError(w, r, http.StatusUnauthorized, "Invalid token", err)
+	case errors.Is(err, errSuspended):
+		respondWithError(w, r, http.StatusForbidden, "Account suspended", err)
+	case errors.Is(err, errPasswordReset):
+		respondWithError(w, r, http.StatusForbidden, "Password reset required", err)
+	default:
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't check credentials", err)
+	}
+}
+
+// The middlewares below declare what a route needs. They nest in any
+// order and authenticate only once.
+
+// requireUser lets only logged in users through to next.
+func (cfg *apiConfig) requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, p, err := cfg.withPrincipal(r)
+		if err != nil {
+			respondWithAuthError(w, r, err)
+			return
+		}
+		if !p.IsUser() {
+			respondWithError(w, r, http.StatusForbidden, "Only users can do this", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// optionalUser passes the principal on to next if the request has a
+// valid one and serves it anonymously otherwise, so stale tokens don't
+// break public routes.
+func (cfg *apiConfig) optionalUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authed, _, err := cfg.withPrincipal(r); err == nil {
+			r = authed
+		}
+		next(w, r)
+	}
+}
+
+// requireScope lets only principals holding scope through to next.
+func (cfg *apiConfig) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, p, err := cfg.withPrincipal(r)
+		if err != nil {
+			respondWithAuthError(w, r, err)
+			return
+		}
+		if !p.HasScope(scope) {
+			respondWithError(w, r, http.StatusForbidden, "Not allowed", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireAdmin lets only admins through to next.
+func (cfg *apiConfig) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return cfg.requireScope(scopeAdmin, next)
+}
+
+// withQueryToken takes the access token from the access_token query
+// parameter if the request has no Authorization header. Browsers can't
+// set headers on WebSocket requests.
+func withQueryToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("access_token")
+		if token == "" || r.Header.Get("Authorization") != "" {
+			next(w, r)
+			return
+		}
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+token)
+		next(w, r)
+	}
+}
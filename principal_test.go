@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/auth"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+// whoami answers with the principal the middleware found, or "anonymous".
+func whoami(w http.ResponseWriter, r *http.Request) {
+	p, ok := principalFrom(r)
+	switch {
+	case !ok:
+		w.Write([]byte("anonymous"))
+	case p.IsUser():
+		w.Write([]byte(p.UserID.String() + " via " + p.Via))
+	default:
+		w.Write([]byte(p.Service + " via " + p.Via))
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	user, token := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	userID := user.ID
+	_, expired := newTestUser(t, cfg, store.RoleUser, -time.Hour)
+	_, moderator := newTestUser(t, cfg, store.RoleModerator, time.Hour)
+	_, admin := newTestUser(t, cfg, store.RoleAdmin, time.Hour)
+	deleted, err := auth.MakeJWT(uuid.New(), testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bearer := func(token string) http.Header { return http.Header{"Authorization": {"Bearer " + token}} }
+	polka := http.Header{"Authorization": {"ApiKey polka-key"}}
+	accessCookies := []*http.Cookie{{Name: accessCookie, Value: token}, {Name: csrfCookie, Value: "csrf"}}
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		method   string
+		header   http.Header
+		cookies  []*http.Cookie
+		wantCode int
+		wantBody string
+	}{
+		{name: "User without credentials", handler: cfg.requireUser(whoami), wantCode: http.StatusUnauthorized},
+		{name: "User with bearer token", handler: cfg.requireUser(whoami), header: bearer(token), wantCode: http.StatusOK, wantBody: userID.String() + " via bearer"},
+		{name: "User with expired token", handler: cfg.requireUser(whoami), header: bearer(expired), wantCode: http.StatusUnauthorized},
+		{name: "User with token of deleted user", handler: cfg.requireUser(whoami), header: bearer(deleted), wantCode: http.StatusUnauthorized},
+		{name: "User with garbage token", handler: cfg.requireUser(whoami), header: bearer("garbage"), wantCode: http.StatusUnauthorized},
+		{name: "User with malformed header", handler: cfg.requireUser(whoami), header: http.Header{"Authorization": {"Basic a b c"}}, wantCode: http.StatusUnauthorized},
+		{name: "User with API key", handler: cfg.requireUser(whoami), header: polka, wantCode: http.StatusForbidden},
+		{name: "User with cookie", handler: cfg.requireUser(whoami), cookies: accessCookies, wantCode: http.StatusOK, wantBody: userID.String() + " via cookie"},
+		{name: "User with cookie, POST without CSRF token", handler: cfg.requireUser(whoami), method: http.MethodPost, cookies: accessCookies, wantCode: http.StatusForbidden},
+		{name: "User with cookie, POST with CSRF token", handler: cfg.requireUser(whoami), method: http.MethodPost, header: http.Header{csrfHeader: {"csrf"}}, cookies: accessCookies, wantCode: http.StatusOK},
+		{name: "Header wins over cookie", handler: cfg.requireUser(whoami), method: http.MethodPost, header: bearer("garbage"), cookies: accessCookies, wantCode: http.StatusUnauthorized},
+		{name: "Scope with API key", handler: cfg.requireScope(scopeWebhooks, whoami), header: polka, wantCode: http.StatusOK, wantBody: "polka via api_key"},
+		{name: "Scope with wrong API key", handler: cfg.requireScope(scopeWebhooks, whoami), header: http.Header{"Authorization": {"ApiKey nope"}}, wantCode: http.StatusUnauthorized},
+		{name: "Scope with user", handler: cfg.requireScope(scopeWebhooks, whoami), header: bearer(token), wantCode: http.StatusForbidden},
+		{name: "Admin with user", handler: cfg.requireAdmin(whoami), header: bearer(token), wantCode: http.StatusForbidden},
+		{name: "Admin with moderator", handler: cfg.requireAdmin(whoami), header: bearer(moderator), wantCode: http.StatusForbidden},
+		{name: "Admin with admin", handler: cfg.requireAdmin(whoami), header: bearer(admin), wantCode: http.StatusOK},
+		{name: "Metrics with moderator", handler: cfg.requireScope(scopeMetrics, whoami), header: bearer(moderator), wantCode: http.StatusOK},
+		{name: "Metrics with user", handler: cfg.requireScope(scopeMetrics, whoami), header: bearer(token), wantCode: http.StatusForbidden},
+		{name: "Moderation with moderator", handler: cfg.requireScope(scopeModerate, whoami), header: bearer(moderator), wantCode: http.StatusOK},
+		{name: "Moderation with user", handler: cfg.requireScope(scopeModerate, whoami), header: bearer(token), wantCode: http.StatusForbidden},
+		{name: "Nested", handler: cfg.requireUser(cfg.optionalUser(whoami)), header: bearer(token), wantCode: http.StatusOK, wantBody: userID.String() + " via bearer"},
+		{name: "Optional without credentials", handler: cfg.optionalUser(whoami), wantCode: http.StatusOK, wantBody: "anonymous"},
+		{name: "Optional with expired token", handler: cfg.optionalUser(whoami), header: bearer(expired), wantCode: http.StatusOK, wantBody: "anonymous"},
+		{name: "Optional with token", handler: cfg.optionalUser(whoami), header: bearer(token), wantCode: http.StatusOK, wantBody: userID.String() + " via bearer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/", nil)
+			for k, vs := range tt.header {
+				for _, v := range vs {
+					req.Header.Add(k, v)
+				}
+			}
+			for _, c := range tt.cookies {
+				req.AddCookie(c)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestWithQueryToken(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	_, token := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	handler := withQueryToken(cfg.requireUser(whoami))
+
+	tests := []struct {
+		name     string
+		target   string
+		header   http.Header
+		wantCode int
+	}{
+		{"Query token", "/?access_token=" + token, nil, http.StatusOK},
+		{"Bad query token", "/?access_token=garbage", nil, http.StatusUnauthorized},
+		{"Header wins", "/?access_token=" + token, http.Header{"Authorization": {"Bearer garbage"}}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/ratelimit"
+)
+
+// rateLimited puts next behind the rate limit policy for the route
+// registered under pattern, if it has one. Every response of a limited
+// route carries RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers; a client out of tokens gets 429 and a Retry-After header
+// instead of reaching next.
+func (cfg *apiConfig) rateLimited(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	policy, ok := cfg.rateLimits[pattern]
+	if !ok || cfg.limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Requests without the credential the policy is keyed by are
+		// told apart by IP. Failed authentication is left to next.
+		key, red := "ip:"+cfg.clientIP(r), false
+		if policy.Key != "ip" {
+			authed, p, err := cfg.withPrincipal(r)
+			if err == nil {
+				r = authed
+			} else if policy.Key == "user" {
+				// Web UI forms carry their CSRF token in the form, which
+				// withPrincipal doesn't read, so look up the session.
+				r, p, _ = cfg.withSession(w, r)
+			}
+			switch {
+			case policy.Key == "user" && p.IsUser():
+				key, red = "user:"+p.UserID.String(), p.ChirpyRed
+			case policy.Key == "api_key" && p.Service != "":
+				key = "api_key:" + p.Service
+			}
+		}
+
+		requests, burst := policy.Quota(red)
+		res, err := cfg.limiter.Take(r.Context(), pattern+" "+key, ratelimit.Limit{
+			Rate:  float64(requests) / policy.Per.Seconds(),
+			Burst: burst,
+		})
+		if err != nil {
+			// Turning everyone away while the store is unreachable would
+			// be worse than letting everyone through.
+			slog.WarnContext(r.Context(), "rate limit store unavailable", "route", pattern, "error", err)
+			next(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+		if !res.Allowed {
+			h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+			respondWithError(w, r, http.StatusTooManyRequests, "Too many requests", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ceilSeconds formats d as whole seconds, rounded up so a client waiting
+// that long is never early.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
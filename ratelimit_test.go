@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/config"
+	"github.com/TheMaru/go-http-server/internal/ratelimit"
+	"github.com/TheMaru/go-http-server/internal/store"
+)
+
+// TestRateLimited runs requests one after another against limited routes.
+// The buckets refill over an hour, so nothing comes back during the test.
+func TestRateLimited(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	cfg.limiter = ratelimit.NewMemory()
+	cfg.rateLimits = map[string]config.RateLimitPolicy{
+		"POST /login":    {Key: "ip", Requests: 2, Per: time.Hour},
+		"POST /chirps":   {Key: "user", Requests: 1, Per: time.Hour, RedRequests: 2},
+		"POST /webhooks": {Key: "api_key", Requests: 1, Per: time.Hour},
+	}
+	_, token := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	red, redToken := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	if err := cfg.service.SetChirpyRed(t.Context(), red.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	routes := map[string]http.HandlerFunc{
+		"POST /login":    cfg.rateLimited("POST /login", whoami),
+		"POST /chirps":   cfg.rateLimited("POST /chirps", cfg.requireUser(whoami)),
+		"POST /webhooks": cfg.rateLimited("POST /webhooks", cfg.requireScope(scopeWebhooks, whoami)),
+		"GET /chirps":    cfg.rateLimited("GET /chirps", whoami),
+	}
+	bearer := func(token string) string { return "Bearer " + token }
+
+	steps := []struct {
+		name          string
+		route         string
+		ip            string
+		auth          string
+		wantCode      int
+		wantLimit     string
+		wantRemaining string
+		wantRetry     string
+	}{
+		{"First login", "POST /login", "192.0.2.1", "", http.StatusOK, "2", "1", ""},
+		{"Second login", "POST /login", "192.0.2.1", "", http.StatusOK, "2", "0", ""},
+		{"Third login", "POST /login", "192.0.2.1", "", http.StatusTooManyRequests, "2", "0", "1800"},
+		{"Login from elsewhere", "POST /login", "192.0.2.2", "", http.StatusOK, "2", "1", ""},
+		{"Chirp", "POST /chirps", "192.0.2.1", bearer(token), http.StatusOK, "1", "0", ""},
+		{"Chirp from elsewhere", "POST /chirps", "192.0.2.2", bearer(token), http.StatusTooManyRequests, "1", "0", "3600"},
+		{"Chirpy Red chirp", "POST /chirps", "192.0.2.1", bearer(redToken), http.StatusOK, "2", "1", ""},
+		{"Second Chirpy Red chirp", "POST /chirps", "192.0.2.1", bearer(redToken), http.StatusOK, "2", "0", ""},
+		{"Third Chirpy Red chirp", "POST /chirps", "192.0.2.1", bearer(redToken), http.StatusTooManyRequests, "2", "0", "1800"},
+		{"Anonymous chirp", "POST /chirps", "192.0.2.3", "", http.StatusUnauthorized, "1", "0", ""},
+		{"Anonymous chirp again", "POST /chirps", "192.0.2.3", "", http.StatusTooManyRequests, "1", "0", "3600"},
+		{"Bad token is told apart by IP", "POST /chirps", "192.0.2.4", "Bearer garbage", http.StatusUnauthorized, "1", "0", ""},
+		{"Webhook", "POST /webhooks", "192.0.2.1", "ApiKey polka-key", http.StatusOK, "1", "0", ""},
+		{"Webhook from elsewhere", "POST /webhooks", "192.0.2.2", "ApiKey polka-key", http.StatusTooManyRequests, "1", "0", "3600"},
+		{"Route without policy", "GET /chirps", "192.0.2.1", "", http.StatusOK, "", "", ""},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			method, path, _ := strings.Cut(step.route, " ")
+			req := httptest.NewRequest(method, path, nil)
+			req.RemoteAddr = step.ip + ":1234"
+			if step.auth != "" {
+				req.Header.Set("Authorization", step.auth)
+			}
+			rec := httptest.NewRecorder()
+			routes[step.route](rec, req)
+
+			if rec.Code != step.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, step.wantCode, rec.Body)
+			}
+			h := rec.Header()
+			if got := h.Get("RateLimit-Limit"); got != step.wantLimit {
+				t.Errorf("RateLimit-Limit = %q, want %q", got, step.wantLimit)
+			}
+			if got := h.Get("RateLimit-Remaining"); got != step.wantRemaining {
+				t.Errorf("RateLimit-Remaining = %q, want %q", got, step.wantRemaining)
+			}
+			if got := h.Get("Retry-After"); got != step.wantRetry {
+				t.Errorf("Retry-After = %q, want %q", got, step.wantRetry)
+			}
+			if step.wantLimit != "" && h.Get("RateLimit-Reset") == "" {
+				t.Error("RateLimit-Reset missing")
+			}
+		})
+	}
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Take(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store unreachable")
+}
+
+func (failingLimiter) Reset(context.Context) error {
+	return errors.New("store unreachable")
+}
+
+func TestRateLimitedStoreError(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	cfg.limiter = failingLimiter{}
+	cfg.rateLimits = map[string]config.RateLimitPolicy{"POST /login": {Key: "ip", Requests: 1, Per: time.Hour}}
+	handler := cfg.rateLimited("POST /login", whoami)
+
+	for range 3 {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/login", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want requests let through while the store is away", rec.Code)
+		}
+	}
+}
+
+func TestTestResetRefillsBuckets(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	cfg.limiter = ratelimit.NewMemory()
+	cfg.rateLimits = map[string]config.RateLimitPolicy{"POST /login": {Key: "ip", Requests: 1, Per: time.Hour}}
+	handler := cfg.rateLimited("POST /login", whoami)
+	login := func() int {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/login", nil))
+		return rec.Code
+	}
+
+	login()
+	if code := login(); code != http.StatusTooManyRequests {
+		t.Fatalf("second login status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	rec := httptest.NewRecorder()
+	cfg.testResetHandler(rec, httptest.NewRequest(http.MethodPost, "/test/reset", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reset status = %d (body %s)", rec.Code, rec.Body)
+	}
+	if code := login(); code != http.StatusOK {
+		t.Errorf("login after reset status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+// TestRateLimitedWebUI posts chirps through the web UI form, whose CSRF
+// token travels in the form rather than a header, and checks the
+// browsers are told apart by their session although they share an IP.
+func TestRateLimitedWebUI(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	cfg.limiter = ratelimit.NewMemory()
+	cfg.rateLimits = map[string]config.RateLimitPolicy{
+		"POST /app/chirps": {Key: "user", Requests: 1, Per: time.Hour, RedRequests: 2},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /app/{$}", cfg.uiTimelineHandler)
+	mux.HandleFunc("POST /app/chirps", cfg.rateLimited("POST /app/chirps", cfg.uiPostChirpHandler))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	login := func(red bool, accessTTL time.Duration) *browser {
+		t.Helper()
+		user, token := newTestUser(t, cfg, store.RoleUser, accessTTL)
+		if err := cfg.service.SetChirpyRed(t.Context(), user.ID, red); err != nil {
+			t.Fatal(err)
+		}
+		session, err := cfg.service.Login(t.Context(), user.Email, "pw")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := newBrowser(t, srv)
+		b.get("/app/")
+		b.setCookie(accessCookie, token)
+		b.setCookie(refreshCookie, session.RefreshToken)
+		return b
+	}
+	post := func(b *browser, wantCode int, wantLimit string) *http.Response {
+		t.Helper()
+		resp, body := b.post("/app/chirps", url.Values{"body": {"hello"}})
+		if resp.StatusCode != wantCode {
+			t.Fatalf("status = %d, want %d (body %s)", resp.StatusCode, wantCode, body)
+		}
+		if got := resp.Header.Get("RateLimit-Limit"); got != wantLimit {
+			t.Errorf("RateLimit-Limit = %q, want %q", got, wantLimit)
+		}
+		return resp
+	}
+
+	plain := login(false, time.Hour)
+	post(plain, http.StatusSeeOther, "1")
+	post(plain, http.StatusTooManyRequests, "1")
+
+	// An expired access token is renewed once, by the limiter, and the
+	// form handler picks up the session it found.
+	red := login(true, -time.Minute)
+	resp := post(red, http.StatusSeeOther, "2")
+	renewed := 0
+	for _, c := range resp.Cookies() {
+		if c.Name == accessCookie {
+			renewed++
+		}
+	}
+	if renewed != 1 {
+		t.Errorf("access cookie set %d times, want once", renewed)
+	}
+	post(red, http.StatusSeeOther, "2")
+	post(red, http.StatusTooManyRequests, "2")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+// Browser sessions keep the tokens of a login in HttpOnly cookies, out of
+// reach of scripts. Requests authenticated by cookie that change anything
+// also have to send the CSRF token: the web UI in a form field, API
+// clients in the X-CSRF-Token header. It is a random value kept in a
+// cookie of its own that scripts of the site may read but other sites
+// can't, so a forged cross-site request can't supply it.
+const (
+	accessCookie  = "chirpy_access"
+	refreshCookie = "chirpy_refresh"
+	csrfCookie    = "chirpy_csrf"
+	// csrfField is the form field carrying the CSRF token.
+	csrfField = "csrf_token"
+	// csrfHeader is the header carrying the CSRF token on API requests.
+	csrfHeader = "X-CSRF-Token"
+)
+
+func (cfg *apiConfig) setCookie(w http.ResponseWriter, name, value string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: name != csrfCookie,
+		Secure:   cfg.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (cfg *apiConfig) clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cfg.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// startSession stores the tokens of a fresh login in cookies. The access
+// cookie goes away with the browser; the JWT inside expires sooner anyway
+// and is renewed from the refresh cookie.
+func (cfg *apiConfig) startSession(w http.ResponseWriter, session service.Session) {
+	cfg.setCookie(w, accessCookie, session.AccessToken, 0)
+	cfg.setCookie(w, refreshCookie, session.RefreshToken, cfg.refreshTokenTTL)
+}
+
+// endSession revokes the refresh token of the session, if any, and
+// clears its cookies.
+func (cfg *apiConfig) endSession(w http.ResponseWriter, r *http.Request) error {
+	cfg.clearCookie(w, accessCookie)
+	cfg.clearCookie(w, refreshCookie)
+	c, err := r.Cookie(refreshCookie)
+	if err != nil {
+		return nil
+	}
+	return cfg.revoke(r, "logout", c.Value)
+}
+
+type sessionKey struct{}
+
+// sessionUser returns the user logged in through cookies. An expired
+// access token is renewed from the refresh token on the way. A session an
+// outer middleware already looked up with withSession is reused.
+func (cfg *apiConfig) sessionUser(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	if p, ok := r.Context().Value(sessionKey{}).(Principal); ok {
+		return p.UserID, true
+	}
+	p, ok := cfg.sessionPrincipal(w, r)
+	return p.UserID, ok
+}
+
+// withSession looks up the session of r and returns r with it attached
+// for sessionUser. Unlike withPrincipal it doesn't check for a CSRF token:
+// the web UI checks the form's own, and the principal only ever reaches
+// sessionUser.
+func (cfg *apiConfig) withSession(w http.ResponseWriter, r *http.Request) (*http.Request, Principal, bool) {
+	p, ok := cfg.sessionPrincipal(w, r)
+	if !ok {
+		return r, Principal{}, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), sessionKey{}, p)), p, true
+}
+
+// sessionPrincipal is the principal behind sessionUser.
+func (cfg *apiConfig) sessionPrincipal(w http.ResponseWriter, r *http.Request) (Principal, bool) {
+	if c, err := r.Cookie(accessCookie); err == nil {
+		p, err := cfg.tokenPrincipal(r.Context(), c.Value, "cookie")
+		if err == nil {
+			setRequestUser(r, p.UserID)
+			return p, true
+		}
+		if errors.Is(err, errSuspended) || errors.Is(err, errPasswordReset) {
+			cfg.clearCookie(w, accessCookie)
+			cfg.clearCookie(w, refreshCookie)
+			return Principal{}, false
+		}
+	}
+	c, err := r.Cookie(refreshCookie)
+	if err != nil {
+		return Principal{}, false
+	}
+	accessToken, _, err := cfg.service.Refresh(r.Context(), c.Value)
+	switch {
+	case errors.Is(err, service.ErrTokenRevoked), errors.Is(err, service.ErrTokenExpired),
+		errors.Is(err, service.ErrSuspended), errors.Is(err, store.ErrNotFound):
+		// The session is over.
+		cfg.clearCookie(w, accessCookie)
+		cfg.clearCookie(w, refreshCookie)
+		return Principal{}, false
+	case err != nil:
+		// Keep the cookies, the next request may get through.
+		slog.WarnContext(r.Context(), "refreshing session", "error", err)
+		return Principal{}, false
+	}
+	p, err := cfg.tokenPrincipal(r.Context(), accessToken, "cookie")
+	if errors.Is(err, errPasswordReset) {
+		cfg.clearCookie(w, accessCookie)
+		cfg.clearCookie(w, refreshCookie)
+		return Principal{}, false
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "refreshing session", "error", err)
+		return Principal{}, false
+	}
+	cfg.setCookie(w, accessCookie, accessToken, 0)
+	setRequestUser(r, p.UserID)
+	return p, true
+}
+
+// csrfToken returns the CSRF token of the browser, handing out a new one
+// if it has none yet.
+func (cfg *apiConfig) csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	cfg.setCookie(w, csrfCookie, token, 0)
+	return token
+}
+
+// validCSRF reports whether token matches the CSRF cookie of the request.
+func validCSRF(r *http.Request, token string) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(token)) == 1
+}
+
+type cookieAuthKey struct{}
+
+// withCookieAuth lets browsers send next the refresh token in the named
+// cookie instead of an Authorization header. Access tokens are looked up
+// by the auth middleware in principal.go instead. The token is passed on
+// as if it had come in the header, so next doesn't care where it came
+// from. A request that brings its own Authorization header is left alone:
+// browsers never add one by themselves, so it needs no CSRF check.
+func (cfg *apiConfig) withCookieAuth(cookie string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(cookie)
+		if r.Header.Get("Authorization") != "" || err != nil || c.Value == "" {
+			next(w, r)
+			return
+		}
+		if !isSafeMethod(r.Method) && !validCSRF(r, r.Header.Get(csrfHeader)) {
+			respondWithError(w, r, http.StatusForbidden, "Missing or invalid CSRF token", nil)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), cookieAuthKey{}, true))
+		r.Header = r.Header.Clone()
+		r.Header.Set("Authorization", "Bearer "+c.Value)
+		next(w, r)
+	}
+}
+
+// cookieAuthenticated reports whether withCookieAuth took the request's
+// token from a cookie.
+func cookieAuthenticated(r *http.Request) bool {
+	ok, _ := r.Context().Value(cookieAuthKey{}).(bool)
+	return ok
+}
+
+// isSafeMethod reports whether method only reads.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
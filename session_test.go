@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+)
+
+func newCookieAPIServer(t *testing.T, cfg *apiConfig) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", cfg.loginHandler)
+	mux.HandleFunc("POST /api/chirps", cfg.requireUser(cfg.createChirpHandler))
+	mux.HandleFunc("POST /api/refresh", cfg.withCookieAuth(refreshCookie, cfg.refreshHandler))
+	mux.HandleFunc("POST /api/logout", cfg.withCookieAuth(refreshCookie, cfg.logoutHandler))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// do sends a JSON request through b with the given extra headers.
+func (b *browser) do(method, path, body string, header http.Header) (*http.Response, string) {
+	b.t.Helper()
+	req, err := http.NewRequest(method, b.srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func TestCookieAuth(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newCookieAPIServer(t, cfg)
+	if _, err := cfg.service.CreateUser(t.Context(), "cookie@example.com", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	b := newBrowser(t, srv)
+
+	resp, body := b.do(http.MethodPost, "/api/login", `{"email":"cookie@example.com","password":"pw","cookies":true}`, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login status = %d, want 200", resp.StatusCode)
+	}
+	var login User
+	if err := json.Unmarshal([]byte(body), &login); err != nil {
+		t.Fatal(err)
+	}
+	if login.Token != "" || login.RefreshToken != "" {
+		t.Error("cookie login returned the tokens in the body")
+	}
+	if login.CSRFToken == "" || login.CSRFToken != b.cookie(csrfCookie) {
+		t.Errorf("csrf_token = %q, want the CSRF cookie %q", login.CSRFToken, b.cookie(csrfCookie))
+	}
+	for _, c := range resp.Cookies() {
+		if wantHTTPOnly := c.Name != csrfCookie; c.HttpOnly != wantHTTPOnly {
+			t.Errorf("cookie %s HttpOnly = %v, want %v", c.Name, c.HttpOnly, wantHTTPOnly)
+		}
+		if c.SameSite != http.SameSiteLaxMode {
+			t.Errorf("cookie %s SameSite = %v, want Lax", c.Name, c.SameSite)
+		}
+	}
+	refreshToken := b.cookie(refreshCookie)
+
+	csrf := http.Header{csrfHeader: {login.CSRFToken}}
+	tests := []struct {
+		name     string
+		header   http.Header
+		wantCode int
+	}{
+		{"Without CSRF token", nil, http.StatusForbidden},
+		{"Wrong CSRF token", http.Header{csrfHeader: {"forged"}}, http.StatusForbidden},
+		{"With CSRF token", csrf, http.StatusCreated},
+		{"Bearer header takes precedence", http.Header{"Authorization": {"Bearer nope"}}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := b.do(http.MethodPost, "/api/chirps", `{"body":"from a cookie"}`, tt.header)
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+		})
+	}
+
+	b.setCookie(accessCookie, "")
+	resp, _ = b.do(http.MethodPost, "/api/refresh", "", csrf)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("refresh status = %d, want 204", resp.StatusCode)
+	}
+	if b.cookie(accessCookie) == "" {
+		t.Error("refresh didn't renew the access cookie")
+	}
+
+	resp, _ = b.do(http.MethodPost, "/api/logout", "", nil)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("logout without CSRF token status = %d, want 403", resp.StatusCode)
+	}
+	resp, _ = b.do(http.MethodPost, "/api/logout", "", csrf)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("logout status = %d, want 204", resp.StatusCode)
+	}
+	if b.cookie(accessCookie) != "" || b.cookie(refreshCookie) != "" {
+		t.Error("logout left session cookies behind")
+	}
+	if _, _, err := cfg.service.Refresh(t.Context(), refreshToken); err == nil {
+		t.Error("refresh token still valid after logout")
+	}
+	resp, _ = b.do(http.MethodPost, "/api/chirps", `{"body":"after logout"}`, csrf)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("chirp after logout status = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestLogoutWithBearerToken(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newCookieAPIServer(t, cfg)
+	cfg.service.CreateUser(t.Context(), "bearer@example.com", "pw")
+	session, err := cfg.service.Login(t.Context(), "bearer@example.com", "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newBrowser(t, srv)
+
+	for range 2 {
+		resp, _ := b.do(http.MethodPost, "/api/logout", "", http.Header{"Authorization": {"Bearer " + session.RefreshToken}})
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("logout status = %d, want 204 every time", resp.StatusCode)
+		}
+	}
+	if _, _, err := cfg.service.Refresh(t.Context(), session.RefreshToken); err == nil {
+		t.Error("refresh token still valid after logout")
+	}
+	resp, _ := b.do(http.MethodPost, "/api/logout", "", nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("logout without any token status = %d, want 401", resp.StatusCode)
+	}
+}
@@ -0,0 +1,31 @@
+-- name: AppendAuditEvent :exec
+INSERT INTO audit_events (
+  seq, created_at, action, outcome, actor_id, actor_service, target_user_id, ip, details, prev_hash, hash
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);
+
+-- name: LastAuditEvent :one
+SELECT * FROM audit_events
+ORDER BY seq DESC
+LIMIT 1;
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE (action = sqlc.arg(action)::text OR sqlc.arg(action)::text = '')
+  AND (outcome = sqlc.arg(outcome)::text OR sqlc.arg(outcome)::text = '')
+  AND (actor_id = sqlc.narg(actor_id)::uuid OR sqlc.narg(actor_id)::uuid IS NULL)
+  AND (target_user_id = sqlc.narg(target_user_id)::uuid OR sqlc.narg(target_user_id)::uuid IS NULL)
+  AND (created_at >= sqlc.narg(since)::timestamp OR sqlc.narg(since)::timestamp IS NULL)
+  AND (created_at < sqlc.narg(until)::timestamp OR sqlc.narg(until)::timestamp IS NULL)
+  AND (seq < sqlc.arg(before_seq)::bigint OR sqlc.arg(before_seq)::bigint = 0)
+ORDER BY seq DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: AuditEventsAfter :many
+SELECT * FROM audit_events
+WHERE seq > sqlc.arg(after_seq)
+ORDER BY seq
+LIMIT sqlc.arg(max_rows);
+
+-- name: LockAuditEvents :exec
+LOCK TABLE audit_events IN EXCLUSIVE MODE;
@@ -1,17 +1,28 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, parent_id)
 VALUES (
   gen_random_uuid(),
   NOW(),
   NOW(),
   $1,
-  $2
+  $2,
+  $3
 )
 RETURNING *;
 
 -- name: GetChirpsAsc :many
 SELECT * FROM chirps
-ORDER BY created_at;
+WHERE hidden_at IS NULL
+ORDER BY created_at, id;
+
+-- name: GetChirpPage :many
+SELECT * FROM chirps
+WHERE (sqlc.narg(author_id)::uuid IS NULL OR user_id = sqlc.narg(author_id)::uuid)
+  AND (hidden_at IS NULL OR user_id = sqlc.narg(viewer_id)::uuid)
+  AND (sqlc.narg(before_created_at)::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg(before_created_at)::timestamp, sqlc.narg(before_id)::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_rows);
 
 -- name: GetChirpByID :one
 SELECT * FROM chirps
@@ -19,8 +30,36 @@ WHERE id = $1;
 
 -- name: GetChirpsByAuthor :many
 SELECT * FROM chirps
-WHERE user_id = $1
-ORDER BY created_at;
+WHERE user_id = $1 AND hidden_at IS NULL
+ORDER BY created_at, id;
+
+-- name: GetChirpReplies :many
+SELECT * FROM chirps
+WHERE parent_id = $1
+ORDER BY created_at, id;
 
--- name: DeleteChirp :exec
+-- name: GetHiddenChirpsByAuthor :many
+SELECT * FROM chirps
+WHERE user_id = $1 AND hidden_at IS NOT NULL
+ORDER BY created_at, id;
+
+-- name: DeleteChirp :execrows
 DELETE FROM chirps WHERE id = $1;
+
+-- name: CountChirpsByAuthor :one
+SELECT COUNT(*) FROM chirps WHERE user_id = $1;
+
+-- name: SetChirpHidden :execrows
+UPDATE chirps SET hidden_at = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: DeleteAllChirps :execrows
+DELETE FROM chirps WHERE 1=1;
+
+-- name: ListAllChirps :many
+SELECT * FROM chirps
+ORDER BY created_at, id;
+
+-- name: RestoreChirp :exec
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, hidden_at, parent_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
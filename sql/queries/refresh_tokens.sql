@@ -5,7 +5,7 @@ VALUES (
   NOW(),
   NOW(),
   $2,
-  (NOW() AT TIME ZONE 'UTC') + interval '60 days',
+  $3,
   NULL
 )
 RETURNING *;
@@ -13,7 +13,28 @@ RETURNING *;
 -- name: GetRefreshToken :one
 SELECT * FROM refresh_tokens WHERE token = $1;
 
--- name: RevokeToken :exec
+-- name: RevokeToken :execrows
 UPDATE refresh_tokens
 SET updated_at = NOW(), revoked_at = NOW()
 WHERE token = $1;
+
+-- name: GetRefreshTokensByUser :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at DESC, token;
+
+-- name: RevokeUserTokens :execrows
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: DeleteAllRefreshTokens :execrows
+DELETE FROM refresh_tokens WHERE 1=1;
+
+-- name: ListAllRefreshTokens :many
+SELECT * FROM refresh_tokens
+ORDER BY created_at, token;
+
+-- name: RestoreRefreshToken :exec
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at)
+VALUES ($1, $2, $3, $4, $5, $6);
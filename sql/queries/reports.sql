@@ -0,0 +1,49 @@
+-- name: CreateReport :one
+INSERT INTO reports (id, created_at, updated_at, chirp_id, reporter_id, reason, details)
+VALUES (
+  gen_random_uuid(),
+  NOW(),
+  NOW(),
+  $1,
+  $2,
+  $3,
+  $4
+)
+RETURNING *;
+
+-- name: GetReport :one
+SELECT * FROM reports
+WHERE id = $1;
+
+-- name: ListReports :many
+SELECT * FROM reports
+WHERE status = sqlc.arg(status)::text
+  OR (sqlc.arg(status)::text = '' AND status <> 'resolved')
+ORDER BY created_at, id
+LIMIT sqlc.arg(max_rows);
+
+-- name: ClaimReport :execrows
+UPDATE reports
+SET status = 'claimed', moderator_id = $2, claimed_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: ResolveReport :execrows
+UPDATE reports
+SET status = 'resolved', moderator_id = $2, resolution = $3, resolved_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: ResolveChirpReports :execrows
+UPDATE reports
+SET status = 'resolved', moderator_id = $2, resolution = $3, resolved_at = NOW(), updated_at = NOW()
+WHERE chirp_id = $1 AND status <> 'resolved';
+
+-- name: DeleteAllReports :execrows
+DELETE FROM reports WHERE 1=1;
+
+-- name: ListAllReports :many
+SELECT * FROM reports
+ORDER BY created_at, id;
+
+-- name: RestoreReport :exec
+INSERT INTO reports (id, created_at, updated_at, chirp_id, reporter_id, reason, details, status, moderator_id, claimed_at, resolved_at, resolution)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12);
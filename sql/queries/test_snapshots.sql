@@ -0,0 +1,10 @@
+-- name: SaveTestSnapshot :exec
+INSERT INTO test_snapshots (name, created_at, dump)
+VALUES ($1, NOW(), $2)
+ON CONFLICT (name) DO UPDATE SET created_at = excluded.created_at, dump = excluded.dump;
+
+-- name: GetTestSnapshot :one
+SELECT dump FROM test_snapshots WHERE name = $1;
+
+-- name: DeleteTestSnapshot :execrows
+DELETE FROM test_snapshots WHERE name = $1;
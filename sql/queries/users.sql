@@ -9,17 +9,53 @@ VALUES (
 )
 RETURNING *;
 
--- name: DeleteAllUsers :exec
+-- name: DeleteAllUsers :execrows
 DELETE FROM users WHERE 1=1;
 
 -- name: GetUserByEmail :one
 SELECT * FROM users WHERE email = $1; 
 
+-- name: GetUserByID :one
+SELECT * FROM users WHERE id = $1;
+
 -- name: UpdateUser :one
 UPDATE users SET email = $1, hashed_password = $2, updated_at = NOW()
 WHERE id = $3
 RETURNING *;
 
--- name: GrantChirpyRedToUser :exec
+-- name: GrantChirpyRedToUser :execrows
 UPDATE users SET is_chirpy_red = true, updated_at = NOW()
 WHERE id = $1;
+
+-- name: SetUserRole :execrows
+UPDATE users SET role = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: SearchUsersByEmail :many
+SELECT * FROM users
+WHERE email ILIKE '%' || sqlc.arg(query)::text || '%' ESCAPE '\'
+ORDER BY email
+LIMIT sqlc.arg(max_rows);
+
+-- name: SetUserSuspended :execrows
+UPDATE users SET suspended_at = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: SetPasswordReset :execrows
+UPDATE users SET password_reset_required = $1, password_reset_token_hash = $2, updated_at = NOW()
+WHERE id = $3;
+
+-- name: SetUserChirpyRed :execrows
+UPDATE users SET is_chirpy_red = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = $1;
+
+-- name: ListAllUsers :many
+SELECT * FROM users
+ORDER BY created_at, id;
+
+-- name: RestoreUser :exec
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, suspended_at, password_reset_required, password_reset_token_hash)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
@@ -0,0 +1,41 @@
+-- +goose Up
+CREATE TABLE audit_events (
+  seq BIGINT PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  action TEXT NOT NULL,
+  outcome TEXT NOT NULL,
+  -- No foreign keys: the log outlives the users it mentions.
+  actor_id UUID,
+  actor_service TEXT NOT NULL DEFAULT '',
+  target_user_id UUID,
+  ip TEXT NOT NULL DEFAULT '',
+  details TEXT NOT NULL DEFAULT '{}',
+  -- hash covers every other column, prev_hash included, so changing or
+  -- removing an event breaks the chain from there on.
+  prev_hash TEXT NOT NULL,
+  hash TEXT NOT NULL UNIQUE
+);
+
+CREATE INDEX audit_events_action ON audit_events (action, seq);
+CREATE INDEX audit_events_actor_id ON audit_events (actor_id, seq);
+CREATE INDEX audit_events_target_user_id ON audit_events (target_user_id, seq);
+
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_events_no_update_delete
+BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+BEFORE TRUNCATE ON audit_events
+FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+-- +goose Down
+DROP TABLE audit_events;
+DROP FUNCTION audit_events_append_only();
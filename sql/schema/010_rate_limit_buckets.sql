@@ -0,0 +1,16 @@
+-- +goose Up
+-- Only the postgres rate limit store uses this table, so SQLite has no
+-- counterpart. It is unlogged for cheaper writes: a crash just refills
+-- every bucket.
+CREATE UNLOGGED TABLE rate_limit_buckets (
+  key TEXT PRIMARY KEY,
+  tokens DOUBLE PRECISION NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL,
+  -- full_at is when the bucket will have refilled and can be swept.
+  full_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX rate_limit_buckets_full_at ON rate_limit_buckets (full_at);
+
+-- +goose Down
+DROP TABLE rate_limit_buckets;
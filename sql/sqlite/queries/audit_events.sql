@@ -0,0 +1,40 @@
+-- name: AppendAuditEvent :exec
+INSERT INTO audit_events (
+  seq, created_at, action, outcome, actor_id, actor_service, target_user_id, ip, details, prev_hash, hash
+)
+VALUES (
+  sqlc.arg(seq),
+  sqlc.arg(created_at),
+  sqlc.arg(action),
+  sqlc.arg(outcome),
+  sqlc.arg(actor_id),
+  sqlc.arg(actor_service),
+  sqlc.arg(target_user_id),
+  sqlc.arg(ip),
+  sqlc.arg(details),
+  sqlc.arg(prev_hash),
+  sqlc.arg(hash)
+);
+
+-- name: LastAuditEvent :one
+SELECT * FROM audit_events
+ORDER BY seq DESC
+LIMIT 1;
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE (action = sqlc.arg(action) OR sqlc.arg(action) = '')
+  AND (outcome = sqlc.arg(outcome) OR sqlc.arg(outcome) = '')
+  AND (actor_id = sqlc.narg(actor_id) OR sqlc.narg(actor_id) IS NULL)
+  AND (target_user_id = sqlc.narg(target_user_id) OR sqlc.narg(target_user_id) IS NULL)
+  AND (created_at >= sqlc.narg(since) OR sqlc.narg(since) IS NULL)
+  AND (created_at < sqlc.narg(until) OR sqlc.narg(until) IS NULL)
+  AND (seq < sqlc.arg(before_seq) OR sqlc.arg(before_seq) = 0)
+ORDER BY seq DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: AuditEventsAfter :many
+SELECT * FROM audit_events
+WHERE seq > sqlc.arg(after_seq)
+ORDER BY seq
+LIMIT sqlc.arg(max_rows);
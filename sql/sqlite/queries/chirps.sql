@@ -0,0 +1,66 @@
+-- name: CreateChirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, parent_id)
+VALUES (
+  sqlc.arg(id),
+  sqlc.arg(now),
+  sqlc.arg(now),
+  sqlc.arg(body),
+  sqlc.arg(user_id),
+  sqlc.arg(parent_id)
+)
+RETURNING *;
+
+-- name: GetChirpsAsc :many
+SELECT * FROM chirps
+WHERE hidden_at IS NULL
+ORDER BY created_at, id;
+
+-- name: GetChirpPage :many
+SELECT * FROM chirps
+WHERE (sqlc.narg(author_id) IS NULL OR user_id = sqlc.narg(author_id))
+  AND (hidden_at IS NULL OR user_id = sqlc.narg(viewer_id))
+  AND (sqlc.narg(before_created_at) IS NULL
+    OR created_at < sqlc.narg(before_created_at)
+    OR (created_at = sqlc.narg(before_created_at) AND id < sqlc.narg(before_id)))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: GetChirpByID :one
+SELECT * FROM chirps
+WHERE id = ?;
+
+-- name: GetChirpsByAuthor :many
+SELECT * FROM chirps
+WHERE user_id = ? AND hidden_at IS NULL
+ORDER BY created_at, id;
+
+-- name: GetChirpReplies :many
+SELECT * FROM chirps
+WHERE parent_id = ?
+ORDER BY created_at, id;
+
+-- name: GetHiddenChirpsByAuthor :many
+SELECT * FROM chirps
+WHERE user_id = ? AND hidden_at IS NOT NULL
+ORDER BY created_at, id;
+
+-- name: DeleteChirp :execrows
+DELETE FROM chirps WHERE id = ?;
+
+-- name: CountChirpsByAuthor :one
+SELECT COUNT(*) FROM chirps WHERE user_id = ?;
+
+-- name: SetChirpHidden :execrows
+UPDATE chirps SET hidden_at = sqlc.arg(hidden_at), updated_at = sqlc.arg(now)
+WHERE id = sqlc.arg(id);
+
+-- name: DeleteAllChirps :execrows
+DELETE FROM chirps;
+
+-- name: ListAllChirps :many
+SELECT * FROM chirps
+ORDER BY created_at, id;
+
+-- name: RestoreChirp :exec
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, hidden_at, parent_id)
+VALUES (?, ?, ?, ?, ?, ?, ?);
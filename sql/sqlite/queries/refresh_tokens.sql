@@ -0,0 +1,40 @@
+-- name: CreateRefreshToken :one
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at)
+VALUES (
+  sqlc.arg(token),
+  sqlc.arg(now),
+  sqlc.arg(now),
+  sqlc.arg(user_id),
+  sqlc.arg(expires_at),
+  NULL
+)
+RETURNING *;
+
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens WHERE token = ?;
+
+-- name: RevokeToken :execrows
+UPDATE refresh_tokens
+SET updated_at = sqlc.arg(now), revoked_at = sqlc.arg(now)
+WHERE token = sqlc.arg(token);
+
+-- name: GetRefreshTokensByUser :many
+SELECT * FROM refresh_tokens
+WHERE user_id = ?
+ORDER BY created_at DESC, token;
+
+-- name: RevokeUserTokens :execrows
+UPDATE refresh_tokens
+SET updated_at = sqlc.arg(now), revoked_at = sqlc.arg(now)
+WHERE user_id = sqlc.arg(user_id) AND revoked_at IS NULL;
+
+-- name: DeleteAllRefreshTokens :execrows
+DELETE FROM refresh_tokens;
+
+-- name: ListAllRefreshTokens :many
+SELECT * FROM refresh_tokens
+ORDER BY created_at, token;
+
+-- name: RestoreRefreshToken :exec
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at)
+VALUES (?, ?, ?, ?, ?, ?);
@@ -0,0 +1,51 @@
+-- name: CreateReport :one
+INSERT INTO reports (id, created_at, updated_at, chirp_id, reporter_id, reason, details)
+VALUES (
+  sqlc.arg(id),
+  sqlc.arg(now),
+  sqlc.arg(now),
+  sqlc.arg(chirp_id),
+  sqlc.arg(reporter_id),
+  sqlc.arg(reason),
+  sqlc.arg(details)
+)
+RETURNING *;
+
+-- name: GetReport :one
+SELECT * FROM reports
+WHERE id = ?;
+
+-- name: ListReports :many
+SELECT * FROM reports
+WHERE status = sqlc.arg(status)
+  OR (sqlc.arg(status) = '' AND status <> 'resolved')
+ORDER BY created_at, id
+LIMIT sqlc.arg(max_rows);
+
+-- name: ClaimReport :execrows
+UPDATE reports
+SET updated_at = sqlc.arg(now), status = 'claimed', moderator_id = sqlc.arg(moderator_id), claimed_at = sqlc.arg(now)
+WHERE id = sqlc.arg(id);
+
+-- name: ResolveReport :execrows
+UPDATE reports
+SET updated_at = sqlc.arg(now), status = 'resolved', moderator_id = sqlc.arg(moderator_id),
+  resolution = sqlc.arg(resolution), resolved_at = sqlc.arg(now)
+WHERE id = sqlc.arg(id);
+
+-- name: ResolveChirpReports :execrows
+UPDATE reports
+SET updated_at = sqlc.arg(now), status = 'resolved', moderator_id = sqlc.arg(moderator_id),
+  resolution = sqlc.arg(resolution), resolved_at = sqlc.arg(now)
+WHERE chirp_id = sqlc.arg(chirp_id) AND status <> 'resolved';
+
+-- name: DeleteAllReports :execrows
+DELETE FROM reports;
+
+-- name: ListAllReports :many
+SELECT * FROM reports
+ORDER BY created_at, id;
+
+-- name: RestoreReport :exec
+INSERT INTO reports (id, created_at, updated_at, chirp_id, reporter_id, reason, details, status, moderator_id, claimed_at, resolved_at, resolution)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
@@ -0,0 +1,61 @@
+-- name: CreateUser :one
+INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+VALUES (
+  sqlc.arg(id),
+  sqlc.arg(now),
+  sqlc.arg(now),
+  sqlc.arg(email),
+  sqlc.arg(hashed_password)
+)
+RETURNING *;
+
+-- name: DeleteAllUsers :execrows
+DELETE FROM users;
+
+-- name: GetUserByEmail :one
+SELECT * FROM users WHERE email = ?;
+
+-- name: GetUserByID :one
+SELECT * FROM users WHERE id = ?;
+
+-- name: UpdateUser :one
+UPDATE users SET email = sqlc.arg(email), hashed_password = sqlc.arg(hashed_password), updated_at = sqlc.arg(now)
+WHERE id = sqlc.arg(id)
+RETURNING *;
+
+-- name: GrantChirpyRedToUser :execrows
+UPDATE users SET is_chirpy_red = true, updated_at = sqlc.arg(now)
+WHERE id = sqlc.arg(id);
+
+-- name: SetUserRole :execrows
+UPDATE users SET role = sqlc.arg(role), updated_at = sqlc.arg(now)
+WHERE id = sqlc.arg(id);
+
+-- name: SearchUsersByEmail :many
+SELECT * FROM users
+WHERE email LIKE '%' || sqlc.arg(query) || '%' ESCAPE '\'
+ORDER BY email
+LIMIT sqlc.arg(max_rows);
+
+-- name: SetUserSuspended :execrows
+UPDATE users SET suspended_at = sqlc.arg(suspended_at), updated_at = sqlc.arg(now)
+WHERE id = sqlc.arg(id);
+
+-- name: SetPasswordReset :execrows
+UPDATE users SET password_reset_required = sqlc.arg(password_reset_required), password_reset_token_hash = sqlc.arg(password_reset_token_hash), updated_at = sqlc.arg(now)
+WHERE id = sqlc.arg(id);
+
+-- name: SetUserChirpyRed :execrows
+UPDATE users SET is_chirpy_red = sqlc.arg(is_chirpy_red), updated_at = sqlc.arg(now)
+WHERE id = sqlc.arg(id);
+
+-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = ?;
+
+-- name: ListAllUsers :many
+SELECT * FROM users
+ORDER BY created_at, id;
+
+-- name: RestoreUser :exec
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red, role, suspended_at, password_reset_required, password_reset_token_hash)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE chirps (
+  id TEXT PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+  updated_at DATETIME NOT NULL,
+  body TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  FOREIGN KEY (user_id)
+  REFERENCES users(id)
+  ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE chirps;
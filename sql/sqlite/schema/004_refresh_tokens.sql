@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE refresh_tokens (
+  token TEXT PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+  updated_at DATETIME NOT NULL,
+  user_id TEXT NOT NULL,
+  expires_at DATETIME NOT NULL,
+  revoked_at DATETIME,
+  FOREIGN KEY (user_id)
+  REFERENCES users(id)
+  ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE refresh_tokens;
@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN suspended_at DATETIME;
+ALTER TABLE users
+ADD COLUMN password_reset_required BOOLEAN NOT NULL DEFAULT false;
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN password_reset_required;
+ALTER TABLE users
+DROP COLUMN suspended_at;
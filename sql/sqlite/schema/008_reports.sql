@@ -0,0 +1,38 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN hidden_at DATETIME;
+
+CREATE TABLE reports (
+  id TEXT PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+  updated_at DATETIME NOT NULL,
+  chirp_id TEXT NOT NULL,
+  -- reporter_id is NULL for reports the profanity filter filed.
+  reporter_id TEXT,
+  reason TEXT NOT NULL
+  CHECK (reason IN ('spam', 'harassment', 'hate', 'violence', 'profanity', 'other')),
+  details TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL DEFAULT 'open'
+  CHECK (status IN ('open', 'claimed', 'resolved')),
+  moderator_id TEXT,
+  claimed_at DATETIME,
+  resolved_at DATETIME,
+  resolution TEXT NOT NULL DEFAULT '',
+  UNIQUE (chirp_id, reporter_id),
+  FOREIGN KEY (chirp_id)
+  REFERENCES chirps(id)
+  ON DELETE CASCADE,
+  FOREIGN KEY (reporter_id)
+  REFERENCES users(id)
+  ON DELETE SET NULL,
+  FOREIGN KEY (moderator_id)
+  REFERENCES users(id)
+  ON DELETE SET NULL
+);
+
+CREATE INDEX reports_status_created_at ON reports (status, created_at);
+
+-- +goose Down
+DROP TABLE reports;
+ALTER TABLE chirps
+DROP COLUMN hidden_at;
@@ -0,0 +1,40 @@
+-- +goose Up
+CREATE TABLE audit_events (
+  seq INTEGER PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+  action TEXT NOT NULL,
+  outcome TEXT NOT NULL,
+  -- No foreign keys: the log outlives the users it mentions.
+  actor_id TEXT,
+  actor_service TEXT NOT NULL DEFAULT '',
+  target_user_id TEXT,
+  ip TEXT NOT NULL DEFAULT '',
+  details TEXT NOT NULL DEFAULT '{}',
+  -- hash covers every other column, prev_hash included, so changing or
+  -- removing an event breaks the chain from there on.
+  prev_hash TEXT NOT NULL,
+  hash TEXT NOT NULL UNIQUE
+);
+
+CREATE INDEX audit_events_action ON audit_events (action, seq);
+CREATE INDEX audit_events_actor_id ON audit_events (actor_id, seq);
+CREATE INDEX audit_events_target_user_id ON audit_events (target_user_id, seq);
+
+-- +goose StatementBegin
+CREATE TRIGGER audit_events_no_update
+BEFORE UPDATE ON audit_events
+BEGIN
+  SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER audit_events_no_delete
+BEFORE DELETE ON audit_events
+BEGIN
+  SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE audit_events;
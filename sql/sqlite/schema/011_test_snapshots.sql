@@ -0,0 +1,13 @@
+-- +goose Up
+-- Snapshots taken through the test support routes. They are kept here
+-- rather than in the server's memory, so they survive restarts and every
+-- instance sharing the database sees them.
+CREATE TABLE test_snapshots (
+  name TEXT PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+  -- dump is the JSON encoded store.Dump.
+  dump TEXT NOT NULL
+);
+
+-- +goose Down
+DROP TABLE test_snapshots;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- parent_id is the chirp a reply answers, NULL for chirps that start a
+-- thread. Replies outlive a deleted parent and start a thread of their own.
+ALTER TABLE chirps
+ADD COLUMN parent_id TEXT REFERENCES chirps(id) ON DELETE SET NULL;
+
+CREATE INDEX chirps_parent_id_created_at ON chirps (parent_id, created_at);
+
+-- +goose Down
+DROP INDEX chirps_parent_id_created_at;
+ALTER TABLE chirps
+DROP COLUMN parent_id;
@@ -0,0 +1,9 @@
+// Package schema embeds the SQLite flavour of the goose migrations. Keep
+// the versions in step with sql/schema so both backends report the same
+// schema version.
+package schema
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/store"
+)
+
+// registerTestSupport registers the test support routes with handle. They
+// wipe data without asking who is calling, so main only serves them in
+// test mode, on a loopback listener of their own.
+func (cfg *apiConfig) registerTestSupport(handle func(pattern string, handler http.HandlerFunc)) {
+	handle("POST /test/reset", cfg.audited("test_reset", cfg.testResetHandler))
+	handle("POST /test/fixtures", cfg.audited("test_seed_fixtures", cfg.testFixturesHandler))
+	handle("PUT /test/snapshots/{name}", cfg.audited("test_take_snapshot", cfg.testTakeSnapshotHandler))
+	handle("POST /test/snapshots/{name}/restore", cfg.audited("test_restore_snapshot", cfg.testRestoreSnapshotHandler))
+	handle("DELETE /test/snapshots/{name}", cfg.audited("test_delete_snapshot", cfg.testDeleteSnapshotHandler))
+}
+
+type tableChangeResp struct {
+	Deleted  int `json:"deleted"`
+	Inserted int `json:"inserted"`
+}
+
+type testSummaryResp struct {
+	Tables map[string]tableChangeResp `json:"tables"`
+}
+
+// respondWithSummary reports what a test support call changed and tells
+// caches and streams to start over.
+func (cfg *apiConfig) respondWithSummary(w http.ResponseWriter, r *http.Request, summary service.TestSummary) {
+	cfg.publish(r, pubsub.Event{Type: pubsub.Resync})
+	res := testSummaryResp{Tables: map[string]tableChangeResp{}}
+	for table, change := range summary {
+		res.Tables[table] = tableChangeResp(change)
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+// testResetHandler empties the tables named in the body along with the
+// tables referencing them. Without a body it empties every table and also
+// resets the visit counter and refills every rate limit bucket.
+func (cfg *apiConfig) testResetHandler(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Tables []string `json:"tables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, r, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	summary, err := cfg.service.ResetTables(r.Context(), params.Tables)
+	if errors.Is(err, service.ErrUnknownTable) {
+		respondWithError(w, r, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't reset tables", err)
+		return
+	}
+	if len(params.Tables) == 0 {
+		if err := cfg.metrics.resetAppHits(); err != nil {
+			respondWithError(w, r, http.StatusInternalServerError, "Couldn't reset visits", err)
+			return
+		}
+		if cfg.limiter != nil {
+			if err := cfg.limiter.Reset(r.Context()); err != nil {
+				respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't reset rate limits", err)
+				return
+			}
+		}
+	}
+	cfg.respondWithSummary(w, r, summary)
+}
+
+// testFixturesHandler replaces every table's rows with the YAML fixtures in
+// the body.
+func (cfg *apiConfig) testFixturesHandler(w http.ResponseWriter, r *http.Request) {
+	fixtures, err := service.ParseFixtures(r.Body)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	summary, err := cfg.service.SeedFixtures(r.Context(), fixtures)
+	if errors.Is(err, service.ErrInvalidFixtures) {
+		respondWithError(w, r, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't seed fixtures", err)
+		return
+	}
+	cfg.respondWithSummary(w, r, summary)
+}
+
+// testTakeSnapshotHandler saves the rows of every table under the name,
+// replacing an earlier snapshot of that name.
+func (cfg *apiConfig) testTakeSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	d, err := cfg.service.TakeSnapshot(r.Context(), name)
+	if err != nil {
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't take snapshot", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct {
+		Name string         `json:"name"`
+		Rows map[string]int `json:"rows"`
+	}{
+		Name: name,
+		Rows: map[string]int{
+			store.TableUsers:         len(d.Users),
+			store.TableChirps:        len(d.Chirps),
+			store.TableRefreshTokens: len(d.RefreshTokens),
+			store.TableReports:       len(d.Reports),
+		},
+	})
+}
+
+// testRestoreSnapshotHandler puts the rows of a snapshot back in place of
+// the current ones. The snapshot is kept, so it can be restored again.
+func (cfg *apiConfig) testRestoreSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	summary, err := cfg.service.RestoreSnapshot(r.Context(), r.PathValue("name"))
+	if errors.Is(err, store.ErrNotFound) {
+		respondWithError(w, r, http.StatusNotFound, "Snapshot not found", err)
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't restore snapshot", err)
+		return
+	}
+	cfg.respondWithSummary(w, r, summary)
+}
+
+func (cfg *apiConfig) testDeleteSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	err := cfg.service.DeleteSnapshot(r.Context(), r.PathValue("name"))
+	if errors.Is(err, store.ErrNotFound) {
+		respondWithError(w, r, http.StatusNotFound, "Snapshot not found", err)
+		return
+	}
+	if err != nil {
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't delete snapshot", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TheMaru/go-http-server/internal/store"
+)
+
+// failingResetStore reports an error when asked to clear a table.
+type failingResetStore struct {
+	*store.Memory
+}
+
+func (f failingResetStore) InTx(ctx context.Context, fn func(store.Store) error) error {
+	return fn(f)
+}
+
+func (failingResetStore) ClearTable(context.Context, string) (int, error) {
+	return 0, errors.New("disk on fire")
+}
+
+func TestTestResetHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		store    store.Store
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Everything",
+			store:    store.NewMemory(),
+			wantCode: http.StatusOK,
+			wantBody: `"users":{"deleted":1,"inserted":0}`,
+		},
+		{
+			name:     "Some tables",
+			store:    store.NewMemory(),
+			body:     `{"tables":["refresh_tokens"]}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"tables":{"refresh_tokens":{"deleted":0,"inserted":0}}}`,
+		},
+		{
+			name:     "Unknown table",
+			store:    store.NewMemory(),
+			body:     `{"tables":["audit_events"]}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Bad body",
+			store:    store.NewMemory(),
+			body:     `{"tables":`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Store error is reported",
+			store:    failingResetStore{Memory: store.NewMemory()},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPIConfig(tt.store)
+			if _, err := cfg.service.CreateUser(t.Context(), "saul@example.com", "pw"); err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			cfg.testResetHandler(rec, httptest.NewRequest(http.MethodPost, "/test/reset", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status code = %d, want %d (body %s)", rec.Code, tt.wantCode, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestTestSupportRoutes(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	mux := http.NewServeMux()
+	cfg.registerTestSupport(func(pattern string, handler http.HandlerFunc) { mux.HandleFunc(pattern, handler) })
+	mux.HandleFunc("POST /api/login", cfg.loginHandler)
+
+	fixtures := `
+users:
+  - email: mike@example.com
+    password: halfmeasures
+chirps:
+  - author: mike@example.com
+    body: no more half measures
+`
+	login := `{"email":"mike@example.com","password":"halfmeasures"}`
+
+	// The steps run in order, each on the state the last one left.
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "Seed", method: http.MethodPost, path: "/test/fixtures", body: fixtures, wantCode: http.StatusOK, wantBody: `"users":{"deleted":0,"inserted":1}`},
+		{name: "Log in as fixture", method: http.MethodPost, path: "/api/login", body: login, wantCode: http.StatusOK, wantBody: `"email":"mike@example.com"`},
+		{name: "Snapshot", method: http.MethodPut, path: "/test/snapshots/seeded", wantCode: http.StatusOK, wantBody: `"rows":{"chirps":1,"refresh_tokens":1,"reports":0,"users":1}`},
+		{name: "Reset chirps", method: http.MethodPost, path: "/test/reset", body: `{"tables":["chirps"]}`, wantCode: http.StatusOK, wantBody: `"chirps":{"deleted":1,"inserted":0}`},
+		{name: "Reset all", method: http.MethodPost, path: "/test/reset", wantCode: http.StatusOK, wantBody: `"users":{"deleted":1,"inserted":0}`},
+		{name: "Logged out", method: http.MethodPost, path: "/api/login", body: login, wantCode: http.StatusUnauthorized},
+		{name: "Restore", method: http.MethodPost, path: "/test/snapshots/seeded/restore", wantCode: http.StatusOK, wantBody: `"chirps":{"deleted":0,"inserted":1}`},
+		{name: "Logged in again", method: http.MethodPost, path: "/api/login", body: login, wantCode: http.StatusOK},
+		{name: "Restore unknown", method: http.MethodPost, path: "/test/snapshots/lost/restore", wantCode: http.StatusNotFound},
+		{name: "Bad fixtures", method: http.MethodPost, path: "/test/fixtures", body: "users:\n  - email: mike@example.com\n", wantCode: http.StatusBadRequest, wantBody: "has no password"},
+		{name: "Delete snapshot", method: http.MethodDelete, path: "/test/snapshots/seeded", wantCode: http.StatusNoContent},
+		{name: "Delete snapshot again", method: http.MethodDelete, path: "/test/snapshots/seeded", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantCode, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body, tt.wantBody)
+			}
+		})
+	}
+
+	events, err := cfg.service.AuditEvents(t.Context(), store.AuditEventFilter{Action: "test_reset", Limit: 10})
+	if err != nil || len(events) != 2 {
+		t.Errorf("test_reset audit events = %v, %v, want 2", events, err)
+	}
+}
+
+func TestSnapshotsOutliveServer(t *testing.T) {
+	db := newSQLiteStore(t)
+	serve := func(cfg *apiConfig, method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		mux := http.NewServeMux()
+		cfg.registerTestSupport(func(pattern string, handler http.HandlerFunc) { mux.HandleFunc(pattern, handler) })
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	first := newTestAPIConfig(db)
+	serve(first, http.MethodPost, "/test/fixtures", "users:\n  - email: mike@example.com\n    password: halfmeasures\n")
+	if rec := serve(first, http.MethodPut, "/test/snapshots/seeded", ""); rec.Code != http.StatusOK {
+		t.Fatalf("snapshot status = %d (body %s)", rec.Code, rec.Body)
+	}
+	serve(first, http.MethodPost, "/test/reset", "")
+
+	// A second server on the same database sees the first one's snapshot.
+	rec := serve(newTestAPIConfig(db), http.MethodPost, "/test/snapshots/seeded/restore", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"users":{"deleted":0,"inserted":1}`) {
+		t.Errorf("restore = %d %s, want the seeded user back", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestQueryName(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "sqlc query",
+			query: "-- name: GetChirpByID :one\nSELECT id FROM chirps WHERE id = $1\n",
+			want:  "GetChirpByID",
+		},
+		{
+			name:  "Plain SQL",
+			query: "SELECT 1",
+			want:  "query",
+		},
+		{
+			name:  "Empty name",
+			query: "-- name: ",
+			want:  "query",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := queryName(tt.query); got != tt.want {
+				t.Errorf("queryName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// testSpans records the spans of every test. The package tracer keeps
+// the first provider it is given, so there can only be one.
+var testSpans = func() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+}()
+
+// spansOf returns the ended spans of the trace with the given ID by name.
+func spansOf(traceID trace.TraceID) map[string]sdktrace.ReadOnlySpan {
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range testSpans.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			byName[span.Name()] = span
+		}
+	}
+	return byName
+}
+
+func TestRouteAndQuerySpans(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	db, _ := newBlockingDB(t)
+	s := store.NewPostgres(db, store.WithQueryWrapper(traceQueries(store.DriverPostgres)))
+	cfg := &apiConfig{service: service.New(s, service.Config{})}
+
+	// The same chain main builds, since the middlewares between the
+	// tracing and the mux decide whether the pattern gets through.
+	const pattern = "GET /api/chirps/{chirpID}"
+	mux := http.NewServeMux()
+	route(mux, pattern, withDeadline(10*time.Millisecond, http.HandlerFunc(cfg.getChirpByIDHandler)))
+	handler := rootHandler(mux, newMetrics(nil), slog.New(slog.DiscardHandler))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps/"+uuid.NewString(), nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	id, _ := trace.TraceIDFromHex(traceID)
+	byName := spansOf(id)
+
+	server, ok := byName[pattern]
+	if !ok {
+		t.Fatalf("no span named %q, got %v", pattern, byName)
+	}
+	if got := server.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("server span trace id = %s, want %s from traceparent", got, traceID)
+	}
+	if got := spanAttr(server, semconv.HTTPRouteKey); got != "/api/chirps/{chirpID}" {
+		t.Errorf("server span http.route = %q, want /api/chirps/{chirpID}", got)
+	}
+
+	query, ok := byName["db GetChirpByID"]
+	if !ok {
+		t.Fatal("no span for the GetChirpByID query")
+	}
+	if query.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("query span is not a child of the route span")
+	}
+	if got := spanAttr(query, semconv.DBSystemNameKey); got != "postgresql" {
+		t.Errorf("query span db.system.name = %q, want postgresql", got)
+	}
+}
+
+func TestSQLiteQuerySpans(t *testing.T) {
+	s := newSQLiteStore(t)
+	ctx, parent := tracer.Start(t.Context(), "test")
+	if _, err := s.GetChirpByID(ctx, uuid.New()); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetChirpByID() error = %v, want ErrNotFound", err)
+	}
+	parent.End()
+
+	query, ok := spansOf(parent.SpanContext().TraceID())["db GetChirpByID"]
+	if !ok {
+		t.Fatal("no span for the GetChirpByID query")
+	}
+	if got := spanAttr(query, semconv.DBSystemNameKey); got != "sqlite" {
+		t.Errorf("db.system.name = %q, want sqlite", got)
+	}
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value.AsString()
+		}
+	}
+	return ""
+}
@@ -1,14 +1,15 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"time"
 
 	"github.com/TheMaru/go-http-server/internal/auth"
-	"github.com/TheMaru/go-http-server/internal/database"
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/store"
 	"github.com/google/uuid"
 )
 
@@ -20,208 +21,311 @@ type User struct {
 	Token        string    `json:"token"`
 	RefreshToken string    `json:"refresh_token"`
 	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Role         string    `json:"role"`
+	// CSRFToken is only set for logins that asked for cookies.
+	CSRFToken string `json:"csrf_token,omitempty"`
 }
 
-func getRequestUserData(r *http.Request) (email string, hashedPw string, err error) {
-	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+func newUserResp(u store.User) User {
+	return User{
+		ID:          u.ID,
+		CreatedAt:   u.CreatedAt,
+		UpdatedAt:   u.UpdatedAt,
+		Email:       u.Email,
+		IsChirpyRed: u.IsChirpyRed,
+		Role:        u.Role,
 	}
+}
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func decodeCredentials(r *http.Request) (credentials, error) {
+	var params credentials
+	err := json.NewDecoder(r.Body).Decode(&params)
+	return params, err
+}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
+func (cfg *apiConfig) addUserHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := decodeCredentials(r)
 	if err != nil {
-		return "", "", err
+		respondWithError(w, r, http.StatusBadRequest, "Could not get params", err)
+		return
 	}
 
-	hashedPw, err = auth.HashPassword(params.Password)
+	user, err := cfg.createUser(r, params.Email, params.Password)
+	if errors.Is(err, store.ErrConflict) {
+		respondWithError(w, r, http.StatusConflict, "Email already in use", err)
+		return
+	}
 	if err != nil {
-		return "", "", err
+		respondWithDBError(w, r, http.StatusInternalServerError, "Error creating user", err)
+		return
 	}
 
-	return params.Email, hashedPw, nil
+	respondWithJSON(w, http.StatusCreated, newUserResp(user))
 }
 
-func (cfg *apiConfig) addUserHandler(w http.ResponseWriter, r *http.Request) {
-	email, hashedPw, err := getRequestUserData(r)
+// createUser signs a user up and records it. Both the API and the web UI
+// go through it.
+func (cfg *apiConfig) createUser(r *http.Request, email, password string) (store.User, error) {
+	user, err := cfg.service.CreateUser(r.Context(), email, password)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Could not get params", err)
-		return
-	}
-	userParams := database.CreateUserParams{
-		Email:          email,
-		HashedPassword: hashedPw,
+		return store.User{}, err
 	}
+	cfg.recordAudit(r, service.AuditRecord{
+		Action:       "create_user",
+		Outcome:      service.AuditSuccess,
+		ActorID:      user.ID,
+		TargetUserID: user.ID,
+		Details:      map[string]string{"email": user.Email},
+	})
+	return user, nil
+}
 
-	dbUser, err := cfg.dbQueries.CreateUser(context.Background(), userParams)
+// login opens a session through the service, counts the attempt and
+// records it. Both the API and the web UI go through it. With a
+// resetToken it sets newPassword as ResetPassword does, and password
+// isn't needed.
+func (cfg *apiConfig) login(r *http.Request, email, password, resetToken, newPassword string) (service.Session, error) {
+	var session service.Session
+	var err error
+	if resetToken != "" {
+		session, err = cfg.service.ResetPassword(r.Context(), email, resetToken, newPassword)
+	} else {
+		session, err = cfg.service.Login(r.Context(), email, password)
+	}
+	event := service.AuditRecord{Action: "login", Outcome: service.AuditDenied, Details: map[string]string{"email": email}}
+	switch {
+	case errors.Is(err, service.ErrPasswordRequired):
+		return service.Session{}, err
+	case errors.Is(err, service.ErrInvalidCredentials):
+		event.Outcome = service.AuditFailure
+		event.Details["reason"] = "invalid_credentials"
+	case errors.Is(err, service.ErrSuspended):
+		event.Details["reason"] = "suspended"
+	case errors.Is(err, service.ErrPasswordResetRequired):
+		event.Details["reason"] = "password_reset_required"
+	case err != nil:
+		cfg.metrics.logins.WithLabelValues("error").Inc()
+		return service.Session{}, err
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error creating user", err)
-		return
+		cfg.metrics.logins.WithLabelValues("failure").Inc()
+		cfg.recordAudit(r, event)
+		return service.Session{}, err
 	}
 
-	user := User{
-		ID:          dbUser.ID,
-		CreatedAt:   dbUser.CreatedAt,
-		UpdatedAt:   dbUser.UpdatedAt,
-		Email:       dbUser.Email,
-		IsChirpyRed: dbUser.IsChirpyRed,
+	setRequestUser(r, session.User.ID)
+	cfg.metrics.logins.WithLabelValues("success").Inc()
+	event.Outcome, event.ActorID, event.TargetUserID = service.AuditSuccess, session.User.ID, session.User.ID
+	if resetToken != "" {
+		event.Details["password_changed"] = "true"
 	}
+	cfg.recordAudit(r, event)
+	return session, nil
+}
 
-	respondWithJSON(w, http.StatusCreated, user)
+// revoke revokes a refresh token and records it under action. The API and
+// the web UI log out through it too.
+func (cfg *apiConfig) revoke(r *http.Request, action, refreshToken string) error {
+	userID, err := cfg.service.Revoke(r.Context(), refreshToken)
+	event := service.AuditRecord{Action: action, Outcome: service.AuditSuccess, ActorID: userID, TargetUserID: userID}
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		event.Outcome = service.AuditFailure
+		event.Details = map[string]string{"reason": "unknown_token"}
+	case err != nil:
+		return err
+	}
+	cfg.recordAudit(r, event)
+	return err
 }
 
+// loginHandler returns the tokens of a new session in the response, or,
+// if the client asks for cookies, sets them as HttpOnly cookies and
+// returns the CSRF token to send along with cookie-authenticated requests
+// instead. Users whose password an admin reset must send the reset_token
+// they were given and a new_password in place of the password.
 func (cfg *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+	var params struct {
+		credentials
+		ResetToken  string `json:"reset_token"`
+		NewPassword string `json:"new_password"`
+		Cookies     bool   `json:"cookies"`
 	}
-
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err := decoder.Decode(&params)
+	err := json.NewDecoder(r.Body).Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		respondWithError(w, r, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.NewPassword != "" && params.ResetToken == "" {
+		respondWithError(w, r, http.StatusBadRequest, "new_password needs a reset_token", nil)
 		return
 	}
 
-	dbUser, err := cfg.dbQueries.GetUserByEmail(context.Background(), params.Email)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't find user", err)
+	session, err := cfg.login(r, params.Email, params.Password, params.ResetToken, params.NewPassword)
+	switch {
+	case errors.Is(err, service.ErrPasswordRequired):
+		respondWithError(w, r, http.StatusBadRequest, "reset_token needs a new_password", err)
+		return
+	case errors.Is(err, service.ErrInvalidCredentials):
+		respondWithError(w, r, http.StatusUnauthorized, "Incorrect email or password", err)
+		return
+	case errors.Is(err, service.ErrSuspended):
+		respondWithError(w, r, http.StatusForbidden, "Account suspended", err)
+		return
+	case errors.Is(err, service.ErrPasswordResetRequired):
+		respondWithError(w, r, http.StatusForbidden, "Password reset required, log in with your reset_token and a new_password", err)
+		return
+	case err != nil:
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't log in", err)
 		return
 	}
 
-	isCorrectPW, err := auth.CheckPasswordHash(params.Password, dbUser.HashedPassword)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
-		return
-	}
-
-	if isCorrectPW {
-		expirationDuration := time.Duration(1) * time.Hour
-
-		token, err := auth.MakeJWT(dbUser.ID, cfg.secret, expirationDuration)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Couldn't generate JWT", err)
-			return
-		}
-
-		refreshToken, err := auth.MakeRefreshToken()
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Couldn't generate refresh token", err)
-			return
-		}
-
-		refreshTokenParams := database.CreateRefreshTokenParams{
-			Token:  refreshToken,
-			UserID: dbUser.ID,
-		}
-		_, err = cfg.dbQueries.CreateRefreshToken(context.Background(), refreshTokenParams)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
-			return
-		}
-
-		respondWithJSON(w, http.StatusOK, User{
-			ID:           dbUser.ID,
-			CreatedAt:    dbUser.CreatedAt,
-			UpdatedAt:    dbUser.UpdatedAt,
-			Email:        dbUser.Email,
-			IsChirpyRed:  dbUser.IsChirpyRed,
-			Token:        token,
-			RefreshToken: refreshToken,
-		})
+	res := newUserResp(session.User)
+	if params.Cookies {
+		cfg.startSession(w, session)
+		res.CSRFToken = cfg.csrfToken(w, r)
 	} else {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", errors.New("Incorrect email or password"))
+		res.Token = session.AccessToken
+		res.RefreshToken = session.RefreshToken
 	}
+	respondWithJSON(w, http.StatusOK, res)
 }
 
 func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "No Refresh Token", errors.New("No Refresh Token"))
+		respondWithError(w, r, http.StatusUnauthorized, "No Refresh Token", errors.New("No Refresh Token"))
 		return
 	}
 
-	refreshTokenDB, err := cfg.dbQueries.GetRefreshToken(context.Background(), refreshToken)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Token not found", errors.New("Token not found"))
-		return
+	newToken, userID, err := cfg.service.Refresh(r.Context(), refreshToken)
+	// Refreshes are too routine to record, but refusals aren't: a revoked
+	// token coming back may well be a stolen one.
+	refused := func(reason string) {
+		cfg.recordAudit(r, service.AuditRecord{
+			Action:       "refresh",
+			Outcome:      service.AuditDenied,
+			TargetUserID: userID,
+			Details:      map[string]string{"reason": reason},
+		})
 	}
-
-	if refreshTokenDB.RevokedAt.Valid {
-		respondWithError(w, http.StatusUnauthorized, "Token revoked", errors.New("Token revoked"))
+	switch {
+	case errors.Is(err, service.ErrTokenRevoked):
+		refused("revoked_token")
+		respondWithError(w, r, http.StatusUnauthorized, "Token revoked", err)
+		return
+	case errors.Is(err, service.ErrTokenExpired):
+		respondWithError(w, r, http.StatusUnauthorized, "Token expired", err)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		respondWithError(w, r, http.StatusUnauthorized, "Token not found", err)
+		return
+	case errors.Is(err, service.ErrSuspended):
+		refused("suspended")
+		respondWithError(w, r, http.StatusForbidden, "Account suspended", err)
+		return
+	case err != nil:
+		respondWithDBError(w, r, http.StatusInternalServerError, "New Token could not be generated", err)
 		return
 	}
+	setRequestUser(r, userID)
 
-	if refreshTokenDB.ExpiresAt.Before(time.Now()) {
-		respondWithError(w, http.StatusUnauthorized, "Token expired", errors.New("Token expired"))
+	// A refresh token from a cookie gets its access token as one too.
+	if cookieAuthenticated(r) {
+		cfg.setCookie(w, accessCookie, newToken, 0)
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-
 	type refreshTokenRes struct {
 		Token string `json:"token"`
 	}
-	newToken, err := auth.MakeJWT(refreshTokenDB.UserID, cfg.secret, time.Duration(1)*time.Hour)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "New Token could not be generated", err)
-		return
-	}
 	respondWithJSON(w, http.StatusOK, refreshTokenRes{Token: newToken})
 }
 
 func (cfg *apiConfig) revokeHandler(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "No Refresh Token", errors.New("No Refresh Token"))
+		respondWithError(w, r, http.StatusUnauthorized, "No Refresh Token", errors.New("No Refresh Token"))
 		return
 	}
 
-	err = cfg.dbQueries.RevokeToken(context.Background(), refreshToken)
+	err = cfg.revoke(r, "revoke_token", refreshToken)
+	if errors.Is(err, store.ErrNotFound) {
+		respondWithError(w, r, http.StatusUnauthorized, "Refresh token not found", err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Refresh token not found", errors.New("Refresh token not found"))
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't revoke refresh token", err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+// logoutHandler revokes the refresh token and clears the session cookies.
+// Logging out twice is fine, so an unknown token isn't an error.
+func (cfg *apiConfig) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Token not found", err)
+		respondWithError(w, r, http.StatusUnauthorized, "No Refresh Token", err)
 		return
 	}
+	cfg.clearCookie(w, accessCookie)
+	cfg.clearCookie(w, refreshCookie)
+
+	err = cfg.revoke(r, "logout", refreshToken)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondWithDBError(w, r, http.StatusInternalServerError, "Couldn't revoke refresh token", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
 
-	uuid, err := auth.ValidateJWT(token, cfg.secret)
+	params, err := decodeCredentials(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid token", err)
+		respondWithError(w, r, http.StatusBadRequest, "Could not get params", err)
 		return
 	}
 
-	email, hashedPw, err := getRequestUserData(r)
+	before, err := cfg.service.GetUser(r.Context(), p.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Could not get params", err)
+		respondWithDBError(w, r, http.StatusInternalServerError, "Error updating user", err)
 		return
 	}
-	userParams := database.UpdateUserParams{
-		Email:          email,
-		HashedPassword: hashedPw,
-		ID:             uuid,
+	user, err := cfg.service.UpdateUser(r.Context(), p.UserID, params.Email, params.Password)
+	if errors.Is(err, store.ErrConflict) {
+		cfg.recordAudit(r, service.AuditRecord{
+			Action:       "update_user",
+			Outcome:      service.AuditFailure,
+			TargetUserID: p.UserID,
+			Details:      map[string]string{"reason": "email_taken", "email": params.Email},
+		})
+		respondWithError(w, r, http.StatusConflict, "Email already in use", err)
+		return
 	}
-
-	dbUser, err := cfg.dbQueries.UpdateUser(context.Background(), userParams)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error updating user", err)
+		respondWithDBError(w, r, http.StatusInternalServerError, "Error updating user", err)
 		return
 	}
-
-	user := User{
-		ID:          dbUser.ID,
-		CreatedAt:   dbUser.CreatedAt,
-		UpdatedAt:   dbUser.UpdatedAt,
-		Email:       dbUser.Email,
-		IsChirpyRed: dbUser.IsChirpyRed,
+	cfg.publish(r, pubsub.Event{Type: pubsub.UserUpdated, UserID: user.ID})
+	details := map[string]string{"password_changed": "true"}
+	if user.Email != before.Email {
+		details["old_email"], details["email"] = before.Email, user.Email
 	}
-	respondWithJSON(w, http.StatusOK, user)
+	cfg.recordAudit(r, service.AuditRecord{
+		Action:       "update_user",
+		Outcome:      service.AuditSuccess,
+		TargetUserID: user.ID,
+		Details:      details,
+	})
+
+	respondWithJSON(w, http.StatusOK, newUserResp(user))
 }
@@ -0,0 +1,28 @@
+// Package web holds the assets built into the binary.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed public templates
+var files embed.FS
+
+// Public returns the static assets served under /app/static/.
+func Public() fs.FS {
+	public, err := fs.Sub(files, "public")
+	if err != nil {
+		panic(err)
+	}
+	return public
+}
+
+// Templates returns the html/template files of the web UI.
+func Templates() fs.FS {
+	templates, err := fs.Sub(files, "templates")
+	if err != nil {
+		panic(err)
+	}
+	return templates
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"net/http"
 
-	"github.com/TheMaru/go-http-server/internal/auth"
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/TheMaru/go-http-server/internal/service"
 	"github.com/google/uuid"
 )
 
@@ -17,30 +17,33 @@ type polkaRequest struct {
 }
 
 func (cfg *apiConfig) polkaWebhookHandler(w http.ResponseWriter, r *http.Request) {
-	key, err := auth.GetAPIKey(r.Header)
-	if err != nil || key != cfg.polkaKey {
-		respondWithError(w, http.StatusUnauthorized, "Not authorized", err)
-		return
-	}
-
 	decoder := json.NewDecoder(r.Body)
 	requestParams := polkaRequest{}
-	err = decoder.Decode(&requestParams)
+	err := decoder.Decode(&requestParams)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couln't decode parameters", err)
+		cfg.metrics.webhooks.WithLabelValues("polka", "bad_request").Inc()
+		respondWithError(w, r, http.StatusBadRequest, "Couln't decode parameters", err)
 		return
 	}
 
 	if requestParams.Event != "user.upgraded" {
+		cfg.metrics.webhooks.WithLabelValues("polka", "ignored").Inc()
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	err = cfg.dbQueries.GrantChirpyRedToUser(context.Background(), requestParams.Data.UserID)
+	err = cfg.service.UpgradeUser(r.Context(), requestParams.Data.UserID)
+	event := service.AuditRecord{Action: "polka_upgrade", Outcome: service.AuditSuccess, TargetUserID: requestParams.Data.UserID}
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Could not update user", err)
+		event.Outcome = service.AuditFailure
+		cfg.recordAudit(r, event)
+		cfg.metrics.webhooks.WithLabelValues("polka", "failed").Inc()
+		respondWithDBError(w, r, http.StatusNotFound, "Could not update user", err)
 		return
 	}
+	cfg.recordAudit(r, event)
+	cfg.metrics.webhooks.WithLabelValues("polka", "upgraded").Inc()
+	cfg.publish(r, pubsub.Event{Type: pubsub.UserUpgraded, UserID: requestParams.Data.UserID})
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/google/uuid"
+)
+
+const (
+	// wsReadLimit caps the size of a client message.
+	wsReadLimit = 4096
+	// wsMaxTopics caps how many topics one connection may subscribe to.
+	wsMaxTopics = 50
+	// wsStatusTokenExpired closes a connection whose access token ran
+	// out. Clients should reconnect with a fresh one.
+	wsStatusTokenExpired websocket.StatusCode = 4001
+)
+
+// wsTopic is something a connection can subscribe to:
+//
+//	timeline              every chirp
+//	timeline:<author_id>  chirps of one author
+//	chirp:<chirp_id>      the deletion of one chirp
+//	notifications         events about the connected user
+//
+// Replies go out as chirp_created events with their parent_id, so there
+// is no topic per thread. There are no likes to push events about.
+type wsTopic struct {
+	kind string
+	id   uuid.UUID
+}
+
+func parseTopic(s string) (wsTopic, error) {
+	kind, rawID, hasID := strings.Cut(s, ":")
+	switch {
+	case kind == "timeline" && !hasID, kind == "notifications" && !hasID:
+		return wsTopic{kind: kind}, nil
+	case kind == "timeline", kind == "chirp":
+		id, err := uuid.Parse(rawID)
+		if err != nil {
+			return wsTopic{}, fmt.Errorf("topic %q: malformed id", s)
+		}
+		return wsTopic{kind: kind, id: id}, nil
+	}
+	return wsTopic{}, fmt.Errorf("unknown topic %q", s)
+}
+
+func (t wsTopic) match(e pubsub.Event, userID uuid.UUID) bool {
+	isChirpEvent := e.Type == pubsub.ChirpCreated || e.Type == pubsub.ChirpDeleted
+	switch t.kind {
+	case "timeline":
+		return isChirpEvent && (t.id == uuid.Nil || e.Chirp.UserID == t.id)
+	case "chirp":
+		return e.Type == pubsub.ChirpDeleted && e.Chirp.ID == t.id
+	case "notifications":
+		return !isChirpEvent && e.UserID == userID
+	}
+	return false
+}
+
+type wsClientMessage struct {
+	Type  string `json:"type"`
+	Topic string `json:"topic"`
+	// malformed is set for messages that weren't valid JSON.
+	malformed bool
+}
+
+type wsServerMessage struct {
+	Type  string `json:"type"`
+	Topic string `json:"topic,omitempty"`
+	ID    uint64 `json:"id,omitempty"`
+	Event string `json:"event,omitempty"`
+	Data  any    `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// chirpDeletedResp is the data of a chirp_deleted event. It never
+// carries the body, which for a chirp hidden by a moderator is exactly
+// what should no longer be shown.
+type chirpDeletedResp struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func eventData(e pubsub.Event) any {
+	switch e.Type {
+	case pubsub.ChirpCreated:
+		return newChirpResp(e.Chirp)
+	case pubsub.ChirpDeleted:
+		return chirpDeletedResp{ID: e.Chirp.ID, UserID: e.Chirp.UserID}
+	}
+	return struct {
+		UserID uuid.UUID `json:"user_id"`
+	}{e.UserID}
+}
+
+// wsHandler upgrades to a WebSocket that pushes events for the topics the
+// client subscribes to. The connection ends when the access token it was
+// opened with expires.
+func (cfg *apiConfig) wsHandler(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFrom(r)
+
+	// The server's read and write timeouts are still armed on the
+	// connection and would cut it after a few seconds once hijacked.
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		// Accept has already responded.
+		slog.Info("websocket upgrade failed", append(requestAttrs(r), slog.Any("error", err))...)
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(wsReadLimit)
+
+	status, reason := cfg.serveWS(r.Context(), conn, p.UserID, p.ExpiresAt)
+	conn.Close(status, reason)
+}
+
+// serveWS runs a connection until it should end and returns the status to
+// close it with. Only this goroutine writes messages; a reader goroutine
+// hands over client messages and a pinger checks the peer is still there.
+// Backpressure comes from the bus: a connection that can't write events as
+// fast as they arrive is dropped by it.
+func (cfg *apiConfig) serveWS(ctx context.Context, conn *websocket.Conn, userID uuid.UUID, expiresAt time.Time) (websocket.StatusCode, string) {
+	// The reader and pinger get their own context: cancelling the one a
+	// read is blocked on tears the connection down, which has to wait
+	// until the close status has been sent.
+	loopCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+
+	sub, _ := cfg.events.Subscribe(0, streamBuffer)
+	defer sub.Close()
+
+	requests := make(chan wsClientMessage)
+	go func() {
+		defer cancel()
+		for {
+			_, data, err := conn.Read(loopCtx)
+			if err != nil {
+				return
+			}
+			var msg wsClientMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				msg = wsClientMessage{malformed: true}
+			}
+			select {
+			case requests <- msg:
+			case <-loopCtx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer cancel()
+		ticker := time.NewTicker(cfg.streamHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-loopCtx.Done():
+				return
+			case <-ticker.C:
+				pingCtx, cancelPing := context.WithTimeout(loopCtx, cfg.streamHeartbeat)
+				err := conn.Ping(pingCtx)
+				cancelPing()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	write := func(msg wsServerMessage) error {
+		writeCtx, cancel := context.WithTimeout(loopCtx, streamWriteTimeout)
+		defer cancel()
+		return wsjson.Write(writeCtx, conn, msg)
+	}
+
+	expiry := time.NewTimer(time.Until(expiresAt))
+	defer expiry.Stop()
+
+	topics := map[string]wsTopic{}
+	for {
+		var err error
+		select {
+		case <-loopCtx.Done():
+			// The client went away or stopped answering pings.
+			return websocket.StatusGoingAway, ""
+		case <-ctx.Done():
+			return websocket.StatusGoingAway, ""
+		case <-expiry.C:
+			return wsStatusTokenExpired, "token expired"
+		case e, ok := <-sub.C:
+			if !ok {
+				if sub.Dropped() {
+					return websocket.StatusTryAgainLater, "too slow"
+				}
+				return websocket.StatusGoingAway, "server shutting down"
+			}
+			for name, topic := range topics {
+				if err == nil && topic.match(e, userID) {
+					err = write(wsServerMessage{Type: "event", Topic: name, ID: e.ID, Event: e.Type, Data: eventData(e)})
+				}
+			}
+		case msg := <-requests:
+			err = write(handleWSMessage(topics, msg))
+		}
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return websocket.StatusTryAgainLater, "too slow"
+			}
+			return websocket.StatusInternalError, ""
+		}
+	}
+}
+
+// handleWSMessage applies a client message to topics and returns the reply.
+func handleWSMessage(topics map[string]wsTopic, msg wsClientMessage) wsServerMessage {
+	fail := func(err string) wsServerMessage {
+		return wsServerMessage{Type: "error", Topic: msg.Topic, Error: err}
+	}
+	if msg.malformed {
+		return fail("message is not valid JSON")
+	}
+	switch msg.Type {
+	case "subscribe":
+		topic, err := parseTopic(msg.Topic)
+		if err != nil {
+			return fail(err.Error())
+		}
+		if _, ok := topics[msg.Topic]; !ok && len(topics) >= wsMaxTopics {
+			return fail(fmt.Sprintf("at most %d topics per connection", wsMaxTopics))
+		}
+		topics[msg.Topic] = topic
+		return wsServerMessage{Type: "subscribed", Topic: msg.Topic}
+	case "unsubscribe":
+		delete(topics, msg.Topic)
+		return wsServerMessage{Type: "unsubscribed", Topic: msg.Topic}
+	default:
+		return fail(fmt.Sprintf("unknown message type %q", msg.Type))
+	}
+}
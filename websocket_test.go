@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/pubsub"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/google/uuid"
+)
+
+func TestParseTopic(t *testing.T) {
+	id := uuid.New()
+	tests := []struct {
+		topic   string
+		want    wsTopic
+		wantErr bool
+	}{
+		{topic: "timeline", want: wsTopic{kind: "timeline"}},
+		{topic: "timeline:" + id.String(), want: wsTopic{kind: "timeline", id: id}},
+		{topic: "chirp:" + id.String(), want: wsTopic{kind: "chirp", id: id}},
+		{topic: "notifications", want: wsTopic{kind: "notifications"}},
+		{topic: "chirp", wantErr: true},
+		{topic: "chirp:nope", wantErr: true},
+		{topic: "thread:" + id.String(), wantErr: true},
+		{topic: "notifications:" + id.String(), wantErr: true},
+		{topic: "everything", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			got, err := parseTopic(tt.topic)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTopic() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseTopic() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+type wsClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func newWSServer(t *testing.T, cfg *apiConfig) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/ws", withQueryToken(cfg.requireUser(cfg.wsHandler)))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Cleanup(cfg.events.Close)
+	return srv
+}
+
+func dialWS(t *testing.T, srv *httptest.Server, token string) *wsClient {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/api/ws", &websocket.DialOptions{
+		HTTPHeader: http.Header{"Authorization": {"Bearer " + token}},
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return &wsClient{t: t, conn: conn}
+}
+
+func (c *wsClient) send(msg string) {
+	c.t.Helper()
+	if err := c.conn.Write(context.Background(), websocket.MessageText, []byte(msg)); err != nil {
+		c.t.Fatalf("Write() error = %v", err)
+	}
+}
+
+func (c *wsClient) read() wsServerMessage {
+	c.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var msg struct {
+		wsServerMessage
+		Data json.RawMessage `json:"data"`
+	}
+	if err := wsjson.Read(ctx, c.conn, &msg); err != nil {
+		c.t.Fatalf("Read() error = %v", err)
+	}
+	msg.wsServerMessage.Data = string(msg.Data)
+	return msg.wsServerMessage
+}
+
+func (c *wsClient) subscribe(topic string) {
+	c.t.Helper()
+	c.send(`{"type":"subscribe","topic":"` + topic + `"}`)
+	if got := c.read(); got.Type != "subscribed" || got.Topic != topic {
+		c.t.Fatalf("subscribe(%s) reply = %+v", topic, got)
+	}
+}
+
+// closeStatus waits for the server to close the connection.
+func (c *wsClient) closeStatus() websocket.StatusCode {
+	c.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		_, _, err := c.conn.Read(ctx)
+		if err != nil {
+			return websocket.CloseStatus(err)
+		}
+	}
+}
+
+func TestWebSocketRejectsBadTokens(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newWSServer(t, cfg)
+
+	for name, header := range map[string]http.Header{
+		"No token":  nil,
+		"Bad token": {"Authorization": {"Bearer nope"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, resp, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http")+"/api/ws", &websocket.DialOptions{HTTPHeader: header})
+			if err == nil {
+				t.Fatal("Dial() succeeded without a valid token")
+			}
+			if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("response = %v, want 401", resp)
+			}
+		})
+	}
+}
+
+func TestWebSocketTokenInQuery(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newWSServer(t, cfg)
+	_, token := newTestUser(t, cfg, store.RoleUser, time.Hour)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/ws?access_token=" + token
+	conn, _, err := websocket.Dial(context.Background(), url, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.CloseNow()
+}
+
+func TestWebSocketTopics(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newWSServer(t, cfg)
+	user, token := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	me, other := user.ID, uuid.New()
+	c := dialWS(t, srv, token)
+
+	watched := store.Chirp{ID: uuid.New(), UserID: other, Body: "watched"}
+	c.subscribe("timeline:" + me.String())
+	c.subscribe("chirp:" + watched.ID.String())
+	c.subscribe("notifications")
+
+	events := []pubsub.Event{
+		pubsub.ChirpEvent(pubsub.ChirpCreated, store.Chirp{ID: uuid.New(), UserID: other, Body: "ignored"}),
+		pubsub.ChirpEvent(pubsub.ChirpCreated, store.Chirp{ID: uuid.New(), UserID: me, Body: "mine"}),
+		pubsub.ChirpEvent(pubsub.ChirpDeleted, watched),
+		{Type: pubsub.UserUpgraded, UserID: other},
+		{Type: pubsub.UserUpgraded, UserID: me},
+	}
+	for _, e := range events {
+		cfg.events.Publish(context.Background(), e)
+	}
+
+	want := []struct{ topic, event, data string }{
+		{"timeline:" + me.String(), pubsub.ChirpCreated, `"body":"mine"`},
+		{"chirp:" + watched.ID.String(), pubsub.ChirpDeleted, `{"id":"` + watched.ID.String() + `","user_id":"` + other.String() + `"}`},
+		{"notifications", pubsub.UserUpgraded, `"user_id":"` + me.String() + `"`},
+	}
+	for _, w := range want {
+		got := c.read()
+		if got.Type != "event" || got.Topic != w.topic || got.Event != w.event || !strings.Contains(got.Data.(string), w.data) {
+			t.Errorf("got %+v, want %s on %s with %s", got, w.event, w.topic, w.data)
+		}
+	}
+
+	c.send(`{"type":"unsubscribe","topic":"notifications"}`)
+	if got := c.read(); got.Type != "unsubscribed" {
+		t.Errorf("unsubscribe reply = %+v", got)
+	}
+	cfg.events.Publish(context.Background(), pubsub.Event{Type: pubsub.UserUpgraded, UserID: me})
+	cfg.events.Publish(context.Background(), pubsub.ChirpEvent(pubsub.ChirpCreated, store.Chirp{UserID: me, Body: "after"}))
+	if got := c.read(); !strings.Contains(got.Data.(string), `"body":"after"`) {
+		t.Errorf("got %+v after unsubscribing, want the next chirp", got)
+	}
+}
+
+func TestWebSocketBadMessages(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newWSServer(t, cfg)
+	_, token := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	c := dialWS(t, srv, token)
+
+	tests := []struct {
+		msg     string
+		wantErr string
+	}{
+		{`not json`, "not valid JSON"},
+		{`{"type":"shout"}`, "unknown message type"},
+		{`{"type":"subscribe","topic":"everything"}`, "unknown topic"},
+		{`{"type":"subscribe","topic":"chirp:nope"}`, "malformed id"},
+	}
+	for _, tt := range tests {
+		c.send(tt.msg)
+		if got := c.read(); got.Type != "error" || !strings.Contains(got.Error, tt.wantErr) {
+			t.Errorf("reply to %s = %+v, want error containing %q", tt.msg, got, tt.wantErr)
+		}
+	}
+}
+
+func TestWebSocketClosesOnTokenExpiry(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newWSServer(t, cfg)
+	// JWT expiry has second resolution and validation allows a second of
+	// leeway, so this is the shortest token that is still accepted.
+	_, token := newTestUser(t, cfg, store.RoleUser, time.Second)
+	c := dialWS(t, srv, token)
+
+	if got := c.closeStatus(); got != wsStatusTokenExpired {
+		t.Errorf("close status = %v, want %v", got, wsStatusTokenExpired)
+	}
+}
+
+func TestWebSocketClosesOnShutdown(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newWSServer(t, cfg)
+	_, token := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	c := dialWS(t, srv, token)
+	c.subscribe("timeline")
+
+	cfg.events.Close()
+	if got := c.closeStatus(); got != websocket.StatusGoingAway {
+		t.Errorf("close status = %v, want %v", got, websocket.StatusGoingAway)
+	}
+}
+
+func TestWebSocketDropsSlowClients(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newWSServer(t, cfg)
+	_, token := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	c := dialWS(t, srv, token)
+	c.conn.SetReadLimit(1 << 20)
+	c.subscribe("timeline")
+
+	// Publish far more than the socket buffers hold while the client
+	// isn't reading. The server blocks writing, its bus subscription
+	// overflows, and once the client drains the backlog it finds the
+	// connection closed.
+	body := strings.Repeat("x", 64<<10)
+	for range 500 {
+		cfg.events.Publish(context.Background(), pubsub.ChirpEvent(pubsub.ChirpCreated, store.Chirp{Body: body}))
+	}
+	if got := c.closeStatus(); got != websocket.StatusTryAgainLater {
+		t.Errorf("close status = %v, want %v", got, websocket.StatusTryAgainLater)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/service"
+	"github.com/TheMaru/go-http-server/internal/static"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/TheMaru/go-http-server/web"
+	"github.com/google/uuid"
+)
+
+const (
+	// timelineSize is how many chirps a page of the timeline or of a
+	// profile shows.
+	timelineSize = 100
+	// maxFormSize caps the body of a posted form.
+	maxFormSize = 64 << 10
+)
+
+// pageData is what every page template gets. Each page uses the fields
+// that concern it.
+type pageData struct {
+	// Viewer is the logged in user, nil for visitors.
+	Viewer *store.User
+	CSRF   string
+	Error  string
+	// Status is the status line of the error page.
+	Status string
+	Chirp  store.Chirp
+	Chirps []store.Chirp
+	// Ancestors and Replies are the thread around Chirp, as
+	// service.Thread has them.
+	Ancestors []store.Chirp
+	Replies   []store.Chirp
+	// Older links to the next page of Chirps, if there is one.
+	Older   string
+	Profile store.User
+	// ChirpCount is how many chirps of Profile the viewer can see.
+	ChirpCount int
+	// Email, Body and Next refill a form that has to be submitted again.
+	Email          string
+	Body           string
+	Next           string
+	MaxChirpLength int
+	// NewPassword asks the login form for the reset token and a new
+	// password instead of the password.
+	NewPassword bool
+}
+
+// viewerID is the ID of the logged in user, or uuid.Nil for visitors.
+func (d pageData) viewerID() uuid.UUID {
+	if d.Viewer == nil {
+		return uuid.Nil
+	}
+	return d.Viewer.ID
+}
+
+// loadPages parses every page of the web UI together with the layout.
+// Asset links go through assets so they point at fingerprinted names.
+func loadPages(assets *static.Server) (map[string]*template.Template, error) {
+	funcs := template.FuncMap{
+		"asset":   func(name string) string { return fileserverRoute + assets.Path(name) },
+		"shortID": func(id uuid.UUID) string { return id.String()[:8] },
+		"date":    func(t time.Time) string { return t.UTC().Format("Jan 2, 2006 15:04") },
+	}
+	pages := map[string]*template.Template{}
+	for _, name := range []string{"timeline", "chirp", "profile", "login", "signup", "error"} {
+		t, err := template.New("layout").Funcs(funcs).ParseFS(web.Templates(), "layout.html", name+".html")
+		if err != nil {
+			return nil, fmt.Errorf("parsing page %s: %w", name, err)
+		}
+		pages[name] = t
+	}
+	return pages, nil
+}
+
+// newPage starts the data of a page with the viewer and the CSRF token
+// for its forms.
+func (cfg *apiConfig) newPage(w http.ResponseWriter, r *http.Request) pageData {
+	data := pageData{CSRF: cfg.csrfToken(w, r), MaxChirpLength: service.MaxChirpLength}
+	if userID, ok := cfg.sessionUser(w, r); ok {
+		// A user deleted since logging in browses on as a visitor.
+		if user, err := cfg.service.GetUser(r.Context(), userID); err == nil {
+			data.Viewer = &user
+		}
+	}
+	return data
+}
+
+// render executes a page into a buffer first, so a template error still
+// gets a clean 500 instead of half a page.
+func (cfg *apiConfig) render(w http.ResponseWriter, r *http.Request, code int, page string, data pageData) {
+	var buf bytes.Buffer
+	if err := cfg.pages[page].ExecuteTemplate(&buf, "layout", data); err != nil {
+		slog.ErrorContext(r.Context(), "rendering page", "page", page, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	// Pages differ per session, so nothing may keep them.
+	h.Set("Cache-Control", "no-store")
+	h.Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'; form-action 'self'")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// renderError shows the error page. Like respondWithDBError, it reports a
+// request that ran out of time as such.
+func (cfg *apiConfig) renderError(w http.ResponseWriter, r *http.Request, data pageData, code int, err error) {
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		code = http.StatusServiceUnavailable
+	}
+	if code > 499 {
+		slog.Error("rendering 5XX page", append(requestAttrs(r), slog.Int("status", code), slog.Any("error", err))...)
+	}
+	data.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+	cfg.render(w, r, code, "error", data)
+}
+
+// parseForm reads a posted form and checks its CSRF token. On failure it
+// has already responded.
+func (cfg *apiConfig) parseForm(w http.ResponseWriter, r *http.Request, data pageData) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+	if err := r.ParseForm(); err != nil {
+		cfg.renderError(w, r, data, http.StatusBadRequest, err)
+		return false
+	}
+	if !validCSRF(r, r.PostForm.Get(csrfField)) {
+		data.Error = "The form expired, please try again."
+		cfg.renderError(w, r, data, http.StatusForbidden, nil)
+		return false
+	}
+	return true
+}
+
+// safeNext returns next if it is a path within the web UI, and the
+// timeline otherwise, so a login link can't send people elsewhere.
+func safeNext(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(next, "//") ||
+		strings.Contains(next, `\`) || !strings.HasPrefix(u.Path, "/app/") {
+		return "/app/"
+	}
+	return next
+}
+
+func redirect(w http.ResponseWriter, r *http.Request, to string) {
+	http.Redirect(w, r, to, http.StatusSeeOther)
+}
+
+// loadChirps fills data with the page of chirps, of authorID unless it is
+// uuid.Nil, that the before query parameter asks for: the newest ones, or
+// those past the chirp it names. On failure it has already responded.
+func (cfg *apiConfig) loadChirps(w http.ResponseWriter, r *http.Request, data *pageData, authorID uuid.UUID) bool {
+	var cursorID uuid.UUID
+	if before := r.URL.Query().Get("before"); before != "" {
+		id, err := uuid.Parse(before)
+		if err != nil {
+			cfg.renderError(w, r, *data, http.StatusBadRequest, err)
+			return false
+		}
+		cursorID = id
+	}
+	err := cfg.chirpPage(r.Context(), data, authorID, cursorID, r.URL.Path)
+	if errors.Is(err, store.ErrNotFound) {
+		cfg.renderError(w, r, *data, http.StatusNotFound, err)
+		return false
+	}
+	if err != nil {
+		cfg.renderError(w, r, *data, http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+// chirpPage fills data with the chirps of authorID, or everyone's if it
+// is uuid.Nil, past cursorID, and links the next page from the page at
+// path if there is one.
+func (cfg *apiConfig) chirpPage(ctx context.Context, data *pageData, authorID, cursorID uuid.UUID, path string) error {
+	// One more than fits tells whether there is another page.
+	chirps, err := cfg.service.ListChirpPage(ctx, data.viewerID(), authorID, cursorID, timelineSize+1)
+	if err != nil {
+		return err
+	}
+	if len(chirps) > timelineSize {
+		chirps = chirps[:timelineSize]
+		data.Older = path + "?before=" + chirps[len(chirps)-1].ID.String()
+	}
+	data.Chirps = chirps
+	return nil
+}
+
+func (cfg *apiConfig) uiTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	data := cfg.newPage(w, r)
+	if !cfg.loadChirps(w, r, &data, uuid.Nil) {
+		return
+	}
+	cfg.render(w, r, http.StatusOK, "timeline", data)
+}
+
+func (cfg *apiConfig) uiChirpHandler(w http.ResponseWriter, r *http.Request) {
+	data := cfg.newPage(w, r)
+	id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		cfg.renderError(w, r, data, http.StatusNotFound, err)
+		return
+	}
+	if err := cfg.loadThread(r.Context(), &data, id); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, store.ErrNotFound) {
+			code = http.StatusNotFound
+		}
+		cfg.renderError(w, r, data, code, err)
+		return
+	}
+	cfg.render(w, r, http.StatusOK, "chirp", data)
+}
+
+// loadThread fills data with the chirp id and the thread around it.
+func (cfg *apiConfig) loadThread(ctx context.Context, data *pageData, id uuid.UUID) error {
+	thread, err := cfg.service.GetThread(ctx, data.viewerID(), id)
+	if err != nil {
+		return err
+	}
+	data.Chirp = thread.Chirp
+	data.Ancestors = thread.Ancestors
+	data.Replies = thread.Replies
+	return nil
+}
+
+func (cfg *apiConfig) uiProfileHandler(w http.ResponseWriter, r *http.Request) {
+	data := cfg.newPage(w, r)
+	id, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		cfg.renderError(w, r, data, http.StatusNotFound, err)
+		return
+	}
+	user, err := cfg.service.GetUser(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		cfg.renderError(w, r, data, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		cfg.renderError(w, r, data, http.StatusInternalServerError, err)
+		return
+	}
+	data.Profile = user
+	if data.ChirpCount, err = cfg.service.CountChirps(r.Context(), data.viewerID(), id); err != nil {
+		cfg.renderError(w, r, data, http.StatusInternalServerError, err)
+		return
+	}
+	if !cfg.loadChirps(w, r, &data, id) {
+		return
+	}
+	cfg.render(w, r, http.StatusOK, "profile", data)
+}
+
+func (cfg *apiConfig) uiLoginPageHandler(w http.ResponseWriter, r *http.Request) {
+	data := cfg.newPage(w, r)
+	next := safeNext(r.URL.Query().Get("next"))
+	if data.Viewer != nil {
+		redirect(w, r, next)
+		return
+	}
+	data.Next = next
+	cfg.render(w, r, http.StatusOK, "login", data)
+}
+
+func (cfg *apiConfig) uiLoginHandler(w http.ResponseWriter, r *http.Request) {
+	data := cfg.newPage(w, r)
+	if !cfg.parseForm(w, r, data) {
+		return
+	}
+	data.Email = r.PostForm.Get("email")
+	data.Next = safeNext(r.PostForm.Get("next"))
+
+	session, err := cfg.login(r, data.Email, r.PostForm.Get("password"), r.PostForm.Get("reset_token"), r.PostForm.Get("new_password"))
+	switch {
+	case errors.Is(err, service.ErrPasswordRequired):
+		data.Error = "Choose a new password."
+		data.NewPassword = true
+		cfg.render(w, r, http.StatusBadRequest, "login", data)
+		return
+	case errors.Is(err, service.ErrInvalidCredentials):
+		data.Error = "Incorrect email or password."
+		cfg.render(w, r, http.StatusUnauthorized, "login", data)
+		return
+	case errors.Is(err, service.ErrSuspended):
+		data.Error = "This account is suspended."
+		cfg.render(w, r, http.StatusForbidden, "login", data)
+		return
+	case errors.Is(err, service.ErrPasswordResetRequired):
+		data.Error = "Your password was reset. Enter the reset token you were given and choose a new password."
+		data.NewPassword = true
+		cfg.render(w, r, http.StatusForbidden, "login", data)
+		return
+	case err != nil:
+		cfg.renderError(w, r, data, http.StatusInternalServerError, err)
+		return
+	}
+	cfg.startSession(w, session)
+	redirect(w, r, data.Next)
+}
+
+func (cfg *apiConfig) uiSignupPageHandler(w http.ResponseWriter, r *http.Request) {
+	data := cfg.newPage(w, r)
+	if data.Viewer != nil {
+		redirect(w, r, "/app/")
+		return
+	}
+	cfg.render(w, r, http.StatusOK, "signup", data)
+}
+
+// uiSignupHandler creates the account and logs straight into it.
+func (cfg *apiConfig) uiSignupHandler(w http.ResponseWriter, r *http.Request) {
+	data := cfg.newPage(w, r)
+	if !cfg.parseForm(w, r, data) {
+		return
+	}
+	data.Email = r.PostForm.Get("email")
+	password := r.PostForm.Get("password")
+	if data.Email == "" || password == "" {
+		data.Error = "Email and password are required."
+		cfg.render(w, r, http.StatusBadRequest, "signup", data)
+		return
+	}
+
+	_, err := cfg.createUser(r, data.Email, password)
+	if errors.Is(err, store.ErrConflict) {
+		data.Error = "That email is already in use."
+		cfg.render(w, r, http.StatusConflict, "signup", data)
+		return
+	}
+	if err != nil {
+		cfg.renderError(w, r, data, http.StatusInternalServerError, err)
+		return
+	}
+	session, err := cfg.login(r, data.Email, password, "", "")
+	if err != nil {
+		cfg.renderError(w, r, data, http.StatusInternalServerError, err)
+		return
+	}
+	cfg.startSession(w, session)
+	redirect(w, r, "/app/")
+}
+
+func (cfg *apiConfig) uiLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	data := cfg.newPage(w, r)
+	if !cfg.parseForm(w, r, data) {
+		return
+	}
+	if err := cfg.endSession(w, r); err != nil && !errors.Is(err, store.ErrNotFound) {
+		// The cookies are gone either way; the token just stays valid
+		// until it expires.
+		slog.WarnContext(r.Context(), "revoking refresh token on logout", "error", err)
+	}
+	redirect(w, r, "/app/")
+}
+
+func (cfg *apiConfig) uiPostChirpHandler(w http.ResponseWriter, r *http.Request) {
+	data := cfg.newPage(w, r)
+	if data.Viewer == nil {
+		redirect(w, r, "/app/login")
+		return
+	}
+	if !cfg.parseForm(w, r, data) {
+		return
+	}
+	data.Body = r.PostForm.Get("body")
+	// Replies come from the chirp page with the chirp they answer.
+	parentID := uuid.Nil
+	if v := r.PostForm.Get("parent_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			cfg.renderError(w, r, data, http.StatusNotFound, err)
+			return
+		}
+		parentID = id
+	}
+
+	_, err := cfg.createChirp(r, data.Viewer.ID, parentID, data.Body)
+	switch {
+	case errors.Is(err, service.ErrChirpTooLong):
+		data.Error = fmt.Sprintf("Chirps can be at most %d characters long.", service.MaxChirpLength)
+		// The timeline or the thread is only the backdrop of the error here.
+		if parentID != uuid.Nil && cfg.loadThread(r.Context(), &data, parentID) == nil {
+			cfg.render(w, r, http.StatusBadRequest, "chirp", data)
+			return
+		}
+		cfg.chirpPage(r.Context(), &data, uuid.Nil, uuid.Nil, "/app/")
+		cfg.render(w, r, http.StatusBadRequest, "timeline", data)
+		return
+	case errors.Is(err, store.ErrNotFound) && parentID != uuid.Nil:
+		cfg.renderError(w, r, data, http.StatusNotFound, err)
+		return
+	case err != nil:
+		cfg.renderError(w, r, data, http.StatusInternalServerError, err)
+		return
+	}
+	if parentID != uuid.Nil {
+		redirect(w, r, "/app/chirps/"+parentID.String())
+		return
+	}
+	redirect(w, r, "/app/")
+}
+
+func (cfg *apiConfig) uiDeleteChirpHandler(w http.ResponseWriter, r *http.Request) {
+	data := cfg.newPage(w, r)
+	if data.Viewer == nil {
+		redirect(w, r, "/app/login")
+		return
+	}
+	if !cfg.parseForm(w, r, data) {
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		cfg.renderError(w, r, data, http.StatusNotFound, err)
+		return
+	}
+
+	err = cfg.deleteChirp(r, data.Viewer.ID, id)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		cfg.renderError(w, r, data, http.StatusNotFound, err)
+		return
+	case errors.Is(err, service.ErrForbidden):
+		cfg.renderError(w, r, data, http.StatusForbidden, err)
+		return
+	case err != nil:
+		cfg.renderError(w, r, data, http.StatusInternalServerError, err)
+		return
+	}
+	redirect(w, r, "/app/users/"+data.Viewer.ID.String())
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheMaru/go-http-server/internal/auth"
+	"github.com/TheMaru/go-http-server/internal/store"
+	"github.com/google/uuid"
+)
+
+func newUIServer(t *testing.T, cfg *apiConfig) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /app/{$}", cfg.uiTimelineHandler)
+	mux.HandleFunc("GET /app/chirps/{chirpID}", cfg.uiChirpHandler)
+	mux.HandleFunc("GET /app/users/{userID}", cfg.uiProfileHandler)
+	mux.HandleFunc("GET /app/login", cfg.uiLoginPageHandler)
+	mux.HandleFunc("POST /app/login", cfg.uiLoginHandler)
+	mux.HandleFunc("GET /app/signup", cfg.uiSignupPageHandler)
+	mux.HandleFunc("POST /app/signup", cfg.uiSignupHandler)
+	mux.HandleFunc("POST /app/logout", cfg.uiLogoutHandler)
+	mux.HandleFunc("POST /app/chirps", cfg.uiPostChirpHandler)
+	mux.HandleFunc("POST /app/chirps/{chirpID}/delete", cfg.uiDeleteChirpHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// browser is an HTTP client that keeps cookies and doesn't follow
+// redirects, like a browser seen one request at a time.
+type browser struct {
+	t      *testing.T
+	srv    *httptest.Server
+	client *http.Client
+}
+
+func newBrowser(t *testing.T, srv *httptest.Server) *browser {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &browser{t: t, srv: srv, client: &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+func (b *browser) get(path string) (*http.Response, string) {
+	b.t.Helper()
+	resp, err := b.client.Get(b.srv.URL + path)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+// post submits form with the browser's CSRF token, unless form brings
+// its own.
+func (b *browser) post(path string, form url.Values) (*http.Response, string) {
+	b.t.Helper()
+	if !form.Has(csrfField) {
+		form.Set(csrfField, b.cookie(csrfCookie))
+	}
+	resp, err := b.client.PostForm(b.srv.URL+path, form)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func (b *browser) cookie(name string) string {
+	u, _ := url.Parse(b.srv.URL)
+	for _, c := range b.client.Jar.Cookies(u) {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func (b *browser) setCookie(name, value string) {
+	u, _ := url.Parse(b.srv.URL)
+	b.client.Jar.SetCookies(u, []*http.Cookie{{Name: name, Value: value, Path: "/"}})
+}
+
+func wantRedirect(t *testing.T, resp *http.Response, location string) {
+	t.Helper()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("status = %d, want 303", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Location"); got != location {
+		t.Errorf("Location = %q, want %q", got, location)
+	}
+}
+
+func TestWebUI(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newUIServer(t, cfg)
+	b := newBrowser(t, srv)
+
+	resp, body := b.get("/app/signup")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `name="csrf_token"`) {
+		t.Fatalf("signup page: status %d, body %q", resp.StatusCode, body)
+	}
+	resp, _ = b.post("/app/signup", url.Values{"email": {"ui@example.com"}, "password": {"secret"}})
+	wantRedirect(t, resp, "/app/")
+	refreshToken := b.cookie(refreshCookie)
+	if b.cookie(accessCookie) == "" || refreshToken == "" {
+		t.Fatal("signup didn't start a session")
+	}
+
+	resp, body = b.get("/app/")
+	if !strings.Contains(body, "ui@example.com") || !strings.Contains(body, `action="/app/chirps"`) {
+		t.Errorf("timeline doesn't show the logged in user and the post form: %s", body)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+
+	resp, _ = b.post("/app/chirps", url.Values{"body": {"hello <b>web</b>"}})
+	wantRedirect(t, resp, "/app/")
+	_, body = b.get("/app/")
+	if !strings.Contains(body, "hello &lt;b&gt;web&lt;/b&gt;") {
+		t.Fatalf("timeline doesn't show the escaped chirp: %s", body)
+	}
+
+	resp, body = b.post("/app/chirps", url.Values{"body": {strings.Repeat("a", 141)}})
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "at most 140") {
+		t.Errorf("long chirp: status %d, want 400 with an explanation", resp.StatusCode)
+	}
+
+	chirps, _ := cfg.service.ListChirps(t.Context(), uuid.Nil, uuid.Nil)
+	if len(chirps) != 1 {
+		t.Fatalf("got %d chirps, want 1", len(chirps))
+	}
+	chirp := chirps[0]
+	_, body = b.get("/app/chirps/" + chirp.ID.String())
+	if !strings.Contains(body, "/app/chirps/"+chirp.ID.String()+"/delete") {
+		t.Error("own chirp page has no delete button")
+	}
+	_, body = b.get("/app/users/" + chirp.UserID.String())
+	if !strings.Contains(body, "1 chirps") {
+		t.Errorf("profile doesn't count the chirp: %s", body)
+	}
+
+	resp, _ = b.post("/app/chirps/"+chirp.ID.String()+"/delete", url.Values{})
+	wantRedirect(t, resp, "/app/users/"+chirp.UserID.String())
+	if resp, _ := b.get("/app/chirps/" + chirp.ID.String()); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("deleted chirp page status = %d, want 404", resp.StatusCode)
+	}
+
+	resp, _ = b.post("/app/logout", url.Values{})
+	wantRedirect(t, resp, "/app/")
+	if b.cookie(accessCookie) != "" || b.cookie(refreshCookie) != "" {
+		t.Error("logout left session cookies behind")
+	}
+	if _, _, err := cfg.service.Refresh(t.Context(), refreshToken); err == nil {
+		t.Error("refresh token still valid after logout")
+	}
+	_, body = b.get("/app/")
+	if !strings.Contains(body, `href="/app/login"`) {
+		t.Error("timeline after logout has no login link")
+	}
+}
+
+func TestWebUILogin(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newUIServer(t, cfg)
+	if _, err := cfg.service.CreateUser(t.Context(), "login@example.com", "right"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		wantCode int
+		wantNext string
+	}{
+		{"Wrong password", url.Values{"email": {"login@example.com"}, "password": {"wrong"}}, http.StatusUnauthorized, ""},
+		{"Success", url.Values{"email": {"login@example.com"}, "password": {"right"}}, http.StatusSeeOther, "/app/"},
+		{"Next", url.Values{"email": {"login@example.com"}, "password": {"right"}, "next": {"/app/users/x"}}, http.StatusSeeOther, "/app/users/x"},
+		{"Foreign next", url.Values{"email": {"login@example.com"}, "password": {"right"}, "next": {"https://evil.example/app/"}}, http.StatusSeeOther, "/app/"},
+		{"Missing CSRF token", url.Values{"email": {"login@example.com"}, "password": {"right"}, csrfField: {""}}, http.StatusForbidden, ""},
+		{"Wrong CSRF token", url.Values{"email": {"login@example.com"}, "password": {"right"}, csrfField: {"forged"}}, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBrowser(t, srv)
+			b.get("/app/login")
+			resp, _ := b.post("/app/login", tt.form)
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if tt.wantNext != "" {
+				wantRedirect(t, resp, tt.wantNext)
+			}
+			if loggedIn := b.cookie(accessCookie) != ""; loggedIn != (tt.wantCode == http.StatusSeeOther) {
+				t.Errorf("logged in = %v after status %d", loggedIn, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestWebUIPasswordReset(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newUIServer(t, cfg)
+	user, err := cfg.service.CreateUser(t.Context(), "reset@example.com", "leaked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := cfg.service.ForcePasswordReset(t.Context(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := newBrowser(t, srv)
+	b.get("/app/login")
+	resp, body := b.post("/app/login", url.Values{"email": {"reset@example.com"}, "password": {"leaked"}})
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(body, `name="reset_token"`) || strings.Contains(body, `name="password"`) {
+		t.Fatalf("login with the old password = %d, want 403 asking for the reset token:\n%s", resp.StatusCode, body)
+	}
+	resp, _ = b.post("/app/login", url.Values{"email": {"reset@example.com"}, "reset_token": {token}, "new_password": {"fresh"}})
+	if resp.StatusCode != http.StatusSeeOther || b.cookie(accessCookie) == "" {
+		t.Fatalf("login with the reset token = %d, want 303 and a session", resp.StatusCode)
+	}
+	if _, err := cfg.service.Login(t.Context(), "reset@example.com", "fresh"); err != nil {
+		t.Errorf("Login() with the new password error = %v", err)
+	}
+}
+
+func TestWebUIThreads(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newUIServer(t, cfg)
+	walt, _ := cfg.service.CreateUser(t.Context(), "walt@example.com", "pw")
+	root, err := cfg.service.CreateChirp(t.Context(), walt.ID, "we need to cook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPage := "/app/chirps/" + root.ID.String()
+
+	b := newBrowser(t, srv)
+	b.get("/app/signup")
+	b.post("/app/signup", url.Values{"email": {"jesse@example.com"}, "password": {"pw"}})
+	_, body := b.get(rootPage)
+	if !strings.Contains(body, `name="parent_id" value="`+root.ID.String()+`"`) || !strings.Contains(body, "No replies yet.") {
+		t.Fatalf("chirp page has no reply form: %s", body)
+	}
+
+	resp, _ := b.post("/app/chirps", url.Values{"body": {"yeah science"}, "parent_id": {root.ID.String()}})
+	wantRedirect(t, resp, rootPage)
+	_, body = b.get(rootPage)
+	if !strings.Contains(body, "yeah science") {
+		t.Fatalf("chirp page doesn't show the reply: %s", body)
+	}
+	replies, _ := cfg.service.ListReplies(t.Context(), uuid.Nil, root.ID)
+	if len(replies) != 1 {
+		t.Fatalf("got %d replies, want 1", len(replies))
+	}
+	_, body = b.get("/app/chirps/" + replies[0].ID.String())
+	if !strings.Contains(body, "Earlier in the thread") || !strings.Contains(body, "we need to cook") {
+		t.Errorf("reply page doesn't show the chirp it answers: %s", body)
+	}
+	if _, body = b.get("/app/"); !strings.Contains(body, `href="`+rootPage+`">in reply`) {
+		t.Errorf("timeline doesn't link the reply to its parent: %s", body)
+	}
+
+	resp, body = b.post("/app/chirps", url.Values{"body": {strings.Repeat("a", 141)}, "parent_id": {root.ID.String()}})
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "at most 140") || !strings.Contains(body, "we need to cook") {
+		t.Errorf("long reply: status %d, want 400 on the thread", resp.StatusCode)
+	}
+	for _, parent := range []string{uuid.NewString(), "nope"} {
+		if resp, _ := b.post("/app/chirps", url.Values{"body": {"hello?"}, "parent_id": {parent}}); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("reply to %s status = %d, want 404", parent, resp.StatusCode)
+		}
+	}
+}
+
+func TestWebUIRefreshesSession(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newUIServer(t, cfg)
+	cfg.service.CreateUser(t.Context(), "refresh@example.com", "pw")
+	session, err := cfg.service.Login(t.Context(), "refresh@example.com", "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := auth.MakeJWT(session.User.ID, testSecret, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := newBrowser(t, srv)
+	b.setCookie(accessCookie, expired)
+	b.setCookie(refreshCookie, session.RefreshToken)
+	_, body := b.get("/app/")
+	if !strings.Contains(body, "refresh@example.com") {
+		t.Error("expired access token with a valid refresh token didn't keep the session")
+	}
+	if got := b.cookie(accessCookie); got == expired || got == "" {
+		t.Error("access cookie wasn't renewed")
+	}
+
+	cfg.service.Revoke(t.Context(), session.RefreshToken)
+	b.setCookie(accessCookie, expired)
+	_, body = b.get("/app/")
+	if strings.Contains(body, "refresh@example.com") {
+		t.Error("revoked refresh token still logs in")
+	}
+	if b.cookie(refreshCookie) != "" {
+		t.Error("revoked refresh cookie wasn't cleared")
+	}
+}
+
+func TestWebUIRequiresLogin(t *testing.T) {
+	srv := newUIServer(t, newTestAPIConfig(store.NewMemory()))
+	b := newBrowser(t, srv)
+	b.get("/app/")
+	resp, _ := b.post("/app/chirps", url.Values{"body": {"anonymous"}})
+	wantRedirect(t, resp, "/app/login")
+}
+
+func TestWebUINotFound(t *testing.T) {
+	srv := newUIServer(t, newTestAPIConfig(store.NewMemory()))
+	b := newBrowser(t, srv)
+	for _, path := range []string{"/app/chirps/nope", "/app/chirps/" + uuid.NewString(), "/app/users/" + uuid.NewString()} {
+		if resp, _ := b.get(path); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s status = %d, want 404", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestWebUIPages(t *testing.T) {
+	cfg := newTestAPIConfig(store.NewMemory())
+	srv := newUIServer(t, cfg)
+	b := newBrowser(t, srv)
+	user, _ := newTestUser(t, cfg, store.RoleUser, time.Hour)
+	for i := range timelineSize + 5 {
+		if _, err := cfg.service.CreateChirp(t.Context(), user.ID, fmt.Sprintf("chirp %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	olderLink := regexp.MustCompile(`class="older" href="([^"]+)"`)
+
+	for _, path := range []string{"/app/", "/app/users/" + user.ID.String()} {
+		_, body := b.get(path)
+		if n := strings.Count(body, `<article class="chirp">`); n != timelineSize {
+			t.Errorf("GET %s shows %d chirps, want %d", path, n, timelineSize)
+		}
+		m := olderLink.FindStringSubmatch(body)
+		if m == nil {
+			t.Fatalf("GET %s has no link to older chirps", path)
+		}
+		if !strings.HasPrefix(m[1], path+"?before=") {
+			t.Errorf("older chirps link = %q, want it on %s", m[1], path)
+		}
+
+		_, body = b.get(html.UnescapeString(m[1]))
+		if n := strings.Count(body, `<article class="chirp">`); n != 5 {
+			t.Errorf("second page of %s shows %d chirps, want 5", path, n)
+		}
+		if !strings.Contains(body, "chirp 0<") || olderLink.MatchString(body) {
+			t.Errorf("second page of %s doesn't end with the oldest chirp", path)
+		}
+	}
+
+	if resp, _ := b.get("/app/?before=nope"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed cursor status = %d, want 400", resp.StatusCode)
+	}
+	if resp, _ := b.get("/app/?before=" + uuid.NewString()); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown cursor status = %d, want 404", resp.StatusCode)
+	}
+
+	// A chirp page shows the chirp alone.
+	chirps, _ := cfg.service.ListChirpPage(t.Context(), uuid.Nil, uuid.Nil, uuid.Nil, 1)
+	_, body := b.get("/app/chirps/" + chirps[0].ID.String())
+	if n := strings.Count(body, `<article class="chirp`); n != 1 {
+		t.Errorf("chirp page shows %d chirps, want 1", n)
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	tests := []struct {
+		next, want string
+	}{
+		{"", "/app/"},
+		{"/app/users/1", "/app/users/1"},
+		{"/app/chirps/1?x=y", "/app/chirps/1?x=y"},
+		{"/api/chirps", "/app/"},
+		{"//evil.example/app/", "/app/"},
+		{`/app/\evil`, "/app/"},
+		{"https://evil.example/app/", "/app/"},
+		{"javascript:alert(1)", "/app/"},
+	}
+	for _, tt := range tests {
+		if got := safeNext(tt.next); got != tt.want {
+			t.Errorf("safeNext(%q) = %q, want %q", tt.next, got, tt.want)
+		}
+	}
+}
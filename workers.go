@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// workerGroup runs long-lived background goroutines that share the server's
+// lifetime. Stopping the group cancels their context and waits for them to
+// return so nothing is still touching the database when it gets closed.
+// Nothing else cancels them: requests still draining after a shutdown
+// signal need the event listener and the other workers to keep going.
+type workerGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	failed map[string]error
+}
+
+func newWorkerGroup() *workerGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workerGroup{ctx: ctx, cancel: cancel, failed: map[string]error{}}
+}
+
+// Go starts fn in its own goroutine. fn should return once ctx is done;
+// returning any earlier marks the worker as failed.
+func (g *workerGroup) Go(name string, fn func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		err := fn(g.ctx)
+		if g.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("exited early")
+		}
+		slog.Error("worker stopped with error", "worker", name, "error", err)
+
+		g.mu.Lock()
+		g.failed[name] = err
+		g.mu.Unlock()
+	}()
+}
+
+// Healthy returns an error naming every worker that stopped before the
+// group did.
+func (g *workerGroup) Healthy() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var errs []error
+	for name, err := range g.failed {
+		errs = append(errs, fmt.Errorf("worker %s: %w", name, err))
+	}
+	return errors.Join(errs...)
+}
+
+// Stop cancels all workers and waits for them, giving up when ctx is done.
+func (g *workerGroup) Stop(ctx context.Context) error {
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}